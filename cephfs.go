@@ -0,0 +1,606 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	cephv1 "github.com/rook/rook/pkg/apis/ceph.rook.io/v1"
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	utilexec "k8s.io/client-go/util/exec"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// defaultFilesystemName is the CephFilesystem that ODF creates for the CephFS StorageClass
+const defaultFilesystemName = "ocs-storagecluster-cephfilesystem"
+
+// cephfsSubvolumeGroup is the subvolume group that the CSI driver creates the subvolumes in
+const cephfsSubvolumeGroup = "csi"
+
+// replicationCephFS mirrors the directories of CephFS subvolumes with cephfs-mirror
+const replicationCephFS = "cephfs-mirror"
+
+// Exit codes of the ceph CLI, ceph exits with the errno of the failed operation
+const (
+	cephExitNotFound = 2
+	cephExitExists   = 17
+	cephExitInvalid  = 22
+)
+
+// States of mirrored CephFS directories, the mapped, shuffling and stalled states are reported by "ceph fs snapshot mirror dirmap"
+const (
+	cephfsMirrorStateMapped = "mapped"
+	// cephfsMirrorStateReplica is shown for the PVs that RDRhelper synced to the peer cluster, their directory is mirrored from the peer
+	cephfsMirrorStateReplica = "replica"
+)
+
+// defaultCephFSSnapshotInterval is how often the mirrored directories are snapshotted, cephfs-mirror only transfers snapshots
+const defaultCephFSSnapshotInterval = "1h"
+
+// isCephFSPV returns true for PVs of a CephFS CSI driver, ODF and Rook prefix the driver name with their namespace
+func isCephFSPV(pv *corev1.PersistentVolume) bool {
+	return pv.Spec.CSI != nil && strings.HasSuffix(pv.Spec.CSI.Driver, cephfsCSIDriverSuffix)
+}
+
+// isRBDPV returns true for PVs of a Ceph RBD CSI driver
+func isRBDPV(pv *corev1.PersistentVolume) bool {
+	return pv.Spec.CSI != nil && strings.HasSuffix(pv.Spec.CSI.Driver, rbdCSIDriverSuffix)
+}
+
+// isCephPV returns true for the RBD and CephFS PVs, whose mirroring RDRhelper manages
+func isCephPV(pv *corev1.PersistentVolume) bool {
+	return isRBDPV(pv) || isCephFSPV(pv)
+}
+
+// getCephFSInfoFromPV returns (fsName, subvolumeName, nil) or ("", "", error)
+func getCephFSInfoFromPV(pv *corev1.PersistentVolume) (string, string, error) {
+	if pv == nil || !isCephFSPV(pv) || pv.Spec.CSI.VolumeAttributes == nil {
+		return "", "", errors.New("PV is not a CephFS PV")
+	}
+	fsName := pv.Spec.CSI.VolumeAttributes["fsName"]
+	subvolumeName := pv.Spec.CSI.VolumeAttributes["subvolumeName"]
+	if fsName == "" || subvolumeName == "" {
+		return "", "", errors.New("could not get the filesystem or subvolume name from PV")
+	}
+	return fsName, subvolumeName, nil
+}
+
+// isCephFSReplica returns true for the static PVs that RDRhelper created for the CephFS PVs of the peer cluster
+func isCephFSReplica(pv *corev1.PersistentVolume) bool {
+	return isCephFSPV(pv) && pv.Spec.CSI.VolumeAttributes["staticVolume"] == "true"
+}
+
+// cephfsPath returns the directory of the subvolume of the PV, which is the same in both clusters
+func cephfsPath(cluster kubeAccess, pv *corev1.PersistentVolume) (string, error) {
+	fsName, subvolumeName, err := getCephFSInfoFromPV(pv)
+	if err != nil {
+		return "", err
+	}
+	if path := pv.Spec.CSI.VolumeAttributes["rootPath"]; path != "" {
+		return path, nil
+	}
+	if path := pv.Spec.CSI.VolumeAttributes["subvolumePath"]; path != "" {
+		return path, nil
+	}
+	return newCephFS(cluster).SubvolumePath(fsName, subvolumeName)
+}
+
+// cephfsReplicaPV turns a copy of the CephFS PV of the peer cluster into a static PV of the mirrored directory.
+// The subvolume only exists in the peer cluster, the CSI driver can not look it up.
+func cephfsReplicaPV(pv *corev1.PersistentVolume, path string) {
+	attributes := make(map[string]string)
+	for key, value := range pv.Spec.CSI.VolumeAttributes {
+		attributes[key] = value
+	}
+	attributes["staticVolume"] = "true"
+	attributes["rootPath"] = path
+	pv.Spec.CSI.VolumeAttributes = attributes
+}
+
+// cephfsDirMap is the output of "ceph fs snapshot mirror dirmap"
+type cephfsDirMap struct {
+	InstanceID   string  `json:"instance_id"`
+	LastShuffled float64 `json:"last_shuffled"`
+	State        string  `json:"state"`
+}
+
+// cephfsPeer is one entry of "ceph fs snapshot mirror peer_list"
+type cephfsPeer struct {
+	ClientName string `json:"client_name"`
+	SiteName   string `json:"site_name"`
+	FSName     string `json:"fs_name"`
+}
+
+// cephFSClient runs ceph commands for CephFS mirroring in the toolbox of a cluster.
+// Commands that change the Ceph state are only recorded during a dry run.
+type cephFSClient struct {
+	cluster kubeAccess
+}
+
+func newCephFS(cluster kubeAccess) cephFSClient {
+	return cephFSClient{cluster: cluster}
+}
+
+// cephExitCode returns the exit code of a failed ceph command, or -1
+func cephExitCode(err error) int {
+	var exitErr utilexec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitStatus()
+	}
+	return -1
+}
+
+func (c cephFSClient) run(args ...string) (string, error) {
+	command := "ceph " + strings.Join(args, " ")
+	stdout, stderr, err := c.cluster.toolbox.run(command)
+	if err != nil {
+		if reason := strings.TrimSpace(stderr); reason != "" {
+			return stdout, errors.WithMessagef(err, "[%s] '%s' failed: %s", c.cluster.name, command, reason)
+		}
+		return stdout, errors.WithMessagef(err, "[%s] '%s' failed", c.cluster.name, command)
+	}
+	return stdout, nil
+}
+
+func (c cephFSClient) runJSON(target interface{}, args ...string) error {
+	stdout, err := c.run(append(args, "--format", "json")...)
+	if err != nil {
+		return err
+	}
+	if err = json.Unmarshal([]byte(stdout), target); err != nil {
+		return errors.Wrapf(err, "[%s] Could not parse the output of ceph %s", c.cluster.name, strings.Join(args, " "))
+	}
+	return nil
+}
+
+func (c cephFSClient) change(args ...string) error {
+	if dryRun.intercept(c.cluster, "exec", "rook-ceph-tools", "ceph "+strings.Join(args, " ")) {
+		return nil
+	}
+	_, err := c.run(args...)
+	return err
+}
+
+// SubvolumePath returns the directory of a subvolume of the CSI subvolume group
+func (c cephFSClient) SubvolumePath(fsName, subvolumeName string) (string, error) {
+	stdout, err := c.run("fs", "subvolume", "getpath", fsName, subvolumeName, "--group_name", cephfsSubvolumeGroup)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(stdout), nil
+}
+
+// EnableMirroringModule enables the mgr module that manages the mirrored directories
+func (c cephFSClient) EnableMirroringModule() error {
+	return c.change("mgr", "module", "enable", "mirroring")
+}
+
+// EnableMirroring enables snapshot mirroring on the filesystem
+func (c cephFSClient) EnableMirroring(fsName string) error {
+	return c.change("fs", "snapshot", "mirror", "enable", fsName)
+}
+
+// AddDirectory starts mirroring the directory to the peers of the filesystem
+func (c cephFSClient) AddDirectory(fsName, path string) error {
+	err := c.change("fs", "snapshot", "mirror", "add", fsName, path)
+	if cephExitCode(err) == cephExitExists {
+		return nil
+	}
+	return err
+}
+
+// RemoveDirectory stops mirroring the directory, directories that are not mirrored are ignored
+func (c cephFSClient) RemoveDirectory(fsName, path string) error {
+	err := c.change("fs", "snapshot", "mirror", "remove", fsName, path)
+	if cephExitCode(err) == cephExitNotFound {
+		return nil
+	}
+	return err
+}
+
+// DirMap returns which mirror daemon syncs the directory, cephExitCode(err) is cephExitNotFound if it is not mirrored
+func (c cephFSClient) DirMap(fsName, path string) (*cephfsDirMap, error) {
+	var dirMap cephfsDirMap
+	if err := c.runJSON(&dirMap, "fs", "snapshot", "mirror", "dirmap", fsName, path); err != nil {
+		return nil, err
+	}
+	return &dirMap, nil
+}
+
+// Peers returns the peers of the filesystem by UUID
+func (c cephFSClient) Peers(fsName string) (map[string]cephfsPeer, error) {
+	peers := make(map[string]cephfsPeer)
+	if err := c.runJSON(&peers, "fs", "snapshot", "mirror", "peer_list", fsName); err != nil {
+		return nil, err
+	}
+	return peers, nil
+}
+
+// CreatePeerBootstrap creates the user the peer mirrors with and returns the token the peer imports
+func (c cephFSClient) CreatePeerBootstrap(fsName, clientName, siteName string) (string, error) {
+	var bootstrap struct {
+		Token string `json:"token"`
+	}
+	stdout, err := c.run("fs", "snapshot", "mirror", "peer_bootstrap", "create", fsName, clientName, siteName)
+	if err != nil {
+		return "", err
+	}
+	if err := json.Unmarshal([]byte(stdout), &bootstrap); err != nil || bootstrap.Token == "" {
+		return "", errors.Errorf("[%s] Could not read the bootstrap token of filesystem %s", c.cluster.name, fsName)
+	}
+	return bootstrap.Token, nil
+}
+
+// ImportPeerBootstrap adds the cluster of the token as peer of the filesystem
+func (c cephFSClient) ImportPeerBootstrap(fsName, token string) error {
+	return c.change("fs", "snapshot", "mirror", "peer_bootstrap", "import", fsName, token)
+}
+
+// RemovePeer stops mirroring the filesystem to the peer with the UUID
+func (c cephFSClient) RemovePeer(fsName, uuid string) error {
+	return c.change("fs", "snapshot", "mirror", "peer_remove", fsName, uuid)
+}
+
+// AddSnapshotSchedule snapshots the directory in the interval, existing schedules are kept
+func (c cephFSClient) AddSnapshotSchedule(fsName, path, interval string) error {
+	err := c.change("fs", "snap-schedule", "add", path, interval, "--fs", fsName)
+	if cephExitCode(err) == cephExitExists {
+		return nil
+	}
+	return err
+}
+
+// RemoveSnapshotSchedules removes all snapshot schedules of the directory
+func (c cephFSClient) RemoveSnapshotSchedules(fsName, path string) error {
+	err := c.change("fs", "snap-schedule", "remove", path, "--fs", fsName)
+	if cephExitCode(err) == cephExitNotFound {
+		return nil
+	}
+	return err
+}
+
+// getCephFSMirrorStatuses returns the mirror status of the directories of the CephFS PVs, keyed by PV name.
+// The statuses have the same form as the ones of RBD images, PVs whose status could not be fetched are left out.
+func getCephFSMirrorStatuses(cluster kubeAccess, pvs []corev1.PersistentVolume) (map[string]*rbdMirrorImageStatus, error) {
+	statuses := make(map[string]*rbdMirrorImageStatus)
+	var firstErr error
+	cephfs := newCephFS(cluster)
+	for i := range pvs {
+		pv := &pvs[i]
+		if !isCephFSPV(pv) {
+			continue
+		}
+		status, err := cephfsMirrorStatus(cephfs, pv)
+		if err != nil {
+			log.WithError(err).Warnf("[%s] Issues when fetching the mirror status of PV %s", cluster.name, pv.Name)
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		statuses[pv.Name] = status
+	}
+	return statuses, firstErr
+}
+
+func cephfsMirrorStatus(cephfs cephFSClient, pv *corev1.PersistentVolume) (*rbdMirrorImageStatus, error) {
+	fsName, _, err := getCephFSInfoFromPV(pv)
+	if err != nil {
+		return nil, err
+	}
+	path, err := cephfsPath(cephfs.cluster, pv)
+	if err != nil {
+		return nil, err
+	}
+	dirMap, err := cephfs.DirMap(fsName, path)
+	switch code := cephExitCode(err); {
+	case code == cephExitNotFound || code == cephExitInvalid:
+		state := rbdMirrorStateDisabled
+		if isCephFSReplica(pv) {
+			state = cephfsMirrorStateReplica
+		}
+		return &rbdMirrorImageStatus{Name: path, State: state}, nil
+	case err != nil:
+		return nil, err
+	}
+	return &rbdMirrorImageStatus{Name: path, State: dirMap.State, Description: fmt.Sprintf("mirror daemon %s", dirMap.InstanceID)}, nil
+}
+
+// cephfsReplication mirrors the directories of CephFS subvolumes with snapshots.
+// Every cluster mirrors the directories that were added in it to its peers, so the direction is changed by
+// removing the directory in one cluster and adding it in the other one.
+type cephfsReplication struct {
+	cluster kubeAccess
+}
+
+func (c cephfsReplication) name() string {
+	return replicationCephFS
+}
+
+// enable adds the directory and a snapshot schedule for it, CephFS only supports snapshot based mirroring
+func (c cephfsReplication) enable(_ context.Context, pv *corev1.PersistentVolume, mode string) error {
+	if mode != "" && mode != mirroringModeSnapshot {
+		return errors.Errorf("CephFS PV %s can only be mirrored with snapshots", pv.Name)
+	}
+	fsName, _, err := getCephFSInfoFromPV(pv)
+	if err != nil {
+		return err
+	}
+	path, err := cephfsPath(c.cluster, pv)
+	if err != nil {
+		return err
+	}
+	cephfs := newCephFS(c.cluster)
+	if err := cephfs.AddSnapshotSchedule(fsName, path, defaultCephFSSnapshotInterval); err != nil {
+		return errors.WithMessagef(err, "could not schedule snapshots of PV %s", pv.Name)
+	}
+	if err := cephfs.AddDirectory(fsName, path); err != nil {
+		return errors.WithMessagef(err, "could not change CephFS mirror status of PV %s", pv.Name)
+	}
+	return nil
+}
+
+func (c cephfsReplication) disable(_ context.Context, pv *corev1.PersistentVolume) error {
+	fsName, _, err := getCephFSInfoFromPV(pv)
+	if err != nil {
+		return err
+	}
+	path, err := cephfsPath(c.cluster, pv)
+	if err != nil {
+		return err
+	}
+	cephfs := newCephFS(c.cluster)
+	if err := cephfs.RemoveDirectory(fsName, path); err != nil {
+		return errors.WithMessagef(err, "could not change CephFS mirror status of PV %s", pv.Name)
+	}
+	if err := cephfs.RemoveSnapshotSchedules(fsName, path); err != nil {
+		return errors.WithMessagef(err, "could not remove the snapshot schedule of PV %s", pv.Name)
+	}
+	return nil
+}
+
+// promote mirrors the directory from this cluster to the peer, there is no primary that needs to be forced
+func (c cephfsReplication) promote(ctx context.Context, pv *corev1.PersistentVolume, _ bool) error {
+	return c.enable(ctx, pv, "")
+}
+
+// demote stops mirroring the directory from this cluster
+func (c cephfsReplication) demote(ctx context.Context, pv *corev1.PersistentVolume) error {
+	return c.disable(ctx, pv)
+}
+
+func (c cephfsReplication) resync(_ context.Context, pv *corev1.PersistentVolume) error {
+	return errors.Errorf("CephFS PV %s cannot be resynced, its directory is synced with every mirrored snapshot", pv.Name)
+}
+
+// cephPVReplication passes CephFS PVs to the CephFS backend and all other PVs to the RBD backend
+type cephPVReplication struct {
+	rbd    replicationBackend
+	cephfs replicationBackend
+}
+
+// name is the name of the RBD backend, which is the one that can be configured
+func (r cephPVReplication) name() string {
+	return r.rbd.name()
+}
+
+func (r cephPVReplication) backendFor(pv *corev1.PersistentVolume) replicationBackend {
+	if isCephFSPV(pv) {
+		return r.cephfs
+	}
+	return r.rbd
+}
+
+func (r cephPVReplication) enable(ctx context.Context, pv *corev1.PersistentVolume, mode string) error {
+	return r.backendFor(pv).enable(ctx, pv, mode)
+}
+
+func (r cephPVReplication) disable(ctx context.Context, pv *corev1.PersistentVolume) error {
+	return r.backendFor(pv).disable(ctx, pv)
+}
+
+func (r cephPVReplication) promote(ctx context.Context, pv *corev1.PersistentVolume, force bool) error {
+	return r.backendFor(pv).promote(ctx, pv, force)
+}
+
+func (r cephPVReplication) demote(ctx context.Context, pv *corev1.PersistentVolume) error {
+	return r.backendFor(pv).demote(ctx, pv)
+}
+
+func (r cephPVReplication) resync(ctx context.Context, pv *corev1.PersistentVolume) error {
+	return r.backendFor(pv).resync(ctx, pv)
+}
+
+// cephFilesystemMirrorResource is the CR that makes Rook deploy the cephfs-mirror daemon
+var cephFilesystemMirrorResource = schema.GroupVersionResource{Group: "ceph.rook.io", Version: "v1", Resource: "cephfilesystemmirrors"}
+
+const cephFilesystemMirrorName = "cephfs-mirror"
+
+// cephfsPeerClient is the Ceph user that the peer cluster mirrors the directories with
+const cephfsPeerClient = "client.mirror_remote"
+
+// installCephFS overrides whether the install sets up the mirroring of the CephFilesystem, if it is nil
+// the profile decides. The Rook of OCS 4.7 and 4.8 has no CephFilesystemMirror.
+var installCephFS *bool
+
+// cephfsMirroringFor returns true if the install with the profile sets up the mirroring of the CephFilesystem
+func cephfsMirroringFor(profile odfProfile) bool {
+	if installCephFS != nil {
+		return *installCephFS
+	}
+	return profile.cephfsMirroring
+}
+
+// checkCephFilesystem returns true if the cluster has the CephFilesystem of ODF or of the Rook examples
+func checkCephFilesystem(ctx context.Context, cluster kubeAccess) (bool, error) {
+	name := cluster.environment().defaultFilesystem
+	if name == "" {
+		return false, nil
+	}
+	var filesystem cephv1.CephFilesystem
+	err := cluster.controllerClient.Get(ctx, types.NamespacedName{Name: name, Namespace: cluster.storageNamespace()}, &filesystem)
+	if kerrors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, errors.WithMessagef(err, "[%s] Issues when fetching the CephFilesystem %s", cluster.name, name)
+	}
+	return true, nil
+}
+
+// patchCephFilesystemMirroring enables or disables mirroring in the CephFilesystem spec
+func patchCephFilesystemMirroring(ctx context.Context, cluster kubeAccess, enabled bool) error {
+	name := cluster.environment().defaultFilesystem
+	patch, _ := json.Marshal(map[string]interface{}{
+		"spec": map[string]interface{}{"mirroring": map[string]interface{}{"enabled": enabled}},
+	})
+	if dryRun.intercept(cluster, "patch", "CephFilesystem/"+name, string(patch)) {
+		return nil
+	}
+	err := cluster.controllerClient.Patch(ctx,
+		&cephv1.CephFilesystem{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: cluster.storageNamespace()}},
+		client.RawPatch(types.MergePatchType, patch))
+	if err != nil {
+		return errors.WithMessagef(err, "[%s] Issues when patching the CephFilesystem %s", cluster.name, name)
+	}
+	return nil
+}
+
+// enableCephFSMirroring deploys the cephfs-mirror daemon and enables mirroring on the CephFilesystem
+func enableCephFSMirroring(ctx context.Context, cluster kubeAccess) error {
+	progress := installProgress.forCluster(cluster.name)
+	found, err := checkCephFilesystem(ctx, cluster)
+	if err != nil {
+		return err
+	}
+	if !found {
+		progress.info("No CephFilesystem %s, skipping CephFS mirroring", cluster.environment().defaultFilesystem)
+		return nil
+	}
+	mirror := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "ceph.rook.io/v1",
+		"kind":       "CephFilesystemMirror",
+		"metadata":   map[string]interface{}{"name": cephFilesystemMirrorName, "namespace": cluster.storageNamespace()},
+		"spec":       map[string]interface{}{},
+	}}
+	if !dryRun.intercept(cluster, "create", "CephFilesystemMirror/"+cephFilesystemMirrorName, nil) {
+		_, err = cluster.dynamicClient.Resource(cephFilesystemMirrorResource).Namespace(cluster.storageNamespace()).Create(ctx, mirror, metav1.CreateOptions{})
+		if kerrors.IsNotFound(err) {
+			return errors.Errorf("[%s] The CephFilesystemMirror API is not available, CephFS mirroring needs Rook 1.6 or newer. Install without CephFS mirroring.", cluster.name)
+		}
+		if err != nil && !kerrors.IsAlreadyExists(err) {
+			return errors.WithMessagef(err, "[%s] Issues when creating the CephFilesystemMirror", cluster.name)
+		}
+		progress.info("Created the CephFilesystemMirror")
+	}
+	return patchCephFilesystemMirroring(ctx, cluster, true)
+}
+
+// checkCephFSMirror returns true if the cephfs-mirror daemon is deployed, or if there is no CephFilesystem to mirror
+func checkCephFSMirror(ctx context.Context, cluster kubeAccess) (bool, error) {
+	if found, err := checkCephFilesystem(ctx, cluster); err != nil || !found {
+		return !found, err
+	}
+	_, err := cluster.dynamicClient.Resource(cephFilesystemMirrorResource).Namespace(cluster.storageNamespace()).Get(ctx, cephFilesystemMirrorName, metav1.GetOptions{})
+	if kerrors.IsNotFound(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// peerCephFilesystems makes the cluster mirror the directories of its CephFilesystem to the one of the peer.
+// The peer creates a bootstrap token that the cluster imports.
+func peerCephFilesystems(ctx context.Context, cluster, peer kubeAccess) error {
+	progress := installProgress.forCluster(cluster.name)
+	found, err := checkCephFilesystem(ctx, cluster)
+	if err != nil || !found {
+		return err
+	}
+	for _, c := range []kubeAccess{cluster, peer} {
+		if !dryRun.enabled {
+			err := waitFor(ctx, timeouts().Install, fmt.Sprintf("the Ceph Toolbox in the %s cluster", c.name), func(ctx context.Context) (bool, error) {
+				_, err := getToolsPod(c.typedClient, c.storageNamespace())
+				return err == nil, nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		cephfs := newCephFS(c)
+		if err := cephfs.EnableMirroringModule(); err != nil {
+			return err
+		}
+		if err := cephfs.EnableMirroring(c.environment().defaultFilesystem); err != nil {
+			return err
+		}
+	}
+	filesystem := cluster.environment().defaultFilesystem
+	if dryRun.enabled {
+		// The token is only created for real
+		dryRun.intercept(cluster, "exec", "rook-ceph-tools",
+			fmt.Sprintf("ceph fs snapshot mirror peer_bootstrap import %s <bootstrap token of the %s cluster>", filesystem, peer.name))
+		return nil
+	}
+	token, err := newCephFS(peer).CreatePeerBootstrap(peer.environment().defaultFilesystem, cephfsPeerClient, peer.name)
+	if err != nil {
+		return err
+	}
+	if err := newCephFS(cluster).ImportPeerBootstrap(filesystem, token); err != nil {
+		return err
+	}
+	progress.info("The CephFilesystem is mirrored to the %s cluster", peer.name)
+	return nil
+}
+
+// checkCephFSPeers returns true if the CephFilesystem has a peer, or if there is no CephFilesystem to mirror
+func checkCephFSPeers(ctx context.Context, cluster kubeAccess) (bool, error) {
+	if found, err := checkCephFilesystem(ctx, cluster); err != nil || !found {
+		return !found, err
+	}
+	peers, err := newCephFS(cluster).Peers(cluster.environment().defaultFilesystem)
+	if err != nil {
+		return false, err
+	}
+	return len(peers) > 0, nil
+}
+
+// disableCephFSMirroring removes the peers of the CephFilesystem and the cephfs-mirror daemon
+func disableCephFSMirroring(ctx context.Context, cluster kubeAccess) error {
+	progress := uninstallProgress.forCluster(cluster.name)
+	found, err := checkCephFilesystem(ctx, cluster)
+	if err != nil || !found {
+		return err
+	}
+	if _, err := getToolsPod(cluster.typedClient, cluster.storageNamespace()); err == nil {
+		cephfs, filesystem := newCephFS(cluster), cluster.environment().defaultFilesystem
+		peers, err := cephfs.Peers(filesystem)
+		if err != nil && cephExitCode(err) != cephExitInvalid {
+			return err
+		}
+		for uuid := range peers {
+			if err := cephfs.RemovePeer(filesystem, uuid); err != nil {
+				return err
+			}
+			progress.info("Removed the CephFS mirroring peer %s", uuid)
+		}
+	}
+	if err := patchCephFilesystemMirroring(ctx, cluster, false); err != nil {
+		return err
+	}
+	if dryRun.intercept(cluster, "delete", "CephFilesystemMirror/"+cephFilesystemMirrorName, nil) {
+		return nil
+	}
+	err = cluster.dynamicClient.Resource(cephFilesystemMirrorResource).Namespace(cluster.storageNamespace()).Delete(ctx, cephFilesystemMirrorName, metav1.DeleteOptions{})
+	if err != nil && !kerrors.IsNotFound(err) {
+		return errors.WithMessagef(err, "[%s] Issues when deleting the CephFilesystemMirror", cluster.name)
+	}
+	progress.info("CephFilesystemMirror deleted")
+	return nil
+}
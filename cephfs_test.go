@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestCephFSReplication(t *testing.T) {
+	pv := newCephFSPV("pv-files", "shop", "files", "sub-files", corev1.VolumeBound)
+	cluster, toolbox := newFakeCluster(t, "primary", pv)
+	directory := defaultFilesystemName + ":/volumes/csi/sub-files/0001"
+	backend := replicationFor(context.Background(), cluster)
+
+	if err := backend.enable(context.Background(), pv, mirroringModeJournal); err == nil || !strings.Contains(err.Error(), "snapshots") {
+		t.Errorf("expected journal mirroring to be rejected, got %v", err)
+	}
+	if err := backend.enable(context.Background(), pv, mirroringModeSnapshot); err != nil {
+		t.Fatalf("enable failed: %s", err)
+	}
+	if toolbox.directories[directory] != cephfsMirrorStateMapped || toolbox.cephfsSchedules["/volumes/csi/sub-files/0001"] != defaultCephFSSnapshotInterval {
+		t.Errorf("expected the directory to be mirrored and snapshotted, got %v and %v", toolbox.directories, toolbox.cephfsSchedules)
+	}
+	if rbd := toolbox.commandsContaining("rbd "); len(rbd) != 0 {
+		t.Errorf("expected no rbd commands for a CephFS PV, got %v", rbd)
+	}
+	// enabling twice is fine, the directory is already tracked
+	if err := backend.promote(context.Background(), pv, false); err != nil {
+		t.Errorf("promote failed: %s", err)
+	}
+	if status, err := getMirrorStatus(cluster, pv); err != nil || status.State != cephfsMirrorStateMapped {
+		t.Errorf("expected the mapped state, got %+v (%v)", status, err)
+	}
+
+	if err := backend.demote(context.Background(), pv); err != nil {
+		t.Fatalf("demote failed: %s", err)
+	}
+	if len(toolbox.directories) != 0 || len(toolbox.cephfsSchedules) != 0 {
+		t.Errorf("expected the directory and its schedule to be removed, got %v and %v", toolbox.directories, toolbox.cephfsSchedules)
+	}
+	if err := backend.disable(context.Background(), pv); err != nil {
+		t.Errorf("expected disabling an untracked directory to succeed, got %s", err)
+	}
+	if status, err := getMirrorStatus(cluster, pv); err != nil || status.State != rbdMirrorStateDisabled {
+		t.Errorf("expected the disabled state, got %+v (%v)", status, err)
+	}
+	if err := backend.resync(context.Background(), pv); err == nil {
+		t.Error("expected resync to be rejected for CephFS")
+	}
+}
+
+func TestSyncPVsCephFS(t *testing.T) {
+	from, fromToolbox := newFakeCluster(t, "primary",
+		newCephFSPV("pv-files", "shop", "files", "sub-files", corev1.VolumeBound),
+		newCephFSPV("pv-scratch", "shop", "scratch", "sub-scratch", corev1.VolumeBound),
+	)
+	fromToolbox.directories[defaultFilesystemName+":/volumes/csi/sub-files/0001"] = cephfsMirrorStateMapped
+	to, toToolbox := newFakeCluster(t, "secondary")
+
+	if err := syncPVs(from, to); err != nil {
+		t.Fatalf("syncPVs failed: %s", err)
+	}
+	replica, err := to.typedClient.CoreV1().PersistentVolumes().Get(context.TODO(), "pv-files", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	attributes := replica.Spec.CSI.VolumeAttributes
+	if !isCephFSReplica(replica) || attributes["rootPath"] != "/volumes/csi/sub-files/0001" || attributes["subvolumeName"] != "sub-files" {
+		t.Errorf("expected a static PV of the mirrored directory, got %v", attributes)
+	}
+	if len(toToolbox.commands) != 0 {
+		t.Errorf("expected no commands in the secondary cluster, got %v", toToolbox.commands)
+	}
+
+	// The replica is not mirrored from the secondary, but must not be removed as dangling
+	replica.Status.Phase = corev1.VolumeReleased
+	if _, err := to.typedClient.CoreV1().PersistentVolumes().UpdateStatus(context.TODO(), replica, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := syncPVs(from, to); err != nil {
+		t.Fatalf("second syncPVs failed: %s", err)
+	}
+	names := listPVNames(t, to)
+	sort.Strings(names)
+	if !reflect.DeepEqual(names, []string{"pv-files"}) {
+		t.Errorf("expected only the replica in the secondary cluster, got %v", names)
+	}
+	if status, err := getMirrorStatus(to, replica); err != nil || status.State != cephfsMirrorStateReplica {
+		t.Errorf("expected the replica state, got %+v (%v)", status, err)
+	}
+}
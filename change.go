@@ -6,80 +6,135 @@ import (
 	"encoding/json"
 	"fmt"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/pkg/errors"
-	"github.com/rivo/tview"
 	"github.com/tidwall/sjson"
 	velerov1 "github.com/vmware-tanzu/velero/pkg/apis/velero/v1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	types "k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/remotecommand"
+	utilexec "k8s.io/client-go/util/exec"
 	"k8s.io/kubectl/pkg/scheme"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
-func executeInPod(cluster kubeAccess, pod *corev1.Pod, command string) (stdout string, stderr string, err error) {
+// podExecutor runs a command in the first container of a Pod
+type podExecutor interface {
+	execute(pod *corev1.Pod, command []string) (stdout string, stderr string, err error)
+}
+
+// toolboxRunner runs Ceph commands like rbd in the rook-ceph-tools Pod
+type toolboxRunner interface {
+	run(command string) (stdout string, stderr string, err error)
+}
+
+// spdyExecutor uses the exec subresource of the API server
+type spdyExecutor struct {
+	typedClient kubernetes.Interface
+	restConfig  *rest.Config
+}
+
+func (e spdyExecutor) execute(pod *corev1.Pod, command []string) (string, string, error) {
 	stdoutBuf := &bytes.Buffer{}
 	stderrBuf := &bytes.Buffer{}
-	// actualCommand := []string{"/bin/sh", "-c", "'", command, "'"}
-	actualCommand := strings.Split(command, " ")
-	request := cluster.typedClient.CoreV1().RESTClient().
+	request := e.typedClient.CoreV1().RESTClient().
 		Post().
 		Namespace(pod.Namespace).
 		Resource("pods").
 		Name(pod.Name).
 		SubResource("exec").
 		VersionedParams(&corev1.PodExecOptions{
-			Command: actualCommand,
+			Command: command,
 			Stdin:   false,
 			Stdout:  true,
 			Stderr:  true,
 			// TTY:     true,
 		}, scheme.ParameterCodec)
-	exec, err := remotecommand.NewSPDYExecutor(&cluster.restConfig, "POST", request.URL())
+	exec, err := remotecommand.NewSPDYExecutor(e.restConfig, "POST", request.URL())
 	if err != nil {
-		return "", "", errors.Wrapf(err, "Could not upgrade connection to %s/%s", strings.Join(actualCommand, " "), pod.Namespace, pod.Name)
+		return "", "", errors.Wrapf(err, "Could not upgrade connection for %s to %s/%s", strings.Join(command, " "), pod.Namespace, pod.Name)
 	}
 	err = exec.Stream(remotecommand.StreamOptions{
 		Stdout: stdoutBuf,
 		Stderr: stderrBuf,
 	})
-	stdout = stdoutBuf.String()
-	stderr = stderrBuf.String()
-	if err != nil {
-		log.WithError(err).WithField("stdout", stdout).WithField("stderr", stderr).WithField("command", command).Debug("PROBLEM")
-		return stdout, stderr, errors.Wrapf(err, "Failed executing command '%s' on %s/%s", strings.Join(actualCommand, " "), pod.Namespace, pod.Name)
-	}
+	return stdoutBuf.String(), stderrBuf.String(), err
+}
 
-	return stdout, stderr, nil
+// toolboxPodRunner executes the commands in the rook-ceph-tools Pod.
+// The Pod is looked up once and again only when it could not be reached any more, e.g. after it was rescheduled.
+type toolboxPodRunner struct {
+	typedClient kubernetes.Interface
+	namespace   string
+	executor    podExecutor
+	mu          sync.Mutex
+	pod         *corev1.Pod
 }
 
-func executeInToolbox(cluster kubeAccess, command string) (string, string, error) {
-	toolBoxPod, err := getToolsPod(cluster)
-	if err != nil {
-		return "", "", err
+func (r *toolboxPodRunner) toolsPod(refresh bool) (*corev1.Pod, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.namespace == "" {
+		return nil, errors.New("the storage namespace is not known, the storage environment of the cluster was not detected")
 	}
-	log.WithField("podname", toolBoxPod.Name).Debug("Pod found")
+	if r.pod == nil || refresh {
+		pod, err := getToolsPod(r.typedClient, r.namespace)
+		if err != nil {
+			r.pod = nil
+			return nil, err
+		}
+		log.WithField("podname", pod.Name).Debug("Pod found")
+		r.pod = &pod
+	}
+	return r.pod, nil
+}
 
-	stdout, stderr, err := executeInPod(cluster, &toolBoxPod, command)
+func (r *toolboxPodRunner) run(command string) (string, string, error) {
+	toolBoxPod, err := r.toolsPod(false)
 	if err != nil {
+		return "", "", err
+	}
+	stdout, stderr, err := executeWith(r.executor, toolBoxPod, command)
+	var exitErr utilexec.ExitError
+	if err == nil || errors.As(err, &exitErr) {
 		return stdout, stderr, err
 	}
-	if stderr != "" {
-		log.WithField("command", command).WithField("stderr", stderr).Warn("Command executed with error")
-		return stdout, stderr, errors.New("Command executed with error")
+	// The command did not run at all, retry once if the cached Pod was replaced
+	newToolBoxPod, refreshErr := r.toolsPod(true)
+	if refreshErr != nil || newToolBoxPod.UID == toolBoxPod.UID {
+		return stdout, stderr, err
+	}
+	return executeWith(r.executor, newToolBoxPod, command)
+}
+
+func executeInPod(cluster kubeAccess, pod *corev1.Pod, command string) (stdout string, stderr string, err error) {
+	return executeWith(cluster.executor, pod, command)
+}
+
+func executeWith(executor podExecutor, pod *corev1.Pod, command string) (stdout string, stderr string, err error) {
+	// actualCommand := []string{"/bin/sh", "-c", "'", command, "'"}
+	actualCommand := strings.Split(command, " ")
+	stdout, stderr, err = executor.execute(pod, actualCommand)
+	if err != nil {
+		log.WithError(err).WithField("stdout", stdout).WithField("stderr", stderr).WithField("command", command).Debug("PROBLEM")
+		return stdout, stderr, errors.Wrapf(err, "Failed executing command '%s' on %s/%s", strings.Join(actualCommand, " "), pod.Namespace, pod.Name)
 	}
-	log.WithField("stdout", stdout).WithField("stderr", stderr).Trace("EXECUTE!")
+
 	return stdout, stderr, nil
 }
 
-func getToolsPod(cluster kubeAccess) (corev1.Pod, error) {
-	list, err := cluster.typedClient.CoreV1().Pods(ocsNamespace).List(context.TODO(), metav1.ListOptions{LabelSelector: "app=rook-ceph-tools"})
-	if err != nil || len(list.Items) == 0 {
-		errors.Wrapf(err, "error when looking for tools pod in %s namespace", ocsNamespace)
-		return corev1.Pod{}, err
+func getToolsPod(typedClient kubernetes.Interface, namespace string) (corev1.Pod, error) {
+	list, err := typedClient.CoreV1().Pods(namespace).List(context.TODO(), metav1.ListOptions{LabelSelector: "app=rook-ceph-tools"})
+	if err != nil {
+		return corev1.Pod{}, errors.Wrapf(err, "error when looking for tools pod in %s namespace", namespace)
+	}
+	if len(list.Items) == 0 {
+		return corev1.Pod{}, errors.Errorf("no tools pod found in %s namespace", namespace)
 	}
 	if len(list.Items) > 1 {
 		return corev1.Pod{}, errors.New("more than one tools pod found")
@@ -88,16 +143,23 @@ func getToolsPod(cluster kubeAccess) (corev1.Pod, error) {
 
 }
 
+// networkDiagnosticsNamespace holds the network-check-target Pods of OpenShift, which run on every node
+const networkDiagnosticsNamespace = "openshift-network-diagnostics"
+
 func getNetworkCheckPods(cluster kubeAccess) (*corev1.PodList, error) {
-	list, err := cluster.typedClient.CoreV1().Pods("openshift-network-diagnostics").List(context.TODO(), metav1.ListOptions{LabelSelector: "app=network-check-target"})
-	if err != nil || len(list.Items) == 0 {
-		errors.Wrapf(err, "could not find network-check pod in %s namespace", "openshift-network-diagnostics")
-		return &corev1.PodList{}, err
+	list, err := cluster.typedClient.CoreV1().Pods(networkDiagnosticsNamespace).List(context.TODO(), metav1.ListOptions{LabelSelector: "app=network-check-target"})
+	if err != nil {
+		return nil, errors.Wrapf(err, "[%s] could not list the network-check-target Pods in the %s namespace", cluster.name, networkDiagnosticsNamespace)
+	}
+	if len(list.Items) == 0 {
+		return nil, errors.Errorf("[%s] no network-check-target Pod found in the %s namespace", cluster.name, networkDiagnosticsNamespace)
 	}
 	return list, nil
 }
 
-func checkNetworkBetweenClusters(from, to kubeAccess) error {
+// checkNetworkBetweenClusters curls the network-check-target Pods of the to cluster from every running
+// network-check-target Pod of the from cluster, so that a single node without a route is found as well
+func checkNetworkBetweenClusters(ctx context.Context, from, to kubeAccess) error {
 	networkCheckPodsSource, err := getNetworkCheckPods(from)
 	if err != nil {
 		return err
@@ -109,7 +171,7 @@ func checkNetworkBetweenClusters(from, to kubeAccess) error {
 
 	var ips []string
 	for _, targetPod := range networkCheckPodsTarget.Items {
-		if targetPod.Status.PodIP == "" {
+		if targetPod.Status.Phase != corev1.PodRunning || targetPod.Status.PodIP == "" {
 			continue
 		}
 		ips = append(ips, targetPod.Status.PodIP)
@@ -117,18 +179,37 @@ func checkNetworkBetweenClusters(from, to kubeAccess) error {
 	if len(ips) == 0 {
 		return errors.Errorf("Could not find any IPs to connect to in the %s cluster", to.name)
 	}
-	log.Infof("Checking network from %s to %s - found %d IPs to check", from.name, to.name, len(ips))
-
-	for _, ip := range ips {
-		stdout, stderr, err := executeInPod(from, &networkCheckPodsSource.Items[0], fmt.Sprintf("curl --silent --fail %s:8080", ip))
-		if err != nil {
-			return err
+	var sources []*corev1.Pod
+	for i := range networkCheckPodsSource.Items {
+		if networkCheckPodsSource.Items[i].Status.Phase == corev1.PodRunning {
+			sources = append(sources, &networkCheckPodsSource.Items[i])
 		}
-		if stderr != "" {
-			log.WithField("command", fmt.Sprintf("curl %s", ip)).WithField("stderr", stderr).Warn("Command executed with error")
-			return errors.Errorf("Command %s executed with error.\nStderr %s\nStdout %s", fmt.Sprintf("curl %s", ip), stderr, stdout)
+	}
+	if len(sources) == 0 {
+		return errors.Errorf("Could not find a running network-check-target Pod to connect from in the %s cluster", from.name)
+	}
+	log.Infof("Checking network from %d Pods in %s to %s - found %d IPs to check", len(sources), from.name, to.name, len(ips))
+
+	var failed []string
+	for _, source := range sources {
+		for _, ip := range ips {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			stdout, stderr, err := executeInPod(from, source, fmt.Sprintf("curl --silent --fail %s:8080", ip))
+			if err == nil && stderr != "" {
+				err = errors.Errorf("Command curl %s executed with error.\nStderr %s\nStdout %s", ip, stderr, stdout)
+			}
+			if err != nil {
+				log.WithError(err).Warnf("Network check from %s/%s to %s failed", from.name, source.Name, ip)
+				failed = append(failed, fmt.Sprintf("%s -> %s: %s", source.Name, ip, err))
+				continue
+			}
+			log.WithField("stdout", stdout).WithField("stderr", stderr).Trace("EXECUTE!")
 		}
-		log.WithField("stdout", stdout).WithField("stderr", stderr).Trace("EXECUTE!")
+	}
+	if len(failed) > 0 {
+		return errors.Errorf("%d of %d connections from %s to %s failed:\n%s", len(failed), len(sources)*len(ips), from.name, to.name, strings.Join(failed, "\n"))
 	}
 	log.Infof("Network check from %s to %s was successful", from.name, to.name)
 
@@ -153,57 +234,85 @@ func showRBDInfo(cluster kubeAccess, pv *corev1.PersistentVolume) error {
 	if err != nil {
 		return err
 	}
-	command := fmt.Sprintf("rbd -p %s info %s", poolName, rbdName)
-	stdout, stderr, err := executeInToolbox(cluster, command)
-	// Catch error later, since exit code 22 is thrown when image is not enabled
-	if strings.Contains(stderr, "mirroring not enabled on the image") {
-		showAlert("mirroring is not enabled on this PVC")
-		return errors.New("mirroring is not enabled on this PVC")
-	}
+	rbd := newRBD(cluster)
+	info, err := rbd.Info(poolName, rbdName)
 	if err != nil {
-		showAlert("could not get RBD mirror info from PV")
-		return errors.Wrapf(err, "could not get RBD mirror info from PV")
+		showAlert("could not get RBD info from PV")
+		return errors.WithMessagef(err, "could not get RBD info from PV %s", pv.Name)
+	}
+	text := &strings.Builder{}
+	fmt.Fprintf(text, "Image:    %s/%s\n", poolName, info.Name)
+	fmt.Fprintf(text, "Size:     %d MiB\n", info.Size/1024/1024)
+	fmt.Fprintf(text, "Created:  %s\n", info.CreateTimestamp)
+	fmt.Fprintf(text, "Features: %s\n", strings.Join(info.Features, ", "))
+	if info.Mirroring == nil || info.Mirroring.State != "enabled" {
+		fmt.Fprintf(text, "\nMirroring is not enabled on this PVC\n")
+	} else {
+		fmt.Fprintf(text, "\nMirroring: %s mode, primary: %t\n", info.Mirroring.Mode, info.Mirroring.Primary)
+		status, err := rbd.MirrorImageStatus(poolName, rbdName)
+		if err != nil {
+			fmt.Fprintf(text, "Could not get the mirror status: %s\n", err)
+		} else {
+			fmt.Fprintf(text, "State:     %s (%s)\n", status.displayState(), status.Description)
+			for _, peer := range status.PeerSites {
+				fmt.Fprintf(text, "Peer %s: %s (%s)\n", peer.SiteName, peer.State, peer.Description)
+			}
+		}
 	}
 	buttons := make(map[string]func())
 	buttons["Close"] = func() { pages.RemovePage("mirrorInfo") }
-	showInfo("mirrorInfo", stdout, buttons)
+	showInfo("mirrorInfo", text.String(), buttons)
 	return nil
 }
 
-func demotePV(cluster kubeAccess, pv *corev1.PersistentVolume) error {
-	rbdName, poolName, err := getRBDInfoFromPV(pv)
+func showCephFSInfo(cluster kubeAccess, pv *corev1.PersistentVolume) error {
+	fsName, subvolumeName, err := getCephFSInfoFromPV(pv)
 	if err != nil {
 		return err
 	}
-	// rbd -p replicapool mirror image demote csi-vol-94953897-88fc-11eb-b175-0a580a061092
-	command := fmt.Sprintf("rbd -p %s mirror image demote %s", poolName, rbdName)
-	_, stderr, err := executeInToolbox(cluster, command)
-	// Catch error later, since exit code 22 is thrown when image is not enabled
-	if strings.Contains(stderr, "mirroring not enabled on the image") {
-		showAlert("mirroring is not enabled on this PVC")
-		return errors.WithMessagef(err, "mirroring is not enabled on this PV", pv.Name)
+	path, err := cephfsPath(cluster, pv)
+	if err != nil {
+		showAlert("could not get the CephFS path from PV")
+		return errors.WithMessagef(err, "could not get the CephFS path from PV %s", pv.Name)
+	}
+	text := &strings.Builder{}
+	fmt.Fprintf(text, "Filesystem: %s\n", fsName)
+	fmt.Fprintf(text, "Subvolume:  %s\n", subvolumeName)
+	fmt.Fprintf(text, "Directory:  %s\n", path)
+	status, err := cephfsMirrorStatus(newCephFS(cluster), pv)
+	switch {
+	case err != nil:
+		fmt.Fprintf(text, "\nCould not get the mirror status: %s\n", err)
+	case status.State == rbdMirrorStateDisabled:
+		fmt.Fprintf(text, "\nMirroring is not enabled on this PVC\n")
+	case status.State == cephfsMirrorStateReplica:
+		fmt.Fprintf(text, "\nThe directory is mirrored from the peer cluster\n")
+	default:
+		fmt.Fprintf(text, "\nMirroring: snapshots every %s\n", defaultCephFSSnapshotInterval)
+		fmt.Fprintf(text, "State:      %s (%s)\n", status.State, status.Description)
 	}
-	return err
+	buttons := make(map[string]func())
+	buttons["Close"] = func() { pages.RemovePage("mirrorInfo") }
+	showInfo("mirrorInfo", text.String(), buttons)
+	return nil
 }
 
-func promotePV(cluster kubeAccess, pv *corev1.PersistentVolume) error {
-	rbdName, poolName, err := getRBDInfoFromPV(pv)
-	if err != nil {
-		return err
-	}
-	command := fmt.Sprintf("rbd -p %s mirror image promote %s", poolName, rbdName)
-	_, stderr, err := executeInToolbox(cluster, command)
-	// Catch error later, since exit code 22 is thrown when image is not enabled
-	if strings.Contains(stderr, "mirroring not enabled on the image") {
-		showAlert("mirroring is not enabled on this PVC")
-		return errors.WithMessagef(err, "mirroring is not enabled on this PV", pv.Name)
-	}
-	return err
+// backupSettings control the OADP backup Schedule of the mirrored namespaces
+type backupSettings struct {
+	// Schedule is the cron expression of the Velero Schedule
+	Schedule string
+	// TTL is the time the Backups are kept
+	TTL time.Duration
+}
+
+var defaultBackupSettings = backupSettings{
+	Schedule: "*/10 * * * *", // every 10 minutes
+	TTL:      8 * time.Hour,
 }
 
-func setNamespacesToBackup(cluster kubeAccess, namespaces []string) {
+func setNamespacesToBackup(cluster kubeAccess, namespaces []string, settings backupSettings) error {
 	if !checkForOADP(cluster) {
-		return
+		return nil
 	}
 	snapshotVolumeSetting := false
 	scheduleCR := velerov1.Schedule{
@@ -220,24 +329,27 @@ func setNamespacesToBackup(cluster kubeAccess, namespaces []string) {
 				IncludedNamespaces: namespaces,
 				ExcludedResources:  []string{"imagetags.image.openshift.io"},
 				SnapshotVolumes:    &snapshotVolumeSetting,
-				TTL:                metav1.Duration{8 * time.Hour},
+				TTL:                metav1.Duration{Duration: settings.TTL},
 				StorageLocation:    "default",
 			},
-			Schedule: "*/10 * * * *", // every 10 minutes
+			Schedule: settings.Schedule,
 		},
 	}
 
 	if err := velerov1.AddToScheme(cluster.controllerClient.Scheme()); err != nil {
-		log.WithError(err).Warn("[%s] Issues when adding velero schemas", cluster.name)
+		log.WithError(err).Warnf("[%s] Issues when adding velero schemas", cluster.name)
 	}
 
 	backupScheduleJSON, err := json.Marshal(scheduleCR)
 	if err != nil {
-		log.WithError(err).Warn("[%s] Issues when converting Backup CR to JSON")
-		showAlert("The OADP Backup plan might not have been updated properly")
+		return protectionProgress.forCluster(cluster.name).failed("backup-schedule", err,
+			"Issues when converting Backup CR to JSON, the OADP Backup plan might not have been updated properly")
 	}
 
 	backupSchedulePatchedJSON, _ := sjson.Delete(string(backupScheduleJSON), "spec.ttl")
+	if dryRun.intercept(cluster, "patch", "oadp-operator/Schedule/regional-dr-backup", backupSchedulePatchedJSON) {
+		return nil
+	}
 
 	err = cluster.controllerClient.Patch(context.TODO(),
 		&scheduleCR,
@@ -245,12 +357,13 @@ func setNamespacesToBackup(cluster kubeAccess, namespaces []string) {
 		&client.PatchOptions{FieldManager: "RDRhelper"})
 
 	if err != nil {
-		log.WithError(err).Warnf("[%s] Issues when applying Backup CR", cluster.name)
-		showAlert("The OADP Backup plan might not have been updated properly")
+		return protectionProgress.forCluster(cluster.name).failed("backup-schedule", err,
+			"Issues when applying Backup CR, the OADP Backup plan might not have been updated properly")
 	}
+	return nil
 }
 
-func setNamespacesToRestore(cluster kubeAccess, namespaces []string) error {
+func setNamespacesToRestore(ctx context.Context, cluster kubeAccess, namespaces []string) error {
 	if !checkForOADP(cluster) {
 		return errors.New("Cluster has no OADP installed")
 	}
@@ -262,10 +375,13 @@ func setNamespacesToRestore(cluster kubeAccess, namespaces []string) error {
 	// Find the last Backup name
 	// Due to using a Schedule, we will have several Backups that are auto-generated by OADP
 	backupList := velerov1.BackupList{}
-	err := cluster.controllerClient.List(context.TODO(), &backupList, &client.ListOptions{Namespace: "oadp-operator"})
-	if err != nil || len(backupList.Items) == 0 {
+	err := cluster.controllerClient.List(ctx, &backupList, &client.ListOptions{Namespace: "oadp-operator"})
+	if err != nil {
 		return errors.WithMessagef(err, "Issues when listing available Backups")
 	}
+	if len(backupList.Items) == 0 {
+		return errors.Errorf("[%s] No Backups available to restore from", cluster.name)
+	}
 	lastBackup := backupList.Items[0]
 
 	for _, backup := range backupList.Items {
@@ -291,12 +407,15 @@ func setNamespacesToRestore(cluster kubeAccess, namespaces []string) error {
 
 	restoreJSON, err := json.Marshal(restoreCR)
 	if err != nil {
-		return errors.WithMessagef(err, "[%s] Issues when converting Restore CR to JSON")
+		return errors.WithMessagef(err, "[%s] Issues when converting Restore CR to JSON", cluster.name)
 	}
 
 	restorePatchedJSON, _ := sjson.Delete(string(restoreJSON), "spec.ttl")
+	if dryRun.intercept(cluster, "patch", "oadp-operator/Restore/regional-dr-restore", restorePatchedJSON) {
+		return nil
+	}
 
-	err = cluster.controllerClient.Patch(context.TODO(),
+	err = cluster.controllerClient.Patch(ctx,
 		&restoreCR,
 		client.RawPatch(types.ApplyPatchType, []byte(restorePatchedJSON)),
 		&client.PatchOptions{FieldManager: "RDRhelper"})
@@ -307,20 +426,26 @@ func setNamespacesToRestore(cluster kubeAccess, namespaces []string) error {
 	return nil
 }
 
-func waitForRecoveryDone(cluster kubeAccess, failoverLog *tview.TextView) error {
+func waitForRecoveryDone(ctx context.Context, cluster kubeAccess) error {
+	progress := failoverProgress.forCluster(cluster.name)
 	if !checkForOADP(cluster) {
 		return errors.New("Cluster has no OADP installed")
 	}
-	var restoreCR velerov1.Restore
-	for {
-		err := cluster.controllerClient.Get(context.TODO(), types.NamespacedName{Name: "regional-dr-restore", Namespace: "oadp-operator"}, &restoreCR)
+	if dryRun.enabled {
+		progress.info("DRY RUN - not waiting for the restore")
+		return nil
+	}
+	return waitFor(ctx, timeouts().Recovery, fmt.Sprintf("the restore in the %s cluster", cluster.name), func(ctx context.Context) (bool, error) {
+		var restoreCR velerov1.Restore
+		err := cluster.controllerClient.Get(ctx, types.NamespacedName{Name: "regional-dr-restore", Namespace: "oadp-operator"}, &restoreCR)
 		if err != nil {
-			addRowOfTextOutput(failoverLog, "  Error while fetching Retore CR: %s", err)
+			progress.warn("Error while fetching Retore CR: %s", err)
+			return false, nil
 		}
 		if restoreCR.Status.Phase == velerov1.RestorePhaseCompleted {
-			return nil
+			return true, nil
 		}
-		addRowOfTextOutput(failoverLog, "  The restore status is %s", restoreCR.Status.Phase)
-		time.Sleep(5 * time.Second)
-	}
+		progress.info("The restore status is %s", restoreCR.Status.Phase)
+		return false, nil
+	})
 }
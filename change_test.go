@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	velerov1 "github.com/vmware-tanzu/velero/pkg/apis/velero/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+)
+
+func newBackup(name string, created time.Time) *velerov1.Backup {
+	return &velerov1.Backup{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:         "oadp-operator",
+			Name:              name,
+			CreationTimestamp: metav1.NewTime(created),
+		},
+	}
+}
+
+func TestSetNamespacesToRestore(t *testing.T) {
+	velero := newPod("oadp-operator", "velero-1", map[string]string{"component": "velero"}, true, "velero")
+	now := time.Now()
+
+	tests := []struct {
+		name           string
+		objects        []runtime.Object
+		expectedError  bool
+		expectedBackup string
+	}{
+		{
+			name:          "no OADP installed",
+			objects:       []runtime.Object{newBackup("regional-dr-backup-1", now)},
+			expectedError: true,
+		},
+		{
+			name:          "no Backups",
+			objects:       []runtime.Object{velero},
+			expectedError: true,
+		},
+		{
+			name: "latest Backup is restored",
+			objects: []runtime.Object{
+				velero,
+				newBackup("regional-dr-backup-1", now.Add(-20*time.Minute)),
+				newBackup("regional-dr-backup-3", now),
+				newBackup("regional-dr-backup-2", now.Add(-10*time.Minute)),
+			},
+			expectedBackup: "regional-dr-backup-3",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cluster, _ := newFakeCluster(t, "secondary", test.objects...)
+			recorder := &patchRecorder{Client: cluster.controllerClient}
+			cluster.controllerClient = recorder
+
+			err := setNamespacesToRestore(context.Background(), cluster, []string{"shop", "analytics"})
+			if test.expectedError {
+				if err == nil {
+					t.Error("expected an error, got none")
+				}
+				if len(recorder.patched) != 0 {
+					t.Errorf("expected no Restore to be created, got %d", len(recorder.patched))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("setNamespacesToRestore failed: %s", err)
+			}
+			if len(recorder.data) != 1 {
+				t.Fatalf("expected one Restore to be applied, got %d", len(recorder.data))
+			}
+			var restore velerov1.Restore
+			if err = json.Unmarshal([]byte(recorder.data[0]), &restore); err != nil {
+				t.Fatal(err)
+			}
+			if restore.Name != "regional-dr-restore" || restore.Namespace != "oadp-operator" {
+				t.Errorf("unexpected Restore %s/%s", restore.Namespace, restore.Name)
+			}
+			if restore.Spec.BackupName != test.expectedBackup {
+				t.Errorf("expected Backup %s to be restored, got %s", test.expectedBackup, restore.Spec.BackupName)
+			}
+			if !reflect.DeepEqual(restore.Spec.IncludedNamespaces, []string{"shop", "analytics"}) {
+				t.Errorf("unexpected namespaces to restore %v", restore.Spec.IncludedNamespaces)
+			}
+		})
+	}
+}
+
+// countingExecutor fails the exec into Pods that are gone
+type countingExecutor struct {
+	executed map[string]int
+	gone     map[string]bool
+}
+
+func (e *countingExecutor) execute(pod *corev1.Pod, command []string) (string, string, error) {
+	if e.gone[pod.Name] {
+		return "", "", errors.Errorf("pods %q not found", pod.Name)
+	}
+	e.executed[pod.Name]++
+	return "ok", "", nil
+}
+
+func TestToolboxPodRunnerCachesPod(t *testing.T) {
+	toolsLabels := map[string]string{"app": "rook-ceph-tools"}
+	oldPod := newPod(environmentODF.namespace, "rook-ceph-tools-old", toolsLabels, true, "rook-ceph-tools")
+	oldPod.UID = "old"
+	typedClient := k8sfake.NewSimpleClientset(oldPod)
+	executor := &countingExecutor{executed: map[string]int{}, gone: map[string]bool{}}
+	runner := &toolboxPodRunner{typedClient: typedClient, namespace: environmentODF.namespace, executor: executor}
+
+	for i := 0; i < 3; i++ {
+		if _, _, err := runner.run("ceph status"); err != nil {
+			t.Fatalf("run failed: %s", err)
+		}
+	}
+	if lists := len(typedClient.Actions()); lists != 1 {
+		t.Errorf("expected the tools Pod to be looked up once, got %d lookups", lists)
+	}
+
+	// The Pod was rescheduled
+	if err := typedClient.CoreV1().Pods(environmentODF.namespace).Delete(context.TODO(), oldPod.Name, metav1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	replacementPod := newPod(environmentODF.namespace, "rook-ceph-tools-new", toolsLabels, true, "rook-ceph-tools")
+	replacementPod.UID = "new"
+	if _, err := typedClient.CoreV1().Pods(environmentODF.namespace).Create(context.TODO(), replacementPod, metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	executor.gone[oldPod.Name] = true
+
+	if _, _, err := runner.run("ceph status"); err != nil {
+		t.Fatalf("run after the Pod was rescheduled failed: %s", err)
+	}
+	if executor.executed[replacementPod.Name] != 1 || executor.executed[oldPod.Name] != 3 {
+		t.Errorf("unexpected executions %v", executor.executed)
+	}
+}
+
+func TestToolboxPodRunnerNeedsNamespace(t *testing.T) {
+	typedClient := k8sfake.NewSimpleClientset(newPod(environmentODF.namespace, "rook-ceph-tools", map[string]string{"app": "rook-ceph-tools"}, true, "rook-ceph-tools"))
+	executor := &countingExecutor{executed: map[string]int{}, gone: map[string]bool{}}
+	runner := &toolboxPodRunner{typedClient: typedClient, executor: executor}
+
+	if _, _, err := runner.run("ceph status"); err == nil || !strings.Contains(err.Error(), "not detected") {
+		t.Errorf("expected the runner to fail before detection, got %v", err)
+	}
+	if len(executor.executed) != 0 || len(typedClient.Actions()) != 0 {
+		t.Errorf("expected no lookup and no exec, got %v and %d actions", executor.executed, len(typedClient.Actions()))
+	}
+}
+
+func TestCheckNetworkBetweenClustersFromAllPods(t *testing.T) {
+	networkCheckPod := func(name, ip string) *corev1.Pod {
+		pod := newPod(networkDiagnosticsNamespace, name, map[string]string{"app": "network-check-target"}, true, "network-check-target-container")
+		pod.Status.Phase = corev1.PodRunning
+		pod.Status.PodIP = ip
+		return pod
+	}
+	primary, _ := newFakeCluster(t, "primary", networkCheckPod("source-a", "10.0.0.1"), networkCheckPod("source-b", "10.0.0.2"))
+	secondary, _ := newFakeCluster(t, "secondary", networkCheckPod("target-a", "10.1.0.1"), networkCheckPod("target-b", "10.1.0.2"))
+	executor := &countingExecutor{executed: map[string]int{}, gone: map[string]bool{}}
+	primary.executor = executor
+
+	if err := checkNetworkBetweenClusters(context.Background(), primary, secondary); err != nil {
+		t.Fatal(err)
+	}
+	if executor.executed["source-a"] != 2 || executor.executed["source-b"] != 2 {
+		t.Errorf("expected every source Pod to connect to every target, got %v", executor.executed)
+	}
+
+	executor.gone["source-b"] = true
+	if err := checkNetworkBetweenClusters(context.Background(), primary, secondary); err == nil {
+		t.Error("expected the check to fail if a single source Pod cannot connect")
+	}
+}
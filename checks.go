@@ -0,0 +1,357 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// checkSeverity decides whether a failed check stops the operation that runs it
+type checkSeverity string
+
+const (
+	// severityCritical checks stop the operation when they fail
+	severityCritical checkSeverity = "critical"
+	// severityWarning checks are reported as warnings, the operation continues
+	severityWarning checkSeverity = "warning"
+)
+
+// checkPhase is an operation that runs a subset of the checks
+type checkPhase string
+
+const (
+	phaseInstall  checkPhase = "install"
+	phaseVerify   checkPhase = "verify"
+	phaseFailover checkPhase = "failover"
+	// phaseMirroring gates the menu items that work on mirrored PVCs
+	phaseMirroring checkPhase = "mirroring"
+)
+
+// Names of the checks, as they appear in the reports and in skipChecks
+const (
+	checkODFRelease      = "odf-release"
+	checkStorageCluster  = "storage-cluster"
+	checkPodNetwork      = "pod-network"
+	checkCephNetwork     = "ceph-network"
+	checkOMAPGenerator   = "omap-generator"
+	checkRBDMirrorPods   = "rbd-mirror-pods"
+	checkBlockPoolMirror = "blockpool-mirroring"
+	checkOADPOperator    = "oadp-operator"
+)
+
+// checkDefinition is a requirement check in the registry
+type checkDefinition struct {
+	id          string
+	description string
+	severity    checkSeverity
+	phases      []checkPhase
+	// remediation is used for failed results that do not bring their own
+	remediation string
+	// appliesTo decides whether the check applies to a cluster in the storage environment, checks without it
+	// apply to all clusters. Peer checks are skipped if either cluster does not match.
+	appliesTo func(env storageEnvironment) bool
+	// run checks a single cluster
+	run func(cluster kubeAccess) checkResult
+	// runPeer checks from one cluster towards the other one, it is run in both directions of every peering
+	runPeer func(ctx context.Context, from, to kubeAccess) checkResult
+}
+
+// appliesToAll returns true if the check applies to all given clusters, otherwise the environment it does not apply to
+func (c checkDefinition) appliesToAll(clusters ...kubeAccess) (bool, storageEnvironment) {
+	for _, cluster := range clusters {
+		if c.appliesTo != nil && !c.appliesTo(cluster.environment()) {
+			return false, cluster.environment()
+		}
+	}
+	return true, storageEnvironment{}
+}
+
+// internalCeph applies to clusters whose Ceph daemons run inside, in ODF external mode they run outside
+func internalCeph(env storageEnvironment) bool {
+	return !env.external
+}
+
+// internalCephOnOpenShift applies to internal Ceph clusters on OpenShift, which has the network-check-target Pods.
+// The Ceph network check probes from the toolbox and also covers Rook on plain Kubernetes.
+func internalCephOnOpenShift(env storageEnvironment) bool {
+	return internalCeph(env) && env.openshift
+}
+
+func (c checkDefinition) runsIn(phase checkPhase) bool {
+	for _, p := range c.phases {
+		if p == phase {
+			return true
+		}
+	}
+	return false
+}
+
+// checkRegistry holds all checks in the order they are run. Cluster checks run before peer checks.
+var checkRegistry = []checkDefinition{
+	{
+		id:          checkODFRelease,
+		description: "The OCS/ODF release is supported by RDRhelper",
+		severity:    severityCritical,
+		phases:      []checkPhase{phaseInstall, phaseVerify, phaseFailover},
+		run:         verifyODFRelease,
+	},
+	{
+		id:          checkStorageCluster,
+		description: "The StorageCluster, or the CephCluster of Rook, is Ready",
+		severity:    severityCritical,
+		phases:      []checkPhase{phaseInstall},
+		remediation: "Wait until the StorageCluster is Ready, check the ocs-operator or rook-ceph-operator logs otherwise",
+		run:         verifyStorageClusterReady,
+	},
+	{
+		id:          checkOMAPGenerator,
+		description: "The OMAP generator of the CSI driver is running",
+		severity:    severityCritical,
+		phases:      []checkPhase{phaseVerify, phaseFailover, phaseMirroring},
+		run:         verifyOMAPpods,
+	},
+	{
+		id:          checkRBDMirrorPods,
+		description: "The rbd-mirror daemon is running",
+		severity:    severityCritical,
+		phases:      []checkPhase{phaseVerify},
+		appliesTo:   internalCeph,
+		run:         verifyRBDMirrorPods,
+	},
+	{
+		id:          checkBlockPoolMirror,
+		description: "The mirrored Block Pools are healthy",
+		severity:    severityCritical,
+		phases:      []checkPhase{phaseVerify},
+		appliesTo:   internalCeph,
+		run:         verifyCBPmirror,
+	},
+	// The PVCs are mirrored without OADP as well, only the namespaces have to be restored by hand
+	{
+		id:          checkOADPOperator,
+		description: "OADP is installed to back up the namespaces",
+		severity:    severityWarning,
+		phases:      []checkPhase{phaseVerify},
+		run:         verifyOADPOperator,
+	},
+	{
+		id:          checkPodNetwork,
+		description: "The Pod networks of the clusters are connected",
+		severity:    severityCritical,
+		phases:      []checkPhase{phaseInstall},
+		remediation: "Connect the Pod networks of both clusters, e.g. with Submariner",
+		appliesTo:   internalCephOnOpenShift,
+		runPeer:     verifyPodNetwork,
+	},
+	{
+		id:          checkCephNetwork,
+		description: "The Ceph daemons are reachable from the other cluster",
+		severity:    severityCritical,
+		phases:      []checkPhase{phaseInstall, phaseVerify},
+		appliesTo:   internalCeph,
+		runPeer:     verifyCephNetwork,
+	},
+}
+
+// checkIDs returns the IDs of all registered checks
+func checkIDs() []string {
+	var ids []string
+	for _, check := range checkRegistry {
+		ids = append(ids, check.id)
+	}
+	return ids
+}
+
+// findCheck returns the registered check with the ID
+func findCheck(id string) (checkDefinition, bool) {
+	for _, check := range checkRegistry {
+		if check.id == id {
+			return check, true
+		}
+	}
+	return checkDefinition{}, false
+}
+
+// validateSkipChecks makes sure that only registered checks are skipped
+func validateSkipChecks(ids []string) error {
+	known := checkIDs()
+	for _, id := range ids {
+		if !stringInSliceBool(id, known) {
+			sort.Strings(known)
+			return errors.Errorf("unknown check %q, use one of %s", id, strings.Join(known, ", "))
+		}
+	}
+	return nil
+}
+
+// runChecks runs the checks of the phase on all clusters and the peer checks between the peered ones.
+// The checks in appConfig.SkipChecks are reported as skipped. Every result is passed to onResult as soon as it is known.
+func runChecks(ctx context.Context, phase checkPhase, onResult func(checkResult), clusters ...kubeAccess) *verifyReport {
+	report := newVerifyReport()
+	add := func(result checkResult) {
+		report.add(result)
+		if onResult != nil {
+			onResult(result)
+		}
+	}
+	var peerChecks []checkDefinition
+	for _, check := range checkRegistry {
+		if !check.runsIn(phase) {
+			continue
+		}
+		if check.runPeer != nil {
+			peerChecks = append(peerChecks, check)
+			continue
+		}
+		for _, cluster := range clusters {
+			cluster := cluster
+			if applies, env := check.appliesToAll(cluster); !applies {
+				add(skipNotApplicable(check, cluster.name, env))
+				continue
+			}
+			add(runCheck(check, cluster.name, func() checkResult { return check.run(cluster) }))
+		}
+	}
+	for _, check := range peerChecks {
+		for _, from := range clusters {
+			for _, to := range clusters {
+				if !arePeered(from.name, to.name) {
+					continue
+				}
+				if applies, env := check.appliesToAll(from, to); !applies {
+					add(skipNotApplicable(check, from.name, env))
+					continue
+				}
+				from, to := from, to
+				add(runCheck(check, from.name, func() checkResult { return check.runPeer(ctx, from, to) }))
+			}
+		}
+	}
+	return report
+}
+
+// runCheck runs a single check, unless it is skipped, and applies the defaults of its definition
+func runCheck(check checkDefinition, clusterName string, run func() checkResult) checkResult {
+	if stringInSliceBool(check.id, appConfig.SkipChecks) {
+		return checkResult{Check: check.id, Cluster: clusterName, Status: checkSkip, Message: "Skipped as configured in skipChecks"}
+	}
+	result := run()
+	result.Check = check.id
+	if result.Status == checkFail {
+		if result.Remediation == "" {
+			result.Remediation = check.remediation
+		}
+		if check.severity == severityWarning {
+			result.Status = checkWarn
+		}
+	}
+	return result
+}
+
+// skipNotApplicable reports a check that does not apply to clusters in the storage environment
+func skipNotApplicable(check checkDefinition, clusterName string, env storageEnvironment) checkResult {
+	return checkResult{Check: check.id, Cluster: clusterName, Status: checkSkip, Message: fmt.Sprintf("Skipped, the check does not apply to %s", env.name)}
+}
+
+// reportTo returns an onResult function for runChecks that emits every result to progress
+func reportTo(progress reporter) func(checkResult) {
+	return func(result checkResult) {
+		clusterProgress := progress.forCluster(result.Cluster)
+		switch result.Status {
+		case checkPass:
+			clusterProgress.result(result.Check, nil, "%s: %s", result.Check, result.Message)
+		case checkWarn, checkSkip:
+			clusterProgress.warn("%s: %s", result.Check, result.Message)
+		default:
+			details := result.Details
+			if details == "" {
+				details = "check failed"
+			}
+			clusterProgress.result(result.Check, errors.New(details), "%s: %s", result.Check, result.Message)
+		}
+	}
+}
+
+// requireChecks runs the checks of the phase and returns an error with the failed results if any failed
+func requireChecks(ctx context.Context, phase checkPhase, progress reporter, clusters ...kubeAccess) error {
+	report := runChecks(ctx, phase, reportTo(progress), clusters...)
+	if !report.failed() {
+		return nil
+	}
+	text := &strings.Builder{}
+	printVerifyReport(text, report.withStatus(checkFail))
+	return errors.Errorf("The %s requirements are not met:\n%s\nSkip single checks with skipChecks in the config or --skip-checks", phase, text)
+}
+
+// verifyStorageClusterReady checks that the StorageCluster finished its reconciliation, or the CephCluster with upstream Rook
+func verifyStorageClusterReady(cluster kubeAccess) checkResult {
+	result := checkResult{
+		Cluster: cluster.name,
+		Status:  checkFail,
+	}
+	if !cluster.environment().storageCluster {
+		return verifyCephClusterReady(cluster, result)
+	}
+	storageClusterIdentifier := types.NamespacedName{
+		Name:      storageClusterName,
+		Namespace: cluster.storageNamespace(),
+	}
+	status, err := getObjectStatus(storageClusterResource, storageClusterIdentifier, cluster)
+	if err != nil {
+		result.Message = "Issues when checking the StorageCluster status"
+		result.Details = err.Error()
+		return result
+	}
+	if status != "Ready" {
+		result.Message = fmt.Sprintf("StorageCluster is not ready yet - current status is %s", status)
+		return result
+	}
+	result.Status = checkPass
+	result.Message = "ODF StorageCluster is Ready"
+	return result
+}
+
+// verifyCephClusterReady checks that Rook finished the reconciliation of the CephCluster
+func verifyCephClusterReady(cluster kubeAccess, result checkResult) checkResult {
+	ctx, cancel := requestContext(context.Background())
+	defer cancel()
+	phase, err := cephClusterPhase(ctx, cluster)
+	if err != nil {
+		result.Message = "Issues when checking the CephCluster status"
+		result.Details = err.Error()
+		return result
+	}
+	if phase != "Ready" {
+		result.Message = fmt.Sprintf("CephCluster is not ready yet - current phase is %s", phase)
+		return result
+	}
+	result.Status = checkPass
+	result.Message = "Rook CephCluster is Ready"
+	return result
+}
+
+// verifyPodNetwork checks that the network-check-target Pods of the to cluster are reachable from the from cluster
+func verifyPodNetwork(ctx context.Context, from, to kubeAccess) checkResult {
+	result := checkResult{
+		Cluster: from.name,
+		Status:  checkFail,
+	}
+	if err := checkNetworkBetweenClusters(ctx, from, to); err != nil {
+		result.Message = fmt.Sprintf("The Pods of the %s cluster are not reachable", to.name)
+		result.Details = err.Error()
+		return result
+	}
+	result.Status = checkPass
+	result.Message = fmt.Sprintf("The Pods of the %s cluster are reachable", to.name)
+	return result
+}
+
+// mirroringReady returns true if the clusters pass the checks of the menu items that work on mirrored PVCs.
+// The results are not reported, since this runs every time the main menu is shown.
+func mirroringReady(clusters ...kubeAccess) bool {
+	return !runChecks(context.Background(), phaseMirroring, nil, clusters...).failed()
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestRunChecksOfPhase(t *testing.T) {
+	primary, _ := newFakeCluster(t, "primary")
+	secondary, _ := newFakeCluster(t, "secondary")
+
+	var reported []string
+	report := runChecks(context.Background(), phaseFailover, func(result checkResult) { reported = append(reported, result.Check) }, secondary)
+	if expected := "odf-release,omap-generator"; strings.Join(report.checks(), ",") != expected {
+		t.Errorf("expected the failover checks %s, got %v", expected, report.checks())
+	}
+	if len(reported) != len(report.Results) {
+		t.Errorf("expected every result to be passed on, got %v", reported)
+	}
+	if !report.failed() {
+		t.Error("expected the checks of an empty cluster to fail")
+	}
+
+	report = runChecks(context.Background(), phaseInstall, nil, primary, secondary)
+	if result, found := report.get(checkPodNetwork, "primary"); !found || result.Status != checkFail || result.Remediation == "" {
+		t.Errorf("expected the pod network check to fail with the remediation of the registry, got %+v", result)
+	}
+	if len(report.Results) != 8 {
+		t.Errorf("expected 2 checks per cluster and 2 peer checks in both directions, got %d results", len(report.Results))
+	}
+}
+
+func TestSkipChecks(t *testing.T) {
+	defer func() { appConfig.SkipChecks = nil }()
+	secondary, _ := newFakeCluster(t, "secondary")
+
+	appConfig.SkipChecks = []string{checkODFRelease, checkOMAPGenerator}
+	if err := requireChecks(context.Background(), phaseFailover, failoverProgress, secondary); err != nil {
+		t.Errorf("expected skipped checks not to fail, got %v", err)
+	}
+	report := runChecks(context.Background(), phaseFailover, nil, secondary)
+	if result, _ := report.get(checkOMAPGenerator, "secondary"); result.Status != checkSkip {
+		t.Errorf("expected the OMAP generator check to be skipped, got %+v", result)
+	}
+	junit, err := report.toJUnit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(junit), `skipped="2"`) {
+		t.Errorf("expected 2 skipped test cases, got\n%s", junit)
+	}
+
+	appConfig.SkipChecks = []string{checkODFRelease}
+	err = requireChecks(context.Background(), phaseFailover, failoverProgress, secondary)
+	if err == nil || !strings.Contains(err.Error(), "FAIL omap-generator") || strings.Contains(err.Error(), "odf-release") {
+		t.Errorf("expected only the OMAP generator to fail, got %v", err)
+	}
+
+	if err := validateSkipChecks([]string{checkCephNetwork, "ntp"}); err == nil || !strings.Contains(err.Error(), `"ntp"`) {
+		t.Errorf("expected the unknown check to be rejected, got %v", err)
+	}
+}
+
+func TestCheckSeverity(t *testing.T) {
+	registry := checkRegistry
+	defer func() { checkRegistry = registry }()
+	cluster, _ := newFakeCluster(t, "primary")
+
+	checkRegistry = []checkDefinition{
+		{
+			id:          "clock",
+			severity:    severityWarning,
+			phases:      []checkPhase{phaseVerify},
+			remediation: "Configure NTP",
+			run: func(cluster kubeAccess) checkResult {
+				return checkResult{Cluster: cluster.name, Status: checkFail, Message: "clock skew"}
+			},
+		},
+	}
+	report := runChecks(context.Background(), phaseVerify, nil, cluster)
+	if report.failed() {
+		t.Error("expected a failed warning check not to fail the report")
+	}
+	if result, _ := report.get("clock", "primary"); result.Status != checkWarn || result.Remediation != "Configure NTP" {
+		t.Errorf("expected a warning with the remediation of the registry, got %+v", result)
+	}
+	if report := runChecks(context.Background(), phaseInstall, nil, cluster); len(report.Results) != 0 {
+		t.Errorf("expected no checks in the install phase, got %v", report.Results)
+	}
+}
+
+func TestCheckAppliesTo(t *testing.T) {
+	registry := checkRegistry
+	defer func() { checkRegistry = registry }()
+	odf, _ := newFakeCluster(t, "odf")
+	rook, _ := newFakeCluster(t, "rook")
+	useEnvironment(&rook, environmentRook)
+
+	checkRegistry = []checkDefinition{
+		{
+			id:        "storage-cluster-only",
+			severity:  severityCritical,
+			phases:    []checkPhase{phaseVerify},
+			appliesTo: func(env storageEnvironment) bool { return env.storageCluster },
+			run: func(cluster kubeAccess) checkResult {
+				return checkResult{Cluster: cluster.name, Status: checkPass}
+			},
+		},
+	}
+	report := runChecks(context.Background(), phaseVerify, nil, odf, rook)
+	if result, _ := report.get("storage-cluster-only", "odf"); result.Status != checkPass {
+		t.Errorf("expected the check to run on the ODF cluster, got %+v", result)
+	}
+	if result, _ := report.get("storage-cluster-only", "rook"); result.Status != checkSkip || !strings.Contains(result.Message, "Rook") {
+		t.Errorf("expected the check to be skipped on the Rook cluster, got %+v", result)
+	}
+}
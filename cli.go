@@ -0,0 +1,893 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+
+	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const cliUsage = `Usage: RDRhelper [command] [flags]
+
+Without a command, the interactive UI is started.
+
+Commands:
+  verify     Verify the RDR installation of all clusters
+  preflight  Check that the Ceph daemons of each cluster are reachable from the other one
+  install    Install RDR on all peered clusters
+  uninstall  Remove the RDR setup from all clusters
+  pvc        List PVCs or change their mirroring (list, enable, disable, resync)
+  schedule   List or change mirror snapshot schedules (list, set, add, remove)
+  failover   Failover (or failback) namespaces to the other cluster
+  plan       Show (diff) or reconcile (apply) a protection plan file
+  controller Continuously reconcile a protection policy, to run inside a cluster
+  serve      Expose mirroring and backup health as Prometheus metrics
+
+With clusters configured by name, --source and --target choose the clusters that the
+commands use as primary and secondary cluster.
+
+Use "RDRhelper [command] -h" for the flags of a command.
+`
+
+// Exit codes of the CLI subcommands
+const (
+	exitOK    = 0
+	exitError = 1
+	exitUsage = 2
+)
+
+// clusterFlags holds the kubeconfig, replication and check overrides every subcommand understands
+type clusterFlags struct {
+	primaryKubeConfig   string
+	secondaryKubeConfig string
+	replication         string
+	skipChecks          string
+	source              string
+	target              string
+}
+
+func (c *clusterFlags) register(flags *flag.FlagSet) {
+	flags.StringVar(&c.primaryKubeConfig, "primary-kubeconfig", "", "path to the kubeconfig of the primary cluster (default from config)")
+	flags.StringVar(&c.secondaryKubeConfig, "secondary-kubeconfig", "", "path to the kubeconfig of the secondary cluster (default from config)")
+	flags.StringVar(&c.replication, "replication", "", "how PVs are enabled, promoted and demoted: auto, toolbox or VolumeReplication (default from config or auto)")
+	flags.StringVar(&c.skipChecks, "skip-checks", "", "comma separated IDs of requirement checks to skip (default from config)")
+	flags.StringVar(&c.source, "source", "", "name of the configured cluster to use as primary (default from the first peering)")
+	flags.StringVar(&c.target, "target", "", "name of the configured cluster to use as secondary (default from the first peering)")
+}
+
+// pairSelected returns true if the clusters to work on were chosen with --source or --target
+func (c *clusterFlags) pairSelected() bool {
+	return c.source != "" || c.target != ""
+}
+
+// load reads the RDRhelper config, applies the overrides and makes sure the primary and secondary cluster are reachable
+func (c *clusterFlags) load() error {
+	readConfig()
+	subscribeEventLog()
+	if c.replication != "" {
+		appConfig.Replication = c.replication
+	}
+	if err := validateReplication(appConfig.Replication); err != nil {
+		return err
+	}
+	if c.skipChecks != "" {
+		appConfig.SkipChecks = strings.Split(c.skipChecks, ",")
+	}
+	if err := validateSkipChecks(appConfig.SkipChecks); err != nil {
+		return err
+	}
+	if c.primaryKubeConfig != "" {
+		primaryKubeConfChanged(c.primaryKubeConfig)
+	}
+	if c.secondaryKubeConfig != "" {
+		secondaryKubeConfChanged(c.secondaryKubeConfig)
+	}
+	if c.pairSelected() {
+		if !namedSites() {
+			return errors.New("--source and --target need clusters in the config")
+		}
+		source, target := c.source, c.target
+		if source == "" {
+			source = kubeConfigPrimary.name
+		}
+		if target == "" {
+			target = kubeConfigSecondary.name
+		}
+		if err := selectSites(source, target); err != nil {
+			return err
+		}
+	}
+	if kubeConfigPrimary.path == "" {
+		return errors.New("no valid kubeconfig for the primary cluster configured")
+	}
+	if kubeConfigSecondary.path == "" {
+		return errors.New("no valid kubeconfig for the secondary cluster configured")
+	}
+	return nil
+}
+
+// dryRunFlags are understood by all subcommands that change the clusters
+type dryRunFlags struct {
+	enabled    bool
+	planOutput string
+}
+
+func (d *dryRunFlags) register(flags *flag.FlagSet) {
+	flags.BoolVar(&d.enabled, "dry-run", false, "only record the changes and print them as plan")
+	flags.StringVar(&d.planOutput, "plan-output", "", "with --dry-run, also write the plan as JSON to this file")
+}
+
+func (d *dryRunFlags) start() {
+	dryRun.enabled = d.enabled
+	dryRun.start()
+}
+
+// finish prints the recorded plan and passes through the exit code of the command
+func (d *dryRunFlags) finish(exitCode int) int {
+	if !d.enabled {
+		return exitCode
+	}
+	dryRun.print(os.Stdout)
+	if d.planOutput != "" {
+		if err := dryRun.writePlan(d.planOutput); err != nil {
+			return cliFail(err)
+		}
+	}
+	return exitCode
+}
+
+// progressFlags select how the progress of the subcommands that change the clusters is printed
+type progressFlags struct {
+	format string
+}
+
+func (p *progressFlags) register(flags *flag.FlagSet) {
+	flags.StringVar(&p.format, "progress", "text", "format of the progress output: text or json")
+}
+
+// start prints the progress events to stdout in the chosen format
+func (p *progressFlags) start() error {
+	switch p.format {
+	case "text":
+		events.subscribe(textSink(os.Stdout))
+	case "json":
+		events.subscribe(jsonSink(os.Stdout))
+	default:
+		return errors.Errorf("Unknown progress format %q, use text or json", p.format)
+	}
+	return nil
+}
+
+func runCLI(args []string) int {
+	headless = true
+
+	switch args[0] {
+	case "verify":
+		return cliVerify(args[1:])
+	case "install":
+		return cliInstall(args[1:])
+	case "uninstall":
+		return cliUninstall(args[1:])
+	case "preflight":
+		return cliPreflight(args[1:])
+	case "pvc":
+		return cliPVC(args[1:])
+	case "schedule":
+		return cliSchedule(args[1:])
+	case "failover":
+		return cliFailover(args[1:])
+	case "plan":
+		return cliPlan(args[1:])
+	case "controller":
+		return cliController(args[1:])
+	case "serve":
+		return cliServe(args[1:])
+	case "help", "-h", "--help":
+		fmt.Print(cliUsage)
+		return exitOK
+	}
+	fmt.Fprintf(os.Stderr, "Unknown command %q\n\n%s", args[0], cliUsage)
+	return exitUsage
+}
+
+// cliFail prints the error and returns the matching exit code
+func cliFail(err error) int {
+	log.WithError(err).Error("Command failed")
+	fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
+	return exitError
+}
+
+func cliVerify(args []string) int {
+	var clusterFlags clusterFlags
+	var format, outputPath string
+	flags := flag.NewFlagSet("verify", flag.ContinueOnError)
+	clusterFlags.register(flags)
+	flags.StringVar(&format, "format", "text", "output format of the report: text, json or junit")
+	flags.StringVar(&outputPath, "output", "", "write the report to this file instead of stdout")
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+	if format != "text" && format != "json" && format != "junit" {
+		fmt.Fprintf(os.Stderr, "Unknown format %q, use text, json or junit\n", format)
+		return exitUsage
+	}
+	if err := clusterFlags.load(); err != nil {
+		return cliFail(err)
+	}
+
+	clusters := allSites()
+	if clusterFlags.pairSelected() {
+		clusters = []kubeAccess{kubeConfigPrimary, kubeConfigSecondary}
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	report := runVerifyChecks(ctx, clusters...)
+	if err := writeVerifyReport(report, format, outputPath); err != nil {
+		return cliFail(err)
+	}
+	if report.failed() {
+		return exitError
+	}
+	return exitOK
+}
+
+func cliPreflight(args []string) int {
+	var clusterFlags clusterFlags
+	var format string
+	flags := flag.NewFlagSet("preflight", flag.ContinueOnError)
+	clusterFlags.register(flags)
+	flags.StringVar(&format, "format", "text", "output format of the endpoint results: text or json")
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+	if format != "text" && format != "json" {
+		fmt.Fprintf(os.Stderr, "Unknown format %q, use text or json\n", format)
+		return exitUsage
+	}
+	if err := clusterFlags.load(); err != nil {
+		return cliFail(err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	probes, err := probeCephNetworkBothWays(ctx, kubeConfigPrimary, kubeConfigSecondary)
+	if format == "json" {
+		content, jsonErr := json.MarshalIndent(probes, "", "  ")
+		if jsonErr != nil {
+			return cliFail(jsonErr)
+		}
+		fmt.Println(string(content))
+	} else {
+		fmt.Print(summarizeProbes(probes))
+	}
+	if err != nil {
+		return cliFail(err)
+	}
+	if len(unreachable(probes)) > 0 {
+		return exitError
+	}
+	return exitOK
+}
+
+func writeVerifyReport(report *verifyReport, format, outputPath string) error {
+	var output io.Writer = os.Stdout
+	if outputPath != "" {
+		f, err := os.Create(outputPath)
+		if err != nil {
+			return errors.Wrapf(err, "could not create report file %s", outputPath)
+		}
+		defer f.Close()
+		output = f
+	}
+	var content []byte
+	var err error
+	switch format {
+	case "json":
+		content, err = report.toJSON()
+	case "junit":
+		content, err = report.toJUnit()
+	default:
+		printVerifyReport(output, report)
+		return nil
+	}
+	if err != nil {
+		return errors.Wrapf(err, "could not convert the report to %s", format)
+	}
+	_, err = fmt.Fprintln(output, string(content))
+	return err
+}
+
+func cliInstall(args []string) int {
+	var clusterFlags clusterFlags
+	var skipOADP, skipCephFS bool
+	// The S3 flags override the values from the config
+	var s3Overrides s3information
+	// The pool flags override the values from the config
+	var poolOverrides poolSettings
+	var snapshotSchedules, storageClassParameters, mirroringMode string
+	var dryRunFlags dryRunFlags
+	var progressFlags progressFlags
+	flags := flag.NewFlagSet("install", flag.ContinueOnError)
+	clusterFlags.register(flags)
+	dryRunFlags.register(flags)
+	progressFlags.register(flags)
+	flags.BoolVar(&useNewBlockPoolForMirroring, "dedicated-pool", false, "use a dedicated block pool for mirroring instead of the default one")
+	flags.BoolVar(&skipOADP, "skip-oadp", false, "do not install OADP for CR backups")
+	flags.BoolVar(&skipCephFS, "skip-cephfs", false, "do not set up the mirroring of the CephFilesystem (default from the ODF release, skipped for OCS 4.7 and 4.8)")
+	flags.BoolVar(&restartInstall, "restart", false, "run all steps again, also the ones that finished in an earlier install")
+	flags.StringVar(&s3Overrides.S3keyID, "s3-key-id", "", "s3 access key ID (default from config)")
+	flags.StringVar(&s3Overrides.S3keySecret, "s3-key-secret", "", "s3 access key secret (default from config)")
+	flags.StringVar(&s3Overrides.Region, "s3-region", "", "s3 region (default from config)")
+	flags.StringVar(&s3Overrides.Bucketname, "s3-bucket", "", "s3 bucket name (default from config)")
+	flags.StringVar(&s3Overrides.Objectprefix, "s3-prefix", "", "object name prefix (default from config)")
+	flags.StringVar(&poolOverrides.Name, "pool-name", "", "name of the dedicated block pool (default from config or replicapool)")
+	flags.UintVar(&poolOverrides.ReplicaSize, "replica-size", 0, "replica size of the dedicated block pool (default from config or 3)")
+	flags.StringVar(&poolOverrides.FailureDomain, "failure-domain", "", "failure domain of the dedicated block pool, e.g. host or zone (default from config)")
+	flags.StringVar(&poolOverrides.DeviceClass, "device-class", "", "device class of the dedicated block pool, e.g. ssd (default from config)")
+	flags.StringVar(&snapshotSchedules, "snapshot-schedules", "", "mirror snapshot schedules as interval[@startTime], separated by commas, e.g. 1h,1d@02:00:00 (default from config)")
+	flags.StringVar(&poolOverrides.StorageClassName, "storage-class-name", "", "name of the StorageClass of the dedicated block pool (default from config or ocs-storagecluster-ceph-mirror)")
+	flags.StringVar(&storageClassParameters, "storage-class-parameters", "", "additional StorageClass parameters as key=value, separated by commas (default from config)")
+	flags.StringVar(&mirroringMode, "mirroring-mode", "", "mirroring mode of the PVCs in the mirrored block pool: snapshot or journal (default from config or snapshot)")
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+	if mirroringMode != "" {
+		if err := validateMirroringMode(mirroringMode); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitUsage
+		}
+	}
+	var err error
+	if poolOverrides.SnapshotSchedules, err = parseSnapshotSchedules(snapshotSchedules); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage
+	}
+	if poolOverrides.StorageClassParameters, err = parseParameters(storageClassParameters); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage
+	}
+	if err := progressFlags.start(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage
+	}
+	if err := clusterFlags.load(); err != nil {
+		return cliFail(err)
+	}
+	applyS3Overrides(s3Overrides)
+	applyPoolOverrides(poolOverrides)
+	if mirroringMode != "" {
+		for _, pool := range mirroringBlockPools() {
+			setMirroringMode(pool, mirroringMode)
+		}
+	}
+	installOADP = !skipOADP
+	if skipCephFS {
+		install := false
+		installCephFS = &install
+	}
+
+	if installOADP && !validateS3info() {
+		return cliFail(errors.New("S3 information is incomplete, please provide key ID, key secret, region and bucket name"))
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if err := checkAllInstallRequirements(ctx); err != nil {
+		return cliFail(err)
+	}
+	dryRunFlags.start()
+	if err := doInstall(ctx); err != nil {
+		return dryRunFlags.finish(cliFail(err))
+	}
+	return dryRunFlags.finish(exitOK)
+}
+
+func cliUninstall(args []string) int {
+	var clusterFlags clusterFlags
+	var dryRunFlags dryRunFlags
+	var progressFlags progressFlags
+	flags := flag.NewFlagSet("uninstall", flag.ContinueOnError)
+	clusterFlags.register(flags)
+	dryRunFlags.register(flags)
+	progressFlags.register(flags)
+	flags.BoolVar(&useNewBlockPoolForMirroring, "dedicated-pool", false, "delete the dedicated block pool, only used if the install state does not record the pool")
+	flags.BoolVar(&uninstallOADP, "with-oadp", false, "also remove the OADP Subscription, the Velero CR and the backup Schedule")
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+	if err := progressFlags.start(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage
+	}
+	if err := clusterFlags.load(); err != nil {
+		return cliFail(err)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	dryRunFlags.start()
+	if err := doUninstall(ctx); err != nil {
+		return dryRunFlags.finish(cliFail(err))
+	}
+	return dryRunFlags.finish(exitOK)
+}
+
+func applyPoolOverrides(overrides poolSettings) {
+	if overrides.Name != "" {
+		appConfig.Pool.Name = overrides.Name
+	}
+	if overrides.ReplicaSize != 0 {
+		appConfig.Pool.ReplicaSize = overrides.ReplicaSize
+	}
+	if overrides.FailureDomain != "" {
+		appConfig.Pool.FailureDomain = overrides.FailureDomain
+	}
+	if overrides.DeviceClass != "" {
+		appConfig.Pool.DeviceClass = overrides.DeviceClass
+	}
+	if len(overrides.SnapshotSchedules) > 0 {
+		appConfig.Pool.SnapshotSchedules = overrides.SnapshotSchedules
+	}
+	if overrides.StorageClassName != "" {
+		appConfig.Pool.StorageClassName = overrides.StorageClassName
+	}
+	if len(overrides.StorageClassParameters) > 0 {
+		appConfig.Pool.StorageClassParameters = overrides.StorageClassParameters
+	}
+}
+
+func applyS3Overrides(overrides s3information) {
+	if overrides.S3keyID != "" {
+		appConfig.S3info.S3keyID = overrides.S3keyID
+	}
+	if overrides.S3keySecret != "" {
+		appConfig.S3info.S3keySecret = overrides.S3keySecret
+	}
+	if overrides.Region != "" {
+		appConfig.S3info.Region = overrides.Region
+	}
+	if overrides.Bucketname != "" {
+		appConfig.S3info.Bucketname = overrides.Bucketname
+	}
+	if overrides.Objectprefix != "" {
+		appConfig.S3info.Objectprefix = overrides.Objectprefix
+	}
+}
+
+func cliPVC(args []string) int {
+	if len(args) < 1 {
+		fmt.Fprintln(os.Stderr, "Usage: RDRhelper pvc list|enable|disable|resync [flags] [namespace/pvc ...], flags must come before the PVCs")
+		return exitUsage
+	}
+	action := args[0]
+	var clusterFlags clusterFlags
+	var clusterName, mirroringMode string
+	var dryRunFlags dryRunFlags
+	var progressFlags progressFlags
+	flags := flag.NewFlagSet("pvc "+action, flag.ContinueOnError)
+	clusterFlags.register(flags)
+	dryRunFlags.register(flags)
+	progressFlags.register(flags)
+	flags.StringVar(&clusterName, "cluster", "primary", "cluster the PVCs live in (primary or secondary)")
+	flags.StringVar(&mirroringMode, "mode", "", "with enable, the mirroring mode: snapshot or journal (default from the config of the pool or snapshot)")
+	if err := flags.Parse(args[1:]); err != nil {
+		return exitUsage
+	}
+	// Parsing stops at the first PVC, so a flag after it would be taken as a PVC and e.g. --dry-run be ignored
+	if err := validatePVCRefs(flags.Args()); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage
+	}
+	if mirroringMode != "" {
+		if err := validateMirroringMode(mirroringMode); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitUsage
+		}
+	}
+	if err := progressFlags.start(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage
+	}
+	if err := clusterFlags.load(); err != nil {
+		return cliFail(err)
+	}
+
+	currentCluster, otherCluster := kubeConfigPrimary, kubeConfigSecondary
+	switch clusterName {
+	case "primary":
+	case "secondary":
+		currentCluster, otherCluster = kubeConfigSecondary, kubeConfigPrimary
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown cluster %q, use primary or secondary\n", clusterName)
+		return exitUsage
+	}
+
+	switch action {
+	case "list":
+		return cliListPVCs(currentCluster)
+	case "enable", "disable", "resync":
+		if flags.NArg() == 0 {
+			fmt.Fprintln(os.Stderr, "Please provide at least one PVC as namespace/pvc")
+			return exitUsage
+		}
+		dryRunFlags.start()
+		if action == "resync" {
+			return dryRunFlags.finish(cliResyncPVCs(currentCluster, flags.Args()))
+		}
+		return dryRunFlags.finish(cliSetPVCMirroring(currentCluster, otherCluster, flags.Args(), action == "enable", mirroringMode))
+	}
+	fmt.Fprintf(os.Stderr, "Unknown pvc action %q, use list, enable, disable or resync\n", action)
+	return exitUsage
+}
+
+// validatePVCRefs checks all namespace/pvc arguments before any of them is changed
+func validatePVCRefs(pvcs []string) error {
+	for _, pvcRef := range pvcs {
+		if strings.HasPrefix(pvcRef, "-") {
+			return errors.Errorf("Flag %q after the PVCs, flags must come before the PVCs", pvcRef)
+		}
+		parts := strings.SplitN(pvcRef, "/", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return errors.Errorf("Invalid PVC %q, use namespace/pvc", pvcRef)
+		}
+	}
+	return nil
+}
+
+func cliListPVCs(cluster kubeAccess) int {
+	pvs, err := cluster.typedClient.CoreV1().PersistentVolumes().List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return cliFail(errors.WithMessagef(err, "[%s] Issues when listing PVs", cluster.name))
+	}
+	statuses, err := getMirrorStatuses(cluster, pvs.Items)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	fmt.Printf("%-30s %-40s %-20s %s\n", "NAMESPACE", "PVC", "REPLICATION", "JOURNAL LAG")
+	for _, pv := range pvs.Items {
+		pvc := pv.Spec.ClaimRef
+		if pvc == nil {
+			continue
+		}
+		status, known := statuses[pv.Name]
+		if !known {
+			continue
+		}
+		lag := ""
+		if entries, journaled := status.journalEntriesBehind(); journaled {
+			lag = fmt.Sprintf("%d entries", entries)
+		}
+		fmt.Printf("%-30s %-40s %-20s %s\n", pvc.Namespace, pvc.Name, status.displayState(), lag)
+	}
+	return exitOK
+}
+
+// cliSetPVCMirroring enables or disables mirroring on the PVCs, which validatePVCRefs checked
+func cliSetPVCMirroring(currentCluster, otherCluster kubeAccess, pvcs []string, enable bool, mode string) int {
+	progress := protectionProgress.forCluster(currentCluster.name)
+	failed := false
+	backend := replicationFor(context.TODO(), currentCluster)
+	for _, pvcRef := range pvcs {
+		parts := strings.SplitN(pvcRef, "/", 2)
+		pv, err := getPVForPVC(currentCluster, parts[0], parts[1])
+		if err != nil {
+			progress.result(pvcRef, err, "could not find the PV of %s", pvcRef)
+			failed = true
+			continue
+		}
+		if mirrored, err := checkMirrorStatus(currentCluster, pv); err == nil && mirrored == enable {
+			progress.result(pvcRef, nil, "%s is already in the desired state", pvcRef)
+			continue
+		}
+		err = setMirrorStatus(context.TODO(), backend, pv, enable, mode)
+		progress.result(pvcRef, err, "mirror status changed for %s", pvcRef)
+		failed = failed || err != nil
+	}
+
+	namespaces, err := getMirroredNamespaces(currentCluster)
+	if err != nil {
+		return cliFail(err)
+	}
+	setNamespacesToBackup(currentCluster, namespaces, defaultBackupSettings)
+	for _, target := range peerTargets(currentCluster, otherCluster) {
+		if err = syncPVs(currentCluster, target); err != nil {
+			return cliFail(err)
+		}
+	}
+	if failed {
+		return exitError
+	}
+	return exitOK
+}
+
+// cliResyncPVCs resyncs the non-primary images of the PVCs from the primary, e.g. after a split-brain.
+// The PVCs were checked by validatePVCRefs.
+func cliResyncPVCs(cluster kubeAccess, pvcs []string) int {
+	progress := protectionProgress.forCluster(cluster.name)
+	backend := replicationFor(context.TODO(), cluster)
+	failed := false
+	for _, pvcRef := range pvcs {
+		parts := strings.SplitN(pvcRef, "/", 2)
+		pv, err := getPVForPVC(cluster, parts[0], parts[1])
+		if err == nil {
+			err = backend.resync(context.TODO(), pv)
+		}
+		progress.result(pvcRef, err, "resync of %s requested", pvcRef)
+		failed = failed || err != nil
+	}
+	if failed {
+		return exitError
+	}
+	return exitOK
+}
+
+func cliSchedule(args []string) int {
+	if len(args) < 1 {
+		fmt.Fprintln(os.Stderr, "Usage: RDRhelper schedule list|set|add|remove [flags] [interval[@startTime] ...]")
+		return exitUsage
+	}
+	action := args[0]
+	var clusterFlags clusterFlags
+	var poolName, pvcRef, format string
+	var dryRunFlags dryRunFlags
+	var progressFlags progressFlags
+	flags := flag.NewFlagSet("schedule "+action, flag.ContinueOnError)
+	clusterFlags.register(flags)
+	dryRunFlags.register(flags)
+	progressFlags.register(flags)
+	flags.StringVar(&poolName, "pool", "", "block pool whose schedules are changed")
+	flags.StringVar(&pvcRef, "pvc", "", "namespace/pvc whose image schedules are changed, instead of the ones of the pool")
+	flags.StringVar(&format, "format", "text", "output format of list: text or json")
+	if err := flags.Parse(args[1:]); err != nil {
+		return exitUsage
+	}
+	if format != "text" && format != "json" {
+		fmt.Fprintf(os.Stderr, "Unknown format %q, use text or json\n", format)
+		return exitUsage
+	}
+	if action != "list" && action != "set" && action != "add" && action != "remove" {
+		fmt.Fprintf(os.Stderr, "Unknown schedule action %q, use list, set, add or remove\n", action)
+		return exitUsage
+	}
+	if action != "list" && (poolName == "") == (pvcRef == "") {
+		fmt.Fprintln(os.Stderr, "Please provide either --pool or --pvc")
+		return exitUsage
+	}
+	schedules, err := parseSnapshotSchedules(strings.Join(flags.Args(), ","))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage
+	}
+	if (action == "add" || action == "remove") && len(schedules) == 0 {
+		fmt.Fprintln(os.Stderr, "Please provide at least one schedule as interval[@startTime]")
+		return exitUsage
+	}
+	if action != "list" {
+		if err := progressFlags.start(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitUsage
+		}
+	}
+	if err := clusterFlags.load(); err != nil {
+		return cliFail(err)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	clusters := []kubeAccess{kubeConfigPrimary, kubeConfigSecondary}
+	if action == "list" {
+		return cliListSchedules(ctx, clusters, format)
+	}
+
+	var image string
+	if pvcRef != "" {
+		parts := strings.SplitN(pvcRef, "/", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			fmt.Fprintf(os.Stderr, "Invalid PVC %q, use namespace/pvc\n", pvcRef)
+			return exitUsage
+		}
+		pv, err := getPVForPVC(kubeConfigPrimary, parts[0], parts[1])
+		if err != nil {
+			return cliFail(err)
+		}
+		if image, poolName, err = getRBDInfoFromPV(pv); err != nil {
+			return cliFail(err)
+		}
+	}
+	if action != "set" {
+		current, err := currentSnapshotSchedules(ctx, kubeConfigPrimary, poolName, image)
+		if err != nil {
+			return cliFail(err)
+		}
+		schedules = changeSchedules(current, schedules, action == "add")
+	}
+	dryRunFlags.start()
+	if err := setSnapshotSchedules(ctx, clusters, poolName, image, schedules); err != nil {
+		return dryRunFlags.finish(cliFail(err))
+	}
+	return dryRunFlags.finish(exitOK)
+}
+
+// changeSchedules adds the schedules that are missing in current, or removes the given ones from it
+func changeSchedules(current, schedules []snapshotSchedule, add bool) []snapshotSchedule {
+	given := make(map[snapshotSchedule]bool)
+	for _, schedule := range schedules {
+		given[schedule] = true
+	}
+	changed := []snapshotSchedule{}
+	for _, schedule := range current {
+		if given[schedule] {
+			if !add {
+				continue
+			}
+			delete(given, schedule)
+		}
+		changed = append(changed, schedule)
+	}
+	if add {
+		for _, schedule := range schedules {
+			if given[schedule] {
+				changed = append(changed, schedule)
+				delete(given, schedule)
+			}
+		}
+	}
+	return changed
+}
+
+func cliListSchedules(ctx context.Context, clusters []kubeAccess, format string) int {
+	levels := []snapshotScheduleLevel{}
+	failed := false
+	for _, cluster := range clusters {
+		clusterLevels, err := listSnapshotSchedules(ctx, cluster)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			failed = true
+		}
+		levels = append(levels, clusterLevels...)
+	}
+	if format == "json" {
+		content, err := json.MarshalIndent(levels, "", "  ")
+		if err != nil {
+			return cliFail(err)
+		}
+		fmt.Println(string(content))
+	} else {
+		fmt.Printf("%-12s %-60s %-20s %s\n", "CLUSTER", "POOL/IMAGE", "SCHEDULES", "STATUS")
+		for _, level := range levels {
+			fmt.Printf("%-12s %-60s %-20s %s\n", level.Cluster, level.target(), formatScheduleList(level.Active), level.state())
+		}
+	}
+	if failed {
+		return exitError
+	}
+	return exitOK
+}
+
+func cliFailover(args []string) int {
+	var clusterFlags clusterFlags
+	var namespaceList string
+	var failback bool
+	var dryRunFlags dryRunFlags
+	var progressFlags progressFlags
+	flags := flag.NewFlagSet("failover", flag.ContinueOnError)
+	clusterFlags.register(flags)
+	dryRunFlags.register(flags)
+	progressFlags.register(flags)
+	flags.StringVar(&namespaceList, "namespaces", "", "comma separated list of namespaces to fail over")
+	flags.BoolVar(&failback, "failback", false, "fail back from the secondary to the primary cluster")
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+	var namespaces []string
+	for _, namespace := range strings.Split(namespaceList, ",") {
+		if namespace = strings.TrimSpace(namespace); namespace != "" {
+			namespaces = append(namespaces, namespace)
+		}
+	}
+	if len(namespaces) == 0 {
+		fmt.Fprintln(os.Stderr, "Please provide at least one namespace with --namespaces")
+		return exitUsage
+	}
+	if err := progressFlags.start(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage
+	}
+	if err := clusterFlags.load(); err != nil {
+		return cliFail(err)
+	}
+
+	from, to := kubeConfigPrimary, kubeConfigSecondary
+	if failback {
+		from, to = kubeConfigSecondary, kubeConfigPrimary
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	return runFailover(ctx, from, to, namespaces, dryRunFlags)
+}
+
+// runFailover checks the cluster that takes over and fails the namespaces over to it.
+// The exit code is non-zero when the checks fail or any PV could not be promoted.
+func runFailover(ctx context.Context, from, to kubeAccess, namespaces []string, dryRunFlags dryRunFlags) int {
+	if err := checkFailoverRequirements(ctx, to); err != nil {
+		return cliFail(err)
+	}
+	dryRunFlags.start()
+	if err := workOnFailoverWithNamespaces(ctx, from, to, namespaces); err != nil {
+		return dryRunFlags.finish(cliFail(err))
+	}
+	return dryRunFlags.finish(exitOK)
+}
+
+func cliPlan(args []string) int {
+	if len(args) < 1 || (args[0] != "diff" && args[0] != "apply") {
+		fmt.Fprintln(os.Stderr, "Usage: RDRhelper plan diff|apply -f plan.yaml [flags]")
+		return exitUsage
+	}
+	action := args[0]
+	var clusterFlags clusterFlags
+	var planPath, clusterName, format string
+	var dryRunFlags dryRunFlags
+	var progressFlags progressFlags
+	flags := flag.NewFlagSet("plan "+action, flag.ContinueOnError)
+	clusterFlags.register(flags)
+	dryRunFlags.register(flags)
+	progressFlags.register(flags)
+	flags.StringVar(&planPath, "f", "", "path to the protection plan")
+	flags.StringVar(&clusterName, "cluster", "primary", "cluster the protected PVCs live in (primary or secondary)")
+	flags.StringVar(&format, "format", "text", "output format of diff: text or json")
+	if err := flags.Parse(args[1:]); err != nil {
+		return exitUsage
+	}
+	if planPath == "" {
+		fmt.Fprintln(os.Stderr, "Please provide the plan with -f")
+		return exitUsage
+	}
+	plan, err := readProtectionPlan(planPath)
+	if err != nil {
+		return cliFail(err)
+	}
+	if err := progressFlags.start(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage
+	}
+	if err := clusterFlags.load(); err != nil {
+		return cliFail(err)
+	}
+	from, to := kubeConfigPrimary, kubeConfigSecondary
+	switch clusterName {
+	case "primary":
+	case "secondary":
+		from, to = kubeConfigSecondary, kubeConfigPrimary
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown cluster %q, use primary or secondary\n", clusterName)
+		return exitUsage
+	}
+
+	if action == "apply" {
+		dryRunFlags.start()
+		if err := applyProtectionPlan(plan, from, to); err != nil {
+			return dryRunFlags.finish(cliFail(err))
+		}
+		return dryRunFlags.finish(exitOK)
+	}
+
+	diff, err := diffProtectionPlan(plan, from, to)
+	if err != nil {
+		return cliFail(err)
+	}
+	if format == "json" {
+		content, err := json.MarshalIndent(diff, "", "  ")
+		if err != nil {
+			return cliFail(err)
+		}
+		fmt.Println(string(content))
+		return exitOK
+	}
+	diff.print(os.Stdout)
+	return exitOK
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestCliPVCRejectsFlagsAfterPVCs(t *testing.T) {
+	// Rejected before the clusters are loaded, so nothing is changed
+	if code := cliPVC([]string{"enable", "my-app/data", "--dry-run"}); code != exitUsage {
+		t.Errorf("expected a usage error for a flag after the PVCs, got %d", code)
+	}
+	if err := validatePVCRefs([]string{"my-app/data", "my-app"}); err == nil {
+		t.Error("expected the PVC without namespace to be rejected")
+	}
+	if err := validatePVCRefs([]string{"my-app/data", "other-app/logs"}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCliFailoverFailsOnFailedPromote(t *testing.T) {
+	defer func() { appConfig.SkipChecks = nil }()
+	appConfig.SkipChecks = []string{checkODFRelease, checkOMAPGenerator}
+	primary, _ := newFakeCluster(t, "primary")
+	secondary, toolbox := newFakeCluster(t, "secondary",
+		newRBDPV("pv-shop", "shop", "data", "img-shop", corev1.VolumeReleased),
+	)
+	toolbox.mirrored["replicapool/img-shop"] = "up+replaying"
+	toolbox.failing["rbd mirror image promote replicapool/img-shop"] = rbdExitBusy
+
+	if code := runFailover(context.Background(), primary, secondary, []string{"shop"}, dryRunFlags{}); code != exitError {
+		t.Errorf("expected the failed promotion to exit with %d, got %d", exitError, code)
+	}
+
+	delete(toolbox.failing, "rbd mirror image promote replicapool/img-shop")
+	if code := runFailover(context.Background(), primary, secondary, []string{"shop"}, dryRunFlags{}); code != exitOK {
+		t.Errorf("expected the failover to succeed, got %d", code)
+	}
+}
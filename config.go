@@ -2,10 +2,8 @@ package main
 
 import (
 	"fmt"
-	"net/url"
 	"os"
 	"path"
-	"strings"
 
 	"github.com/gdamore/tcell/v2"
 	"github.com/pkg/errors"
@@ -22,30 +20,61 @@ import (
 var appFrame *tview.Frame
 
 var appConfig = struct {
-	KubeConfigPrimaryPath   string        `yaml:"kubeConfigPrimaryPath"`
-	KubeConfigSecondaryPath string        `yaml:"kubeConfigSecondaryPath"`
-	S3info                  s3information `yaml:"s3info"`
+	KubeConfigPrimaryPath   string `yaml:"kubeConfigPrimaryPath"`
+	KubeConfigSecondaryPath string `yaml:"kubeConfigSecondaryPath"`
+	// StorageNamespacePrimary and StorageNamespaceSecondary override the detected namespace of the Ceph cluster
+	StorageNamespacePrimary   string          `yaml:"storageNamespacePrimary,omitempty"`
+	StorageNamespaceSecondary string          `yaml:"storageNamespaceSecondary,omitempty"`
+	S3info                    s3information   `yaml:"s3info"`
+	Timeouts                  timeoutSettings `yaml:"timeouts,omitempty"`
+	Pool                      poolSettings    `yaml:"pool,omitempty"`
+	// Clusters replace the primary and secondary kubeconfig paths for setups with more than two clusters
+	Clusters []clusterConfig `yaml:"clusters,omitempty"`
+	// Peerings are the pairs of Clusters that mirror to each other, the first cluster is peered with all others if not set
+	Peerings []peeringConfig `yaml:"peerings,omitempty"`
+	// MirroringModes are the mirroring modes (snapshot or journal) of the images per pool name, snapshot if not set
+	MirroringModes map[string]string `yaml:"mirroringModes,omitempty"`
+	// Replication selects how PVs are enabled, promoted and demoted: auto, toolbox or VolumeReplication, auto if not set
+	Replication string `yaml:"replication,omitempty"`
+	// SkipChecks are the IDs of requirement checks that are reported as skipped instead of being run
+	SkipChecks []string `yaml:"skipChecks,omitempty"`
+	// EventLog is a file that all progress events are appended to as JSON lines
+	EventLog string `yaml:"eventLog,omitempty"`
 }{}
 
+// kubeAccess bundles everything that is needed to work on a cluster.
+// All cluster operations go through the interfaces, so they can be replaced by fakes in tests.
 type kubeAccess struct {
 	name             string
 	path             string
 	config           clientcmdapi.Config
 	restConfig       rest.Config
-	typedClient      *kubernetes.Clientset
+	typedClient      kubernetes.Interface
 	dynamicClient    dynamic.Interface
 	controllerClient controllerClient.Client
+	// executor runs commands in Pods
+	executor podExecutor
+	// toolbox runs Ceph commands like rbd in the rook-ceph-tools Pod
+	toolbox toolboxRunner
+	// env is the detected storage environment, see environment()
+	env *storageEnvironment
 }
 
 var primaryLocation, secondaryLocation string
 var kubeConfigPrimary, kubeConfigSecondary kubeAccess
 
 func updateFrame() {
+	if headless {
+		return
+	}
 	appFrame.Clear()
 	appFrame.
 		AddText("Regional DR Helper Tool", true, tview.AlignCenter, tcell.ColorWhite).
 		AddText(fmt.Sprintf("Primary: %s", primaryLocation), false, tview.AlignLeft, tcell.ColorWhite).
 		AddText(fmt.Sprintf("Secondary: %s", secondaryLocation), false, tview.AlignRight, tcell.ColorWhite)
+	if dryRun.enabled {
+		appFrame.AddText("DRY RUN - changes are only recorded", true, tview.AlignCenter, tcell.ColorRed)
+	}
 	pagesChangedFunc()
 }
 
@@ -67,9 +96,15 @@ func readConfig() error {
 		log.WithError(err).Warn("Could not understand config")
 		return writeNewConfig()
 	}
+	if err := validateSkipChecks(appConfig.SkipChecks); err != nil {
+		log.WithError(err).Warn("Ignoring invalid skipChecks in the config")
+	}
 	// Call conf changed to set the config
 	primaryKubeConfChanged(appConfig.KubeConfigPrimaryPath)
 	secondaryKubeConfChanged(appConfig.KubeConfigSecondaryPath)
+	if err := loadSites(); err != nil {
+		log.WithError(err).Warn("Could not load the clusters of the config")
+	}
 	return nil
 }
 
@@ -119,12 +154,41 @@ func writeNewConfig() error {
 }
 
 func showConfigPage() {
-	form := tview.NewForm().
-		AddInputField("primary KubeConf location", appConfig.KubeConfigPrimaryPath, 0, nil, primaryKubeConfChanged).
-		AddInputField("secondary KubeConf location", appConfig.KubeConfigSecondaryPath, 0, nil, secondaryKubeConfChanged)
+	form := tview.NewForm()
+	// The clusters are connected when the form is left, not for every key typed into a path
+	sitesChanged := false
+	primaryPath, secondaryPath := appConfig.KubeConfigPrimaryPath, appConfig.KubeConfigSecondaryPath
+	if namedSites() {
+		for i := range appConfig.Clusters {
+			i := i
+			form.AddInputField(appConfig.Clusters[i].Name+" KubeConf location", appConfig.Clusters[i].KubeConfigPath, 0, nil, func(path string) {
+				appConfig.Clusters[i].KubeConfigPath = path
+				sitesChanged = true
+			})
+		}
+	} else {
+		form.
+			AddInputField("primary KubeConf location", appConfig.KubeConfigPrimaryPath, 0, nil, func(path string) { primaryPath = path }).
+			AddInputField("secondary KubeConf location", appConfig.KubeConfigSecondaryPath, 0, nil, func(path string) { secondaryPath = path })
+	}
+	leave := func() {
+		if sitesChanged {
+			if err := loadSites(); err != nil {
+				log.WithError(err).Warn("Could not load the clusters of the config")
+			}
+		}
+		if !namedSites() && primaryPath != kubeConfigPrimary.path {
+			primaryKubeConfChanged(primaryPath)
+		}
+		if !namedSites() && secondaryPath != kubeConfigSecondary.path {
+			secondaryKubeConfChanged(secondaryPath)
+		}
+		writeNewConfig()
+		pages.SwitchToPage("main")
+	}
 	form.
-		AddButton("Go back", func() { writeNewConfig(); pages.SwitchToPage("main") }).
-		SetCancelFunc(func() { writeNewConfig(); pages.SwitchToPage("main") })
+		AddButton("Go back", leave).
+		SetCancelFunc(leave)
 	form.SetBorder(true).
 		SetTitle("Configuration").SetTitleAlign(tview.AlignLeft)
 	pages.AddAndSwitchToPage("KubeConfiguration", form, true)
@@ -137,12 +201,9 @@ func primaryKubeConfChanged(path string) {
 	}
 	appConfig.KubeConfigPrimaryPath = path
 	access.name = "primary"
+	access.detectStorage(appConfig.StorageNamespacePrimary)
 	kubeConfigPrimary = access
-	url, err := url.Parse(kubeConfigPrimary.config.Clusters[kubeConfigPrimary.config.Contexts[kubeConfigPrimary.config.CurrentContext].Cluster].Server)
-	if err != nil {
-		return
-	}
-	primaryLocation = strings.TrimPrefix(url.Hostname(), "api.")
+	primaryLocation = siteLocation(access)
 	updateFrame()
 }
 func secondaryKubeConfChanged(path string) {
@@ -152,12 +213,9 @@ func secondaryKubeConfChanged(path string) {
 	}
 	appConfig.KubeConfigSecondaryPath = path
 	access.name = "secondary"
+	access.detectStorage(appConfig.StorageNamespaceSecondary)
 	kubeConfigSecondary = access
-	url, err := url.Parse(kubeConfigSecondary.config.Clusters[kubeConfigSecondary.config.Contexts[kubeConfigSecondary.config.CurrentContext].Cluster].Server)
-	if err != nil {
-		return
-	}
-	secondaryLocation = strings.TrimPrefix(url.Hostname(), "api.")
+	secondaryLocation = siteLocation(access)
 	updateFrame()
 }
 
@@ -175,26 +233,40 @@ func validateKubeConfig(path string) (kubeAccess, error) {
 	}
 	restConfig, err := clientcmd.NewDefaultClientConfig(*fileConfig, &clientcmd.ConfigOverrides{}).ClientConfig()
 	if err != nil {
-		return kubeAccess{}, errors.Wrapf(err, "failed to instantiate rest client", path)
+		return kubeAccess{}, errors.Wrapf(err, "failed to instantiate rest client for %s", path)
 	}
+	access, err := newKubeAccess(restConfig)
+	if err != nil {
+		return kubeAccess{}, errors.WithMessagef(err, "failed to load kubeconfig %s", path)
+	}
+	access.path = path
+	access.config = *fileConfig
+	return access, nil
+}
+
+// newKubeAccess creates all clients for the given rest config, the storage environment is detected
+// separately with detectStorage, once the cluster is accepted
+func newKubeAccess(restConfig *rest.Config) (kubeAccess, error) {
 	clientset, err := kubernetes.NewForConfig(restConfig)
 	if err != nil {
-		return kubeAccess{}, errors.Wrapf(err, "failed to load kubeconfig as kubernetes client", path)
+		return kubeAccess{}, errors.Wrap(err, "failed to create kubernetes client")
 	}
 	dynamicClient, err := dynamic.NewForConfig(restConfig)
 	if err != nil {
-		return kubeAccess{}, errors.Wrapf(err, "failed to load kubeconfig as kubernetes client", path)
+		return kubeAccess{}, errors.Wrap(err, "failed to create dynamic client")
 	}
 	cClient, err := controllerClient.New(restConfig, controllerClient.Options{})
 	if err != nil {
-		return kubeAccess{}, errors.Wrapf(err, "failed to load kubeconfig as kubernetes client", path)
+		return kubeAccess{}, errors.Wrap(err, "failed to create controller client")
 	}
+	executor := spdyExecutor{typedClient: clientset, restConfig: restConfig}
 	return kubeAccess{
-		path:             path,
-		config:           *fileConfig,
 		restConfig:       *restConfig,
 		typedClient:      clientset,
 		dynamicClient:    dynamicClient,
 		controllerClient: cClient,
+		executor:         executor,
+		// The namespace is set by detectStorage
+		toolbox: &toolboxPodRunner{typedClient: clientset, executor: executor},
 	}, nil
 }
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/manager/signals"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+// policyConfigMapKey is the key in the policy ConfigMap that holds the protection plan
+const policyConfigMapKey = "plan.yaml"
+
+// protectionReconciler keeps the local cluster in line with the protection policy
+// All events are mapped to the policy, so there is only ever one reconcile running
+type protectionReconciler struct {
+	local  kubeAccess
+	peer   kubeAccess
+	policy types.NamespacedName
+	resync time.Duration
+}
+
+func (r *protectionReconciler) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+	logger := log.WithField("policy", r.policy.String())
+
+	var policyMap corev1.ConfigMap
+	err := r.local.controllerClient.Get(ctx, r.policy, &policyMap)
+	if apierrors.IsNotFound(err) {
+		logger.Warn("Protection policy not found, nothing to reconcile")
+		return reconcile.Result{RequeueAfter: r.resync}, nil
+	}
+	if err != nil {
+		return reconcile.Result{}, errors.WithMessage(err, "Issues when fetching the protection policy")
+	}
+	plan, err := parseProtectionPlan(bytes.NewBufferString(policyMap.Data[policyConfigMapKey]))
+	if err != nil {
+		// Retrying will not help until the policy was changed, which triggers a new reconcile
+		logger.WithError(err).Error("Protection policy is not valid")
+		return reconcile.Result{}, nil
+	}
+
+	logger.Info("Reconciling protection policy")
+	if err = applyProtectionPlan(plan, r.local, r.peer); err != nil {
+		return reconcile.Result{}, err
+	}
+	logger.Info("Protection policy reconciled")
+	return reconcile.Result{RequeueAfter: r.resync}, nil
+}
+
+// toPolicy maps every PV and PVC event to the protection policy
+func (r *protectionReconciler) toPolicy(client.Object) []reconcile.Request {
+	return []reconcile.Request{{NamespacedName: r.policy}}
+}
+
+func (r *protectionReconciler) isPolicy(obj client.Object) bool {
+	return obj.GetNamespace() == r.policy.Namespace && obj.GetName() == r.policy.Name
+}
+
+func (r *protectionReconciler) setupWithManager(mgr manager.Manager) error {
+	return builder.ControllerManagedBy(mgr).
+		Named("rdr-protection").
+		For(&corev1.ConfigMap{}, builder.WithPredicates(predicate.NewPredicateFuncs(r.isPolicy))).
+		Watches(&source.Kind{Type: &corev1.PersistentVolume{}}, handler.EnqueueRequestsFromMapFunc(r.toPolicy)).
+		Watches(&source.Kind{Type: &corev1.PersistentVolumeClaim{}}, handler.EnqueueRequestsFromMapFunc(r.toPolicy)).
+		WithOptions(controller.Options{MaxConcurrentReconciles: 1}).
+		Complete(r)
+}
+
+func cliController(args []string) int {
+	var kubeConfig, peerKubeConfig, policyNamespace, policyName, probeAddress string
+	var resync time.Duration
+	var leaderElection bool
+	flags := flag.NewFlagSet("controller", flag.ContinueOnError)
+	flags.StringVar(&kubeConfig, "kubeconfig", "", "path to the kubeconfig of the local cluster (default in-cluster config)")
+	flags.StringVar(&peerKubeConfig, "peer-kubeconfig", "", "path to the kubeconfig of the peer cluster the PVs are synced to")
+	flags.StringVar(&policyNamespace, "policy-namespace", "", "namespace of the protection policy ConfigMap and the leader election (default the storage namespace)")
+	flags.StringVar(&policyName, "policy-name", "rdrhelper-protection-policy", "name of the protection policy ConfigMap")
+	flags.DurationVar(&resync, "resync", 5*time.Minute, "interval in which the policy is reconciled without any changes")
+	flags.BoolVar(&leaderElection, "leader-elect", false, "use leader election to allow running multiple replicas")
+	flags.StringVar(&probeAddress, "health-probe-bind-address", ":8081", "address of the health probe endpoint")
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+	if peerKubeConfig == "" {
+		fmt.Fprintln(os.Stderr, "Please provide the kubeconfig of the peer cluster with --peer-kubeconfig")
+		return exitUsage
+	}
+	// Running in a container, the log belongs to stderr
+	log.Out = os.Stderr
+
+	restConfig, err := controllerRestConfig(kubeConfig)
+	if err != nil {
+		return cliFail(err)
+	}
+	local, err := newKubeAccess(restConfig)
+	if err != nil {
+		return cliFail(err)
+	}
+	local.name = "local"
+	local.detectStorage("")
+	if policyNamespace == "" {
+		policyNamespace = local.storageNamespace()
+	}
+	peer, err := validateKubeConfig(peerKubeConfig)
+	if err != nil {
+		return cliFail(err)
+	}
+	peer.name = "peer"
+	peer.detectStorage("")
+
+	mgr, err := manager.New(restConfig, manager.Options{
+		MetricsBindAddress:      "0",
+		HealthProbeBindAddress:  probeAddress,
+		LeaderElection:          leaderElection,
+		LeaderElectionID:        "rdrhelper-controller",
+		LeaderElectionNamespace: policyNamespace,
+	})
+	if err != nil {
+		return cliFail(errors.Wrap(err, "could not create the controller manager"))
+	}
+	reconciler := &protectionReconciler{
+		local:  local,
+		peer:   peer,
+		policy: types.NamespacedName{Namespace: policyNamespace, Name: policyName},
+		resync: resync,
+	}
+	if err = reconciler.setupWithManager(mgr); err != nil {
+		return cliFail(errors.Wrap(err, "could not set up the protection controller"))
+	}
+	if err = mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
+		return cliFail(errors.Wrap(err, "could not set up the health check"))
+	}
+
+	log.WithField("policy", reconciler.policy.String()).Info("Starting the protection controller")
+	if err = mgr.Start(signals.SetupSignalHandler()); err != nil {
+		return cliFail(errors.Wrap(err, "controller stopped with an error"))
+	}
+	return exitOK
+}
+
+func controllerRestConfig(kubeConfig string) (*rest.Config, error) {
+	if kubeConfig == "" {
+		restConfig, err := rest.InClusterConfig()
+		return restConfig, errors.Wrap(err, "could not load the in-cluster config, use --kubeconfig outside of a cluster")
+	}
+	restConfig, err := clientcmd.BuildConfigFromFlags("", kubeConfig)
+	return restConfig, errors.Wrapf(err, "could not load kubeconfig %s", kubeConfig)
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// plannedAction is a change to a cluster that was recorded instead of executed during a dry run
+type plannedAction struct {
+	Cluster string `json:"cluster"`
+	// Verb is one of create, patch, delete or exec
+	Verb    string `json:"verb"`
+	Target  string `json:"target"`
+	Payload string `json:"payload,omitempty"`
+}
+
+func (a plannedAction) String() string {
+	return fmt.Sprintf("[%s] %s %s", a.Cluster, a.Verb, a.Target)
+}
+
+// dryRunRecorder collects the plannedActions of a dry run
+type dryRunRecorder struct {
+	enabled bool
+	mu      sync.Mutex
+	actions []plannedAction
+}
+
+var dryRun = &dryRunRecorder{}
+
+// start clears the actions of the previous run
+func (d *dryRunRecorder) start() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.actions = nil
+}
+
+// intercept records the action during a dry run and returns true, the caller must not execute the action then.
+// The payload can be a string, a byte slice or any object that will be converted to JSON.
+func (d *dryRunRecorder) intercept(cluster kubeAccess, verb, target string, payload interface{}) bool {
+	if !d.enabled {
+		return false
+	}
+	action := plannedAction{Cluster: cluster.name, Verb: verb, Target: target}
+	switch p := payload.(type) {
+	case nil:
+	case string:
+		action.Payload = p
+	case []byte:
+		action.Payload = string(p)
+	default:
+		payloadJSON, err := json.Marshal(p)
+		if err != nil {
+			action.Payload = fmt.Sprintf("%+v", p)
+		} else {
+			action.Payload = string(payloadJSON)
+		}
+	}
+	log.WithField("payload", action.Payload).Infof("[DRY RUN] %s", action)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.actions = append(d.actions, action)
+	return true
+}
+
+func (d *dryRunRecorder) plannedActions() []plannedAction {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]plannedAction{}, d.actions...)
+}
+
+// print writes the planned actions in a human readable form
+func (d *dryRunRecorder) print(target io.Writer) {
+	actions := d.plannedActions()
+	addRowOfTextOutput(target, "")
+	addRowOfTextOutput(target, "DRY RUN - %d actions would be executed:", len(actions))
+	for i, action := range actions {
+		addRowOfTextOutput(target, "%3d. %s", i+1, action)
+		if action.Payload != "" {
+			addRowOfTextOutput(target, "       %s", action.Payload)
+		}
+	}
+}
+
+// showDryRunPlan shows the planned actions in the TUI, if dry run mode is enabled
+func showDryRunPlan() {
+	if !dryRun.enabled {
+		return
+	}
+	planText := &strings.Builder{}
+	dryRun.print(planText)
+	buttons := make(map[string]func())
+	buttons["Close"] = func() { pages.RemovePage("dryRunPlan") }
+	showInfo("dryRunPlan", planText.String(), buttons)
+}
+
+// writePlan stores the planned actions as JSON document for review
+func (d *dryRunRecorder) writePlan(path string) error {
+	plan := struct {
+		Created time.Time       `json:"created"`
+		Actions []plannedAction `json:"actions"`
+	}{
+		Created: time.Now().UTC(),
+		Actions: d.plannedActions(),
+	}
+	content, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "could not convert the dry run plan to JSON")
+	}
+	if err = os.WriteFile(path, content, 0600); err != nil {
+		return errors.Wrapf(err, "could not write the dry run plan to %s", path)
+	}
+	return nil
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pkg/errors"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Suffixes of the CSI driver names, the drivers are prefixed with the namespace of the operator
+const (
+	rbdCSIDriverSuffix    = ".rbd.csi.ceph.com"
+	cephfsCSIDriverSuffix = ".cephfs.csi.ceph.com"
+)
+
+// rookNamespace is the namespace of the Rook examples
+const rookNamespace = "rook-ceph"
+
+// openshiftConfigNamespace exists in every OpenShift 4 cluster
+const openshiftConfigNamespace = "openshift-config"
+
+// storageEnvironment describes where the Ceph cluster of a Kubernetes cluster lives and who manages it
+type storageEnvironment struct {
+	name string
+	// namespace holds the Ceph CRs, the CSI driver and the toolbox
+	namespace    string
+	rbdDriver    string
+	cephfsDriver string
+	// defaultPool and defaultFilesystem are the Block Pool and CephFilesystem that ODF or the Rook examples create
+	defaultPool       string
+	defaultFilesystem string
+	// storageCluster is true if the ocs-operator manages Ceph with a StorageCluster, the toolbox is enabled in the
+	// OCSInitialization then. Otherwise RDRhelper deploys the toolbox itself.
+	storageCluster bool
+	// external is true if the Ceph daemons run outside of the cluster, their mirroring is set up there
+	external bool
+	// openshift is true if the cluster is an OpenShift cluster, ODF always is. Rook also runs on plain Kubernetes.
+	openshift bool
+}
+
+var environmentODF = storageEnvironment{
+	name:              "ODF",
+	namespace:         "openshift-storage",
+	rbdDriver:         "openshift-storage" + rbdCSIDriverSuffix,
+	cephfsDriver:      "openshift-storage" + cephfsCSIDriverSuffix,
+	defaultPool:       defaultPoolName,
+	defaultFilesystem: defaultFilesystemName,
+	storageCluster:    true,
+	openshift:         true,
+}
+
+var environmentODFExternal = storageEnvironment{
+	name:           "ODF external mode",
+	namespace:      environmentODF.namespace,
+	rbdDriver:      environmentODF.rbdDriver,
+	cephfsDriver:   environmentODF.cephfsDriver,
+	storageCluster: true,
+	external:       true,
+	openshift:      true,
+}
+
+var environmentRook = storageEnvironment{
+	name:              "Rook",
+	namespace:         rookNamespace,
+	rbdDriver:         rookNamespace + rbdCSIDriverSuffix,
+	cephfsDriver:      rookNamespace + cephfsCSIDriverSuffix,
+	defaultPool:       "replicapool",
+	defaultFilesystem: "myfs",
+}
+
+// environment returns the detected storage environment of the cluster, ODF if it was not detected
+func (k kubeAccess) environment() storageEnvironment {
+	if k.env == nil {
+		return environmentODF
+	}
+	return *k.env
+}
+
+// storageNamespace returns the namespace of the Ceph CRs, the CSI driver and the toolbox of the cluster
+func (k kubeAccess) storageNamespace() string {
+	return k.environment().namespace
+}
+
+// detectEnvironment finds the storage environment from the namespaces of the cluster. ODF is recognized by
+// the openshift-storage namespace and runs in external mode if the StorageCluster says so, upstream Rook
+// by the rook-ceph namespace, on OpenShift if the openshift-config namespace exists. If namespace is set,
+// only that namespace is looked at, e.g. for Rook clusters that do not use the namespace of the examples.
+func detectEnvironment(typedClient kubernetes.Interface, dynamicClient dynamic.Interface, namespace string) (storageEnvironment, error) {
+	ctx, cancel := requestContext(context.Background())
+	defer cancel()
+	candidates := []string{environmentODF.namespace, environmentRook.namespace}
+	if namespace != "" {
+		candidates = []string{namespace}
+	}
+	for _, candidate := range candidates {
+		_, err := typedClient.CoreV1().Namespaces().Get(ctx, candidate, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return environmentODF, errors.Wrap(err, "Issues when looking for the storage namespace")
+		}
+		return environmentIn(ctx, typedClient, dynamicClient, candidate), nil
+	}
+	if namespace != "" {
+		return environmentODF, errors.Errorf("the configured storage namespace %s does not exist", namespace)
+	}
+	return environmentODF, errors.Errorf("neither the %s namespace of ODF nor the %s namespace of Rook exist", environmentODF.namespace, environmentRook.namespace)
+}
+
+// environmentIn returns the environment of the Ceph cluster in the namespace. A StorageCluster means ODF,
+// the openshift-storage namespace as well since its StorageCluster is checked by the requirement checks.
+func environmentIn(ctx context.Context, typedClient kubernetes.Interface, dynamicClient dynamic.Interface, namespace string) storageEnvironment {
+	var env storageEnvironment
+	storageCluster, err := dynamicClient.Resource(storageClusterResource).Namespace(namespace).
+		Get(ctx, storageClusterName, metav1.GetOptions{})
+	switch {
+	case err == nil:
+		env = environmentODF
+		if external, _, _ := unstructured.NestedBool(storageCluster.Object, "spec", "externalStorage", "enable"); external {
+			env = environmentODFExternal
+		}
+	case namespace == environmentODF.namespace:
+		env = environmentODF
+	default:
+		env = environmentRook
+		_, err = typedClient.CoreV1().Namespaces().Get(ctx, openshiftConfigNamespace, metav1.GetOptions{})
+		env.openshift = err == nil
+	}
+	if namespace != env.namespace {
+		// The operator prefixes the CSI drivers with its namespace
+		env.namespace = namespace
+		env.rbdDriver = namespace + rbdCSIDriverSuffix
+		env.cephfsDriver = namespace + cephfsCSIDriverSuffix
+	}
+	return env
+}
+
+// detectStorage detects the storage environment of the cluster once it is connected, namespace overrides
+// the storage namespace. The environment stays ODF if it cannot be detected.
+func (k *kubeAccess) detectStorage(namespace string) {
+	env, err := detectEnvironment(k.typedClient, k.dynamicClient, namespace)
+	if err != nil {
+		log.WithError(err).Warnf("Could not detect the storage environment of %s, assuming %s", k.restConfig.Host, env.name)
+	} else {
+		log.Debugf("Detected %s in the %s namespace at %s", env.name, env.namespace, k.restConfig.Host)
+	}
+	k.env = &env
+	if runner, ok := k.toolbox.(*toolboxPodRunner); ok {
+		runner.namespace = env.namespace
+	}
+}
+
+// adaptPVToEnvironment points a copy of a Ceph PV of the peer cluster to the CSI driver, the clusterID and
+// the secrets of the environment it is created in
+func adaptPVToEnvironment(pv *corev1.PersistentVolume, env storageEnvironment) {
+	csi := pv.Spec.CSI.DeepCopy()
+	pv.Spec.CSI = csi
+	if isRBDPV(pv) {
+		csi.Driver = env.rbdDriver
+	} else if isCephFSPV(pv) {
+		csi.Driver = env.cephfsDriver
+	}
+	if _, found := csi.VolumeAttributes["clusterID"]; found {
+		csi.VolumeAttributes["clusterID"] = env.namespace
+	}
+	for _, secret := range []*corev1.SecretReference{csi.NodeStageSecretRef, csi.NodePublishSecretRef, csi.ControllerPublishSecretRef, csi.ControllerExpandSecretRef} {
+		if secret != nil {
+			secret.Namespace = env.namespace
+		}
+	}
+}
+
+// cephClusterResource is the CephCluster CR of Rook
+var cephClusterResource = schema.GroupVersionResource{Group: "ceph.rook.io", Version: "v1", Resource: "cephclusters"}
+
+// cephClusterPhase returns the phase of the CephCluster in the storage namespace
+func cephClusterPhase(ctx context.Context, cluster kubeAccess) (string, error) {
+	list, err := cluster.dynamicClient.Resource(cephClusterResource).Namespace(cluster.storageNamespace()).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return "", errors.WithMessagef(err, "[%s] Issues when listing the CephClusters", cluster.name)
+	}
+	if len(list.Items) == 0 {
+		return "", errors.Errorf("[%s] no CephCluster found in the %s namespace", cluster.name, cluster.storageNamespace())
+	}
+	phase, _, err := unstructured.NestedString(list.Items[0].Object, "status", "phase")
+	return phase, err
+}
+
+const toolboxName = "rook-ceph-tools"
+
+// newToolboxDeployment returns the toolbox of the Rook examples, with the Ceph image of the operator
+func newToolboxDeployment(namespace, image string) *appsv1.Deployment {
+	labels := map[string]string{"app": toolboxName}
+	replicas := int32(1)
+	return &appsv1.Deployment{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"},
+		ObjectMeta: metav1.ObjectMeta{Name: toolboxName, Namespace: namespace, Labels: labels},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Selector: &metav1.LabelSelector{MatchLabels: labels},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec: corev1.PodSpec{
+					DNSPolicy: corev1.DNSClusterFirstWithHostNet,
+					Containers: []corev1.Container{{
+						Name:    toolboxName,
+						Image:   image,
+						Command: []string{"/tini"},
+						Args:    []string{"-g", "--", "/usr/local/bin/toolbox.sh"},
+						Env: []corev1.EnvVar{
+							{Name: "ROOK_CEPH_USERNAME", ValueFrom: &corev1.EnvVarSource{SecretKeyRef: &corev1.SecretKeySelector{
+								LocalObjectReference: corev1.LocalObjectReference{Name: "rook-ceph-mon"}, Key: "ceph-username"}}},
+							{Name: "ROOK_CEPH_SECRET", ValueFrom: &corev1.EnvVarSource{SecretKeyRef: &corev1.SecretKeySelector{
+								LocalObjectReference: corev1.LocalObjectReference{Name: "rook-ceph-mon"}, Key: "ceph-secret"}}},
+						},
+						VolumeMounts: []corev1.VolumeMount{
+							{Name: "ceph-config", MountPath: "/etc/ceph"},
+							{Name: "mon-endpoint-volume", MountPath: "/etc/rook"},
+						},
+					}},
+					Volumes: []corev1.Volume{
+						{Name: "ceph-config", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}},
+						{Name: "mon-endpoint-volume", VolumeSource: corev1.VolumeSource{ConfigMap: &corev1.ConfigMapVolumeSource{
+							LocalObjectReference: corev1.LocalObjectReference{Name: monEndpointsConfigMap},
+							Items:                []corev1.KeyToPath{{Key: "data", Path: "mon-endpoints"}},
+						}}},
+					},
+				},
+			},
+		},
+	}
+}
+
+// deployToolbox creates the toolbox Deployment of Rook, it uses the image of the rook-ceph-operator
+func deployToolbox(ctx context.Context, cluster kubeAccess) error {
+	namespace := cluster.storageNamespace()
+	operator, err := cluster.typedClient.AppsV1().Deployments(namespace).Get(ctx, "rook-ceph-operator", metav1.GetOptions{})
+	if err != nil {
+		return errors.WithMessagef(err, "[%s] Issues when fetching the rook-ceph-operator Deployment", cluster.name)
+	}
+	if len(operator.Spec.Template.Spec.Containers) == 0 {
+		return errors.Errorf("[%s] the rook-ceph-operator Deployment has no containers", cluster.name)
+	}
+	toolbox := newToolboxDeployment(namespace, operator.Spec.Template.Spec.Containers[0].Image)
+	if dryRun.intercept(cluster, "create", "Deployment/"+toolboxName, fmt.Sprintf("image %s", toolbox.Spec.Template.Spec.Containers[0].Image)) {
+		return nil
+	}
+	_, err = cluster.typedClient.AppsV1().Deployments(namespace).Create(ctx, toolbox, metav1.CreateOptions{})
+	if err != nil && !apierrors.IsAlreadyExists(err) {
+		return errors.WithMessagef(err, "[%s] Issues when creating the toolbox Deployment", cluster.name)
+	}
+	installProgress.forCluster(cluster.name).info("Rook Toolbox deployed")
+	return nil
+}
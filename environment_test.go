@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	cephv1 "github.com/rook/rook/pkg/apis/ceph.rook.io/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func newNamespace(name string) *corev1.Namespace {
+	return &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name}}
+}
+
+// useEnvironment makes the fake cluster look like it was detected in env
+func useEnvironment(cluster *kubeAccess, env storageEnvironment) {
+	cluster.env = &env
+}
+
+func TestDetectEnvironment(t *testing.T) {
+	external := newStorageCluster()
+	if err := unstructured.SetNestedField(external.Object, true, "spec", "externalStorage", "enable"); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name      string
+		objects   []runtime.Object
+		expected  string
+		openshift bool
+	}{
+		{"odf", []runtime.Object{newNamespace(environmentODF.namespace), newStorageCluster()}, environmentODF.name, true},
+		{"external", []runtime.Object{newNamespace(environmentODF.namespace), external}, environmentODFExternal.name, true},
+		{"rook", []runtime.Object{newNamespace(rookNamespace)}, environmentRook.name, false},
+		{"rook on openshift", []runtime.Object{newNamespace(rookNamespace), newNamespace(openshiftConfigNamespace)}, environmentRook.name, true},
+	}
+	for _, test := range tests {
+		cluster, _ := newFakeCluster(t, test.name, test.objects...)
+		env, err := detectEnvironment(cluster.typedClient, cluster.dynamicClient, "")
+		if err != nil || env.name != test.expected || env.openshift != test.openshift {
+			t.Errorf("%s: expected %s (OpenShift %t), got %s (OpenShift %t, %v)", test.name, test.expected, test.openshift, env.name, env.openshift, err)
+		}
+	}
+
+	empty, _ := newFakeCluster(t, "empty")
+	if env, err := detectEnvironment(empty.typedClient, empty.dynamicClient, ""); err == nil || env.name != environmentODF.name {
+		t.Errorf("expected an error and the ODF fallback without storage namespace, got %s (%v)", env.name, err)
+	}
+}
+
+func TestStorageNamespaceOverride(t *testing.T) {
+	cluster, _ := newFakeCluster(t, "rook", newNamespace(rookNamespace), newNamespace("storage"))
+	runner := &toolboxPodRunner{typedClient: cluster.typedClient, namespace: environmentODF.namespace}
+	cluster.toolbox = runner
+
+	cluster.detectStorage("storage")
+	env := cluster.environment()
+	if env.name != environmentRook.name || env.namespace != "storage" || env.rbdDriver != "storage"+rbdCSIDriverSuffix ||
+		env.cephfsDriver != "storage"+cephfsCSIDriverSuffix {
+		t.Errorf("expected Rook in the configured namespace, got %+v", env)
+	}
+	if runner.namespace != "storage" {
+		t.Errorf("expected the toolbox to be looked up in the configured namespace, got %s", runner.namespace)
+	}
+
+	if _, err := detectEnvironment(cluster.typedClient, cluster.dynamicClient, "missing"); err == nil {
+		t.Error("expected an error for a configured namespace that does not exist")
+	}
+}
+
+func TestRookPVs(t *testing.T) {
+	pv := newRBDPV("pv-db", "shop", "db", "csi-vol-db", corev1.VolumeBound)
+	pv.Spec.CSI.Driver = environmentRook.rbdDriver
+	pv.Spec.CSI.VolumeAttributes["clusterID"] = rookNamespace
+	pv.Spec.CSI.NodeStageSecretRef = &corev1.SecretReference{Name: "rook-csi-rbd-node", Namespace: rookNamespace}
+	if !isRBDPV(pv) || !isCephPV(pv) || isCephFSPV(pv) {
+		t.Error("expected the PV of the Rook driver to be a Ceph RBD PV")
+	}
+
+	replica := pv.DeepCopy()
+	adaptPVToEnvironment(replica, environmentODF)
+	if replica.Spec.CSI.Driver != environmentODF.rbdDriver || replica.Spec.CSI.VolumeAttributes["clusterID"] != environmentODF.namespace ||
+		replica.Spec.CSI.NodeStageSecretRef.Namespace != environmentODF.namespace {
+		t.Errorf("expected the replica to use the ODF driver and namespace, got %+v", replica.Spec.CSI)
+	}
+	if pv.Spec.CSI.Driver != environmentRook.rbdDriver || pv.Spec.CSI.NodeStageSecretRef.Namespace != rookNamespace {
+		t.Error("expected the source PV to be unchanged")
+	}
+
+	storageClass := newMirroringStorageClass(environmentRook)
+	if storageClass.Provisioner != environmentRook.rbdDriver || storageClass.Parameters["clusterID"] != rookNamespace ||
+		storageClass.Parameters["csi.storage.k8s.io/provisioner-secret-namespace"] != rookNamespace {
+		t.Errorf("expected the StorageClass of the Rook driver, got %s with %v", storageClass.Provisioner, storageClass.Parameters)
+	}
+}
+
+func TestDeployToolbox(t *testing.T) {
+	operator := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "rook-ceph-operator", Namespace: rookNamespace},
+		Spec: appsv1.DeploymentSpec{Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{Name: "rook-ceph-operator", Image: "rook/ceph:v1.5.8"}},
+		}}},
+	}
+	cluster, _ := newFakeCluster(t, "rook", operator)
+	useEnvironment(&cluster, environmentRook)
+	ctx := context.Background()
+
+	if err := enableToolbox(ctx, cluster); err != nil {
+		t.Fatal(err)
+	}
+	toolbox, err := cluster.typedClient.AppsV1().Deployments(rookNamespace).Get(ctx, toolboxName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if image := toolbox.Spec.Template.Spec.Containers[0].Image; image != "rook/ceph:v1.5.8" {
+		t.Errorf("expected the image of the operator, got %s", image)
+	}
+	// Deploying twice is fine
+	if err := enableToolbox(ctx, cluster); err != nil {
+		t.Error(err)
+	}
+
+	profile, err := detectODFProfile(cluster)
+	if err != nil || profile.name != rookProfile.name {
+		t.Errorf("expected the Rook profile without OCS CSV, got %+v (%v)", profile, err)
+	}
+}
+
+func TestExternalMode(t *testing.T) {
+	primary, _ := newFakeCluster(t, "primary", newOCSCSV(t, "4.9.0"))
+	secondary, _ := newFakeCluster(t, "secondary", newOCSCSV(t, "4.9.0"))
+	useEnvironment(&primary, environmentODFExternal)
+	useEnvironment(&secondary, environmentODFExternal)
+
+	profile, err := installProfile(primary, secondary)
+	if err != nil || profile.setup != setupExternal || !strings.HasSuffix(profile.name, "in external mode") {
+		t.Fatalf("expected the external setup, got %+v (%v)", profile, err)
+	}
+	for _, name := range stepNames(installSteps(profile)) {
+		if name != "toolbox" && name != "oadp" {
+			t.Errorf("expected no mirroring steps in external mode, got %s", name)
+		}
+	}
+
+	report := runChecks(context.Background(), phaseVerify, nil, primary, secondary)
+	for _, result := range report.Results {
+		check, _ := findCheck(result.Check)
+		if applies, _ := check.appliesToAll(primary); !applies && result.Status != checkSkip {
+			t.Errorf("expected %s to be skipped for %s, got %s", result.Check, result.Cluster, result.Status)
+		}
+	}
+}
+
+func TestInstallStepsMixedEnvironments(t *testing.T) {
+	defer func() { dryRun.enabled = false }()
+	primary, _ := newFakeCluster(t, "primary",
+		&cephv1.CephBlockPool{ObjectMeta: metav1.ObjectMeta{Name: environmentODF.defaultPool, Namespace: environmentODF.namespace}})
+	secondary, _ := newFakeCluster(t, "secondary",
+		&cephv1.CephBlockPool{ObjectMeta: metav1.ObjectMeta{Name: environmentRook.defaultPool, Namespace: rookNamespace}})
+	useEnvironment(&primary, environmentODF)
+	useEnvironment(&secondary, environmentRook)
+	dryRun.enabled = true
+	dryRun.start()
+
+	var steps []installStep
+	for _, step := range installSteps(rookProfile) {
+		if step.name == "pool-mirroring" || step.name == "bootstrap-secrets" {
+			steps = append(steps, step)
+		}
+	}
+	if err := runInstallSteps(context.Background(), steps, &primary, &secondary); err != nil {
+		t.Fatal(err)
+	}
+	planned := make(map[string]string)
+	for _, action := range dryRun.plannedActions() {
+		planned[action.String()] = action.Payload
+	}
+	for _, expected := range []string{
+		"[primary] patch CephBlockPool/" + environmentODF.defaultPool,
+		"[secondary] patch CephBlockPool/" + environmentRook.defaultPool,
+	} {
+		if _, found := planned[expected]; !found {
+			t.Errorf("expected each cluster to mirror its own default pool with %q, got %v", expected, planned)
+		}
+	}
+	// Each cluster imports the token of the pool of its peer into its own pool
+	if payload := planned["[secondary] patch Secret/mirror-bootstrap-"+environmentRook.defaultPool]; !strings.Contains(payload, "CephBlockPool "+environmentODF.defaultPool+" in the primary cluster") {
+		t.Errorf("expected the Rook cluster to import the token of the ODF pool, got %v", planned)
+	}
+	if payload := planned["[primary] patch Secret/mirror-bootstrap-"+environmentODF.defaultPool]; !strings.Contains(payload, "CephBlockPool "+environmentRook.defaultPool+" in the secondary cluster") {
+		t.Errorf("expected the ODF cluster to import the token of the Rook pool, got %v", planned)
+	}
+}
+
+func TestPodNetworkCheckOnKubernetes(t *testing.T) {
+	primary, _ := newFakeCluster(t, "primary")
+	secondary, _ := newFakeCluster(t, "secondary")
+	useEnvironment(&secondary, environmentRook)
+
+	report := runChecks(context.Background(), phaseInstall, nil, primary, secondary)
+	for _, cluster := range []string{"primary", "secondary"} {
+		if result, found := report.get(checkPodNetwork, cluster); !found || result.Status != checkSkip {
+			t.Errorf("expected the pod-network check from %s to be skipped without OpenShift, got %+v", cluster, result)
+		}
+	}
+
+	// On OpenShift, missing network-check-target Pods fail the check instead of passing it
+	result := verifyPodNetwork(context.Background(), primary, primary)
+	if result.Status != checkFail || !strings.Contains(result.Details, "no network-check-target Pod found") {
+		t.Errorf("expected the check to fail without network-check-target Pods, got %+v", result)
+	}
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// eventKind is the type of a progress event
+type eventKind string
+
+const (
+	eventInfo         eventKind = "info"
+	eventStepStarted  eventKind = "step-started"
+	eventStepFinished eventKind = "step-finished"
+	eventStepFailed   eventKind = "step-failed"
+	// eventResult is the outcome for a single object, like a PV or a check
+	eventResult  eventKind = "result"
+	eventWarning eventKind = "warning"
+)
+
+// progressEvent is emitted by install, verify, PVC protection and failover
+type progressEvent struct {
+	Time time.Time `json:"time"`
+	Kind eventKind `json:"kind"`
+	// Operation is install, verify, protection or failover
+	Operation string `json:"operation"`
+	Cluster   string `json:"cluster,omitempty"`
+	Step      string `json:"step,omitempty"`
+	Object    string `json:"object,omitempty"`
+	Message   string `json:"message"`
+	Error     string `json:"error,omitempty"`
+}
+
+// failed returns true for failed steps and failed results
+func (e progressEvent) failed() bool {
+	return e.Error != "" && (e.Kind == eventStepFailed || e.Kind == eventResult)
+}
+
+// eventBus passes progress events to all subscribers, in the goroutine that emits them
+type eventBus struct {
+	mu          sync.Mutex
+	subscribers map[int]func(progressEvent)
+	next        int
+}
+
+// events receives the progress of all operations
+var events = newEventBus()
+
+func newEventBus() *eventBus {
+	return &eventBus{subscribers: make(map[int]func(progressEvent))}
+}
+
+// subscribe calls handler for every event until the returned function is called
+func (b *eventBus) subscribe(handler func(progressEvent)) (unsubscribe func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	id := b.next
+	b.next++
+	b.subscribers[id] = handler
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.subscribers, id)
+	}
+}
+
+func (b *eventBus) emit(event progressEvent) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	logEvent(event)
+	b.mu.Lock()
+	handlers := make([]func(progressEvent), 0, len(b.subscribers))
+	for _, handler := range b.subscribers {
+		handlers = append(handlers, handler)
+	}
+	b.mu.Unlock()
+	for _, handler := range handlers {
+		handler(event)
+	}
+}
+
+// logEvent writes every event to the log, so the log has the full history of all operations
+func logEvent(event progressEvent) {
+	entry := log.WithField("operation", event.Operation)
+	if event.Step != "" {
+		entry = entry.WithField("step", event.Step)
+	}
+	if event.Object != "" {
+		entry = entry.WithField("object", event.Object)
+	}
+	if event.Error != "" {
+		entry = entry.WithField("error", event.Error)
+	}
+	level := logrus.InfoLevel
+	if event.Kind == eventWarning {
+		level = logrus.WarnLevel
+	}
+	if event.failed() {
+		level = logrus.ErrorLevel
+	}
+	entry.Log(level, event.text())
+}
+
+// reporter emits the events of one operation, optionally for one cluster
+type reporter struct {
+	bus       *eventBus
+	operation string
+	cluster   string
+}
+
+func (b *eventBus) reporter(operation string) reporter {
+	return reporter{bus: b, operation: operation}
+}
+
+func (r reporter) forCluster(name string) reporter {
+	r.cluster = name
+	return r
+}
+
+func (r reporter) emit(kind eventKind, step, object string, err error, format string, a ...interface{}) {
+	event := progressEvent{
+		Kind:      kind,
+		Operation: r.operation,
+		Cluster:   r.cluster,
+		Step:      step,
+		Object:    object,
+		Message:   fmt.Sprintf(format, a...),
+	}
+	if err != nil {
+		event.Error = err.Error()
+	}
+	r.bus.emit(event)
+}
+
+func (r reporter) info(format string, a ...interface{}) {
+	r.emit(eventInfo, "", "", nil, format, a...)
+}
+
+func (r reporter) warn(format string, a ...interface{}) {
+	r.emit(eventWarning, "", "", nil, format, a...)
+}
+
+func (r reporter) started(step, format string, a ...interface{}) {
+	r.emit(eventStepStarted, step, "", nil, format, a...)
+}
+
+func (r reporter) finished(step, format string, a ...interface{}) {
+	r.emit(eventStepFinished, step, "", nil, format, a...)
+}
+
+// failed reports the failed step and returns err with the message
+func (r reporter) failed(step string, err error, format string, a ...interface{}) error {
+	r.emit(eventStepFailed, step, "", err, format, a...)
+	return errors.WithMessagef(err, format, a...)
+}
+
+// result reports the outcome for a single object, it failed if err is set
+func (r reporter) result(object string, err error, format string, a ...interface{}) {
+	r.emit(eventResult, "", object, err, format, a...)
+}
+
+// text renders the event as a line of progress output
+func (e progressEvent) text() string {
+	message := e.Message
+	if e.Cluster != "" {
+		message = fmt.Sprintf("[%s] %s", e.Cluster, message)
+	}
+	switch {
+	case e.Kind == eventStepFailed:
+		return fmt.Sprintf("❌ %s: %s", message, e.Error)
+	case e.Kind == eventResult && e.failed():
+		return fmt.Sprintf("  ❌ %s: %s", message, e.Error)
+	case e.Kind == eventResult:
+		return fmt.Sprintf("  ✔️ %s", message)
+	case e.Kind == eventWarning:
+		return fmt.Sprintf("  ⚠️ %s", message)
+	}
+	return message
+}
+
+// textSink writes the events as lines of text, e.g. to a TextView or stdout
+func textSink(target io.Writer) func(progressEvent) {
+	return func(event progressEvent) {
+		if _, err := fmt.Fprintln(target, event.text()); err != nil {
+			log.WithError(err).Error("Error when writing progress")
+		}
+	}
+}
+
+// jsonSink writes the events as JSON lines
+func jsonSink(target io.Writer) func(progressEvent) {
+	var mu sync.Mutex
+	encoder := json.NewEncoder(target)
+	return func(event progressEvent) {
+		mu.Lock()
+		defer mu.Unlock()
+		if err := encoder.Encode(event); err != nil {
+			log.WithError(err).Error("Error when writing progress as JSON")
+		}
+	}
+}
+
+// alertSink shows failed steps as alert
+func alertSink(event progressEvent) {
+	if event.Kind == eventStepFailed {
+		showAlert(event.Message)
+	}
+}
+
+// forOperation only passes the events of the operation to handler
+func forOperation(operation string, handler func(progressEvent)) func(progressEvent) {
+	return func(event progressEvent) {
+		if event.Operation == operation {
+			handler(event)
+		}
+	}
+}
+
+// subscribeEventLog appends all events as JSON lines to the file configured as eventLog
+func subscribeEventLog() {
+	if appConfig.EventLog == "" {
+		return
+	}
+	f, err := os.OpenFile(appConfig.EventLog, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		log.WithError(err).Warnf("Could not open the event log %s", appConfig.EventLog)
+		return
+	}
+	events.subscribe(jsonSink(f))
+}
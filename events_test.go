@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestEventBus(t *testing.T) {
+	bus := newEventBus()
+	var installEvents, allEvents []progressEvent
+	unsubscribeInstall := bus.subscribe(forOperation("install", func(event progressEvent) {
+		installEvents = append(installEvents, event)
+	}))
+	bus.subscribe(func(event progressEvent) { allEvents = append(allEvents, event) })
+
+	install := bus.reporter("install")
+	install.started("toolbox", "Enabling the Ceph Toolbox")
+	err := install.forCluster("primary").failed("toolbox", errors.New("forbidden"), "Issues when enabling the Toolbox")
+	bus.reporter("failover").info("Promoting PVs")
+	unsubscribeInstall()
+	install.info("not received after unsubscribing")
+
+	if err == nil || err.Error() != "Issues when enabling the Toolbox: forbidden" {
+		t.Errorf("expected the error with the message, got %v", err)
+	}
+	if len(allEvents) != 4 {
+		t.Errorf("expected all 4 events, got %d", len(allEvents))
+	}
+	var kinds []eventKind
+	for _, event := range installEvents {
+		kinds = append(kinds, event.Kind)
+	}
+	if !reflect.DeepEqual(kinds, []eventKind{eventStepStarted, eventStepFailed}) {
+		t.Errorf("unexpected install events %v", kinds)
+	}
+	failed := installEvents[1]
+	if failed.Cluster != "primary" || failed.Step != "toolbox" || failed.Error != "forbidden" || failed.Time.IsZero() {
+		t.Errorf("unexpected failed event %+v", failed)
+	}
+}
+
+func TestEventSinks(t *testing.T) {
+	bus := newEventBus()
+	text := &strings.Builder{}
+	jsonLines := &strings.Builder{}
+	bus.subscribe(textSink(text))
+	bus.subscribe(jsonSink(jsonLines))
+
+	progress := bus.reporter("protection").forCluster("primary")
+	progress.result("pv-shop", nil, "mirroring enabled for PV %s", "pv-shop")
+	progress.result("pv-logs", errors.New("rbd failed"), "mirroring enabled for PV %s", "pv-logs")
+	progress.warn("pool %s is degraded", "replicapool")
+
+	expected := "  ✔️ [primary] mirroring enabled for PV pv-shop\n" +
+		"  ❌ [primary] mirroring enabled for PV pv-logs: rbd failed\n" +
+		"  ⚠️ [primary] pool replicapool is degraded\n"
+	if text.String() != expected {
+		t.Errorf("expected text output\n%s\ngot\n%s", expected, text.String())
+	}
+
+	lines := strings.Split(strings.TrimSpace(jsonLines.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected one JSON line per event, got %q", jsonLines.String())
+	}
+	var event progressEvent
+	if err := json.Unmarshal([]byte(lines[1]), &event); err != nil {
+		t.Fatal(err)
+	}
+	if event.Kind != eventResult || event.Object != "pv-logs" || event.Operation != "protection" || !event.failed() {
+		t.Errorf("unexpected JSON event %+v", event)
+	}
+}
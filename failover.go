@@ -3,17 +3,17 @@ package main
 import (
 	"context"
 	"fmt"
-	"time"
 
 	"github.com/gdamore/tcell/v2"
+	"github.com/pkg/errors"
 	"github.com/rivo/tview"
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
-var contextTimeout = 2 * time.Second
-
 func askSeriousForFailover() {
-	showModal("sure", "Are you sure you want to start a Failover?",
+	showModal("sure", fmt.Sprintf("Are you sure you want to start a Failover?\nfailOVER moves the namespaces from %s to %s, failBACK from %s to %s.",
+		kubeConfigPrimary.name, kubeConfigSecondary.name, kubeConfigSecondary.name, kubeConfigPrimary.name),
 		[]string{"failOVER", "failBACK", "NO"},
 		func(buttonIndex int, buttonLabel string) {
 			pages.RemovePage("sure")
@@ -108,7 +108,7 @@ func populateTableWithRestoreableNamespaces(table *tview.Table, cluster kubeAcce
 	table.Clear()
 	namespaces, err := getListOfRestoreableNamespaces(cluster)
 	if err != nil {
-		log.WithError(err).Warnf("Issues when collecting namespaces from the %s cluster for failover")
+		log.WithError(err).Warnf("Issues when collecting namespaces from the %s cluster for failover", cluster.name)
 		return
 	}
 	log.Debugf("Found %d restorable namespaces", len(namespaces))
@@ -136,15 +136,15 @@ func getListOfRestoreableNamespaces(cluster kubeAccess) (restoreableNamespace ma
 	// Filter for PVs in Released state, these are most likely our mirrored PVs
 	// field-selector does not support status.phase for PVs :/
 	for _, pv := range targetPVs.Items {
-		if pv.Spec.CSI == nil || pv.Spec.CSI.Driver != "openshift-storage.rbd.csi.ceph.com" {
-			// not a CSI backed PV or not a Ceph RBD PV
+		if !isCephPV(&pv) {
+			// not a Ceph RBD or CephFS PV
 			continue
 		}
 		if pv.Spec.ClaimRef == nil {
 			// The PV does not have the ClaimRef we need...
 			continue
 		}
-		// We assume here for performance reasons, that all released RBD PVs are mirrored PVs
+		// We assume here for performance reasons, that all released Ceph PVs are mirrored PVs
 		restoreableNamespace[pv.Spec.ClaimRef.Namespace] = nil
 	}
 	return
@@ -158,62 +158,89 @@ func showFailoverWithNamespaces(from, to kubeAccess, namespaces []string) {
 	pages.AddPage("failoverAction", failoverLog, true, true)
 	pages.SwitchToPage("failoverAction")
 
-	go workOnFailoverWithNamespaces(from, to, namespaces, failoverLog)
+	unsubscribe := events.subscribe(forOperation("failover", textSink(failoverLog)))
+	runCancellable(failoverLog, func(ctx context.Context) {
+		if err := checkFailoverRequirements(ctx, to); err != nil {
+			return
+		}
+		dryRun.start()
+		workOnFailoverWithNamespaces(ctx, from, to, namespaces)
+		if dryRun.enabled {
+			dryRun.print(failoverLog)
+		}
+	}, func() {
+		unsubscribe()
+		pages.SwitchToPage("main")
+		pages.RemovePage("failoverAction")
+	})
 }
 
-func workOnFailoverWithNamespaces(from, to kubeAccess, namespaces []string, failoverLog *tview.TextView) {
-	addRowOfTextOutput(failoverLog, "Trying to demote PVs in the %s cluster now...", from.name)
-	addRowOfTextOutput(failoverLog, "This is OK to fail")
-	err := changePVStatiInNamespaces(from, namespaces, "demote", failoverLog)
+// failoverProgress reports the progress of failovers and failbacks
+var failoverProgress = events.reporter("failover")
+
+// checkFailoverRequirements runs the failover checks of the registry on the cluster that takes over.
+// The other cluster is not checked, it might be down.
+func checkFailoverRequirements(ctx context.Context, to kubeAccess) error {
+	if err := requireChecks(ctx, phaseFailover, failoverProgress, to); err != nil {
+		return failoverProgress.failed("checks", err, "The %s cluster cannot take over", to.name)
+	}
+	return nil
+}
+
+func workOnFailoverWithNamespaces(ctx context.Context, from, to kubeAccess, namespaces []string) error {
+	failoverProgress.started("demote", "Trying to demote PVs in the %s cluster now...", from.name)
+	failoverProgress.info("This is OK to fail")
+	err := changePVStatiInNamespaces(ctx, from, namespaces, "demote")
 	if err != nil {
-		addRowOfTextOutput(failoverLog, "Issues when demoting images in the %s cluster: %s", from.name, err)
+		failoverProgress.warn("Issues when demoting images in the %s cluster: %s", from.name, err)
+	}
+	if ctx.Err() != nil {
+		return failoverProgress.failed("demote", ctx.Err(), "Failover stopped")
 	}
-	addRowOfTextOutput(failoverLog, "Finished demoting PVs in the %s cluster!", from.name)
-	addRowOfTextOutput(failoverLog, "Promoting PVs in the %s cluster now...", to.name)
-	err = changePVStatiInNamespaces(to, namespaces, "promote", failoverLog)
+	failoverProgress.finished("demote", "Finished demoting PVs in the %s cluster!", from.name)
+	failoverProgress.started("promote", "Promoting PVs in the %s cluster now...", to.name)
+	err = changePVStatiInNamespaces(ctx, to, namespaces, "promote")
 	if err != nil {
-		addRowOfTextOutput(failoverLog, "Issues when promoting images in the %s cluster: %s", to.name, err)
-		addRowOfTextOutput(failoverLog, "Bailing out - please consult the log and try again later")
-		return
+		return failoverProgress.failed("promote", err, "Issues when promoting images in the %s cluster, bailing out - please consult the log and try again later", to.name)
 	}
-	addRowOfTextOutput(failoverLog, "Finished promoting PVs in the %s cluster!", to.name)
+	failoverProgress.finished("promote", "Finished promoting PVs in the %s cluster!", to.name)
 
 	if !checkForOADP(to) {
 		// No OADP installed in target cluster, we are done
-		addRowOfTextOutput(failoverLog, "OADP is not installed in the %s cluster - we are done now", to.name)
-		return
+		failoverProgress.info("OADP is not installed in the %s cluster - we are done now", to.name)
+		return nil
 	}
 
-	addRowOfTextOutput(failoverLog, "Starting namespace recovery in the %s cluster!", to.name)
-	err = setNamespacesToRestore(to, namespaces)
+	failoverProgress.started("restore", "Starting namespace recovery in the %s cluster!", to.name)
+	err = setNamespacesToRestore(ctx, to, namespaces)
 	if err != nil {
-		log.Errorf("Issues when restoring namespaces with OADP: %s\n\nCheck the log for more information", err)
-		showAlert(fmt.Sprintf("Issues when restoring namespaces with OADP: %s\n\nCheck the log for more information", err))
+		return failoverProgress.failed("restore", err, "Issues when restoring namespaces with OADP, check the log for more information")
 	}
-	addRowOfTextOutput(failoverLog, "Recovery CR is created, waiting for Recovery to finish...")
+	failoverProgress.info("Recovery CR is created, waiting for Recovery to finish...")
 
-	waitForRecoveryDone(to, failoverLog)
+	err = waitForRecoveryDone(ctx, to)
+	if err != nil {
+		return failoverProgress.failed("restore", err, "Recovery did not finish")
+	}
 
-	failoverLog.SetDoneFunc(func(key tcell.Key) {
-		pages.SwitchToPage("main")
-		pages.RemovePage("failoverAction")
-	})
-	addRowOfTextOutput(failoverLog, "Recovery is finished")
-	addRowOfTextOutput(failoverLog, "Failover from the %s to the %s cluster is done", from.name, to.name)
-	addRowOfTextOutput(failoverLog, "Press Enter or Esc to return to the main menu")
+	failoverProgress.finished("restore", "Recovery is finished")
+	failoverProgress.info("Failover from the %s to the %s cluster is done", from.name, to.name)
+	return nil
 }
 
-func changePVStatiInNamespaces(cluster kubeAccess, namespaces []string, action string, failoverLog *tview.TextView) error {
-	ctx, cancel := context.WithTimeout(context.Background(), contextTimeout)
+func changePVStatiInNamespaces(ctx context.Context, cluster kubeAccess, namespaces []string, action string) error {
+	progress := failoverProgress.forCluster(cluster.name)
+	requestCtx, cancel := requestContext(ctx)
 	defer cancel()
-	pvs, err := cluster.typedClient.CoreV1().PersistentVolumes().List(ctx, metav1.ListOptions{})
+	pvs, err := cluster.typedClient.CoreV1().PersistentVolumes().List(requestCtx, metav1.ListOptions{})
 	if err != nil {
 		log.WithError(err).Warn("Issues when listing pods for PVC list")
 		return err
 	}
+	var namespacePVs []corev1.PersistentVolume
 	for _, pv := range pvs.Items {
-		if pv.Spec.CSI == nil || pv.Spec.CSI.Driver != "openshift-storage.rbd.csi.ceph.com" {
-			// not a CSI backed PV or not a Ceph RBD PV
+		if !isCephPV(&pv) {
+			// not a Ceph RBD or CephFS PV
 			continue
 		}
 		if pv.Spec.ClaimRef == nil {
@@ -223,22 +250,43 @@ func changePVStatiInNamespaces(cluster kubeAccess, namespaces []string, action s
 		if !stringInSliceBool(pv.Spec.ClaimRef.Namespace, namespaces) {
 			continue
 		}
-		if mirrored, err := checkMirrorStatus(cluster, &pv); !mirrored || err != nil {
+		namespacePVs = append(namespacePVs, pv)
+	}
+	statuses, err := getMirrorStatuses(cluster, namespacePVs)
+	if err != nil {
+		progress.warn("%s", err)
+	}
+	backend := replicationFor(ctx, cluster)
+	progress.info("Using %s to %s the PVs", backend.name(), action)
+	failed, changed := 0, 0
+	for _, pv := range namespacePVs {
+		if ctx.Err() != nil {
+			return errors.Wrapf(ctx.Err(), "[%s] Stopped to %s PVs", cluster.name, action)
+		}
+		if status, known := statuses[pv.Name]; !known || status.State == rbdMirrorStateDisabled {
 			// Could not determine mirror status or is not mirrored, skip
 			continue
 		}
 
 		switch action {
 		case "demote":
-			err = demotePV(cluster, &pv)
+			err = backend.demote(ctx, &pv)
 		case "promote":
-			err = promotePV(cluster, &pv)
+			err = backend.promote(ctx, &pv, false)
 		}
 		if err != nil {
-			addRowOfTextOutput(failoverLog, "  ❌ failed to change mirror status for PV %s", pv.Name)
+			progress.result(pv.Name, err, "failed to change mirror status for PV %s", pv.Name)
+			if isRBDBusy(err) {
+				progress.warn("the image of PV %s is still primary in the other cluster, it needs to be demoted there first", pv.Name)
+			}
+			failed++
 			continue
 		}
-		addRowOfTextOutput(failoverLog, "  ✔️ mirror status changed for PV %s", pv.Name)
+		changed++
+		progress.result(pv.Name, nil, "mirror status changed for PV %s", pv.Name)
+	}
+	if failed > 0 {
+		return errors.Errorf("[%s] %d of %d PVs failed to %s", cluster.name, failed, failed+changed, action)
 	}
 	return nil
 }
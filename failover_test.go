@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestGetListOfRestoreableNamespaces(t *testing.T) {
+	nfsPV := newRBDPV("pv-nfs", "files", "data", "", corev1.VolumeReleased)
+	nfsPV.Spec.CSI = nil
+	cluster, _ := newFakeCluster(t, "secondary",
+		newRBDPV("pv-shop", "shop", "data", "img-shop", corev1.VolumeReleased),
+		newRBDPV("pv-shop-logs", "shop", "logs", "img-shop-logs", corev1.VolumeReleased),
+		newRBDPV("pv-analytics", "analytics", "data", "img-analytics", corev1.VolumeReleased),
+		newRBDPV("pv-unbound", "", "", "img-unbound", corev1.VolumeAvailable),
+		nfsPV,
+	)
+
+	namespaces, err := getListOfRestoreableNamespaces(cluster)
+	if err != nil {
+		t.Fatalf("getListOfRestoreableNamespaces failed: %s", err)
+	}
+	expected := map[string]interface{}{"shop": nil, "analytics": nil}
+	if !reflect.DeepEqual(namespaces, expected) {
+		t.Errorf("expected namespaces %v, got %v", expected, namespaces)
+	}
+}
+
+func TestChangePVStatiInNamespaces(t *testing.T) {
+	cluster, toolbox := newFakeCluster(t, "secondary",
+		newRBDPV("pv-shop", "shop", "data", "img-shop", corev1.VolumeReleased),
+		newRBDPV("pv-shop-local", "shop", "cache", "img-shop-local", corev1.VolumeReleased),
+		newRBDPV("pv-shop-broken", "shop", "logs", "img-shop-broken", corev1.VolumeReleased),
+		newRBDPV("pv-analytics", "analytics", "data", "img-analytics", corev1.VolumeReleased),
+	)
+	toolbox.mirrored["replicapool/img-shop"] = "up+replaying"
+	toolbox.mirrored["replicapool/img-shop-broken"] = "up+replaying"
+	toolbox.mirrored["replicapool/img-analytics"] = "up+replaying"
+	toolbox.failing["rbd mirror image promote replicapool/img-shop-broken"] = rbdExitBusy
+
+	recorder := recordEvents(t)
+	err := changePVStatiInNamespaces(context.Background(), cluster, []string{"shop"}, "promote")
+	if err == nil || !strings.Contains(err.Error(), "1 of 2 PVs failed to promote") {
+		t.Errorf("expected the failed promotion to be returned, got %v", err)
+	}
+
+	promoted := toolbox.commandsContaining("promote")
+	expected := []string{
+		"rbd mirror image promote replicapool/img-shop",
+		"rbd mirror image promote replicapool/img-shop-broken",
+	}
+	if !reflect.DeepEqual(promoted, expected) {
+		t.Errorf("expected commands %v, got %v", expected, promoted)
+	}
+	if !strings.Contains(recorder.text.String(), "mirror status changed for PV pv-shop\n") {
+		t.Errorf("expected the promotion of pv-shop in the output, got %q", recorder.text.String())
+	}
+	if !strings.Contains(recorder.text.String(), "failed to change mirror status for PV pv-shop-broken") {
+		t.Errorf("expected the failed promotion of pv-shop-broken in the output, got %q", recorder.text.String())
+	}
+	if !strings.Contains(recorder.text.String(), "still primary in the other cluster") {
+		t.Errorf("expected a hint to demote the image in the other cluster, got %q", recorder.text.String())
+	}
+}
+
+func TestChangePVStatiInNamespacesDemote(t *testing.T) {
+	cluster, toolbox := newFakeCluster(t, "primary",
+		newRBDPV("pv-shop", "shop", "data", "img-shop", corev1.VolumeBound),
+	)
+	toolbox.mirrored["replicapool/img-shop"] = "up+replaying"
+
+	if err := changePVStatiInNamespaces(context.Background(), cluster, []string{"shop"}, "demote"); err != nil {
+		t.Fatalf("changePVStatiInNamespaces failed: %s", err)
+	}
+	demoted := toolbox.commandsContaining("demote")
+	if !reflect.DeepEqual(demoted, []string{"rbd mirror image demote replicapool/img-shop"}) {
+		t.Errorf("expected pv-shop to be demoted, got commands %v", demoted)
+	}
+}
+
+func TestChangePVStatiInNamespacesCancelled(t *testing.T) {
+	cluster, toolbox := newFakeCluster(t, "secondary",
+		newRBDPV("pv-shop", "shop", "data", "img-shop", corev1.VolumeReleased),
+	)
+	toolbox.mirrored["replicapool/img-shop"] = "up+replaying"
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := changePVStatiInNamespaces(ctx, cluster, []string{"shop"}, "promote"); err == nil {
+		t.Error("expected an error after the cancellation")
+	}
+	if promoted := toolbox.commandsContaining("promote"); len(promoted) != 0 {
+		t.Errorf("expected no promotion after the cancellation, got %v", promoted)
+	}
+}
@@ -0,0 +1,456 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+
+	ocsv1 "github.com/openshift/ocs-operator/api/v1"
+	"github.com/operator-framework/api/pkg/lib/version"
+	operatorsv1alpha1 "github.com/operator-framework/api/pkg/operators/v1alpha1"
+	"github.com/pkg/errors"
+	cephv1 "github.com/rook/rook/pkg/apis/ceph.rook.io/v1"
+	velerov1 "github.com/vmware-tanzu/velero/pkg/apis/velero/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	k8stesting "k8s.io/client-go/testing"
+	utilexec "k8s.io/client-go/util/exec"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	ctrlfake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestMain(m *testing.M) {
+	// Alerts go to stderr instead of the TUI and the log stays quiet
+	headless = true
+	log.Out = os.Stderr
+	log.SetLevel(0)
+	os.Exit(m.Run())
+}
+
+// fakeToolbox answers rbd mirror image commands like the rook-ceph-tools Pod would
+type fakeToolbox struct {
+	// mirrored holds the mirror state of the "pool/image" names that have mirroring enabled
+	mirrored map[string]string
+	// modes holds the mirroring mode of the mirrored images, snapshot if not set
+	modes map[string]string
+	// nonPrimary holds the mirrored images that are replicas of the peer cluster
+	nonPrimary map[string]bool
+	// features holds the image features of the "pool/image" names
+	features map[string][]string
+	// schedules holds the mirror snapshot schedules of the "pool" and "pool/image" names
+	schedules map[string][]rbdSnapshotSchedule
+	// directories holds the dirmap state of the "fs:path" directories that are mirrored with cephfs-mirror
+	directories map[string]string
+	// cephfsSchedules holds the snapshot intervals of the CephFS directories
+	cephfsSchedules map[string]string
+	// failing holds the exit codes of commands that fail
+	failing map[string]int
+	// commands are all commands that were run, in order
+	commands []string
+}
+
+func exitWith(code int) error {
+	return utilexec.CodeExitError{Err: errors.Errorf("command terminated with non-zero exit code: %d", code), Code: code}
+}
+
+func (f *fakeToolbox) run(command string) (string, string, error) {
+	f.commands = append(f.commands, command)
+	if code, failing := f.failing[command]; failing {
+		return "", "rbd: command failed", exitWith(code)
+	}
+	// rbd mirror image|pool <action> <pool>[/<image>] [flags]
+	fields := strings.Fields(command)
+	if len(fields) > 2 && fields[0] == "ceph" && fields[1] == "fs" {
+		return f.cephfs(fields[2:])
+	}
+	if len(fields) > 2 && fields[0] == "rbd" && fields[1] == "info" {
+		return f.info(fields[2])
+	}
+	if len(fields) > 3 && fields[0] == "rbd" && fields[1] == "feature" {
+		f.changeFeatures(fields[3], fields[2] == "enable", fields[4:])
+		return "", "", nil
+	}
+	if len(fields) < 5 || fields[0] != "rbd" || fields[1] != "mirror" {
+		return "", "", errors.Errorf("unexpected command %s", command)
+	}
+	if fields[2] == "snapshot" && fields[3] == "schedule" {
+		return f.schedule(fields[4], fields[5:])
+	}
+	if fields[2] == "pool" && fields[3] == "status" {
+		return f.poolStatus(fields[4])
+	}
+	if fields[2] != "image" {
+		return "", "", errors.Errorf("unexpected command %s", command)
+	}
+	action, spec := fields[3], fields[4]
+	state, mirrored := f.mirrored[spec]
+	if action == "enable" && !mirrored {
+		f.mirrored[spec] = "up+stopped"
+		f.modes[spec] = fields[5]
+		return "", "", nil
+	}
+	if !mirrored {
+		return "", "rbd: mirroring not enabled on the image", exitWith(rbdExitInvalid)
+	}
+	if action == "status" {
+		status, _ := json.Marshal(rbdMirrorImageStatus{Name: spec[strings.Index(spec, "/")+1:], State: state})
+		return string(status), "", nil
+	}
+	return "", "", nil
+}
+
+// cephfs answers ceph fs subvolume getpath, ceph fs snapshot mirror add|remove|dirmap and ceph fs snap-schedule add|remove
+func (f *fakeToolbox) cephfs(args []string) (string, string, error) {
+	switch {
+	case args[0] == "subvolume" && args[1] == "getpath":
+		return "/volumes/csi/" + args[3] + "/0001\n", "", nil
+	case args[0] == "snap-schedule" && args[1] == "add":
+		f.cephfsSchedules[args[2]] = args[3]
+		return "", "", nil
+	case args[0] == "snap-schedule" && args[1] == "remove":
+		if _, scheduled := f.cephfsSchedules[args[2]]; !scheduled {
+			return "", "Error ENOENT: no schedule", exitWith(cephExitNotFound)
+		}
+		delete(f.cephfsSchedules, args[2])
+		return "", "", nil
+	case args[0] != "snapshot" || args[1] != "mirror" || len(args) < 5:
+		return "", "", errors.Errorf("unexpected command ceph fs %s", strings.Join(args, " "))
+	}
+	directory := args[3] + ":" + args[4]
+	state, mirrored := f.directories[directory]
+	switch args[2] {
+	case "add":
+		if mirrored {
+			return "", "Error EEXIST: directory is already tracked", exitWith(cephExitExists)
+		}
+		f.directories[directory] = cephfsMirrorStateMapped
+	case "remove":
+		if !mirrored {
+			return "", "Error ENOENT: directory is not tracked", exitWith(cephExitNotFound)
+		}
+		delete(f.directories, directory)
+	case "dirmap":
+		if !mirrored {
+			return "", "Error EINVAL: directory is not tracked", exitWith(cephExitInvalid)
+		}
+		output, _ := json.Marshal(cephfsDirMap{InstanceID: "4242", State: state})
+		return string(output), "", nil
+	default:
+		return "", "", errors.Errorf("unexpected command ceph fs %s", strings.Join(args, " "))
+	}
+	return "", "", nil
+}
+
+func (f *fakeToolbox) info(spec string) (string, string, error) {
+	info := rbdImageInfo{Name: spec[strings.Index(spec, "/")+1:], Features: f.features[spec]}
+	if _, mirrored := f.mirrored[spec]; mirrored {
+		info.Mirroring = &rbdImageMirroring{Mode: mirroringModeSnapshot, State: "enabled", Primary: !f.nonPrimary[spec]}
+		if mode, known := f.modes[spec]; known {
+			info.Mirroring.Mode = mode
+		}
+	}
+	output, _ := json.Marshal(info)
+	return string(output), "", nil
+}
+
+func (f *fakeToolbox) changeFeatures(spec string, enable bool, features []string) {
+	var kept []string
+	for _, feature := range f.features[spec] {
+		if !stringInSliceBool(feature, features) {
+			kept = append(kept, feature)
+		}
+	}
+	if enable {
+		kept = append(kept, features...)
+	}
+	f.features[spec] = kept
+}
+
+func (f *fakeToolbox) poolStatus(pool string) (string, string, error) {
+	status := rbdPoolStatus{Summary: map[string]interface{}{"health": "OK"}}
+	for spec, state := range f.mirrored {
+		if strings.HasPrefix(spec, pool+"/") {
+			status.Images = append(status.Images, rbdMirrorImageStatus{Name: strings.TrimPrefix(spec, pool+"/"), State: state})
+		}
+	}
+	if len(status.Images) == 0 {
+		return "", "rbd: mirroring not enabled on the pool", exitWith(rbdExitInvalid)
+	}
+	output, _ := json.Marshal(status)
+	return string(output), "", nil
+}
+
+// schedule answers rbd mirror snapshot schedule <action> --pool <pool> [--image <image>] [interval [startTime]]
+func (f *fakeToolbox) schedule(action string, args []string) (string, string, error) {
+	var pool, image string
+	var positional []string
+	recursive := false
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--pool":
+			i++
+			pool = args[i]
+		case "--image":
+			i++
+			image = args[i]
+		case "--format":
+			i++
+		case "--recursive":
+			recursive = true
+		default:
+			positional = append(positional, args[i])
+		}
+	}
+	spec := pool
+	if image != "" {
+		spec = imageSpec(pool, image)
+	}
+	var output interface{}
+	switch action {
+	case "ls":
+		output = f.schedules[spec]
+		if recursive {
+			var levels []rbdSnapshotScheduleLevel
+			for name, items := range f.schedules {
+				if name == pool || strings.HasPrefix(name, pool+"/") {
+					levels = append(levels, rbdSnapshotScheduleLevel{Pool: pool, Image: strings.TrimPrefix(strings.TrimPrefix(name, pool), "/"), Items: items})
+				}
+			}
+			output = levels
+		}
+	case "status":
+		var status rbdScheduleStatus
+		for name := range f.mirrored {
+			if strings.HasPrefix(name, pool+"/") {
+				status.ScheduledImages = append(status.ScheduledImages, rbdScheduledImage{Image: name, ScheduleTime: "2021-04-26 11:00:00"})
+			}
+		}
+		output = status
+	case "add", "remove":
+		schedule := rbdSnapshotSchedule{Interval: positional[0]}
+		if len(positional) > 1 {
+			schedule.StartTime = positional[1]
+		}
+		var kept []rbdSnapshotSchedule
+		for _, existing := range f.schedules[spec] {
+			if existing != schedule {
+				kept = append(kept, existing)
+			}
+		}
+		if action == "add" {
+			kept = append(kept, schedule)
+		}
+		f.schedules[spec] = kept
+		return "", "", nil
+	default:
+		return "", "", errors.Errorf("unexpected schedule action %s", action)
+	}
+	data, _ := json.Marshal(output)
+	return string(data), "", nil
+}
+
+// commandsContaining returns all commands that contain the given text
+func (f *fakeToolbox) commandsContaining(text string) []string {
+	var commands []string
+	for _, command := range f.commands {
+		if strings.Contains(command, text) {
+			commands = append(commands, command)
+		}
+	}
+	return commands
+}
+
+// patchRecorder records patches, since the fake client does not support server side apply
+type patchRecorder struct {
+	client.Client
+	patched []client.Object
+	data    []string
+}
+
+func (p *patchRecorder) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	data, err := patch.Data(obj)
+	if err != nil {
+		return err
+	}
+	p.patched = append(p.patched, obj)
+	p.data = append(p.data, string(data))
+	return nil
+}
+
+// newFakeCluster returns a kubeAccess backed by fake clients that serve the given objects.
+// Core objects are served by both clients, all others only by the controller client.
+func newFakeCluster(t *testing.T, name string, objects ...runtime.Object) (kubeAccess, *fakeToolbox) {
+	t.Helper()
+	controllerScheme := runtime.NewScheme()
+	for _, addToScheme := range []func(*runtime.Scheme) error{clientgoscheme.AddToScheme, velerov1.AddToScheme, cephv1.AddToScheme, ocsv1.AddToScheme, operatorsv1alpha1.AddToScheme} {
+		if err := addToScheme(controllerScheme); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Unstructured objects are only served by the dynamic client
+	var typedObjects, controllerObjects, dynamicObjects []runtime.Object
+	for _, object := range objects {
+		if _, isUnstructured := object.(*unstructured.Unstructured); isUnstructured {
+			dynamicObjects = append(dynamicObjects, object)
+			continue
+		}
+		controllerObjects = append(controllerObjects, object)
+		if _, _, err := clientgoscheme.Scheme.ObjectKinds(object); err == nil {
+			typedObjects = append(typedObjects, object.DeepCopyObject())
+		}
+	}
+	dynamicClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{
+			storageClusterResource:         "StorageClusterList",
+			cephClusterResource:            "CephClusterList",
+			volumeReplicationResource:      "VolumeReplicationList",
+			volumeReplicationClassResource: "VolumeReplicationClassList",
+		}, dynamicObjects...)
+	toolbox := &fakeToolbox{mirrored: map[string]string{}, modes: map[string]string{}, nonPrimary: map[string]bool{}, features: map[string][]string{}, schedules: map[string][]rbdSnapshotSchedule{}, directories: map[string]string{}, cephfsSchedules: map[string]string{}, failing: map[string]int{}}
+	return kubeAccess{
+		name:             name,
+		typedClient:      k8sfake.NewSimpleClientset(typedObjects...),
+		controllerClient: ctrlfake.NewClientBuilder().WithScheme(controllerScheme).WithRuntimeObjects(controllerObjects...).Build(),
+		dynamicClient:    dynamicClient,
+		toolbox:          toolbox,
+	}, toolbox
+}
+
+// newRBDPV returns a PV of the Ceph RBD CSI driver, bound to namespace/pvc if namespace is set
+func newRBDPV(name, namespace, pvc, image string, phase corev1.PersistentVolumePhase) *corev1.PersistentVolume {
+	pv := &corev1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec: corev1.PersistentVolumeSpec{
+			PersistentVolumeSource: corev1.PersistentVolumeSource{
+				CSI: &corev1.CSIPersistentVolumeSource{
+					Driver:           environmentODF.rbdDriver,
+					VolumeHandle:     image,
+					VolumeAttributes: map[string]string{"pool": "replicapool", "imageName": image},
+				},
+			},
+		},
+		Status: corev1.PersistentVolumeStatus{Phase: phase},
+	}
+	if namespace != "" {
+		pv.Spec.ClaimRef = &corev1.ObjectReference{Kind: "PersistentVolumeClaim", Namespace: namespace, Name: pvc, UID: "1234", ResourceVersion: "42"}
+	}
+	return pv
+}
+
+// newCephFSPV returns a CephFS PV of a subvolume in the default filesystem
+func newCephFSPV(name, namespace, pvc, subvolume string, phase corev1.PersistentVolumePhase) *corev1.PersistentVolume {
+	pv := newRBDPV(name, namespace, pvc, subvolume, phase)
+	pv.Spec.CSI.Driver = environmentODF.cephfsDriver
+	pv.Spec.CSI.VolumeAttributes = map[string]string{"fsName": defaultFilesystemName, "subvolumeName": subvolume}
+	return pv
+}
+
+// newPod returns a Pod with the given labels and a container per name, that are all ready or not
+func newPod(namespace, name string, labels map[string]string, ready bool, containers ...string) *corev1.Pod {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name, Labels: labels},
+	}
+	podReady := corev1.ConditionTrue
+	if !ready {
+		podReady = corev1.ConditionFalse
+	}
+	pod.Status.Conditions = []corev1.PodCondition{{Type: corev1.PodReady, Status: podReady}}
+	for _, container := range containers {
+		pod.Spec.Containers = append(pod.Spec.Containers, corev1.Container{Name: container})
+		pod.Status.ContainerStatuses = append(pod.Status.ContainerStatuses, corev1.ContainerStatus{Name: container, Ready: ready})
+	}
+	return pod
+}
+
+// eventRecorder collects the progress events of a test
+type eventRecorder struct {
+	events []progressEvent
+	text   strings.Builder
+}
+
+// recordEvents subscribes an eventRecorder until the test ends
+func recordEvents(t *testing.T) *eventRecorder {
+	t.Helper()
+	recorder := &eventRecorder{}
+	render := textSink(&recorder.text)
+	t.Cleanup(events.subscribe(func(event progressEvent) {
+		recorder.events = append(recorder.events, event)
+		render(event)
+	}))
+	return recorder
+}
+
+func listPVNames(t *testing.T, cluster kubeAccess) []string {
+	t.Helper()
+	pvs, err := cluster.typedClient.CoreV1().PersistentVolumes().List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, pv := range pvs.Items {
+		names = append(names, pv.Name)
+	}
+	return names
+}
+
+// newOCSCSV returns the ClusterServiceVersion of the ocs-operator in the given version
+func newOCSCSV(t *testing.T, ocsVersion string) *operatorsv1alpha1.ClusterServiceVersion {
+	t.Helper()
+	var csvVersion version.OperatorVersion
+	if err := json.Unmarshal([]byte(`"`+ocsVersion+`"`), &csvVersion); err != nil {
+		t.Fatal(err)
+	}
+	return &operatorsv1alpha1.ClusterServiceVersion{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "ocs-operator.v" + ocsVersion,
+			Namespace: environmentODF.namespace,
+			Labels:    map[string]string{"operators.coreos.com/ocs-operator.openshift-storage": ""},
+		},
+		Spec: operatorsv1alpha1.ClusterServiceVersionSpec{Version: csvVersion},
+	}
+}
+
+// newStorageCluster returns the StorageCluster as the dynamic client serves it
+func newStorageCluster() *unstructured.Unstructured {
+	storageCluster := &unstructured.Unstructured{Object: map[string]interface{}{"spec": map[string]interface{}{}}}
+	storageCluster.SetAPIVersion("ocs.openshift.io/v1")
+	storageCluster.SetKind("StorageCluster")
+	storageCluster.SetNamespace(environmentODF.namespace)
+	storageCluster.SetName(storageClusterName)
+	return storageCluster
+}
+
+// reconcileVolumeReplications lets the VolumeReplications of the cluster report their desired state as reached,
+// like the csi-addons operator would
+func reconcileVolumeReplications(cluster kubeAccess) {
+	fake := cluster.dynamicClient.(*dynamicfake.FakeDynamicClient)
+	objects := fake.ReactionChain[0]
+	fake.PrependReactor("get", "volumereplications", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		handled, object, err := objects.React(action)
+		if !handled || err != nil {
+			return handled, object, err
+		}
+		replication := object.(*unstructured.Unstructured).DeepCopy()
+		state, _, _ := unstructured.NestedString(replication.Object, "spec", "replicationState")
+		if state == replicationStateResync {
+			state = replicationStateSecondary
+		}
+		replication.Object["status"] = map[string]interface{}{
+			"state":              strings.Title(state),
+			"observedGeneration": replication.GetGeneration(),
+			"conditions": []interface{}{
+				map[string]interface{}{"type": "Completed", "status": "True"},
+				map[string]interface{}{"type": "Degraded", "status": "False"},
+			},
+		}
+		return true, replication, nil
+	})
+}
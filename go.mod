@@ -7,6 +7,7 @@ require (
 	github.com/openshift/ocs-operator v0.0.1-alpha1.0.20210329143343-282f7dedfebd
 	github.com/operator-framework/api v0.7.1
 	github.com/pkg/errors v0.9.1
+	github.com/prometheus/client_golang v1.8.0
 	github.com/rivo/tview v0.0.0-20210312174852-ae9464cc3598
 	github.com/rook/rook v1.5.8-0.20210219161258-2744b997ad89
 	github.com/sirupsen/logrus v1.8.1
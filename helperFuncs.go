@@ -2,6 +2,9 @@ package main
 
 import (
 	"context"
+	"fmt"
+	"os"
+	"sync/atomic"
 
 	"github.com/gdamore/tcell/v2"
 	"github.com/pkg/errors"
@@ -24,6 +27,10 @@ func showModal(modalTitle string, modalText string, buttons []string, doneFunc f
 }
 
 func showAlert(alertText string) {
+	if headless {
+		fmt.Fprintln(os.Stderr, alertText)
+		return
+	}
 	showModal("alert", alertText, []string{"OK"}, func(buttonIndex int, buttonLabel string) { pages.RemovePage("alert") })
 }
 
@@ -52,6 +59,33 @@ func showInfo(pageTitle string, information string, buttons map[string]func()) {
 	)
 }
 
+// runCancellable shows the progress of work in view and runs it in the background.
+// While work is running, ESC cancels its context. Once it is done, ENTER or ESC calls onDone.
+func runCancellable(view *tview.TextView, work func(ctx context.Context), onDone func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	var running int32 = 1
+	view.SetDoneFunc(func(key tcell.Key) {
+		if atomic.LoadInt32(&running) == 0 {
+			onDone()
+			return
+		}
+		if key == tcell.KeyEscape && ctx.Err() == nil {
+			cancel()
+			// Writing to the view redraws the app, which must not happen in the event loop
+			go addRowOfTextOutput(view, "Cancelling - waiting for the current step to stop...")
+		}
+	})
+	go func() {
+		defer cancel()
+		work(ctx)
+		atomic.StoreInt32(&running, 0)
+		if ctx.Err() != nil {
+			addRowOfTextOutput(view, "Cancelled")
+		}
+		addRowOfTextOutput(view, "Press ENTER or ESC to get back to main")
+	}()
+}
+
 func checkForOADP(cluster kubeAccess) (OADPpresent bool) {
 	OADPpresent = false
 	// Check if OADP is available
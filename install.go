@@ -4,9 +4,10 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"time"
+	"io"
+	"reflect"
+	"strconv"
 
-	"github.com/gdamore/tcell/v2"
 	ocsv1 "github.com/openshift/ocs-operator/api/v1"
 	"github.com/operator-framework/api/pkg/lib/version"
 	operatorsv1 "github.com/operator-framework/api/pkg/operators/v1"
@@ -18,20 +19,21 @@ import (
 	velerov1 "github.com/vmware-tanzu/velero/pkg/apis/velero/v1"
 	corev1 "k8s.io/api/core/v1"
 	v1 "k8s.io/api/storage/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	types "k8s.io/apimachinery/pkg/types"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
-//  patchStringValue specifies a patch operation for a string.
+// patchStringValue specifies a patch operation for a string.
 type patchStringValue struct {
 	Op    string `json:"op"`
 	Path  string `json:"path"`
 	Value string `json:"value"`
 }
 
-//  patchStringValue specifies a patch operation for a bool.
+// patchStringValue specifies a patch operation for a bool.
 type patchBoolValue struct {
 	Op    string `json:"op"`
 	Path  string `json:"path"`
@@ -54,12 +56,13 @@ var installText = tview.NewTextView().
 		app.Draw()
 	})
 
-const ocsNamespace = "openshift-storage"
+// installProgress reports the progress of the install, the TUI shows it in installText
+var installProgress = events.reporter("install")
 
 var useNewBlockPoolForMirroring = false
 var installOADP = true
 
-func addRowOfTextOutput(target *tview.TextView, format string, a ...interface{}) {
+func addRowOfTextOutput(target io.Writer, format string, a ...interface{}) {
 	newText := fmt.Sprintf(format, a...)
 	log.Info(newText)
 	_, err := fmt.Fprintln(target, newText)
@@ -69,47 +72,39 @@ func addRowOfTextOutput(target *tview.TextView, format string, a ...interface{})
 }
 
 func init() {
-	installText.
-		SetDoneFunc(func(key tcell.Key) {
-			installText.Clear()
-			pages.RemovePage("install")
-			pages.SwitchToPage("main")
-		})
 	appConfig.S3info.Objectprefix = "velero"
 	appConfig.S3info.S3ForcePathStyle = true
 	appConfig.S3info.S3AllowInsecure = false
 }
 
 func showBlockPoolChoice() {
-	err := checkInstallRequirements(kubeConfigPrimary)
+	err := checkAllInstallRequirements(context.Background())
 	if err != nil {
-		showAlert(fmt.Sprintf("Install requirements are not met in the primary cluster!\n%s", err))
+		showAlert(err.Error())
 		return
 	}
-	err = checkInstallRequirements(kubeConfigSecondary)
+	// The CephFS default follows the ODF release, OCS 4.7 and 4.8 cannot mirror CephFS
+	profile, err := installProfile(kubeConfigPrimary, kubeConfigSecondary)
 	if err != nil {
-		showAlert(fmt.Sprintf("Install requirements are not met in the secondary cluster!\n%s", err))
+		showAlert(err.Error())
 		return
 	}
-	err = checkNetworkBetweenClusters(kubeConfigPrimary, kubeConfigSecondary)
-	if err != nil {
-		showAlert(fmt.Sprintf("Network connection between cluster could not be checked successfully!\n%s", err))
-		log.Error("Network check failed")
-		return
-	}
-	log.Info("Install requirements met")
 	pages.RemovePage("checkRequirement")
 
+	restartInstall = false
+	installCephFS = nil
 	form := tview.NewForm().
 		AddCheckbox("Install OADP for CR backups", true, func(checked bool) { installOADP = checked }).
+		AddCheckbox("Mirror CephFS volumes", cephfsMirroringFor(profile), func(checked bool) { installCephFS = &checked }).
+		AddCheckbox("Run finished steps again", false, func(checked bool) { restartInstall = checked }).
 		AddButton("Use Default Block Pool", func() {
 			useNewBlockPoolForMirroring = false
-			gatherS3Info()
+			showPoolSettings()
 			pages.RemovePage("blockPoolChoice")
 		}).
 		AddButton("Use Dedicated Block Pool", func() {
 			useNewBlockPoolForMirroring = true
-			gatherS3Info()
+			showPoolSettings()
 			pages.RemovePage("blockPoolChoice")
 		}).
 		SetCancelFunc(func() {
@@ -131,6 +126,74 @@ func showBlockPoolChoice() {
 	app.Draw()
 }
 
+// showPoolSettings asks for the settings of the mirrored pool, only the snapshot schedules apply to the default pool
+func showPoolSettings() {
+	settings := pool()
+	schedules := formatSnapshotSchedules(settings.SnapshotSchedules)
+	parameters := formatParameters(settings.StorageClassParameters)
+	mode := mirroringModeFor(mirroringBlockPool(kubeConfigPrimary.environment()))
+	modes := []string{mirroringModeSnapshot, mirroringModeJournal}
+	modeIndex, _ := stringInSlice(mode, modes)
+	form := tview.NewForm()
+	if useNewBlockPoolForMirroring {
+		form.
+			AddInputField("Pool name", settings.Name, 0, nil, func(text string) { settings.Name = text }).
+			AddInputField("Replica size", fmt.Sprint(settings.ReplicaSize), 0, tview.InputFieldInteger, func(text string) {
+				size, _ := strconv.ParseUint(text, 10, 32)
+				settings.ReplicaSize = uint(size)
+			}).
+			AddInputField("Failure domain (e.g. host, zone)", settings.FailureDomain, 0, nil, func(text string) { settings.FailureDomain = text }).
+			AddInputField("Device class (e.g. ssd)", settings.DeviceClass, 0, nil, func(text string) { settings.DeviceClass = text })
+	}
+	form.
+		AddDropDown("Mirroring mode of the PVCs", modes, modeIndex, func(option string, index int) { mode = option }).
+		AddInputField("Snapshot schedules (interval[@startTime], ...)", schedules, 0, nil, func(text string) { schedules = text })
+	if useNewBlockPoolForMirroring {
+		form.
+			AddInputField("StorageClass name", settings.StorageClassName, 0, nil, func(text string) { settings.StorageClassName = text }).
+			AddInputField("StorageClass parameters (key=value, ...)", parameters, 0, nil, func(text string) { parameters = text })
+	}
+	form.
+		AddButton("Proceed", func() {
+			var err error
+			if settings.SnapshotSchedules, err = parseSnapshotSchedules(schedules); err != nil {
+				showAlert(err.Error())
+				return
+			}
+			if settings.StorageClassParameters, err = parseParameters(parameters); err != nil {
+				showAlert(err.Error())
+				return
+			}
+			appConfig.Pool = settings
+			for _, pool := range mirroringBlockPools() {
+				setMirroringMode(pool, mode)
+			}
+			writeNewConfig()
+			gatherS3Info()
+			pages.RemovePage("poolSettings")
+		}).
+		AddButton("Cancel", func() {
+			pages.RemovePage("poolSettings")
+			pages.SwitchToPage("main")
+		}).
+		SetCancelFunc(func() {
+			pages.RemovePage("poolSettings")
+			pages.SwitchToPage("main")
+		}).
+		SetButtonsAlign(tview.AlignCenter)
+
+	helperText :=
+		tview.NewTextView().
+			SetText("Settings of the mirrored Block Pool, they are saved in the config\nSnapshot schedules are intervals like 5m, 1h or 1d, optionally with a start time like 1d@02:00:00\nUse TAB to jump between lines, then select Proceed with ENTER").
+			SetTextAlign(tview.AlignCenter)
+
+	container := tview.NewFlex().SetDirection(tview.FlexRow)
+	container.AddItem(helperText, 4, 1, false)
+	container.AddItem(form, 0, 1, true)
+
+	pages.AddAndSwitchToPage("poolSettings", container, true)
+}
+
 func gatherS3Info() {
 	if !installOADP {
 		installReplication()
@@ -182,206 +245,336 @@ func installReplication() {
 	//  * Check that Kubernetes links are ok
 	//  * Check that OCS is installed and ready
 	//  * Check that the cluster networks are linked
-	go doInstall()
+	unsubscribe := events.subscribe(forOperation("install", textSink(installText)))
+	runCancellable(installText, func(ctx context.Context) {
+		dryRun.start()
+		doInstall(ctx)
+		if dryRun.enabled {
+			dryRun.print(installText)
+		}
+	}, func() {
+		unsubscribe()
+		installText.Clear()
+		pages.RemovePage("install")
+		pages.SwitchToPage("main")
+	})
 }
 
-func doInstall() error {
-	addRowOfTextOutput(installText, "Starting Install!")
+func doInstall(ctx context.Context) error {
+	installProgress.info("Starting Install!")
 	if useNewBlockPoolForMirroring {
-		addRowOfTextOutput(installText, "Using dedicated Block Pool")
+		installProgress.info("Using dedicated Block Pool")
 	} else {
-		addRowOfTextOutput(installText, "Using default Block Pool")
+		installProgress.info("Using default Block Pool")
 	}
-	addRowOfTextOutput(installText, "")
 
-	err := enableOMAPGenerator(kubeConfigPrimary)
-	if err != nil {
-		log.WithError(err).Warn("Issues when enabling OMAP generator in primary cluster")
-		showAlert("Issues when enabling OMAP generator in primary cluster")
-		return err
-	}
-	err = enableOMAPGenerator(kubeConfigSecondary)
-	if err != nil {
-		log.WithError(err).Warn("Issues when enabling OMAP generator in secondary cluster")
-		showAlert("Issues when enabling OMAP generator in secondary cluster")
-		return err
+	for _, cluster := range allSites() {
+		if err := ocsv1.AddToScheme(cluster.controllerClient.Scheme()); err != nil {
+			return installProgress.failed("schemes", err, "Issues when adding the ocsv1 scheme to the %s client", cluster.name)
+		}
+		if err := cephv1.AddToScheme(cluster.controllerClient.Scheme()); err != nil {
+			return installProgress.failed("schemes", err, "Issues when adding the cephv1 scheme to the %s client", cluster.name)
+		}
 	}
 
-	if err = ocsv1.AddToScheme(kubeConfigPrimary.controllerClient.Scheme()); err != nil {
-		log.WithError(err).Warn("Issues when adding the ocsv1 scheme to the primary client")
-		showAlert("Issues when adding the ocsv1 scheme to the primary client")
-		return err
+	// Every peering is installed on its own, steps that are already done in a cluster are skipped for its other peers
+	for _, peering := range sitePeerings() {
+		primary, err := siteByName(peering.Primary)
+		if err != nil {
+			return installProgress.failed("peering", err, "Issues when looking up the peering")
+		}
+		secondary, err := siteByName(peering.Secondary)
+		if err != nil {
+			return installProgress.failed("peering", err, "Issues when looking up the peering")
+		}
+		if namedSites() {
+			installProgress.info("Peering %s with %s", primary.name, secondary.name)
+		}
+		profile, err := installProfile(primary, secondary)
+		if err != nil {
+			return installProgress.failed("profile", err, "Issues when detecting the ODF release")
+		}
+		installProgress.info("Using the profile %s", profile.describe())
+
+		if err := runInstallSteps(ctx, installSteps(profile), &primary, &secondary); err != nil {
+			return err
+		}
 	}
-	if err = ocsv1.AddToScheme(kubeConfigSecondary.controllerClient.Scheme()); err != nil {
-		log.WithError(err).Warn("Issues when adding the ocsv1 scheme to the secondary client")
-		showAlert("Issues when adding the ocsv1 scheme to the secondary client")
-		return err
+	installProgress.info("Install steps done!!")
+
+	return nil
+}
+
+// installSteps returns the steps of the install with the current settings and the profile of the ODF release, in the order they run.
+// The Block Pool is looked up per cluster, since the default pools of ODF and Rook have different names.
+func installSteps(profile odfProfile) []installStep {
+	blockpool := func(cluster kubeAccess) string {
+		return mirroringBlockPool(cluster.environment())
+	}
+	toolbox := installStep{
+		name:        "toolbox",
+		description: "Enabling the Ceph Toolbox",
+		run: func(ctx context.Context, cluster, _ *kubeAccess) error {
+			return enableToolbox(ctx, *cluster)
+		},
+		check: func(ctx context.Context, cluster kubeAccess) (bool, error) {
+			_, err := getToolsPod(cluster.typedClient, cluster.storageNamespace())
+			return err == nil, nil
+		},
 	}
-	if err = cephv1.AddToScheme(kubeConfigPrimary.controllerClient.Scheme()); err != nil {
-		log.WithError(err).Warn("Issues when adding the cephv1 scheme to the primary client")
-		showAlert("Issues when adding the cephv1 scheme to the primary client")
-		return err
+	oadp := installStep{
+		name:        "oadp",
+		description: "Installing OADP",
+		input: func(kubeAccess) string {
+			return appConfig.S3info.Bucketname + "/" + appConfig.S3info.Objectprefix
+		},
+		run: func(ctx context.Context, cluster, _ *kubeAccess) error {
+			if err := doInstallOADP(ctx, *cluster); err != nil {
+				return err
+			}
+			return verifyOADPinstall(ctx, *cluster)
+		},
 	}
-	if err = cephv1.AddToScheme(kubeConfigSecondary.controllerClient.Scheme()); err != nil {
-		log.WithError(err).Warn("Issues when adding the cephv1 scheme to the secondary client")
-		showAlert("Issues when adding the cephv1 scheme to the secondary client")
-		return err
+	var steps []installStep
+	if profile.setup == setupExternal {
+		// The pools, rbd-mirror and their peers are set up in the external Ceph cluster
+		steps = append(steps, toolbox)
+		if installOADP {
+			steps = append(steps, oadp)
+		}
+		return steps
+	}
+	if profile.setup == setupStorageCluster {
+		steps = append(steps, installStep{
+			name:        "storage-cluster-mirroring",
+			description: "Enabling mirroring in the StorageCluster",
+			run: func(ctx context.Context, cluster, _ *kubeAccess) error {
+				return enableStorageClusterMirroring(ctx, *cluster)
+			},
+			check: checkStorageClusterMirroring,
+		})
+	} else {
+		steps = append(steps, installStep{
+			name:        "omap-generator",
+			description: "Enabling the OMAP generator",
+			run: func(ctx context.Context, cluster, _ *kubeAccess) error {
+				return enableOMAPGenerator(ctx, *cluster)
+			},
+			check: func(ctx context.Context, cluster kubeAccess) (bool, error) {
+				return checkForOMAPGenerator(ctx, cluster), nil
+			},
+		})
 	}
-
-	blockpool := "ocs-storagecluster-cephblockpool"
 	if useNewBlockPoolForMirroring {
-		blockpool = "replicapool"
-		newBlockPool := cephv1.CephBlockPool{
-			TypeMeta: metav1.TypeMeta{
-				APIVersion: "ceph.rook.io/v1",
-				Kind:       "CephBlockPool",
-			},
-			ObjectMeta: metav1.ObjectMeta{
-				Name:      "replicapool",
-				Namespace: ocsNamespace,
+		steps = append(steps,
+			installStep{
+				name:        "block-pool",
+				description: "Creating the dedicated Block Pool",
+				input:       blockpool,
+				run: func(ctx context.Context, cluster, _ *kubeAccess) error {
+					return createBlockPool(ctx, *cluster, newMirroringBlockPool(cluster.environment()))
+				},
+				check: checkDedicatedPool,
 			},
-			Spec: cephv1.PoolSpec{
-				Replicated: cephv1.ReplicatedSpec{
-					Size: 3,
+			installStep{
+				name:        "storage-class",
+				description: "Creating the StorageClass for mirrored PVCs",
+				input:       blockpool,
+				run: func(ctx context.Context, cluster, _ *kubeAccess) error {
+					return createStorageClass(ctx, *cluster, newMirroringStorageClass(cluster.environment()))
 				},
-				Mirroring: cephv1.MirroringSpec{
-					Enabled: true,
-					Mode:    "image",
-					SnapshotSchedules: []cephv1.SnapshotScheduleSpec{
-						{Interval: "1h"},
-					},
+				check: checkMirroringStorageClass,
+			})
+	} else if profile.setup == setupCephBlockPool {
+		// With the StorageCluster setup, ODF mirrors the default pool
+		steps = append(steps, installStep{
+			name:        "pool-mirroring",
+			description: "Enabling mirroring on the default Block Pool",
+			input:       blockpool,
+			run: func(ctx context.Context, cluster, _ *kubeAccess) error {
+				return enablePoolMirroring(ctx, *cluster, blockpool(*cluster))
+			},
+			check: func(ctx context.Context, cluster kubeAccess) (bool, error) {
+				return checkPoolMirroring(ctx, cluster, blockpool(cluster))
+			},
+		})
+	}
+	steps = append(steps,
+		installStep{
+			name:        "bootstrap-secrets",
+			description: "Exchanging the mirroring bootstrap secrets",
+			input:       blockpool,
+			perPeer:     true,
+			// Each cluster imports the bootstrap secret of the pool of its peer into its own pool
+			run: func(ctx context.Context, cluster, peer *kubeAccess) error {
+				return exchangeMirroringBootstrapSecrets(ctx, peer, cluster, blockpool(*peer), blockpool(*cluster), profile.setup)
+			},
+		},
+		toolbox)
+	if cephfsMirroringFor(profile) {
+		steps = append(steps,
+			installStep{
+				name:        "cephfs-mirroring",
+				description: "Deploying the CephFS mirror daemon",
+				run: func(ctx context.Context, cluster, _ *kubeAccess) error {
+					return enableCephFSMirroring(ctx, *cluster)
 				},
+				check: checkCephFSMirror,
 			},
-		}
+			installStep{
+				name:        "cephfs-peers",
+				description: "Peering the CephFilesystems",
+				perPeer:     true,
+				// Each cluster mirrors its directories to its peer
+				run: func(ctx context.Context, cluster, peer *kubeAccess) error {
+					return peerCephFilesystems(ctx, *cluster, *peer)
+				},
+				check: checkCephFSPeers,
+			})
+	}
+	if installOADP {
+		steps = append(steps, oadp)
+	}
+	return steps
+}
 
-		if err = createBlockPool(kubeConfigPrimary, &newBlockPool); err != nil {
-			log.WithError(err).Warn("Issues when adding new block pool in primary cluster")
-			showAlert("Issues when adding new block pool in primary cluster")
-			return err
-		}
-		if err = createBlockPool(kubeConfigSecondary, &newBlockPool); err != nil {
-			log.WithError(err).Warn("Issues when adding new block pool in secondary cluster")
-			showAlert("Issues when adding new block pool in secondary cluster")
-			return err
-		}
+// mirroringBlockPool returns the name of the Block Pool that the install enables mirroring on
+func mirroringBlockPool(env storageEnvironment) string {
+	if useNewBlockPoolForMirroring {
+		return pool().Name
+	}
+	return env.defaultPool
+}
 
-		storageclassPolicy := corev1.PersistentVolumeReclaimRetain
-		storageclassBindingMode := v1.VolumeBindingImmediate
-		storageclassVolumeExpansion := true
+// mirroringBlockPools returns the mirrored Block Pools of all clusters, the default pools of ODF and Rook differ
+func mirroringBlockPools() []string {
+	var pools []string
+	for _, cluster := range allSites() {
+		if pool := mirroringBlockPool(cluster.environment()); !stringInSliceBool(pool, pools) {
+			pools = append(pools, pool)
+		}
+	}
+	return pools
+}
 
-		newStorageClass := v1.StorageClass{
-			TypeMeta: metav1.TypeMeta{
-				APIVersion: "storage.k8s.io/v1",
-				Kind:       "StorageClass",
-			},
-			ObjectMeta: metav1.ObjectMeta{
-				Name: "ocs-storagecluster-ceph-mirror",
+// newMirroringBlockPool returns the dedicated Block Pool for mirroring
+func newMirroringBlockPool(env storageEnvironment) *cephv1.CephBlockPool {
+	settings := pool()
+	return &cephv1.CephBlockPool{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "ceph.rook.io/v1",
+			Kind:       "CephBlockPool",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      settings.Name,
+			Namespace: env.namespace,
+		},
+		Spec: cephv1.PoolSpec{
+			FailureDomain: settings.FailureDomain,
+			DeviceClass:   settings.DeviceClass,
+			Replicated: cephv1.ReplicatedSpec{
+				Size: settings.ReplicaSize,
 			},
-			Parameters: map[string]string{
-				"csi.storage.k8s.io/controller-expand-secret-name":      "rook-csi-rbd-provisioner",
-				"csi.storage.k8s.io/controller-expand-secret-namespace": "openshift-storage",
-				"csi.storage.k8s.io/fstype":                             "ext4",
-				"csi.storage.k8s.io/node-stage-secret-name":             "rook-csi-rbd-node",
-				"csi.storage.k8s.io/node-stage-secret-namespace":        "openshift-storage",
-				"csi.storage.k8s.io/provisioner-secret-name":            "rook-csi-rbd-provisioner",
-				"csi.storage.k8s.io/provisioner-secret-namespace":       "openshift-storage",
-				"clusterID":     "openshift-storage",
-				"imageFeatures": "layering",
-				"imageFormat":   "2",
-				"pool":          "replicapool",
+			Mirroring: cephv1.MirroringSpec{
+				Enabled:           true,
+				Mode:              "image",
+				SnapshotSchedules: settings.cephSnapshotSchedules(),
 			},
-			Provisioner:          "openshift-storage.rbd.csi.ceph.com",
-			ReclaimPolicy:        &storageclassPolicy,
-			VolumeBindingMode:    &storageclassBindingMode,
-			AllowVolumeExpansion: &storageclassVolumeExpansion,
-		}
+		},
+	}
+}
 
-		if err = createStorageClass(kubeConfigPrimary, &newStorageClass); err != nil {
-			log.WithError(err).Warn("Issues when adding StorageClass in primary cluster")
-			showAlert("Issues when adding StorageClass in primary cluster")
-			return err
-		}
-		if err = createStorageClass(kubeConfigSecondary, &newStorageClass); err != nil {
-			log.WithError(err).Warn("Issues when adding StorageClass in secondary cluster")
-			showAlert("Issues when adding StorageClass in secondary cluster")
-			return err
-		}
-	} else {
-		if err = enablePoolMirroring(kubeConfigPrimary, blockpool); err != nil {
-			log.WithError(err).Warn("Issues when enabling mirroring in primary cluster")
-			showAlert("Issues when enabling mirroring in primary cluster")
-			return err
-		}
-		if err = enablePoolMirroring(kubeConfigSecondary, blockpool); err != nil {
-			log.WithError(err).Warn("Issues when enabling mirroring in secondary cluster")
-			showAlert("Issues when enabling mirroring in secondary cluster")
-			return err
-		}
+// newMirroringStorageClass returns the StorageClass for PVCs in the dedicated Block Pool
+func newMirroringStorageClass(env storageEnvironment) *v1.StorageClass {
+	settings := pool()
+	storageclassPolicy := corev1.PersistentVolumeReclaimRetain
+	storageclassBindingMode := v1.VolumeBindingImmediate
+	storageclassVolumeExpansion := true
+
+	parameters := map[string]string{
+		"csi.storage.k8s.io/controller-expand-secret-name":      "rook-csi-rbd-provisioner",
+		"csi.storage.k8s.io/controller-expand-secret-namespace": env.namespace,
+		"csi.storage.k8s.io/fstype":                             "ext4",
+		"csi.storage.k8s.io/node-stage-secret-name":             "rook-csi-rbd-node",
+		"csi.storage.k8s.io/node-stage-secret-namespace":        env.namespace,
+		"csi.storage.k8s.io/provisioner-secret-name":            "rook-csi-rbd-provisioner",
+		"csi.storage.k8s.io/provisioner-secret-namespace":       env.namespace,
+		"clusterID":     env.namespace,
+		"imageFeatures": "layering",
+		"imageFormat":   "2",
+	}
+	if mirroringModeFor(settings.Name) == mirroringModeJournal {
+		// The kernel RBD client cannot map images with the journaling feature
+		parameters["imageFeatures"] = "layering,exclusive-lock,journaling"
+		parameters["mounter"] = "rbd-nbd"
+	}
+	for key, value := range settings.StorageClassParameters {
+		parameters[key] = value
+	}
+	parameters["pool"] = settings.Name
+
+	return &v1.StorageClass{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "storage.k8s.io/v1",
+			Kind:       "StorageClass",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name: settings.StorageClassName,
+		},
+		Parameters:           parameters,
+		Provisioner:          env.rbdDriver,
+		ReclaimPolicy:        &storageclassPolicy,
+		VolumeBindingMode:    &storageclassBindingMode,
+		AllowVolumeExpansion: &storageclassVolumeExpansion,
 	}
+}
 
-	// Wait for status to be populated...
-	time.Sleep(5 * time.Second)
-	err = exchangeMirroringBootstrapSecrets(&kubeConfigSecondary, &kubeConfigPrimary, blockpool)
-	if err != nil {
-		log.WithError(err).Warnf("Issues when exchanging bootstrap infos from %s to %s", "secondary", "primary")
-		showAlert(fmt.Sprintf("Issues when exchanging bootstrap infos from %s to %s", "secondary", "primary"))
-		return err
+// checkPoolMirroring returns true if mirroring is enabled in the spec of the Block Pool with the configured snapshot schedules
+func checkPoolMirroring(ctx context.Context, cluster kubeAccess, poolname string) (bool, error) {
+	var blockPool cephv1.CephBlockPool
+	err := cluster.controllerClient.Get(ctx, types.NamespacedName{Name: poolname, Namespace: cluster.storageNamespace()}, &blockPool)
+	if apierrors.IsNotFound(err) {
+		return false, nil
 	}
-	err = exchangeMirroringBootstrapSecrets(&kubeConfigPrimary, &kubeConfigSecondary, blockpool)
 	if err != nil {
-		log.WithError(err).Warnf("Issues when exchanging bootstrap infos from %s to %s", "primary", "secondary")
-		showAlert(fmt.Sprintf("Issues when exchanging bootstrap infos from %s to %s", "primary", "secondary"))
-		return err
+		return false, errors.WithMessagef(err, "[%s] Issues when fetching CephBlockPool %s", cluster.name, poolname)
 	}
+	return blockPool.Spec.Mirroring.Enabled &&
+		reflect.DeepEqual(blockPool.Spec.Mirroring.SnapshotSchedules, pool().cephSnapshotSchedules()), nil
+}
 
-	err = enableToolbox(kubeConfigPrimary)
-	if err != nil {
-		log.WithError(err).Warnf("Issues when enabling the Toolbox in the %s cluster", "primary")
-		showAlert(fmt.Sprintf("Issues when enabling the Toolbox in the %s cluster", "primary"))
-		return err
+// checkDedicatedPool returns true if the dedicated Block Pool exists with the configured settings
+func checkDedicatedPool(ctx context.Context, cluster kubeAccess) (bool, error) {
+	settings := pool()
+	var blockPool cephv1.CephBlockPool
+	err := cluster.controllerClient.Get(ctx, types.NamespacedName{Name: settings.Name, Namespace: cluster.storageNamespace()}, &blockPool)
+	if apierrors.IsNotFound(err) {
+		return false, nil
 	}
-	err = enableToolbox(kubeConfigSecondary)
 	if err != nil {
-		log.WithError(err).Warnf("Issues when enabling the Toolbox in the %s cluster", "secondary")
-		showAlert(fmt.Sprintf("Issues when enabling the Toolbox in the %s cluster", "secondary"))
-		return err
-	}
+		return false, errors.WithMessagef(err, "[%s] Issues when fetching CephBlockPool %s", cluster.name, settings.Name)
+	}
+	expected := newMirroringBlockPool(cluster.environment()).Spec
+	return blockPool.Spec.Replicated.Size == expected.Replicated.Size &&
+		blockPool.Spec.FailureDomain == expected.FailureDomain &&
+		blockPool.Spec.DeviceClass == expected.DeviceClass &&
+		blockPool.Spec.Mirroring.Enabled &&
+		reflect.DeepEqual(blockPool.Spec.Mirroring.SnapshotSchedules, expected.Mirroring.SnapshotSchedules), nil
+}
 
-	if installOADP {
-		err = doInstallOADP(kubeConfigPrimary)
-		if err != nil {
-			log.WithError(err).Warnf("Issues when installing OADP in the %s cluster", "primary")
-			showAlert(fmt.Sprintf("Issues when installing OADP in the %s cluster", "primary"))
-			return err
-		}
-		err = doInstallOADP(kubeConfigSecondary)
-		if err != nil {
-			log.WithError(err).Warnf("Issues when installing OADP in the %s cluster", "secondary")
-			showAlert(fmt.Sprintf("Issues when installing OADP in the %s cluster", "secondary"))
-			return err
-		}
-		err = verifyOADPinstall(kubeConfigPrimary)
-		if err != nil {
-			log.WithError(err).Warnf("Issues when verifying OADP in the %s cluster", "primary")
-			showAlert(fmt.Sprintf("Issues when verifying OADP in the %s cluster", "primary"))
-			return err
-		}
-		err = verifyOADPinstall(kubeConfigSecondary)
-		if err != nil {
-			log.WithError(err).Warnf("Issues when verifying OADP in the %s cluster", "secondary")
-			showAlert(fmt.Sprintf("Issues when verifying OADP in the %s cluster", "secondary"))
-			return err
-		}
+// checkMirroringStorageClass returns true if the StorageClass for mirrored PVCs exists
+func checkMirroringStorageClass(ctx context.Context, cluster kubeAccess) (bool, error) {
+	_, err := cluster.typedClient.StorageV1().StorageClasses().Get(ctx, newMirroringStorageClass(cluster.environment()).Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return false, nil
 	}
-
-	addRowOfTextOutput(installText, "")
-	addRowOfTextOutput(installText, "Install steps done!!")
-	addRowOfTextOutput(installText, "Press ENTER to get back to main")
-
-	return nil
+	if err != nil {
+		return false, errors.WithMessagef(err, "[%s] Issues when fetching the StorageClass for mirrored PVCs", cluster.name)
+	}
+	return true, nil
 }
 
-func createBlockPool(cluster kubeAccess, newBlockPool *cephv1.CephBlockPool) error {
+func createBlockPool(ctx context.Context, cluster kubeAccess, newBlockPool *cephv1.CephBlockPool) error {
 	patchPoolJson, err := json.Marshal(*newBlockPool)
 	if err != nil {
 		return errors.WithMessage(err, "Issues when converting BlockPool CR to JSON")
@@ -392,7 +585,10 @@ func createBlockPool(cluster kubeAccess, newBlockPool *cephv1.CephBlockPool) err
 	if err != nil {
 		return errors.WithMessage(err, "Issues when patching BlockPool CR in JSON")
 	}
-	err = cluster.controllerClient.Patch(context.TODO(),
+	if dryRun.intercept(cluster, "patch", "CephBlockPool/"+newBlockPool.Name, patchedPoolJson) {
+		return nil
+	}
+	err = cluster.controllerClient.Patch(ctx,
 		newBlockPool.DeepCopy(),
 		client.RawPatch(types.ApplyPatchType, []byte(patchedPoolJson)),
 		&client.PatchOptions{FieldManager: "RDRhelper"})
@@ -403,12 +599,15 @@ func createBlockPool(cluster kubeAccess, newBlockPool *cephv1.CephBlockPool) err
 	return nil
 }
 
-func createStorageClass(cluster kubeAccess, newStorageClass *v1.StorageClass) error {
+func createStorageClass(ctx context.Context, cluster kubeAccess, newStorageClass *v1.StorageClass) error {
 	patchClassJson, err := json.Marshal(*newStorageClass)
 	if err != nil {
 		return errors.WithMessage(err, "Issues when converting StorageClass CR to JSON")
 	}
-	_, err = cluster.typedClient.StorageV1().StorageClasses().Patch(context.TODO(),
+	if dryRun.intercept(cluster, "patch", "StorageClass/"+newStorageClass.Name, patchClassJson) {
+		return nil
+	}
+	_, err = cluster.typedClient.StorageV1().StorageClasses().Patch(ctx,
 		newStorageClass.Name,
 		types.ApplyPatchType,
 		patchClassJson,
@@ -421,7 +620,7 @@ func createStorageClass(cluster kubeAccess, newStorageClass *v1.StorageClass) er
 	return nil
 }
 
-func enablePoolMirroring(cluster kubeAccess, poolname string) error {
+func enablePoolMirroring(ctx context.Context, cluster kubeAccess, poolname string) error {
 	patchClusterJson := `
 	{
 		"spec": {
@@ -430,22 +629,17 @@ func enablePoolMirroring(cluster kubeAccess, poolname string) error {
 	}`
 
 	currentBlockPool := cephv1.CephBlockPool{}
-	err := cluster.controllerClient.Get(context.TODO(),
-		types.NamespacedName{Name: poolname, Namespace: ocsNamespace},
+	err := cluster.controllerClient.Get(ctx,
+		types.NamespacedName{Name: poolname, Namespace: cluster.storageNamespace()},
 		&currentBlockPool)
 	if err != nil {
 		return errors.WithMessagef(err, "Issues when fetching current CephBlockPool in %s cluster", cluster.name)
 	}
 
 	mirrorSpec := cephv1.MirroringSpec{
-		Enabled: true,
-		Mode:    "image",
-		SnapshotSchedules: []cephv1.SnapshotScheduleSpec{
-			{
-				Interval: "5m",
-				// StartTime: "00:00:00-00:00",
-			},
-		},
+		Enabled:           true,
+		Mode:              "image",
+		SnapshotSchedules: pool().cephSnapshotSchedules(),
 	}
 	currentBlockPool.Spec.Mirroring = mirrorSpec
 	patchClassJson, err := json.Marshal(currentBlockPool)
@@ -453,27 +647,42 @@ func enablePoolMirroring(cluster kubeAccess, poolname string) error {
 		return errors.WithMessage(err, "Issues when converting CephBlockPool Patch to JSON")
 	}
 
-	err = cluster.controllerClient.Patch(context.TODO(),
-		&ocsv1.StorageCluster{ObjectMeta: metav1.ObjectMeta{Name: "ocs-storagecluster", Namespace: ocsNamespace}},
-		client.RawPatch(types.MergePatchType, []byte(patchClusterJson)))
+	// Without a StorageCluster, nothing reconciles the Block Pools
+	storageCluster := cluster.environment().storageCluster
+	if dryRun.enabled {
+		if storageCluster {
+			dryRun.intercept(cluster, "patch", "StorageCluster/ocs-storagecluster", patchClusterJson)
+		}
+		dryRun.intercept(cluster, "patch", "CephBlockPool/"+poolname, patchClassJson)
+		return nil
+	}
+	if storageCluster {
+		err = cluster.controllerClient.Patch(ctx,
+			&ocsv1.StorageCluster{ObjectMeta: metav1.ObjectMeta{Name: "ocs-storagecluster", Namespace: cluster.storageNamespace()}},
+			client.RawPatch(types.MergePatchType, []byte(patchClusterJson)))
 
-	if err != nil {
-		return errors.WithMessagef(err, "Issues when patching StorageCluster in %s cluster", cluster.name)
+		if err != nil {
+			return errors.WithMessagef(err, "Issues when patching StorageCluster in %s cluster", cluster.name)
+		}
+		installProgress.forCluster(cluster.name).info("OCS Block Pool reconcile strategy set to ignore")
 	}
-	addRowOfTextOutput(installText, "[%s] OCS Block Pool reconcile strategy set to ignore", cluster.name)
 
-	err = cluster.controllerClient.Patch(context.TODO(),
-		&cephv1.CephBlockPool{ObjectMeta: metav1.ObjectMeta{Name: poolname, Namespace: ocsNamespace}},
+	err = cluster.controllerClient.Patch(ctx,
+		&cephv1.CephBlockPool{ObjectMeta: metav1.ObjectMeta{Name: poolname, Namespace: cluster.storageNamespace()}},
 		client.RawPatch(types.MergePatchType, patchClassJson))
 	if err != nil {
 		return errors.WithMessagef(err, "Issues when patching CephBlockPool in %s cluster", cluster.name)
 	}
-	addRowOfTextOutput(installText, "[%s] OCS Block Pool Mirroring enabled", cluster.name)
+	installProgress.forCluster(cluster.name).info("OCS Block Pool Mirroring enabled")
 
 	return nil
 }
 
-func enableToolbox(cluster kubeAccess) error {
+// enableToolbox lets ODF deploy the rook-ceph-tools Pod, with upstream Rook RDRhelper deploys it
+func enableToolbox(ctx context.Context, cluster kubeAccess) error {
+	if !cluster.environment().storageCluster {
+		return deployToolbox(ctx, cluster)
+	}
 	patchClusterStruc := []patchBoolValue{
 		{
 			Op:    "replace",
@@ -485,14 +694,17 @@ func enableToolbox(cluster kubeAccess) error {
 	if err != nil {
 		return errors.WithMessage(err, "Issues when converting OCSInitialization Patch to JSON")
 	}
-	err = cluster.controllerClient.Patch(context.TODO(),
-		&ocsv1.OCSInitialization{ObjectMeta: metav1.ObjectMeta{Name: "ocsinit", Namespace: ocsNamespace}},
+	if dryRun.intercept(cluster, "patch", "OCSInitialization/ocsinit", patchClusterJson) {
+		return nil
+	}
+	err = cluster.controllerClient.Patch(ctx,
+		&ocsv1.OCSInitialization{ObjectMeta: metav1.ObjectMeta{Name: "ocsinit", Namespace: cluster.storageNamespace()}},
 		client.RawPatch(types.JSONPatchType, patchClusterJson))
 
 	if err != nil {
 		return errors.WithMessagef(err, "Issues when enabling Ceph Toolbox in %s cluster", cluster.name)
 	}
-	addRowOfTextOutput(installText, "[%s] OCS Toolbox enabled", cluster.name)
+	installProgress.forCluster(cluster.name).info("OCS Toolbox enabled")
 
 	return nil
 }
@@ -516,22 +728,39 @@ func enableToolbox(cluster kubeAccess) error {
 // 	if err != nil {
 // 		return errors.WithMessagef(err, "Issues when creating new StorageClass in %s cluster", cluster.name)
 // 	}
-// 	addRowOfTextOutput(installText,"[%s] OCS RBD Storage Class retain policy changed to retain", cluster.name)
+// 	addRowOfTextOutput(installOutput,"[%s] OCS RBD Storage Class retain policy changed to retain", cluster.name)
 // 	return nil
 // }
 
-func exchangeMirroringBootstrapSecrets(from, to *kubeAccess, blockPoolName string) error {
+// bootstrapSecretName returns the name of the Secret that holds the bootstrap token of the pool of the from cluster.
+// With named clusters, a cluster can have several peers, so the name of the from cluster is added.
+func bootstrapSecretName(blockPoolName string, from kubeAccess) string {
+	if namedSites() {
+		return fmt.Sprintf("mirror-bootstrap-%s-%s", blockPoolName, from.name)
+	}
+	return fmt.Sprintf("mirror-bootstrap-%s", blockPoolName)
+}
+
+// exchangeMirroringBootstrapSecrets copies the bootstrap secret of the pool fromPool of one cluster to the other
+// and registers it as peer of its pool toPool the way the setup of the ODF release expects
+func exchangeMirroringBootstrapSecrets(ctx context.Context, from, to *kubeAccess, fromPool, toPool, setup string) error {
+	if dryRun.enabled {
+		// The pool status, and with it the token, is only available after the pool was created for real
+		dryRun.intercept(*to, "patch", "Secret/"+bootstrapSecretName(toPool, *from),
+			fmt.Sprintf("bootstrap token and site name from the status of CephBlockPool %s in the %s cluster", fromPool, from.name))
+		return registerMirroringPeers(ctx, *to, toPool, setup)
+	}
 	var blockPool cephv1.CephBlockPool
 	var cbpList cephv1.CephBlockPoolList
 	var tokenSecretName string
-	for {
-		err := from.controllerClient.List(context.TODO(),
-			&cbpList, &client.ListOptions{Namespace: ocsNamespace})
+	err := waitFor(ctx, timeouts().Install, fmt.Sprintf("the mirroring info of CephBlockPool %s in the %s cluster", fromPool, from.name), func(ctx context.Context) (bool, error) {
+		err := from.controllerClient.List(ctx,
+			&cbpList, &client.ListOptions{Namespace: from.storageNamespace()})
 		if err != nil {
-			return errors.WithMessagef(err, "[%s] Issues when listing CephBlockPools", from.name)
+			return false, errors.WithMessagef(err, "[%s] Issues when listing CephBlockPools", from.name)
 		}
 		for _, cbp := range cbpList.Items {
-			if cbp.Name == blockPoolName {
+			if cbp.Name == fromPool {
 				blockPool = cbp
 				break
 			}
@@ -539,23 +768,26 @@ func exchangeMirroringBootstrapSecrets(from, to *kubeAccess, blockPoolName strin
 		if blockPool.Status != nil && blockPool.Status.Info != nil && blockPool.Status.MirroringInfo != nil && blockPool.Status.MirroringInfo.Summary["summary"] != nil {
 			tokenSecretName = blockPool.Status.Info["rbdMirrorBootstrapPeerSecretName"]
 			if tokenSecretName != "" {
-				break
+				return true, nil
 			}
 		}
-		addRowOfTextOutput(installText, "[%s] mirroring info not yet available in pool status", from.name)
-		time.Sleep(time.Second * 3)
+		installProgress.forCluster(from.name).info("mirroring info not yet available in pool status")
+		return false, nil
+	})
+	if err != nil {
+		return err
 	}
 	if tokenSecretName == "" {
-		log.Warnf("[%s] Could not find 'rbdMirrorBootstrapPeerSecretName' in %s status block", from.name, blockPoolName)
+		log.Warnf("[%s] Could not find 'rbdMirrorBootstrapPeerSecretName' in %s status block", from.name, fromPool)
 		return errors.New("secret name not found in pool status")
 	}
 
-	secret, err := from.typedClient.CoreV1().Secrets(ocsNamespace).Get(context.TODO(), tokenSecretName, metav1.GetOptions{})
+	secret, err := from.typedClient.CoreV1().Secrets(from.storageNamespace()).Get(ctx, tokenSecretName, metav1.GetOptions{})
 	if err != nil {
 		return errors.WithMessagef(err, "[%s] Issues when fetching secret token", from.name)
 	}
 	poolToken := secret.Data["token"]
-	addRowOfTextOutput(installText, "[%s] Got Pool Mirror secret from secret %s", from.name, tokenSecretName)
+	installProgress.forCluster(from.name).info("Got Pool Mirror secret from secret %s", tokenSecretName)
 	mirrorinfo := blockPool.Status.MirroringInfo
 	if mirrorinfo == nil {
 		log.Warnf("[%s] MirroringInfo not set yet %+v", from.name, mirrorinfo)
@@ -566,15 +798,15 @@ func exchangeMirroringBootstrapSecrets(from, to *kubeAccess, blockPoolName strin
 		log.Warnf("[%s] site_name not set yet %+v", from.name, siteName)
 		return errors.New("site_name not set yet")
 	}
-	addRowOfTextOutput(installText, "[%s] Got site name %s", from.name, siteName["site_name"])
-	bootstrapSecretName := fmt.Sprintf("mirror-bootstrap-%s", blockPoolName)
+	installProgress.forCluster(from.name).info("Got site name %s", siteName["site_name"])
+	secretName := bootstrapSecretName(toPool, *from)
 	bootstrapSecretStruc := corev1.Secret{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      bootstrapSecretName,
-			Namespace: ocsNamespace,
+			Name:      secretName,
+			Namespace: to.storageNamespace(),
 			Labels: map[string]string{
 				"usage":     "bootstrap",
-				"pool":      blockPoolName,
+				"pool":      toPool,
 				"site-name": siteName["site_name"].(string),
 			},
 		},
@@ -584,26 +816,67 @@ func exchangeMirroringBootstrapSecrets(from, to *kubeAccess, blockPoolName strin
 		},
 		Data: map[string][]byte{
 			"token": poolToken,
-			"pool":  []byte(blockPoolName),
+			"pool":  []byte(toPool),
 		},
 	}
 	bootstrapSecretJSON, err := json.Marshal(bootstrapSecretStruc)
 	if err != nil {
 		return errors.WithMessagef(err, "[%s] issues when converting secret to JSON %+v", from.name, bootstrapSecretStruc)
 	}
-	_, err = to.typedClient.CoreV1().Secrets(ocsNamespace).
-		Patch(context.TODO(), bootstrapSecretName,
+	_, err = to.typedClient.CoreV1().Secrets(to.storageNamespace()).
+		Patch(ctx, secretName,
 			types.ApplyPatchType, bootstrapSecretJSON, metav1.PatchOptions{FieldManager: "RDRhelper"})
 	if err != nil {
 		return errors.WithMessagef(err, "Issues when creating bootstrap secret in %s location", to.name)
 	}
-	addRowOfTextOutput(installText, "[%s] Created bootstrap secret", to.name)
-	mirrroringSecrets := getAllSecretNames(*to)
+	installProgress.forCluster(to.name).info("Created bootstrap secret")
+	return registerMirroringPeers(ctx, *to, toPool, setup)
+}
+
+// registerMirroringPeers configures the bootstrap secrets as peers of the pool. With the CephBlockPool setup,
+// RDRhelper deploys rbd-mirror with all peers. With the StorageCluster setup, ODF deploys rbd-mirror, the peers
+// of the default pool are set in the StorageCluster and the ones of other pools in their CephBlockPool.
+func registerMirroringPeers(ctx context.Context, to kubeAccess, blockPoolName, setup string) error {
+	if dryRun.enabled {
+		target, peers := "CephRBDMirror/rbd-mirror", "peer secrets: all Secrets with label usage=bootstrap"
+		switch {
+		case setup == setupCephBlockPool:
+		case blockPoolName == to.environment().defaultPool:
+			target, peers = "StorageCluster/"+storageClusterName, "spec.mirroring.peerSecretNames: all Secrets with label usage=bootstrap"
+		default:
+			target, peers = "CephBlockPool/"+blockPoolName, "spec.mirroring.peers.secretNames: all Secrets with label usage=bootstrap"
+		}
+		dryRun.intercept(to, "patch", target, peers)
+		return nil
+	}
+	mirrroringSecrets := getAllSecretNames(ctx, to)
 	if len(mirrroringSecrets) == 0 {
-		return errors.WithMessagef(err, "No bootstrap secrets found")
+		return errors.New("No bootstrap secrets found")
+	}
+	if setup == setupStorageCluster {
+		if blockPoolName == to.environment().defaultPool {
+			if err := setStorageClusterPeers(ctx, to, mirrroringSecrets); err != nil {
+				return err
+			}
+			installProgress.forCluster(to.name).info("Set the peers in the StorageCluster")
+			return nil
+		}
+		patch, _ := json.Marshal(map[string]interface{}{
+			"spec": map[string]interface{}{
+				"mirroring": map[string]interface{}{"peers": map[string]interface{}{"secretNames": mirrroringSecrets}},
+			},
+		})
+		err := to.controllerClient.Patch(ctx,
+			&cephv1.CephBlockPool{ObjectMeta: metav1.ObjectMeta{Name: blockPoolName, Namespace: to.storageNamespace()}},
+			client.RawPatch(types.MergePatchType, patch))
+		if err != nil {
+			return errors.WithMessagef(err, "Issues when setting the peers of CephBlockPool %s in %s location", blockPoolName, to.name)
+		}
+		installProgress.forCluster(to.name).info("Set the peers of CephBlockPool %s", blockPoolName)
+		return nil
 	}
 	rbdMirrorSpec := cephv1.CephRBDMirror{
-		ObjectMeta: metav1.ObjectMeta{Name: "rbd-mirror", Namespace: ocsNamespace},
+		ObjectMeta: metav1.ObjectMeta{Name: "rbd-mirror", Namespace: to.storageNamespace()},
 		TypeMeta:   metav1.TypeMeta{Kind: "CephRBDMirror", APIVersion: "ceph.rook.io/v1"},
 		Spec: cephv1.RBDMirroringSpec{
 			Count: len(mirrroringSecrets),
@@ -613,22 +886,22 @@ func exchangeMirroringBootstrapSecrets(from, to *kubeAccess, blockPoolName strin
 		}}
 	rbdMirrorJSON, err := json.Marshal(rbdMirrorSpec)
 	if err != nil {
-		return errors.WithMessagef(err, "[%s] issues when converting rbd-mirror Spec to JSON %+v", from.name, rbdMirrorSpec)
+		return errors.WithMessagef(err, "[%s] issues when converting rbd-mirror Spec to JSON %+v", to.name, rbdMirrorSpec)
 	}
-	err = to.controllerClient.Patch(context.TODO(),
+	err = to.controllerClient.Patch(ctx,
 		&rbdMirrorSpec,
 		client.RawPatch(types.ApplyPatchType, rbdMirrorJSON), &client.PatchOptions{FieldManager: "RDRhelper"})
 	if err != nil {
 		return errors.WithMessagef(err, "Issues when creating rbd-mirror CR in %s location", to.name)
 	}
-	addRowOfTextOutput(installText, "[%s] Created rbd-mirror CR", to.name)
+	installProgress.forCluster(to.name).info("Created rbd-mirror CR")
 	return nil
 }
 
-func getAllSecretNames(cluster kubeAccess) []string {
+func getAllSecretNames(ctx context.Context, cluster kubeAccess) []string {
 	mirrroringSecrets := []string{}
 	secretList, err := cluster.typedClient.CoreV1().
-		Secrets(ocsNamespace).List(context.TODO(), metav1.ListOptions{LabelSelector: "usage=bootstrap"})
+		Secrets(cluster.storageNamespace()).List(ctx, metav1.ListOptions{LabelSelector: "usage=bootstrap"})
 	if err != nil {
 		log.WithError(err).Warnf("[%s] Issues when listing secrets for bootstrap exchange", cluster.name)
 		return []string{}
@@ -639,8 +912,8 @@ func getAllSecretNames(cluster kubeAccess) []string {
 	return mirrroringSecrets
 }
 
-func enableOMAPGenerator(cluster kubeAccess) error {
-	configMapClient := cluster.typedClient.CoreV1().ConfigMaps(ocsNamespace)
+func enableOMAPGenerator(ctx context.Context, cluster kubeAccess) error {
+	configMapClient := cluster.typedClient.CoreV1().ConfigMaps(cluster.storageNamespace())
 
 	payload := []patchStringValue{{
 		Op:    "add",
@@ -649,24 +922,29 @@ func enableOMAPGenerator(cluster kubeAccess) error {
 	}}
 	payloadBytes, _ := json.Marshal(payload)
 
-	addRowOfTextOutput(installText, "[%s] Patching CM for OMAP Generator", cluster.name)
-	_, err := configMapClient.Patch(context.TODO(), "rook-ceph-operator-config", types.JSONPatchType, payloadBytes, metav1.PatchOptions{})
+	installProgress.forCluster(cluster.name).info("Patching CM for OMAP Generator")
+	if dryRun.intercept(cluster, "patch", "ConfigMap/rook-ceph-operator-config", payloadBytes) {
+		return nil
+	}
+	_, err := configMapClient.Patch(ctx, "rook-ceph-operator-config", types.JSONPatchType, payloadBytes, metav1.PatchOptions{})
 	if err != nil {
 		return errors.WithMessagef(err, "failed with patching the OMAP client on %s", cluster.name)
 	}
-	addRowOfTextOutput(installText, "[%s] Patched CM for OMAP Generator", cluster.name)
-	addRowOfTextOutput(installText, "[%s] Waiting for OMAP generator container to appear", cluster.name)
+	installProgress.forCluster(cluster.name).info("Patched CM for OMAP Generator")
+	installProgress.forCluster(cluster.name).info("Waiting for OMAP generator container to appear")
 
-	for {
-		if checkForOMAPGenerator(cluster) {
-			addRowOfTextOutput(installText, "[%s] OMAP generator container appeared", cluster.name)
-			return nil
-		}
+	err = waitFor(ctx, timeouts().Install, fmt.Sprintf("the OMAP generator container in the %s cluster", cluster.name), func(ctx context.Context) (bool, error) {
+		return checkForOMAPGenerator(ctx, cluster), nil
+	})
+	if err != nil {
+		return err
 	}
+	installProgress.forCluster(cluster.name).info("OMAP generator container appeared")
+	return nil
 }
 
-func checkForOMAPGenerator(cluster kubeAccess) bool {
-	pods, err := cluster.typedClient.CoreV1().Pods(ocsNamespace).List(context.TODO(), metav1.ListOptions{LabelSelector: "app=csi-rbdplugin-provisioner"})
+func checkForOMAPGenerator(ctx context.Context, cluster kubeAccess) bool {
+	pods, err := cluster.typedClient.CoreV1().Pods(cluster.storageNamespace()).List(ctx, metav1.ListOptions{LabelSelector: "app=csi-rbdplugin-provisioner"})
 	if err != nil {
 		return false
 	}
@@ -681,7 +959,7 @@ func checkForOMAPGenerator(cluster kubeAccess) bool {
 	return false
 }
 
-func doInstallOADP(cluster kubeAccess) error {
+func doInstallOADP(ctx context.Context, cluster kubeAccess) error {
 	if err := operatorsv1alpha1.AddToScheme(cluster.controllerClient.Scheme()); err != nil {
 		return errors.WithMessagef(err, "[%s] Issues when adding operator API schemas", cluster.name)
 	}
@@ -692,8 +970,12 @@ func doInstallOADP(cluster kubeAccess) error {
 		return errors.WithMessagef(err, "[%s] Issues when adding velero schemas", cluster.name)
 	}
 
+	if dryRun.enabled {
+		return planInstallOADP(cluster)
+	}
+
 	// Create instead of Patch, because Patch created too many issues... If this fails, it's 99% of the time because the namespace already exists
-	cluster.typedClient.CoreV1().Namespaces().Create(context.TODO(), &corev1.Namespace{TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Namespace"}, ObjectMeta: metav1.ObjectMeta{Name: "oadp-operator"}}, metav1.CreateOptions{})
+	cluster.typedClient.CoreV1().Namespaces().Create(ctx, &corev1.Namespace{TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Namespace"}, ObjectMeta: metav1.ObjectMeta{Name: "oadp-operator"}}, metav1.CreateOptions{})
 
 	oadpSubscriptionSpec := operatorsv1alpha1.Subscription{
 		TypeMeta: metav1.TypeMeta{
@@ -722,7 +1004,7 @@ func doInstallOADP(cluster kubeAccess) error {
 		return errors.WithMessagef(err, "[%s] issues when patching OADP Subscription JSON", cluster.name)
 	}
 	oadpSubscriptionPatchedJSON := []byte(tmp)
-	err = cluster.controllerClient.Patch(context.TODO(),
+	err = cluster.controllerClient.Patch(ctx,
 		&oadpSubscriptionSpec,
 		client.RawPatch(types.ApplyPatchType, oadpSubscriptionPatchedJSON),
 		&client.PatchOptions{FieldManager: "RDRhelper"})
@@ -752,7 +1034,7 @@ func doInstallOADP(cluster kubeAccess) error {
 		return errors.WithMessagef(err, "[%s] issues when patching OADP OperatorGroup JSON", cluster.name)
 	}
 	oadpOGroupPatchedJSON := []byte(tmp)
-	err = cluster.controllerClient.Patch(context.TODO(),
+	err = cluster.controllerClient.Patch(ctx,
 		&oadpOGroupSpec,
 		client.RawPatch(types.ApplyPatchType, oadpOGroupPatchedJSON),
 		&client.PatchOptions{FieldManager: "RDRhelper"})
@@ -777,7 +1059,7 @@ func doInstallOADP(cluster kubeAccess) error {
 	if err != nil {
 		return errors.WithMessagef(err, "[%s] issues when converting secret to JSON %+v", cluster.name, s3CredStruc)
 	}
-	_, err = cluster.typedClient.CoreV1().Secrets("oadp-operator").Patch(context.TODO(),
+	_, err = cluster.typedClient.CoreV1().Secrets("oadp-operator").Patch(ctx,
 		"cloud-credentials",
 		types.ApplyPatchType,
 		s3CredJSON,
@@ -785,39 +1067,39 @@ func doInstallOADP(cluster kubeAccess) error {
 	if err != nil {
 		return errors.WithMessagef(err, "[%s] issues when creating S3 secret", cluster.name)
 	}
-	addRowOfTextOutput(installText, "[%s] OADP cloud secret created", cluster.name)
+	installProgress.forCluster(cluster.name).info("OADP cloud secret created")
 
 	// Wait for OADP Operator to be installed
 
-	for {
+	err = waitFor(ctx, timeouts().Install, fmt.Sprintf("the OADP operator in the %s cluster", cluster.name), func(ctx context.Context) (bool, error) {
 		var csvs operatorsv1alpha1.ClusterServiceVersionList
-		err = cluster.controllerClient.List(context.TODO(),
+		err := cluster.controllerClient.List(ctx,
 			&csvs, client.MatchingLabels{"operators.coreos.com/oadp-operator.oadp-operator": ""})
 		if err != nil {
-			addRowOfTextOutput(installText, "[%s] issues when listing OADP ClusterServiceVersions - Retrying...", cluster.name)
-			time.Sleep(9 * time.Second)
-			continue
+			installProgress.forCluster(cluster.name).warn("issues when listing OADP ClusterServiceVersions - Retrying...")
+			return false, nil
 		}
 		if len(csvs.Items) == 0 {
-			addRowOfTextOutput(installText, "[%s] No OADP Operator detected yet - Retrying...", cluster.name)
-			time.Sleep(9 * time.Second)
-			continue
+			installProgress.forCluster(cluster.name).info("No OADP Operator detected yet - Retrying...")
+			return false, nil
 		}
 
 		csv := csvs.Items[0]
 		if csv.Status.Phase == "" {
-			time.Sleep(3 * time.Second)
-			continue
+			return false, nil
 		}
 
 		if csv.Status.Phase == "Succeeded" {
-			break
+			return true, nil
 		}
 
-		addRowOfTextOutput(installText, "[%s] OADP operator is still installing", cluster.name)
-		time.Sleep(3 * time.Second)
+		installProgress.forCluster(cluster.name).info("OADP operator is still installing")
+		return false, nil
+	})
+	if err != nil {
+		return err
 	}
-	addRowOfTextOutput(installText, "[%s] OADP operator is installed and ready now", cluster.name)
+	installProgress.forCluster(cluster.name).info("OADP operator is installed and ready now")
 
 	veleroJSON := fmt.Sprintf(`
 apiVersion: konveyor.openshift.io/v1alpha1
@@ -849,56 +1131,76 @@ spec:
 		Version:  "v1alpha1",
 		Resource: "veleros",
 	}
-	_, err = cluster.dynamicClient.Resource(veleroRes).Namespace("oadp-operator").Patch(context.TODO(),
+	_, err = cluster.dynamicClient.Resource(veleroRes).Namespace("oadp-operator").Patch(ctx,
 		"oadp-velero", types.ApplyPatchType, []byte(veleroJSON), metav1.PatchOptions{FieldManager: "RDRhelper"})
 	if err != nil {
 		return errors.WithMessagef(err, "[%s] issues when creating Velero CR", cluster.name)
 	}
-	addRowOfTextOutput(installText, "[%s] OADP Velero CR created", cluster.name)
+	installProgress.forCluster(cluster.name).info("OADP Velero CR created")
+	return nil
+}
+
+// planInstallOADP records the OADP install during a dry run
+func planInstallOADP(cluster kubeAccess) error {
+	dryRun.intercept(cluster, "create", "Namespace/oadp-operator", nil)
+	dryRun.intercept(cluster, "patch", "oadp-operator/Subscription/oadp-operator", "package oadp-operator, channel alpha from community-operators")
+	dryRun.intercept(cluster, "patch", "oadp-operator/OperatorGroup/oadp-operator", "targetNamespaces: oadp-operator")
+	// The payload would contain the S3 credentials
+	dryRun.intercept(cluster, "patch", "oadp-operator/Secret/cloud-credentials", fmt.Sprintf("S3 credentials of key ID %s", appConfig.S3info.S3keyID))
+	dryRun.intercept(cluster, "patch", "oadp-operator/Velero/oadp-velero",
+		fmt.Sprintf("bucket %s in region %s with prefix %s", appConfig.S3info.Bucketname, appConfig.S3info.Region, appConfig.S3info.Objectprefix))
 	return nil
 }
-func verifyOADPinstall(cluster kubeAccess) error {
-	addRowOfTextOutput(installText, "[%s] verifying OADP install", cluster.name)
-	for {
-		podlist, err := cluster.typedClient.CoreV1().Pods("oadp-operator").List(context.TODO(), metav1.ListOptions{LabelSelector: "component=velero"})
+
+func verifyOADPinstall(ctx context.Context, cluster kubeAccess) error {
+	installProgress.forCluster(cluster.name).info("verifying OADP install")
+	if dryRun.enabled {
+		installProgress.forCluster(cluster.name).info("DRY RUN - skipping the OADP verification")
+		return nil
+	}
+	err := waitFor(ctx, timeouts().Install, fmt.Sprintf("the Velero Pod in the %s cluster", cluster.name), func(ctx context.Context) (bool, error) {
+		podlist, err := cluster.typedClient.CoreV1().Pods("oadp-operator").List(ctx, metav1.ListOptions{LabelSelector: "component=velero"})
 		if err != nil {
-			addRowOfTextOutput(installText, "[%s] issues when listing Pods in oadp-operator namespace - Retrying...", cluster.name)
-			time.Sleep(9 * time.Second)
-			continue
+			installProgress.forCluster(cluster.name).warn("issues when listing Pods in oadp-operator namespace - Retrying...")
+			return false, nil
 		}
 		if len(podlist.Items) == 0 {
-			addRowOfTextOutput(installText, "[%s] still waiting for Velero Pod to appear...", cluster.name)
-			time.Sleep(9 * time.Second)
-			continue
+			installProgress.forCluster(cluster.name).info("still waiting for Velero Pod to appear...")
+			return false, nil
 		}
 		if podlist.Items[0].Status.Phase == corev1.PodRunning {
-			addRowOfTextOutput(installText, "[%s] Velero Pod is ready and Running", cluster.name)
-			break
+			installProgress.forCluster(cluster.name).info("Velero Pod is ready and Running")
+			return true, nil
 		}
-		addRowOfTextOutput(installText, "[%s] Velero Pod is not yet running", cluster.name)
-		time.Sleep(3 * time.Second)
+		installProgress.forCluster(cluster.name).info("Velero Pod is not yet running")
+		return false, nil
+	})
+	if err != nil {
+		return err
 	}
 
-	for {
+	err = waitFor(ctx, timeouts().Install, fmt.Sprintf("the BackupStorageLocation in the %s cluster", cluster.name), func(ctx context.Context) (bool, error) {
 		var backupstoragelocation velerov1.BackupStorageLocation
-		err := cluster.controllerClient.Get(context.TODO(),
+		err := cluster.controllerClient.Get(ctx,
 			types.NamespacedName{Name: "default", Namespace: "oadp-operator"},
 			&backupstoragelocation)
 		if err != nil {
-			addRowOfTextOutput(installText, "[%s] issues when fetching default BackupStorageLocation - Retrying...", cluster.name)
-			time.Sleep(9 * time.Second)
-			continue
+			installProgress.forCluster(cluster.name).warn("issues when fetching default BackupStorageLocation - Retrying...")
+			return false, nil
 		}
 		if backupstoragelocation.Status.Phase == "Available" {
-			addRowOfTextOutput(installText, "[%s] BackupStorageLocation is Available", cluster.name)
-			break
+			installProgress.forCluster(cluster.name).info("BackupStorageLocation is Available")
+			return true, nil
 		}
 
-		addRowOfTextOutput(installText, "[%s] BackupStorageLocation is not Available yet", cluster.name)
-		time.Sleep(3 * time.Second)
+		installProgress.forCluster(cluster.name).info("BackupStorageLocation is not Available yet")
+		return false, nil
+	})
+	if err != nil {
+		return err
 	}
 
-	addRowOfTextOutput(installText, "[%s] OADP install is complete", cluster.name)
+	installProgress.forCluster(cluster.name).info("OADP install is complete")
 
 	return nil
 }
@@ -916,34 +1218,12 @@ func validateS3info() bool {
 	return true
 }
 
-func checkInstallRequirements(cluster kubeAccess) error {
-	ocsVersion, err := checkForOCSCSV(cluster)
-	if err != nil {
-		return errors.WithMessagef(err, "[%s] OCS not properly installed", cluster.name)
-	}
-	if ocsVersion.Major != uint64(4) || ocsVersion.Minor != uint64(7) {
-		return errors.WithMessagef(err, "[%s] OCS version does not match 4.7 - detected version %d.%d", cluster.name, ocsVersion.Major, ocsVersion.Minor)
-	}
-	log.Infof("[%s] ODF is installed in a supported version", cluster.name)
-
-	storageClusterRes := schema.GroupVersionResource{
-		Group:    "ocs.openshift.io",
-		Version:  "v1",
-		Resource: "storageclusters",
-	}
-	storageClusterIdentifier := types.NamespacedName{
-		Name:      "ocs-storagecluster",
-		Namespace: ocsNamespace,
-	}
-	status, err := getObjectStatus(storageClusterRes, storageClusterIdentifier, cluster)
-	if err != nil {
-		return errors.WithMessagef(err, "[%s] Issues when checking StorageCluster status", cluster.name)
-	}
-	if status != "Ready" {
-		return errors.Errorf("StorageCluster is not ready yet - current status is %s", status)
+// checkAllInstallRequirements runs the install checks of the registry on both clusters and between them
+func checkAllInstallRequirements(ctx context.Context) error {
+	if err := requireChecks(ctx, phaseInstall, installProgress, allSites()...); err != nil {
+		return err
 	}
-	log.Infof("[%s] ODF StorageCluster is Ready", cluster.name)
-
+	log.Info("Install requirements met")
 	return nil
 }
 
@@ -953,7 +1233,7 @@ func checkForOCSCSV(cluster kubeAccess) (version.OperatorVersion, error) {
 	}
 	var csvs operatorsv1alpha1.ClusterServiceVersionList
 	err := cluster.controllerClient.List(context.TODO(),
-		&csvs, client.MatchingLabels{"operators.coreos.com/ocs-operator.openshift-storage": ""})
+		&csvs, client.MatchingLabels{"operators.coreos.com/ocs-operator." + cluster.storageNamespace(): ""})
 	if err != nil {
 		return version.OperatorVersion{}, errors.WithMessagef(err, "[%s] issues when listing OADP ClusterServiceVersions", cluster.name)
 	}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// installStateConfigMap records the finished install steps in each cluster
+const installStateConfigMap = "rdrhelper-install-state"
+
+// restartInstall ignores the recorded install state, so all steps run again
+var restartInstall = false
+
+// installStep is a named part of the install that runs on every cluster
+type installStep struct {
+	name        string
+	description string
+	// input identifies the settings the step ran with in the cluster, the step runs again if they changed
+	input func(cluster kubeAccess) string
+	run   func(ctx context.Context, cluster, peer *kubeAccess) error
+	// check verifies that a finished step is still in place, steps without check trust the record
+	check func(ctx context.Context, cluster kubeAccess) (bool, error)
+	// perPeer steps set up the cluster for its peer, with named clusters they are recorded once per peer
+	perPeer bool
+}
+
+// forPeer returns the step as it is recorded in the cluster for the peer
+func (s installStep) forPeer(peer *kubeAccess) installStep {
+	if s.perPeer && namedSites() {
+		s.name = s.name + "." + peer.name
+	}
+	return s
+}
+
+// inputFor returns the settings the step runs with in the cluster, empty for steps without settings
+func (s installStep) inputFor(cluster kubeAccess) string {
+	if s.input == nil {
+		return ""
+	}
+	return s.input(cluster)
+}
+
+// installStepRecord is stored as JSON per step in the install state ConfigMap
+type installStepRecord struct {
+	Completed time.Time `json:"completed"`
+	Input     string    `json:"input,omitempty"`
+}
+
+// loadInstallState returns the finished steps of the cluster, by step name
+func loadInstallState(ctx context.Context, cluster kubeAccess) (map[string]installStepRecord, error) {
+	records := make(map[string]installStepRecord)
+	configMap, err := cluster.typedClient.CoreV1().ConfigMaps(cluster.storageNamespace()).Get(ctx, installStateConfigMap, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return records, nil
+	}
+	if err != nil {
+		return nil, errors.WithMessagef(err, "[%s] Issues when fetching the install state", cluster.name)
+	}
+	for step, data := range configMap.Data {
+		var record installStepRecord
+		if err := json.Unmarshal([]byte(data), &record); err != nil {
+			log.WithError(err).Warnf("[%s] Ignoring the unreadable install state of step %s", cluster.name, step)
+			continue
+		}
+		records[step] = record
+	}
+	return records, nil
+}
+
+// recordInstallStep marks the step as finished in the install state of the cluster
+func recordInstallStep(ctx context.Context, cluster kubeAccess, step installStep) error {
+	data, err := json.Marshal(installStepRecord{Completed: time.Now().UTC(), Input: step.inputFor(cluster)})
+	if err != nil {
+		return errors.WithMessage(err, "Issues when converting the install state to JSON")
+	}
+	if dryRun.intercept(cluster, "patch", "ConfigMap/"+installStateConfigMap, map[string]string{step.name: string(data)}) {
+		return nil
+	}
+	configMaps := cluster.typedClient.CoreV1().ConfigMaps(cluster.storageNamespace())
+	configMap, err := configMaps.Get(ctx, installStateConfigMap, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		_, err = configMaps.Create(ctx, &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: installStateConfigMap, Namespace: cluster.storageNamespace()},
+			Data:       map[string]string{step.name: string(data)},
+		}, metav1.CreateOptions{})
+		if err != nil {
+			return errors.WithMessagef(err, "[%s] Issues when creating the install state", cluster.name)
+		}
+		return nil
+	}
+	if err != nil {
+		return errors.WithMessagef(err, "[%s] Issues when fetching the install state", cluster.name)
+	}
+	if configMap.Data == nil {
+		configMap.Data = make(map[string]string)
+	}
+	configMap.Data[step.name] = string(data)
+	if _, err = configMaps.Update(ctx, configMap, metav1.UpdateOptions{}); err != nil {
+		return errors.WithMessagef(err, "[%s] Issues when updating the install state", cluster.name)
+	}
+	return nil
+}
+
+// stepDone returns true if the step finished before with the same input and its check still passes
+func stepDone(ctx context.Context, cluster kubeAccess, step installStep, records map[string]installStepRecord) bool {
+	record, found := records[step.name]
+	if restartInstall || !found || record.Input != step.inputFor(cluster) {
+		return false
+	}
+	if step.check == nil {
+		return true
+	}
+	requestCtx, cancel := requestContext(ctx)
+	defer cancel()
+	done, err := step.check(requestCtx, cluster)
+	if err != nil {
+		log.WithError(err).Warnf("[%s] Could not check the install step %s, running it again", cluster.name, step.name)
+	}
+	return done && err == nil
+}
+
+// runInstallSteps runs the steps in order on both clusters. Steps that finished in an earlier run
+// are skipped, so a failed install resumes at the step that failed.
+func runInstallSteps(ctx context.Context, steps []installStep, primary, secondary *kubeAccess) error {
+	clusters := []struct{ cluster, peer *kubeAccess }{{primary, secondary}, {secondary, primary}}
+	records := make(map[string]map[string]installStepRecord)
+	for _, c := range clusters {
+		state, err := loadInstallState(ctx, *c.cluster)
+		if err != nil {
+			return installProgress.failed("install-state", err, "Issues when loading the install state")
+		}
+		records[c.cluster.name] = state
+	}
+
+	for _, step := range steps {
+		installProgress.started(step.name, "%s", step.description)
+		for _, c := range clusters {
+			progress := installProgress.forCluster(c.cluster.name)
+			if err := ctx.Err(); err != nil {
+				return progress.failed(step.name, err, "Install stopped")
+			}
+			clusterStep := step.forPeer(c.peer)
+			if stepDone(ctx, *c.cluster, clusterStep, records[c.cluster.name]) {
+				progress.info("%s already done, skipping", clusterStep.name)
+				continue
+			}
+			if err := step.run(ctx, c.cluster, c.peer); err != nil {
+				return progress.failed(step.name, err, "Issues when running install step %s", step.name)
+			}
+			if err := recordInstallStep(ctx, *c.cluster, clusterStep); err != nil {
+				progress.warn("step %s is done but could not be recorded, it will run again next time: %s", step.name, err)
+			}
+		}
+		installProgress.finished(step.name, "%s done", step.description)
+	}
+	return nil
+}
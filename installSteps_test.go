@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+// stepLog records which step ran on which cluster
+type stepLog struct {
+	runs    []string
+	failing map[string]bool
+}
+
+func (l *stepLog) step(name, input string, check func(ctx context.Context, cluster kubeAccess) (bool, error)) installStep {
+	return installStep{
+		name:        name,
+		description: "Running " + name,
+		input:       func(kubeAccess) string { return input },
+		check:       check,
+		run: func(ctx context.Context, cluster, peer *kubeAccess) error {
+			run := name + "@" + cluster.name
+			l.runs = append(l.runs, run)
+			if l.failing[run] {
+				return errors.New("broken")
+			}
+			return nil
+		},
+	}
+}
+
+func TestRunInstallStepsResumes(t *testing.T) {
+	primary, _ := newFakeCluster(t, "primary")
+	secondary, _ := newFakeCluster(t, "secondary")
+	ctx := context.Background()
+	ran := &stepLog{failing: map[string]bool{"pool@secondary": true}}
+	stillInPlace := true
+	steps := func(pool string) []installStep {
+		return []installStep{
+			ran.step("omap", "", func(ctx context.Context, cluster kubeAccess) (bool, error) { return stillInPlace, nil }),
+			ran.step("pool", pool, nil),
+			ran.step("toolbox", "", nil),
+		}
+	}
+
+	err := runInstallSteps(ctx, steps("replicapool"), &primary, &secondary)
+	if err == nil || err.Error() != "Issues when running install step pool: broken" {
+		t.Fatalf("expected the pool step to fail, got %v", err)
+	}
+	if expected := []string{"omap@primary", "omap@secondary", "pool@primary", "pool@secondary"}; !reflect.DeepEqual(ran.runs, expected) {
+		t.Errorf("expected runs %v, got %v", expected, ran.runs)
+	}
+
+	// The second run resumes at the failed step
+	ran.runs = nil
+	ran.failing = nil
+	if err = runInstallSteps(ctx, steps("replicapool"), &primary, &secondary); err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"pool@secondary", "toolbox@primary", "toolbox@secondary"}; !reflect.DeepEqual(ran.runs, expected) {
+		t.Errorf("expected the install to resume with %v, got %v", expected, ran.runs)
+	}
+
+	// Failed checks and changed inputs run the steps again
+	ran.runs = nil
+	stillInPlace = false
+	if err = runInstallSteps(ctx, steps("ocs-storagecluster-cephblockpool"), &primary, &secondary); err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"omap@primary", "omap@secondary", "pool@primary", "pool@secondary"}; !reflect.DeepEqual(ran.runs, expected) {
+		t.Errorf("expected the changed steps %v to run again, got %v", expected, ran.runs)
+	}
+
+	records, err := loadInstallState(ctx, secondary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record := records["pool"]; record.Input != "ocs-storagecluster-cephblockpool" || record.Completed.IsZero() {
+		t.Errorf("unexpected record of the pool step %+v", record)
+	}
+}
+
+func TestRunInstallStepsRestart(t *testing.T) {
+	defer func() { restartInstall = false }()
+	primary, _ := newFakeCluster(t, "primary")
+	secondary, _ := newFakeCluster(t, "secondary")
+	ctx := context.Background()
+	ran := &stepLog{}
+	steps := []installStep{ran.step("toolbox", "", nil)}
+
+	if err := runInstallSteps(ctx, steps, &primary, &secondary); err != nil {
+		t.Fatal(err)
+	}
+	restartInstall = true
+	if err := runInstallSteps(ctx, steps, &primary, &secondary); err != nil {
+		t.Fatal(err)
+	}
+	if len(ran.runs) != 4 {
+		t.Errorf("expected all steps to run again with restart, got %v", ran.runs)
+	}
+}
+
+func TestRunInstallStepsDryRun(t *testing.T) {
+	defer func() { dryRun.enabled = false }()
+	primary, _ := newFakeCluster(t, "primary")
+	secondary, _ := newFakeCluster(t, "secondary")
+	dryRun.enabled = true
+	dryRun.start()
+
+	if err := runInstallSteps(context.Background(), []installStep{(&stepLog{}).step("toolbox", "", nil)}, &primary, &secondary); err != nil {
+		t.Fatal(err)
+	}
+	records, err := loadInstallState(context.Background(), primary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 0 {
+		t.Errorf("expected the dry run to not record any step, got %v", records)
+	}
+	if actions := dryRun.plannedActions(); len(actions) != 2 || actions[0].Target != "ConfigMap/"+installStateConfigMap {
+		t.Errorf("expected the state updates to be planned, got %v", actions)
+	}
+}
@@ -12,6 +12,9 @@ var app = tview.NewApplication()
 var log = logrus.New()
 var mainMenu = tview.NewList()
 
+// headless is set when RDRhelper runs a CLI subcommand instead of the TUI
+var headless = false
+
 func init() {
 	log.SetFormatter(&logrus.TextFormatter{
 		DisableColors: false,
@@ -27,6 +30,10 @@ func main() {
 	}
 	log.Out = logFile
 
+	if len(os.Args) > 1 {
+		os.Exit(runCLI(os.Args[1:]))
+	}
+
 	pages.SetChangedFunc(pagesChangedFunc)
 
 	pages.AddAndSwitchToPage("main",
@@ -36,6 +43,8 @@ func main() {
 	appFrame = tview.NewFrame(pages)
 
 	readConfig()
+	subscribeEventLog()
+	events.subscribe(alertSink)
 
 	if err := app.SetRoot(appFrame, true).Run(); err != nil {
 		panic(err)
@@ -50,23 +59,39 @@ func pagesChangedFunc() {
 
 	mainMenu.Clear().
 		AddItem("Configure Kubeconfigs", "Configure which Kubeconfigs to use for primary and secondary locations", '5', func() { showConfigPage() }).
+		AddItem(dryRunMenuText(), "Record all changes as a reviewable plan instead of executing them", 'd', func() {
+			dryRun.enabled = !dryRun.enabled
+			updateFrame()
+		}).
 		AddItem("Quit", "Press to exit app", 'q', func() { app.Stop() })
 
 	if kubeConfigPrimary.path == "" || kubeConfigSecondary.path == "" {
 		return
 	}
 	mainMenu.
-		InsertItem(0, "Verify Install", "Verify correct RDR installation", '2', func() { showVerifyPage(kubeConfigPrimary,kubeConfigSecondary) }).
+		InsertItem(0, "Verify Install", "Verify correct RDR installation", '2', func() { showVerifyPage(allSites()...) }).
 		InsertItem(0, "Install", "Install RDR", '1', func() {
 			log.Info("Checking requirements")
 			showModal("checkRequirement", "checking requirements for install...", []string{}, nil)
 			go showBlockPoolChoice()
-		})
+		}).
+		InsertItem(2, "Uninstall", "Remove the RDR setup from all clusters", 'u', func() { askSeriousForUninstall() })
+	if namedSites() {
+		mainMenu.InsertItem(2, "Select Clusters", "Choose the source and target cluster of the PVC views and the failover", 'c', func() { showSiteSelection() })
+	}
 
-	if checkForOMAPGenerator(kubeConfigPrimary) && checkForOMAPGenerator(kubeConfigSecondary) {
+	if mirroringReady(kubeConfigPrimary, kubeConfigSecondary) {
 		mainMenu.
+			InsertItem(2, "Snapshot Schedules", "Show and change the mirror snapshot schedules of pools and PVCs", 's', func() { showSnapshotSchedulePage(allSites()) }).
 			InsertItem(2, "Failover / Failback", "Failover to secondary or Failback to primary location", '9', func() { askSeriousForFailover() }).
 			InsertItem(2, "Configure Secondary", "Configure PVs for DR on the secondary side", '4', func() { setPVCViewPage(secondaryPVCs, kubeConfigSecondary, kubeConfigPrimary) }).
 			InsertItem(2, "Configure Primary", "Configure PVs for DR on the primary side", '3', func() { setPVCViewPage(primaryPVCs, kubeConfigPrimary, kubeConfigSecondary) })
 	}
 }
+
+func dryRunMenuText() string {
+	if dryRun.enabled {
+		return "Disable dry run mode"
+	}
+	return "Enable dry run mode"
+}
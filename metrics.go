@@ -0,0 +1,335 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	cephv1 "github.com/rook/rook/pkg/apis/ceph.rook.io/v1"
+	velerov1 "github.com/vmware-tanzu/velero/pkg/apis/velero/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// mirrorHealthValues maps the Ceph health strings to metric values
+var mirrorHealthValues = map[string]float64{
+	"OK":      0,
+	"WARNING": 1,
+	"ERROR":   2,
+}
+
+// metricValues holds the gauges of one collection
+type metricValues struct {
+	poolHealth         *prometheus.GaugeVec
+	imageState         *prometheus.GaugeVec
+	imageLastSnapshot  *prometheus.GaugeVec
+	rbdMirrorPodReady  *prometheus.GaugeVec
+	lastBackupAge      *prometheus.GaugeVec
+	collectionSuccess  *prometheus.GaugeVec
+	lastCollectionTime prometheus.Gauge
+}
+
+func newMetricValues() *metricValues {
+	return &metricValues{
+		poolHealth: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "rdrhelper_pool_mirroring_health",
+			Help: "Mirroring summary health of a block pool (0 OK, 1 WARNING, 2 ERROR, 3 UNKNOWN)",
+		}, []string{"cluster", "pool", "type"}),
+		imageState: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "rdrhelper_image_mirror_state",
+			Help: "Mirror state of an RBD image, the value is always 1 and the state is in the state label",
+		}, []string{"cluster", "pool", "image", "namespace", "pvc", "site", "state"}),
+		imageLastSnapshot: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "rdrhelper_image_last_snapshot_timestamp_seconds",
+			Help: "Timestamp of the last mirror snapshot of an RBD image that was replayed",
+		}, []string{"cluster", "pool", "image", "namespace", "pvc", "site"}),
+		rbdMirrorPodReady: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "rdrhelper_rbd_mirror_pod_ready",
+			Help: "Readiness of the rbd-mirror daemon pods (1 ready, 0 not ready)",
+		}, []string{"cluster", "pod"}),
+		lastBackupAge: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "rdrhelper_last_backup_age_seconds",
+			Help: "Age of the last completed Velero backup of the regional-dr-backup Schedule",
+		}, []string{"cluster"}),
+		collectionSuccess: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "rdrhelper_collection_success",
+			Help: "Whether the last collection of a metric group succeeded (1) or failed (0)",
+		}, []string{"cluster", "collector"}),
+		lastCollectionTime: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "rdrhelper_last_collection_timestamp_seconds",
+			Help: "Timestamp of the last metric collection",
+		}),
+	}
+}
+
+func (v *metricValues) collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		v.poolHealth,
+		v.imageState,
+		v.imageLastSnapshot,
+		v.rbdMirrorPodReady,
+		v.lastBackupAge,
+		v.collectionSuccess,
+		v.lastCollectionTime,
+	}
+}
+
+// drMetrics serves the gauges of the last finished collection.
+// They are refreshed in an interval, so scrapes do not exec into the toolbox and never see a collection in progress.
+type drMetrics struct {
+	registry *prometheus.Registry
+	lock     sync.RWMutex
+	values   *metricValues
+}
+
+func newDRMetrics() *drMetrics {
+	m := &drMetrics{
+		registry: prometheus.NewRegistry(),
+		values:   newMetricValues(),
+	}
+	m.registry.MustRegister(m)
+	return m
+}
+
+func (m *drMetrics) current() *metricValues {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	return m.values
+}
+
+// Describe implements prometheus.Collector
+func (m *drMetrics) Describe(ch chan<- *prometheus.Desc) {
+	for _, collector := range m.current().collectors() {
+		collector.Describe(ch)
+	}
+}
+
+// Collect implements prometheus.Collector
+func (m *drMetrics) Collect(ch chan<- prometheus.Metric) {
+	for _, collector := range m.current().collectors() {
+		collector.Collect(ch)
+	}
+}
+
+// collect gathers all metrics of the given clusters and replaces the served ones when done
+func (m *drMetrics) collect(ctx context.Context, clusters ...kubeAccess) {
+	values := newMetricValues()
+	for _, cluster := range clusters {
+		values.recordSuccess(cluster, "mirroring", values.collectMirroring(ctx, cluster))
+		values.recordSuccess(cluster, "rbd-mirror-pods", values.collectRBDMirrorPods(ctx, cluster))
+		values.recordSuccess(cluster, "backup", values.collectLastBackup(ctx, cluster))
+	}
+	values.lastCollectionTime.SetToCurrentTime()
+	m.lock.Lock()
+	m.values = values
+	m.lock.Unlock()
+}
+
+// run collects the metrics right away and then in every interval, until ctx is done
+func (m *drMetrics) run(ctx context.Context, interval time.Duration, clusters ...kubeAccess) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		m.collect(ctx, clusters...)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (v *metricValues) recordSuccess(cluster kubeAccess, collector string, err error) {
+	if err != nil {
+		log.WithError(err).WithField("collector", collector).Warnf("[%s] Issues when collecting metrics", cluster.name)
+		v.collectionSuccess.WithLabelValues(cluster.name, collector).Set(0)
+		return
+	}
+	v.collectionSuccess.WithLabelValues(cluster.name, collector).Set(1)
+}
+
+// snapshotReplayStatus is the JSON part of the description of snapshot based mirroring, like
+// replaying, {"bytes_per_second":0.0,"local_snapshot_timestamp":1620000000,"remote_snapshot_timestamp":1620000000,"replay_state":"idle"}
+type snapshotReplayStatus struct {
+	LocalSnapshotTimestamp  int64 `json:"local_snapshot_timestamp"`
+	RemoteSnapshotTimestamp int64 `json:"remote_snapshot_timestamp"`
+}
+
+func parseSnapshotReplayStatus(description string) (snapshotReplayStatus, bool) {
+	var status snapshotReplayStatus
+	start := strings.Index(description, "{")
+	if start < 0 {
+		return status, false
+	}
+	if err := json.Unmarshal([]byte(description[start:]), &status); err != nil {
+		return status, false
+	}
+	return status, status.LocalSnapshotTimestamp != 0
+}
+
+// collectMirroring exports the health of the mirrored pools and the state of their images.
+// A pool whose status cannot be fetched is reported as UNKNOWN, the other pools are still collected.
+func (v *metricValues) collectMirroring(ctx context.Context, cluster kubeAccess) error {
+	if err := cephv1.AddToScheme(cluster.controllerClient.Scheme()); err != nil {
+		return errors.WithMessagef(err, "[%s] Issues when adding the cephv1 scheme", cluster.name)
+	}
+	var cbpList cephv1.CephBlockPoolList
+	err := cluster.controllerClient.List(ctx, &cbpList, &client.ListOptions{Namespace: cluster.storageNamespace()})
+	if err != nil {
+		return errors.WithMessagef(err, "[%s] Issues when listing CephBlockPools", cluster.name)
+	}
+
+	// Map the RBD images back to their PVCs
+	pvcOfImage := make(map[string][2]string)
+	pvs, err := cluster.typedClient.CoreV1().PersistentVolumes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return errors.WithMessagef(err, "[%s] Issues when listing PVs", cluster.name)
+	}
+	for _, pv := range pvs.Items {
+		rbdName, poolName, err := getRBDInfoFromPV(&pv)
+		if err != nil || pv.Spec.ClaimRef == nil {
+			continue
+		}
+		pvcOfImage[poolName+"/"+rbdName] = [2]string{pv.Spec.ClaimRef.Namespace, pv.Spec.ClaimRef.Name}
+	}
+
+	var failed []string
+	for _, cbp := range cbpList.Items {
+		if !cbp.Spec.Mirroring.Enabled {
+			continue
+		}
+		if ctx.Err() != nil {
+			return errors.Wrapf(ctx.Err(), "[%s] Stopped to collect the mirror status", cluster.name)
+		}
+		status, err := newRBD(cluster).MirrorPoolStatus(cbp.Name)
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %s", cbp.Name, err))
+			status = &rbdPoolStatus{}
+		}
+		for _, healthType := range []string{"health", "daemon_health", "image_health"} {
+			value, known := mirrorHealthValues[fmt.Sprint(status.Summary[healthType])]
+			if !known {
+				value = 3
+			}
+			v.poolHealth.WithLabelValues(cluster.name, cbp.Name, healthType).Set(value)
+		}
+		for _, image := range status.Images {
+			pvc := pvcOfImage[cbp.Name+"/"+image.Name]
+			v.imageState.WithLabelValues(cluster.name, cbp.Name, image.Name, pvc[0], pvc[1], "local", image.State).Set(1)
+			if replay, ok := parseSnapshotReplayStatus(image.Description); ok {
+				v.imageLastSnapshot.WithLabelValues(cluster.name, cbp.Name, image.Name, pvc[0], pvc[1], "local").Set(float64(replay.LocalSnapshotTimestamp))
+			}
+			for _, peer := range image.PeerSites {
+				v.imageState.WithLabelValues(cluster.name, cbp.Name, image.Name, pvc[0], pvc[1], peer.SiteName, peer.State).Set(1)
+				if replay, ok := parseSnapshotReplayStatus(peer.Description); ok {
+					v.imageLastSnapshot.WithLabelValues(cluster.name, cbp.Name, image.Name, pvc[0], pvc[1], peer.SiteName).Set(float64(replay.LocalSnapshotTimestamp))
+				}
+			}
+		}
+	}
+	if len(failed) > 0 {
+		return errors.Errorf("[%s] Issues when fetching the mirror status of the pools:\n%s", cluster.name, strings.Join(failed, "\n"))
+	}
+	return nil
+}
+
+func (v *metricValues) collectRBDMirrorPods(ctx context.Context, cluster kubeAccess) error {
+	pods, err := cluster.typedClient.CoreV1().Pods(cluster.storageNamespace()).List(ctx, metav1.ListOptions{LabelSelector: "app=rook-ceph-rbd-mirror"})
+	if err != nil {
+		return errors.WithMessagef(err, "[%s] Issues when listing rbd-mirror pods", cluster.name)
+	}
+	for _, pod := range pods.Items {
+		ready := 1.0
+		if len(pod.Status.ContainerStatuses) == 0 {
+			ready = 0
+		}
+		for _, container := range pod.Status.ContainerStatuses {
+			if !container.Ready {
+				ready = 0
+			}
+		}
+		v.rbdMirrorPodReady.WithLabelValues(cluster.name, pod.Name).Set(ready)
+	}
+	return nil
+}
+
+func (v *metricValues) collectLastBackup(ctx context.Context, cluster kubeAccess) error {
+	if !checkForOADP(cluster) {
+		return nil
+	}
+	if err := velerov1.AddToScheme(cluster.controllerClient.Scheme()); err != nil {
+		return errors.WithMessagef(err, "[%s] Issues when adding velero schemas", cluster.name)
+	}
+	var backups velerov1.BackupList
+	err := cluster.controllerClient.List(ctx, &backups,
+		client.InNamespace("oadp-operator"),
+		client.MatchingLabels{velerov1.ScheduleNameLabel: "regional-dr-backup"})
+	if err != nil {
+		return errors.WithMessagef(err, "[%s] Issues when listing Backups", cluster.name)
+	}
+	var lastCompletion time.Time
+	for _, backup := range backups.Items {
+		if backup.Status.Phase != velerov1.BackupPhaseCompleted || backup.Status.CompletionTimestamp == nil {
+			continue
+		}
+		if backup.Status.CompletionTimestamp.Time.After(lastCompletion) {
+			lastCompletion = backup.Status.CompletionTimestamp.Time
+		}
+	}
+	if lastCompletion.IsZero() {
+		// No completed backup yet, leave the metric out so it can be alerted on with absent()
+		return nil
+	}
+	v.lastBackupAge.WithLabelValues(cluster.name).Set(time.Since(lastCompletion).Seconds())
+	return nil
+}
+
+func cliServe(args []string) int {
+	var clusterFlags clusterFlags
+	var listenAddress string
+	var interval time.Duration
+	flags := flag.NewFlagSet("serve", flag.ContinueOnError)
+	clusterFlags.register(flags)
+	flags.StringVar(&listenAddress, "listen", ":9128", "address the metrics endpoint listens on")
+	flags.DurationVar(&interval, "interval", time.Minute, "interval in which the metrics are collected")
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+	if err := clusterFlags.load(); err != nil {
+		return cliFail(err)
+	}
+	log.Out = os.Stderr
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	metrics := newDRMetrics()
+	go metrics.run(ctx, interval, allSites()...)
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(metrics.registry, promhttp.HandlerOpts{}))
+	server := &http.Server{Addr: listenAddress, Handler: mux}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			log.WithError(err).Warn("Issues when shutting down the metrics endpoint")
+		}
+	}()
+	log.Infof("Serving metrics on %s/metrics", listenAddress)
+	fmt.Printf("Serving metrics on %s/metrics\n", listenAddress)
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return cliFail(errors.Wrap(err, "metrics endpoint stopped"))
+	}
+	return exitOK
+}
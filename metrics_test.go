@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	velerov1 "github.com/vmware-tanzu/velero/pkg/apis/velero/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestCollectMirroring(t *testing.T) {
+	tests := []struct {
+		name           string
+		failingPools   []string
+		expectedError  string
+		expectedImages int
+		expectedHealth map[string]float64
+	}{
+		{
+			name:           "all pools",
+			expectedImages: 2,
+			expectedHealth: map[string]float64{"replicapool": 0, "other-pool": 0},
+		},
+		{
+			name:           "failing pool",
+			failingPools:   []string{"replicapool"},
+			expectedError:  "replicapool: ",
+			expectedImages: 1,
+			expectedHealth: map[string]float64{"replicapool": 3, "other-pool": 0},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cluster, toolbox := newFakeCluster(t, "primary",
+				newMirroredBlockPool("replicapool", nil),
+				newMirroredBlockPool("other-pool", nil),
+				newRBDPV("pv-shop", "shop", "data", "img-shop", corev1.VolumeBound),
+			)
+			toolbox.mirrored["replicapool/img-shop"] = "up+stopped"
+			toolbox.mirrored["other-pool/img-logs"] = "up+stopped"
+			for _, pool := range test.failingPools {
+				toolbox.failing["rbd mirror pool status "+pool+" --verbose --format json"] = rbdExitInvalid
+			}
+
+			values := newMetricValues()
+			err := values.collectMirroring(context.Background(), cluster)
+			if test.expectedError == "" && err != nil {
+				t.Errorf("collectMirroring failed: %s", err)
+			}
+			if test.expectedError != "" && (err == nil || !strings.Contains(err.Error(), test.expectedError)) {
+				t.Errorf("expected an error about %q, got %v", test.expectedError, err)
+			}
+			if images := testutil.CollectAndCount(values.imageState); images != test.expectedImages {
+				t.Errorf("expected %d image series, got %d", test.expectedImages, images)
+			}
+			for pool, expected := range test.expectedHealth {
+				if health := testutil.ToFloat64(values.poolHealth.WithLabelValues("primary", pool, "health")); health != expected {
+					t.Errorf("expected the health %v for pool %s, got %v", expected, pool, health)
+				}
+			}
+		})
+	}
+}
+
+func TestCollectRBDMirrorPods(t *testing.T) {
+	labels := map[string]string{"app": "rook-ceph-rbd-mirror"}
+	tests := []struct {
+		name     string
+		pod      *corev1.Pod
+		expected float64
+	}{
+		{
+			name:     "ready",
+			pod:      newPod(environmentODF.namespace, "rbd-mirror-a", labels, true, "rbd-mirror"),
+			expected: 1,
+		},
+		{
+			name:     "not ready",
+			pod:      newPod(environmentODF.namespace, "rbd-mirror-a", labels, false, "rbd-mirror"),
+			expected: 0,
+		},
+		{
+			name:     "no containers",
+			pod:      newPod(environmentODF.namespace, "rbd-mirror-a", labels, true),
+			expected: 0,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cluster, _ := newFakeCluster(t, "primary", test.pod)
+
+			values := newMetricValues()
+			if err := values.collectRBDMirrorPods(context.Background(), cluster); err != nil {
+				t.Fatalf("collectRBDMirrorPods failed: %s", err)
+			}
+			if ready := testutil.ToFloat64(values.rbdMirrorPodReady.WithLabelValues("primary", "rbd-mirror-a")); ready != test.expected {
+				t.Errorf("expected the readiness %v, got %v", test.expected, ready)
+			}
+		})
+	}
+}
+
+func newScheduledBackup(name string, phase velerov1.BackupPhase, completed time.Time) *velerov1.Backup {
+	backup := newBackup(name, completed)
+	backup.Labels = map[string]string{velerov1.ScheduleNameLabel: "regional-dr-backup"}
+	backup.Status.Phase = phase
+	backup.Status.CompletionTimestamp = &metav1.Time{Time: completed}
+	return backup
+}
+
+func TestCollectLastBackup(t *testing.T) {
+	velero := newPod("oadp-operator", "velero-1", map[string]string{"component": "velero"}, true, "velero")
+	now := time.Now()
+
+	tests := []struct {
+		name          string
+		objects       []runtime.Object
+		expectedAge   bool
+		expectedOlder time.Duration
+	}{
+		{
+			name:    "no OADP installed",
+			objects: []runtime.Object{newScheduledBackup("regional-dr-backup-1", velerov1.BackupPhaseCompleted, now)},
+		},
+		{
+			name:    "no completed Backup",
+			objects: []runtime.Object{velero, newScheduledBackup("regional-dr-backup-1", velerov1.BackupPhaseFailed, now)},
+		},
+		{
+			name: "latest completed Backup",
+			objects: []runtime.Object{
+				velero,
+				newScheduledBackup("regional-dr-backup-1", velerov1.BackupPhaseCompleted, now.Add(-2*time.Hour)),
+				newScheduledBackup("regional-dr-backup-2", velerov1.BackupPhaseCompleted, now.Add(-time.Hour)),
+				newScheduledBackup("regional-dr-backup-3", velerov1.BackupPhaseFailed, now),
+			},
+			expectedAge:   true,
+			expectedOlder: time.Hour,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cluster, _ := newFakeCluster(t, "primary", test.objects...)
+
+			values := newMetricValues()
+			if err := values.collectLastBackup(context.Background(), cluster); err != nil {
+				t.Fatalf("collectLastBackup failed: %s", err)
+			}
+			if !test.expectedAge {
+				if series := testutil.CollectAndCount(values.lastBackupAge); series != 0 {
+					t.Errorf("expected no backup age, got %d series", series)
+				}
+				return
+			}
+			age := testutil.ToFloat64(values.lastBackupAge.WithLabelValues("primary"))
+			if age < test.expectedOlder.Seconds() || age > (test.expectedOlder+time.Minute).Seconds() {
+				t.Errorf("expected the backup to be %s old, got %vs", test.expectedOlder, age)
+			}
+		})
+	}
+}
+
+func TestDRMetricsServeLastCollection(t *testing.T) {
+	cluster, toolbox := newFakeCluster(t, "primary", newMirroredBlockPool("replicapool", nil))
+	toolbox.mirrored["replicapool/img-shop"] = "up+stopped"
+	metrics := newDRMetrics()
+
+	metrics.collect(context.Background(), cluster)
+	if images, err := testutil.GatherAndCount(metrics.registry, "rdrhelper_image_mirror_state"); err != nil || images != 1 {
+		t.Errorf("expected one image series, got %d (%v)", images, err)
+	}
+	served := metrics.current()
+
+	toolbox.mirrored["replicapool/img-logs"] = "up+stopped"
+	metrics.collect(context.Background(), cluster)
+	if metrics.current() == served {
+		t.Error("expected the collection to replace the served values")
+	}
+	if images, err := testutil.GatherAndCount(metrics.registry, "rdrhelper_image_mirror_state"); err != nil || images != 2 {
+		t.Errorf("expected two image series, got %d (%v)", images, err)
+	}
+	if success := testutil.ToFloat64(metrics.current().collectionSuccess.WithLabelValues("primary", "mirroring")); success != 1 {
+		t.Errorf("expected the mirroring collection to succeed, got %v", success)
+	}
+}
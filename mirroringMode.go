@@ -0,0 +1,78 @@
+package main
+
+import (
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Mirroring modes of RBD images. Snapshot mode copies the changes with each scheduled mirror snapshot,
+// journal mode replays every write from the image journal, for a near-zero RPO.
+const (
+	mirroringModeSnapshot = "snapshot"
+	mirroringModeJournal  = "journal"
+)
+
+// journalFeatures are the image features that journal based mirroring needs, in the order they depend on each other
+var journalFeatures = []string{"exclusive-lock", "journaling"}
+
+func validateMirroringMode(mode string) error {
+	if mode != mirroringModeSnapshot && mode != mirroringModeJournal {
+		return errors.Errorf("unknown mirroring mode %q, use snapshot or journal", mode)
+	}
+	return nil
+}
+
+// mirroringModeFor returns the mirroring mode that is configured for the images of the pool, snapshot by default
+func mirroringModeFor(poolName string) string {
+	if mode, configured := appConfig.MirroringModes[poolName]; configured && mode != "" {
+		return mode
+	}
+	return mirroringModeSnapshot
+}
+
+// setMirroringMode stores the mirroring mode of the pool in the config
+func setMirroringMode(poolName, mode string) {
+	if mode == mirroringModeSnapshot {
+		delete(appConfig.MirroringModes, poolName)
+		return
+	}
+	if appConfig.MirroringModes == nil {
+		appConfig.MirroringModes = make(map[string]string)
+	}
+	appConfig.MirroringModes[poolName] = mode
+}
+
+// enableJournaling makes sure that the image has the features for journal based mirroring
+func enableJournaling(rbd rbdClient, pv *corev1.PersistentVolume, poolName, rbdName string) error {
+	if pv.Spec.CSI.VolumeAttributes["mounter"] != "rbd-nbd" {
+		return errors.Errorf("journal based mirroring of PV %s needs the rbd-nbd mounter in its StorageClass, "+
+			"the kernel RBD client cannot map images with the journaling feature", pv.Name)
+	}
+	info, err := rbd.Info(poolName, rbdName)
+	if err != nil {
+		return errors.WithMessagef(err, "could not get the features of the image of PV %s", pv.Name)
+	}
+	present := make(map[string]bool)
+	for _, feature := range info.Features {
+		present[feature] = true
+	}
+	var missing []string
+	for _, feature := range journalFeatures {
+		if !present[feature] {
+			missing = append(missing, feature)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	if err := rbd.EnableFeatures(poolName, rbdName, missing...); err != nil {
+		return errors.WithMessagef(err, "could not enable the journaling features of the image of PV %s", pv.Name)
+	}
+	return nil
+}
+
+// disableJournaling removes the journaling feature after journal based mirroring was disabled,
+// since the journal only slows down the writes without mirroring
+func disableJournaling(rbd rbdClient, poolName, rbdName string) error {
+	return rbd.DisableFeatures(poolName, rbdName, "journaling")
+}
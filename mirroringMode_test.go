@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestSetMirrorStatusJournal(t *testing.T) {
+	defer func() { appConfig.MirroringModes = nil }()
+	pv := newRBDPV("pv-db", "shop", "db", "csi-vol-db", corev1.VolumeBound)
+	pv.Spec.CSI.VolumeAttributes["mounter"] = "rbd-nbd"
+	cluster, toolbox := newFakeCluster(t, "primary", pv)
+	toolbox.features["replicapool/csi-vol-db"] = []string{"layering"}
+	setMirroringMode("replicapool", mirroringModeJournal)
+
+	if err := setMirrorStatus(context.Background(), toolboxReplication{cluster}, pv, true, ""); err != nil {
+		t.Fatal(err)
+	}
+	if features := toolbox.features["replicapool/csi-vol-db"]; !reflect.DeepEqual(features, []string{"layering", "exclusive-lock", "journaling"}) {
+		t.Errorf("expected the journaling features to be enabled, got %v", features)
+	}
+	if commands := toolbox.commandsContaining("mirror image enable replicapool/csi-vol-db journal"); len(commands) != 1 {
+		t.Errorf("expected journal based mirroring to be enabled, got %v", toolbox.commands)
+	}
+
+	if err := setMirrorStatus(context.Background(), toolboxReplication{cluster}, pv, false, ""); err != nil {
+		t.Fatal(err)
+	}
+	if features := toolbox.features["replicapool/csi-vol-db"]; !reflect.DeepEqual(features, []string{"layering", "exclusive-lock"}) {
+		t.Errorf("expected the journaling feature to be removed, got %v", features)
+	}
+}
+
+func TestSetMirrorStatusJournalNeedsNBD(t *testing.T) {
+	pv := newRBDPV("pv-db", "shop", "db", "csi-vol-db", corev1.VolumeBound)
+	cluster, toolbox := newFakeCluster(t, "primary", pv)
+
+	err := setMirrorStatus(context.Background(), toolboxReplication{cluster}, pv, true, mirroringModeJournal)
+	if err == nil || !strings.Contains(err.Error(), "rbd-nbd") {
+		t.Errorf("expected journal mode to need the rbd-nbd mounter, got %v", err)
+	}
+	if len(toolbox.commands) != 0 {
+		t.Errorf("expected the image to stay unchanged, got %v", toolbox.commands)
+	}
+
+	// Snapshot mode stays the default
+	if err := setMirrorStatus(context.Background(), toolboxReplication{cluster}, pv, true, ""); err != nil {
+		t.Fatal(err)
+	}
+	if commands := toolbox.commandsContaining("mirror image enable replicapool/csi-vol-db snapshot"); len(commands) != 1 {
+		t.Errorf("expected snapshot based mirroring to be enabled, got %v", toolbox.commands)
+	}
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilexec "k8s.io/client-go/util/exec"
+)
+
+// Ports of the Ceph daemons that rbd-mirror connects to in the peer cluster
+const (
+	monMsgr2Port = 3300
+	monMsgr1Port = 6789
+	// osdPort is the first port of the OSD range, which the first OSD in each Pod binds
+	osdPort = 6800
+)
+
+const (
+	// osdSampleSize is the number of OSDs of the peer cluster that are probed
+	osdSampleSize = 3
+	// probeTimeoutSeconds bounds each TCP connection attempt
+	probeTimeoutSeconds = 5
+	// probeTimeoutExitCode is the exit code of timeout(1) when the connection attempt did not finish in time
+	probeTimeoutExitCode = 124
+)
+
+const monEndpointsConfigMap = "rook-ceph-mon-endpoints"
+
+const submarinerNamespace = "submariner-operator"
+
+// cephEndpoint is a Ceph daemon that needs to be reachable from the peer cluster
+type cephEndpoint struct {
+	// Kind is mon or osd
+	Kind    string `json:"kind"`
+	Name    string `json:"name"`
+	Address string `json:"address"`
+	Port    int32  `json:"port"`
+}
+
+func (e cephEndpoint) String() string {
+	return fmt.Sprintf("%s %s (%s:%d)", e.Kind, e.Name, e.Address, e.Port)
+}
+
+// endpointProbe is the result of a TCP connection attempt from a Pod in one cluster to an endpoint of the other cluster
+type endpointProbe struct {
+	From      string       `json:"from"`
+	To        string       `json:"to"`
+	Endpoint  cephEndpoint `json:"endpoint"`
+	Reachable bool         `json:"reachable"`
+	Error     string       `json:"error,omitempty"`
+	Hint      string       `json:"hint,omitempty"`
+}
+
+func (p endpointProbe) String() string {
+	if p.Reachable {
+		return fmt.Sprintf("[%s -> %s] %s: reachable", p.From, p.To, p.Endpoint)
+	}
+	return fmt.Sprintf("[%s -> %s] %s: %s", p.From, p.To, p.Endpoint, p.Error)
+}
+
+// cephEndpoints returns the mons and a sample of the OSDs of the cluster.
+// The mons are read from the ConfigMap that rook keeps for the CSI driver and the peers, or from their Services.
+func cephEndpoints(ctx context.Context, cluster kubeAccess) ([]cephEndpoint, error) {
+	mons, err := monEndpoints(ctx, cluster)
+	if err != nil {
+		return nil, err
+	}
+	if len(mons) == 0 {
+		return nil, errors.Errorf("[%s] No Ceph monitors found in the %s namespace", cluster.name, cluster.storageNamespace())
+	}
+	osds, err := osdEndpoints(ctx, cluster)
+	if err != nil {
+		return nil, err
+	}
+	return append(mons, osds...), nil
+}
+
+func monEndpoints(ctx context.Context, cluster kubeAccess) ([]cephEndpoint, error) {
+	requestCtx, cancel := requestContext(ctx)
+	defer cancel()
+	configMap, err := cluster.typedClient.CoreV1().ConfigMaps(cluster.storageNamespace()).Get(requestCtx, monEndpointsConfigMap, metav1.GetOptions{})
+	if err == nil && configMap.Data["data"] != "" {
+		return parseMonEndpoints(configMap.Data["data"]), nil
+	}
+	services, err := cluster.typedClient.CoreV1().Services(cluster.storageNamespace()).List(requestCtx, metav1.ListOptions{LabelSelector: "app=rook-ceph-mon"})
+	if err != nil {
+		return nil, errors.WithMessagef(err, "[%s] Issues when listing the Ceph monitor Services", cluster.name)
+	}
+	var endpoints []cephEndpoint
+	for _, service := range services.Items {
+		if len(service.Spec.Ports) == 0 {
+			continue
+		}
+		// The other messenger port is tried by the probe if this one fails
+		name := strings.TrimPrefix(service.Name, "rook-ceph-mon-")
+		endpoints = append(endpoints, cephEndpoint{Kind: "mon", Name: name, Address: service.Spec.ClusterIP, Port: service.Spec.Ports[0].Port})
+	}
+	return endpoints, nil
+}
+
+// parseMonEndpoints parses the "a=172.30.1.1:6789,b=..." format of rook, mons without a port use the v1 messenger port
+func parseMonEndpoints(data string) []cephEndpoint {
+	var endpoints []cephEndpoint
+	for _, entry := range strings.Split(data, ",") {
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		address, port := parts[1], int32(monMsgr1Port)
+		if i := strings.LastIndex(address, ":"); i >= 0 {
+			if parsed, err := strconv.ParseInt(address[i+1:], 10, 32); err == nil {
+				port = int32(parsed)
+			}
+			address = address[:i]
+		}
+		endpoints = append(endpoints, cephEndpoint{Kind: "mon", Name: parts[0], Address: address, Port: port})
+	}
+	return endpoints
+}
+
+// otherMsgrPort returns the messenger port of the mons that the endpoint does not use
+func otherMsgrPort(port int32) int32 {
+	if port == monMsgr2Port {
+		return monMsgr1Port
+	}
+	return monMsgr2Port
+}
+
+// osdEndpoints returns the first running OSDs, sorted by name
+func osdEndpoints(ctx context.Context, cluster kubeAccess) ([]cephEndpoint, error) {
+	requestCtx, cancel := requestContext(ctx)
+	defer cancel()
+	pods, err := cluster.typedClient.CoreV1().Pods(cluster.storageNamespace()).List(requestCtx, metav1.ListOptions{LabelSelector: "app=rook-ceph-osd"})
+	if err != nil {
+		return nil, errors.WithMessagef(err, "[%s] Issues when listing the OSD Pods", cluster.name)
+	}
+	var endpoints []cephEndpoint
+	for _, pod := range pods.Items {
+		if pod.Status.Phase != corev1.PodRunning || pod.Status.PodIP == "" {
+			continue
+		}
+		name := pod.Labels["ceph-osd-id"]
+		if name == "" {
+			name = pod.Name
+		}
+		endpoints = append(endpoints, cephEndpoint{Kind: "osd", Name: name, Address: pod.Status.PodIP, Port: osdPort})
+	}
+	sort.Slice(endpoints, func(i, j int) bool { return endpoints[i].Name < endpoints[j].Name })
+	if len(endpoints) > osdSampleSize {
+		endpoints = endpoints[:osdSampleSize]
+	}
+	return endpoints, nil
+}
+
+// probePod returns the Pod the connections are tried from. The toolbox is in the same network as rbd-mirror,
+// before the install the network-check-target Pods of OpenShift are used.
+func probePod(ctx context.Context, cluster kubeAccess) (*corev1.Pod, error) {
+	if pod, err := getToolsPod(cluster.typedClient, cluster.storageNamespace()); err == nil {
+		return &pod, nil
+	}
+	requestCtx, cancel := requestContext(ctx)
+	defer cancel()
+	pods, err := cluster.typedClient.CoreV1().Pods(networkDiagnosticsNamespace).List(requestCtx, metav1.ListOptions{LabelSelector: "app=network-check-target"})
+	if err != nil {
+		return nil, errors.WithMessagef(err, "[%s] Issues when listing the network-check-target Pods", cluster.name)
+	}
+	for i := range pods.Items {
+		if pods.Items[i].Status.Phase == corev1.PodRunning {
+			return &pods.Items[i], nil
+		}
+	}
+	return nil, errors.Errorf("[%s] Neither the Ceph toolbox nor a network-check-target Pod is running to probe from", cluster.name)
+}
+
+// probeEndpoint tries to open a TCP connection to the endpoint from the Pod
+func probeEndpoint(cluster kubeAccess, pod *corev1.Pod, endpoint cephEndpoint) error {
+	command := []string{"timeout", strconv.Itoa(probeTimeoutSeconds), "bash", "-c", fmt.Sprintf("</dev/tcp/%s/%d", endpoint.Address, endpoint.Port)}
+	_, stderr, err := cluster.executor.execute(pod, command)
+	var exitErr utilexec.ExitError
+	switch {
+	case err == nil:
+		return nil
+	case errors.As(err, &exitErr) && exitErr.ExitStatus() == probeTimeoutExitCode:
+		return errors.Errorf("timed out after %ds", probeTimeoutSeconds)
+	case errors.As(err, &exitErr):
+		if stderr = strings.TrimSpace(stderr); stderr != "" {
+			return errors.Errorf("connection failed: %s", stderr)
+		}
+		return errors.New("connection failed")
+	}
+	return errors.Wrapf(err, "could not run the probe in %s/%s", pod.Namespace, pod.Name)
+}
+
+// probeCephNetwork probes the Ceph endpoints of the to cluster from a Pod of the from cluster
+func probeCephNetwork(ctx context.Context, from, to kubeAccess) ([]endpointProbe, error) {
+	endpoints, err := cephEndpoints(ctx, to)
+	if err != nil {
+		return nil, err
+	}
+	pod, err := probePod(ctx, from)
+	if err != nil {
+		return nil, err
+	}
+	submariner := usesSubmariner(ctx, from) || usesSubmariner(ctx, to)
+	var probes []endpointProbe
+	for _, endpoint := range endpoints {
+		if ctx.Err() != nil {
+			return probes, ctx.Err()
+		}
+		probe := endpointProbe{From: from.name, To: to.name, Endpoint: endpoint, Reachable: true}
+		err := probeEndpoint(from, pod, endpoint)
+		if err != nil && endpoint.Kind == "mon" {
+			// The peers connect to the messenger port their Ceph version prefers, either one is enough
+			other := endpoint
+			other.Port = otherMsgrPort(endpoint.Port)
+			if probeEndpoint(from, pod, other) == nil {
+				probe.Endpoint, err = other, nil
+			}
+		}
+		if err != nil {
+			probe.Reachable = false
+			probe.Error = err.Error()
+			probe.Hint = networkHint(probe, submariner, to.storageNamespace())
+		}
+		probes = append(probes, probe)
+	}
+	return probes, nil
+}
+
+// probeCephNetworkBothWays probes the Ceph endpoints in both directions, since rbd-mirror runs on both sides
+func probeCephNetworkBothWays(ctx context.Context, primary, secondary kubeAccess) ([]endpointProbe, error) {
+	probes, err := probeCephNetwork(ctx, primary, secondary)
+	if err != nil {
+		return probes, err
+	}
+	reverse, err := probeCephNetwork(ctx, secondary, primary)
+	return append(probes, reverse...), err
+}
+
+// unreachable returns the probes that failed
+func unreachable(probes []endpointProbe) []endpointProbe {
+	var failed []endpointProbe
+	for _, probe := range probes {
+		if !probe.Reachable {
+			failed = append(failed, probe)
+		}
+	}
+	return failed
+}
+
+func usesSubmariner(ctx context.Context, cluster kubeAccess) bool {
+	requestCtx, cancel := requestContext(ctx)
+	defer cancel()
+	_, err := cluster.typedClient.CoreV1().Namespaces().Get(requestCtx, submarinerNamespace, metav1.GetOptions{})
+	return err == nil
+}
+
+// networkHint explains what usually blocks the traffic to the endpoint
+func networkHint(probe endpointProbe, submariner bool, namespace string) string {
+	if !submariner {
+		return fmt.Sprintf("The Pod networks of %s and %s do not seem to be connected. Connect them, e.g. with Submariner, so that rbd-mirror can reach the Ceph daemons of the peer.", probe.From, probe.To)
+	}
+	timedOut := strings.HasPrefix(probe.Error, "timed out")
+	switch {
+	case probe.Endpoint.Kind == "mon" && timedOut:
+		return "The traffic to the Ceph monitor is dropped. Check that the Submariner gateways are connected with 'subctl show connections' " +
+			"and that the Pod and Service CIDRs of both clusters do not overlap, or that Globalnet is enabled."
+	case probe.Endpoint.Kind == "mon":
+		return fmt.Sprintf("The Ceph monitor did not accept the connection on either messenger port (%d, %d). "+
+			"Check that the monitor Services are exported with 'subctl export service --namespace %s rook-ceph-mon-%s'.", monMsgr2Port, monMsgr1Port, namespace, probe.Endpoint.Name)
+	case timedOut:
+		return "The traffic to the OSD is dropped while the monitors might be reachable. Check the firewall rules between the Submariner gateway nodes " +
+			fmt.Sprintf("and that the OSD port range %d-7300 is not blocked.", osdPort)
+	}
+	return "The OSD did not accept the connection. Check with 'subctl diagnose all' that the Pod network of the OSDs is routed through Submariner."
+}
+
+// summarizeProbes returns one line per probe, with the hints of the failed ones
+func summarizeProbes(probes []endpointProbe) string {
+	text := &strings.Builder{}
+	for _, probe := range probes {
+		fmt.Fprintln(text, probe)
+		if probe.Hint != "" {
+			fmt.Fprintf(text, "    -> %s\n", probe.Hint)
+		}
+	}
+	return text.String()
+}
+
+// verifyCephNetwork checks that the Ceph daemons of the to cluster are reachable from the from cluster
+func verifyCephNetwork(ctx context.Context, from, to kubeAccess) checkResult {
+	result := checkResult{
+		Check:   checkCephNetwork,
+		Cluster: from.name,
+		Status:  checkFail,
+	}
+	probes, err := probeCephNetwork(ctx, from, to)
+	if err != nil {
+		result.Status = checkWarn
+		result.Message = fmt.Sprintf("Could not probe the Ceph daemons of the %s cluster", to.name)
+		result.Details = err.Error()
+		return result
+	}
+	failed := unreachable(probes)
+	if len(failed) > 0 {
+		result.Details = summarizeProbes(probes)
+		result.Message = fmt.Sprintf("%d of %d Ceph endpoints of the %s cluster are not reachable", len(failed), len(probes), to.name)
+		result.Remediation = failed[0].Hint
+		return result
+	}
+	result.Status = checkPass
+	result.Message = fmt.Sprintf("All %d probed Ceph endpoints of the %s cluster are reachable", len(probes), to.name)
+	return result
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// fakeProbeExecutor answers the TCP probes of the preflight, endpoints are "address/port"
+type fakeProbeExecutor struct {
+	// blocked endpoints time out, refused endpoints fail right away, all others are reachable
+	blocked, refused map[string]bool
+	probed           []string
+}
+
+func (e *fakeProbeExecutor) execute(pod *corev1.Pod, command []string) (string, string, error) {
+	endpoint := strings.TrimPrefix(command[len(command)-1], "</dev/tcp/")
+	e.probed = append(e.probed, endpoint)
+	if e.blocked[endpoint] {
+		return "", "", exitWith(probeTimeoutExitCode)
+	}
+	if e.refused[endpoint] {
+		return "", "bash: connect: Connection refused", exitWith(1)
+	}
+	return "", "", nil
+}
+
+func newCephPod(name, ip string, labels map[string]string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: environmentODF.namespace, Name: name, Labels: labels},
+		Status:     corev1.PodStatus{Phase: corev1.PodRunning, PodIP: ip},
+	}
+}
+
+// newCephNetworkCluster returns a cluster with two mons, four OSDs and a toolbox to probe from
+func newCephNetworkCluster(t *testing.T, name, subnet string, executor *fakeProbeExecutor, objects ...runtime.Object) kubeAccess {
+	t.Helper()
+	objects = append(objects,
+		&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Namespace: environmentODF.namespace, Name: monEndpointsConfigMap},
+			Data:       map[string]string{"data": "a=" + subnet + ".1:6789,b=" + subnet + ".2:6789"},
+		},
+		newCephPod("rook-ceph-tools", subnet+".100", map[string]string{"app": "rook-ceph-tools"}),
+	)
+	for _, id := range []string{"3", "0", "2", "1"} {
+		objects = append(objects, newCephPod("rook-ceph-osd-"+id, subnet+".1"+id, map[string]string{"app": "rook-ceph-osd", "ceph-osd-id": id}))
+	}
+	cluster, _ := newFakeCluster(t, name, objects...)
+	cluster.executor = executor
+	return cluster
+}
+
+func TestCephEndpoints(t *testing.T) {
+	cluster := newCephNetworkCluster(t, "secondary", "10.1.0", &fakeProbeExecutor{})
+
+	endpoints, err := cephEndpoints(context.Background(), cluster)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var actual []string
+	for _, endpoint := range endpoints {
+		actual = append(actual, endpoint.String())
+	}
+	expected := []string{
+		"mon a (10.1.0.1:6789)", "mon b (10.1.0.2:6789)",
+		"osd 0 (10.1.0.10:6800)", "osd 1 (10.1.0.11:6800)", "osd 2 (10.1.0.12:6800)",
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected endpoints\n%v\ngot\n%v", expected, actual)
+	}
+}
+
+func TestProbeCephNetworkBothWays(t *testing.T) {
+	primaryExecutor := &fakeProbeExecutor{blocked: map[string]bool{"10.1.0.10/6800": true}}
+	// Mon a refuses both messenger ports, mon b only listens on the v2 port
+	secondaryExecutor := &fakeProbeExecutor{refused: map[string]bool{"10.0.0.1/6789": true, "10.0.0.1/3300": true, "10.0.0.2/6789": true}}
+	primary := newCephNetworkCluster(t, "primary", "10.0.0", primaryExecutor,
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: submarinerNamespace}})
+	secondary := newCephNetworkCluster(t, "secondary", "10.1.0", secondaryExecutor)
+
+	probes, err := probeCephNetworkBothWays(context.Background(), primary, secondary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(probes) != 10 || len(primaryExecutor.probed) != 5 || len(secondaryExecutor.probed) != 7 || !strings.HasPrefix(secondaryExecutor.probed[0], "10.0.0.") {
+		t.Errorf("expected 5 endpoints probed from each side and the other port of failed mons, got %v and %v", primaryExecutor.probed, secondaryExecutor.probed)
+	}
+	for _, probe := range probes {
+		if probe.From == "secondary" && probe.Endpoint.Name == "b" && (!probe.Reachable || probe.Endpoint.Port != monMsgr2Port) {
+			t.Errorf("expected mon b to be reachable on the v2 port, got %+v", probe)
+		}
+	}
+	failed := unreachable(probes)
+	if len(failed) != 2 {
+		t.Fatalf("expected 2 unreachable endpoints, got %+v", failed)
+	}
+	if failed[0].From != "primary" || failed[0].Error != "timed out after 5s" || !strings.Contains(failed[0].Hint, "OSD is dropped") {
+		t.Errorf("unexpected OSD result %+v", failed[0])
+	}
+	if failed[1].From != "secondary" || !strings.Contains(failed[1].Error, "Connection refused") || !strings.Contains(failed[1].Hint, "subctl export service --namespace openshift-storage rook-ceph-mon-a") {
+		t.Errorf("unexpected mon result %+v", failed[1])
+	}
+
+	if result := verifyCephNetwork(context.Background(), primary, secondary); result.Status != checkFail || !strings.Contains(result.Details, "osd 0 (10.1.0.10:6800): timed out") {
+		t.Errorf("unexpected check result %+v", result)
+	}
+}
+
+func TestVerifyCephNetworkWithoutProbePod(t *testing.T) {
+	primary, _ := newFakeCluster(t, "primary")
+	secondary := newCephNetworkCluster(t, "secondary", "10.1.0", &fakeProbeExecutor{})
+
+	result := verifyCephNetwork(context.Background(), primary, secondary)
+	if result.Status != checkWarn || !strings.Contains(result.Details, "network-check-target") {
+		t.Errorf("expected a warning without a Pod to probe from, got %+v", result)
+	}
+	if result := verifyCephNetwork(context.Background(), secondary, secondary); result.Status != checkPass {
+		t.Errorf("expected reachable endpoints to pass, got %+v", result)
+	}
+}
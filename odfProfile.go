@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/operator-framework/api/pkg/lib/version"
+	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// How the install enables mirroring
+const (
+	// setupCephBlockPool enables mirroring in the CephBlockPool spec and deploys rbd-mirror with a CephRBDMirror,
+	// after the StorageCluster stopped reconciling the pools
+	setupCephBlockPool = "CephBlockPool"
+	// setupStorageCluster enables mirroring in the StorageCluster spec, ODF then mirrors the default pool
+	// and deploys rbd-mirror and the OMAP generator
+	setupStorageCluster = "StorageCluster"
+	// setupExternal leaves the pools, rbd-mirror and their peers to the admins of the external Ceph cluster
+	setupExternal = "external Ceph cluster"
+)
+
+// How the images of the PVs are enabled, promoted and demoted
+const (
+	// replicationToolbox runs rbd commands in the rook-ceph-tools Pod
+	replicationToolbox = "toolbox"
+	// replicationVolumeReplication creates and patches the VolumeReplication CRs of csi-addons
+	replicationVolumeReplication = "VolumeReplication"
+)
+
+const storageClusterName = "ocs-storagecluster"
+
+var storageClusterResource = schema.GroupVersionResource{
+	Group:    "ocs.openshift.io",
+	Version:  "v1",
+	Resource: "storageclusters",
+}
+
+// odfProfile describes how RDR is set up and controlled in a range of OCS/ODF 4.x releases
+type odfProfile struct {
+	name string
+	// minMinor and maxMinor are the range of 4.x releases of the profile, maxMinor 0 means no upper bound
+	minMinor, maxMinor uint64
+	// unsupported explains why RDRhelper cannot work with these releases, empty for supported releases
+	unsupported string
+	setup       string
+	replication string
+	// cephfsMirroring is true if the Rook release of the profile knows the CephFilesystemMirror, which
+	// decides whether the install mirrors CephFS by default
+	cephfsMirroring bool
+}
+
+// odfProfiles are ordered by release
+var odfProfiles = []odfProfile{
+	{
+		name:        "OCS 4.6 and older",
+		maxMinor:    6,
+		unsupported: "RBD snapshot based mirroring is only available from OCS 4.7 on. Please upgrade the cluster to OCS 4.7 or later.",
+	},
+	{
+		name:        "OCS 4.7 and 4.8",
+		minMinor:    7,
+		maxMinor:    8,
+		setup:       setupCephBlockPool,
+		replication: replicationToolbox,
+	},
+	{
+		name:            "ODF 4.9 and newer",
+		minMinor:        9,
+		setup:           setupStorageCluster,
+		replication:     replicationVolumeReplication,
+		cephfsMirroring: true,
+	},
+}
+
+// rookProfile is used with upstream Rook, which has no OCS CSV and no StorageCluster
+var rookProfile = odfProfile{
+	name:            "Rook",
+	setup:           setupCephBlockPool,
+	replication:     replicationToolbox,
+	cephfsMirroring: true,
+}
+
+func (p odfProfile) supported() bool {
+	return p.unsupported == ""
+}
+
+// describe explains the strategies of the profile to users
+func (p odfProfile) describe() string {
+	if !p.supported() {
+		return fmt.Sprintf("%s: not supported", p.name)
+	}
+	return fmt.Sprintf("%s: mirroring is set up in the %s, PVs are controlled with %s", p.name, p.setup, p.replication)
+}
+
+// profileForVersion returns the profile of the OCS/ODF release
+func profileForVersion(v version.OperatorVersion) odfProfile {
+	if v.Major != 4 {
+		return odfProfile{
+			name:        fmt.Sprintf("OCS/ODF %d.%d", v.Major, v.Minor),
+			unsupported: fmt.Sprintf("RDRhelper only knows the OCS/ODF 4.x releases, but version %d.%d is installed.", v.Major, v.Minor),
+		}
+	}
+	for _, profile := range odfProfiles {
+		if v.Minor >= profile.minMinor && (profile.maxMinor == 0 || v.Minor <= profile.maxMinor) {
+			return profile
+		}
+	}
+	// Not reached, the profiles cover all 4.x releases
+	return odfProfiles[len(odfProfiles)-1]
+}
+
+// detectODFProfile returns the profile of the OCS/ODF release that is installed in the cluster, or the
+// profile of upstream Rook. The error of unsupported releases explains why they are not supported.
+func detectODFProfile(cluster kubeAccess) (odfProfile, error) {
+	env := cluster.environment()
+	if !env.storageCluster {
+		return rookProfile, nil
+	}
+	ocsVersion, err := checkForOCSCSV(cluster)
+	if err != nil {
+		return odfProfile{}, errors.WithMessagef(err, "[%s] OCS not properly installed", cluster.name)
+	}
+	profile := profileForVersion(ocsVersion)
+	if !profile.supported() {
+		return profile, errors.Errorf("[%s] OCS/ODF %d.%d is not supported. %s", cluster.name, ocsVersion.Major, ocsVersion.Minor, profile.unsupported)
+	}
+	if env.external {
+		profile.name += " in external mode"
+		profile.setup = setupExternal
+	}
+	return profile, nil
+}
+
+// installProfile returns the profile that the install uses for both clusters.
+// Both clusters need to set up mirroring the same way, otherwise they cannot become peers.
+func installProfile(primary, secondary kubeAccess) (odfProfile, error) {
+	primaryProfile, err := detectODFProfile(primary)
+	if err != nil {
+		return primaryProfile, err
+	}
+	secondaryProfile, err := detectODFProfile(secondary)
+	if err != nil {
+		return secondaryProfile, err
+	}
+	if primaryProfile.setup != secondaryProfile.setup {
+		return primaryProfile, errors.Errorf("The %s cluster runs %s and the %s cluster %s, which set up mirroring differently. "+
+			"Please upgrade both clusters to releases of the same profile.", primary.name, primaryProfile.name, secondary.name, secondaryProfile.name)
+	}
+	return primaryProfile, nil
+}
+
+// patchStorageCluster applies a merge patch to the StorageCluster. The dynamic client is used, since the
+// StorageCluster API that RDRhelper is built with does not know the mirroring fields of newer releases.
+func patchStorageCluster(ctx context.Context, cluster kubeAccess, patch map[string]interface{}) error {
+	data, err := json.Marshal(patch)
+	if err != nil {
+		return errors.WithMessage(err, "Issues when converting the StorageCluster patch to JSON")
+	}
+	if dryRun.intercept(cluster, "patch", "StorageCluster/"+storageClusterName, data) {
+		return nil
+	}
+	requestCtx, cancel := requestContext(ctx)
+	defer cancel()
+	_, err = cluster.dynamicClient.Resource(storageClusterResource).Namespace(cluster.storageNamespace()).
+		Patch(requestCtx, storageClusterName, types.MergePatchType, data, metav1.PatchOptions{})
+	if err != nil {
+		return errors.WithMessagef(err, "[%s] Issues when patching the StorageCluster", cluster.name)
+	}
+	return nil
+}
+
+// enableStorageClusterMirroring lets ODF mirror the default Block Pool and deploy rbd-mirror and the OMAP generator
+func enableStorageClusterMirroring(ctx context.Context, cluster kubeAccess) error {
+	progress := installProgress.forCluster(cluster.name)
+	err := patchStorageCluster(ctx, cluster, map[string]interface{}{
+		"spec": map[string]interface{}{"mirroring": map[string]interface{}{"enabled": true}},
+	})
+	if err != nil || dryRun.enabled {
+		return err
+	}
+	progress.info("Enabled mirroring in the StorageCluster, waiting for the OMAP generator container to appear")
+	return waitFor(ctx, timeouts().Install, fmt.Sprintf("the OMAP generator container in the %s cluster", cluster.name), func(ctx context.Context) (bool, error) {
+		return checkForOMAPGenerator(ctx, cluster), nil
+	})
+}
+
+// checkStorageClusterMirroring returns true if mirroring is enabled in the StorageCluster spec
+func checkStorageClusterMirroring(ctx context.Context, cluster kubeAccess) (bool, error) {
+	storageCluster, err := cluster.dynamicClient.Resource(storageClusterResource).Namespace(cluster.storageNamespace()).
+		Get(ctx, storageClusterName, metav1.GetOptions{})
+	if err != nil {
+		return false, errors.WithMessagef(err, "[%s] Issues when fetching the StorageCluster", cluster.name)
+	}
+	enabled, _, err := unstructured.NestedBool(storageCluster.Object, "spec", "mirroring", "enabled")
+	return enabled, err
+}
+
+// setStorageClusterPeers configures the bootstrap secrets of the peers of the default Block Pool
+func setStorageClusterPeers(ctx context.Context, cluster kubeAccess, secretNames []string) error {
+	return patchStorageCluster(ctx, cluster, map[string]interface{}{
+		"spec": map[string]interface{}{"mirroring": map[string]interface{}{"peerSecretNames": secretNames}},
+	})
+}
+
+// disableStorageClusterMirroring removes the mirroring settings from the StorageCluster spec
+func disableStorageClusterMirroring(ctx context.Context, cluster kubeAccess) error {
+	return patchStorageCluster(ctx, cluster, map[string]interface{}{
+		"spec": map[string]interface{}{"mirroring": nil},
+	})
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/operator-framework/api/pkg/lib/version"
+)
+
+func TestProfileForVersion(t *testing.T) {
+	tests := []struct {
+		version     string
+		name        string
+		supported   bool
+		setup       string
+		replication string
+	}{
+		{"4.6.4", "OCS 4.6 and older", false, "", ""},
+		{"4.7.0", "OCS 4.7 and 4.8", true, setupCephBlockPool, replicationToolbox},
+		{"4.8.3", "OCS 4.7 and 4.8", true, setupCephBlockPool, replicationToolbox},
+		{"4.9.0", "ODF 4.9 and newer", true, setupStorageCluster, replicationVolumeReplication},
+		{"4.12.1", "ODF 4.9 and newer", true, setupStorageCluster, replicationVolumeReplication},
+		{"5.0.0", "OCS/ODF 5.0", false, "", ""},
+	}
+	for _, test := range tests {
+		var v version.OperatorVersion
+		if err := json.Unmarshal([]byte(`"`+test.version+`"`), &v); err != nil {
+			t.Fatal(err)
+		}
+		profile := profileForVersion(v)
+		if profile.name != test.name || profile.supported() != test.supported || profile.setup != test.setup || profile.replication != test.replication {
+			t.Errorf("%s: unexpected profile %+v", test.version, profile)
+		}
+	}
+}
+
+func TestDetectODFProfile(t *testing.T) {
+	old, _ := newFakeCluster(t, "old", newOCSCSV(t, "4.6.4"))
+	if _, err := detectODFProfile(old); err == nil || !strings.Contains(err.Error(), "only available from OCS 4.7 on") {
+		t.Errorf("expected an explanation for OCS 4.6, got %v", err)
+	}
+	missing, _ := newFakeCluster(t, "missing")
+	if _, err := detectODFProfile(missing); err == nil {
+		t.Error("expected an error without the OCS CSV")
+	}
+	if result := verifyODFRelease(old); result.Status != checkFail || result.Remediation == "" {
+		t.Errorf("expected the release check to fail with a remediation, got %+v", result)
+	}
+}
+
+func TestInstallProfile(t *testing.T) {
+	primary, _ := newFakeCluster(t, "primary", newOCSCSV(t, "4.8.0"))
+	secondary, _ := newFakeCluster(t, "secondary", newOCSCSV(t, "4.7.2"))
+	profile, err := installProfile(primary, secondary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if profile.setup != setupCephBlockPool {
+		t.Errorf("expected the CephBlockPool setup, got %+v", profile)
+	}
+
+	newer, _ := newFakeCluster(t, "newer", newOCSCSV(t, "4.9.0"))
+	if _, err := installProfile(primary, newer); err == nil || !strings.Contains(err.Error(), "set up mirroring differently") {
+		t.Errorf("expected mismatching releases to fail, got %v", err)
+	}
+}
+
+func stepNames(steps []installStep) []string {
+	var names []string
+	for _, step := range steps {
+		names = append(names, step.name)
+	}
+	return names
+}
+
+func TestInstallStepsPerSetup(t *testing.T) {
+	cephBlockPool := stepNames(installSteps(odfProfiles[1]))
+	if expected := []string{"omap-generator", "pool-mirroring", "bootstrap-secrets"}; !reflect.DeepEqual(cephBlockPool[:3], expected) {
+		t.Errorf("expected the CephBlockPool setup to start with %v, got %v", expected, cephBlockPool)
+	}
+	storageCluster := stepNames(installSteps(odfProfiles[2]))
+	if expected := []string{"storage-cluster-mirroring", "bootstrap-secrets"}; !reflect.DeepEqual(storageCluster[:2], expected) {
+		t.Errorf("expected the StorageCluster setup to start with %v, got %v", expected, storageCluster)
+	}
+}
+
+func TestCephFSMirroringDefault(t *testing.T) {
+	defer func() { installCephFS = nil }()
+	if steps := stepNames(installSteps(odfProfiles[1])); stringInSliceBool("cephfs-mirroring", steps) {
+		t.Errorf("expected OCS 4.7 and 4.8 not to mirror CephFS by default, got %v", steps)
+	}
+	if steps := stepNames(installSteps(odfProfiles[2])); !stringInSliceBool("cephfs-mirroring", steps) {
+		t.Errorf("expected ODF 4.9 to mirror CephFS by default, got %v", steps)
+	}
+	install := false
+	installCephFS = &install
+	if steps := stepNames(installSteps(odfProfiles[2])); stringInSliceBool("cephfs-mirroring", steps) {
+		t.Errorf("expected the CephFS steps to be skipped when asked to, got %v", steps)
+	}
+}
+
+func TestStorageClusterMirroring(t *testing.T) {
+	cluster, _ := newFakeCluster(t, "primary", newStorageCluster())
+	ctx := context.Background()
+
+	if err := setStorageClusterPeers(ctx, cluster, []string{"secondary-bootstrap"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := patchStorageCluster(ctx, cluster, map[string]interface{}{
+		"spec": map[string]interface{}{"mirroring": map[string]interface{}{"enabled": true}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	enabled, err := checkStorageClusterMirroring(ctx, cluster)
+	if err != nil || !enabled {
+		t.Errorf("expected mirroring to be enabled, got %v, %v", enabled, err)
+	}
+
+	if err := disableStorageClusterMirroring(ctx, cluster); err != nil {
+		t.Fatal(err)
+	}
+	if enabled, err := checkStorageClusterMirroring(ctx, cluster); err != nil || enabled {
+		t.Errorf("expected mirroring to be disabled, got %v, %v", enabled, err)
+	}
+}
@@ -0,0 +1,483 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	cephv1 "github.com/rook/rook/pkg/apis/ceph.rook.io/v1"
+	velerov1 "github.com/vmware-tanzu/velero/pkg/apis/velero/v1"
+	"gopkg.in/yaml.v2"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// protectionPlan describes which PVCs are protected by RDR
+// PVCs that are not selected by the plan get their mirroring disabled on apply
+type protectionPlan struct {
+	// Namespaces in which all RBD and CephFS backed PVCs are protected
+	Namespaces []string `yaml:"namespaces"`
+	// PVCs selects single PVCs, either by name or by label selector
+	PVCs []pvcSelector `yaml:"pvcs"`
+	// SnapshotInterval is set as snapshot schedule on the block pools of the protected PVCs, e.g. 5m or 1h
+	SnapshotInterval string     `yaml:"snapshotInterval"`
+	Backup           planBackup `yaml:"backup"`
+}
+
+// snapshotIntervalRegexp matches the intervals rbd accepts for snapshot schedules
+var snapshotIntervalRegexp = regexp.MustCompile(`^[1-9][0-9]*[mhd]$`)
+
+type pvcSelector struct {
+	Namespace string `yaml:"namespace"`
+	// Name of the PVC, leave empty to use the Selector
+	Name string `yaml:"name,omitempty"`
+	// Selector is a label selector like "app=db,tier!=cache"
+	Selector string `yaml:"selector,omitempty"`
+}
+
+type planBackup struct {
+	// Enabled defaults to true, the namespaces of the protected PVCs are then backed up with OADP
+	Enabled  *bool  `yaml:"enabled,omitempty"`
+	Schedule string `yaml:"schedule,omitempty"`
+	TTL      string `yaml:"ttl,omitempty"`
+}
+
+func readProtectionPlan(path string) (protectionPlan, error) {
+	var plan protectionPlan
+	f, err := os.Open(path)
+	if err != nil {
+		return plan, errors.Wrapf(err, "could not open plan %s", path)
+	}
+	defer f.Close()
+	return parseProtectionPlan(f)
+}
+
+func parseProtectionPlan(r io.Reader) (protectionPlan, error) {
+	var plan protectionPlan
+	decoder := yaml.NewDecoder(r)
+	decoder.SetStrict(true)
+	if err := decoder.Decode(&plan); err != nil && err != io.EOF {
+		return plan, errors.Wrap(err, "could not parse plan")
+	}
+	return plan, plan.validate()
+}
+
+func (p protectionPlan) validate() error {
+	for _, selector := range p.PVCs {
+		if selector.Namespace == "" {
+			return errors.New("every PVC selector needs a namespace")
+		}
+		if (selector.Name == "") == (selector.Selector == "") {
+			return errors.Errorf("PVC selector in namespace %s needs either a name or a selector", selector.Namespace)
+		}
+		if _, err := labels.Parse(selector.Selector); err != nil {
+			return errors.Wrapf(err, "invalid selector %q in namespace %s", selector.Selector, selector.Namespace)
+		}
+	}
+	if p.SnapshotInterval != "" && !snapshotIntervalRegexp.MatchString(p.SnapshotInterval) {
+		return errors.Errorf("invalid snapshotInterval %q, use a number followed by m, h or d", p.SnapshotInterval)
+	}
+	if _, err := p.backupSettings(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (p protectionPlan) backupEnabled() bool {
+	return p.Backup.Enabled == nil || *p.Backup.Enabled
+}
+
+func (p protectionPlan) backupSettings() (backupSettings, error) {
+	settings := defaultBackupSettings
+	if p.Backup.Schedule != "" {
+		settings.Schedule = p.Backup.Schedule
+	}
+	if p.Backup.TTL != "" {
+		ttl, err := time.ParseDuration(p.Backup.TTL)
+		if err != nil {
+			return settings, errors.Wrapf(err, "invalid backup ttl %q", p.Backup.TTL)
+		}
+		settings.TTL = ttl
+	}
+	return settings, nil
+}
+
+// planChange is a single change that apply would do
+type planChange struct {
+	Action      string `json:"action"`
+	Target      string `json:"target"`
+	Description string `json:"description"`
+}
+
+func (c planChange) String() string {
+	return fmt.Sprintf("%-20s %-50s %s", c.Action, c.Target, c.Description)
+}
+
+// Actions of planChanges
+const (
+	planEnableMirroring  = "enable-mirroring"
+	planDisableMirroring = "disable-mirroring"
+	planSnapshotSchedule = "snapshot-schedule"
+	planBackupSchedule   = "backup-schedule"
+	planSyncPV           = "sync-pv"
+)
+
+// planSkipped is a PV the diff could not decide about, it is left as it is
+type planSkipped struct {
+	Target string `json:"target"`
+	Reason string `json:"reason"`
+}
+
+func (s planSkipped) String() string {
+	return fmt.Sprintf("%-20s %-50s %s", "skipped", s.Target, s.Reason)
+}
+
+// planDiff holds the differences between the plan and the cluster state
+type planDiff struct {
+	Changes []planChange  `json:"changes"`
+	Skipped []planSkipped `json:"skipped,omitempty"`
+
+	enablePVs        []corev1.PersistentVolume
+	disablePVs       []corev1.PersistentVolume
+	pools            []string
+	backupNamespaces []string
+	updateBackup     bool
+}
+
+func (d *planDiff) add(action, target, format string, a ...interface{}) {
+	d.Changes = append(d.Changes, planChange{Action: action, Target: target, Description: fmt.Sprintf(format, a...)})
+}
+
+// skip reports a PV that is left out of the diff
+func (d *planDiff) skip(target, format string, a ...interface{}) {
+	d.Skipped = append(d.Skipped, planSkipped{Target: target, Reason: fmt.Sprintf(format, a...)})
+}
+
+func (d *planDiff) print(target io.Writer) {
+	if len(d.Changes) == 0 {
+		fmt.Fprintln(target, "No changes, the clusters match the plan")
+	}
+	for _, change := range d.Changes {
+		fmt.Fprintln(target, change)
+	}
+	for _, skipped := range d.Skipped {
+		fmt.Fprintln(target, skipped)
+	}
+}
+
+// pvcMatcher decides if a PVC is selected by the plan
+type pvcMatcher struct {
+	cluster kubeAccess
+	plan    protectionPlan
+	// pvcLabels caches the labels of PVCs per namespace
+	pvcLabels map[string]map[string]labels.Set
+}
+
+func (m *pvcMatcher) matches(claim *corev1.ObjectReference) (bool, error) {
+	if stringInSliceBool(claim.Namespace, m.plan.Namespaces) {
+		return true, nil
+	}
+	for _, selector := range m.plan.PVCs {
+		if selector.Namespace != claim.Namespace {
+			continue
+		}
+		if selector.Name != "" {
+			if selector.Name == claim.Name {
+				return true, nil
+			}
+			continue
+		}
+		pvcLabels, err := m.labelsOf(claim.Namespace, claim.Name)
+		if err != nil {
+			return false, err
+		}
+		parsedSelector, _ := labels.Parse(selector.Selector)
+		if parsedSelector.Matches(pvcLabels) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m *pvcMatcher) labelsOf(namespace, name string) (labels.Set, error) {
+	if _, present := m.pvcLabels[namespace]; !present {
+		pvcs, err := m.cluster.typedClient.CoreV1().PersistentVolumeClaims(namespace).List(context.TODO(), metav1.ListOptions{})
+		if err != nil {
+			return nil, errors.WithMessagef(err, "[%s] Issues when listing PVCs in namespace %s", m.cluster.name, namespace)
+		}
+		m.pvcLabels[namespace] = make(map[string]labels.Set)
+		for _, pvc := range pvcs.Items {
+			m.pvcLabels[namespace][pvc.Name] = labels.Set(pvc.Labels)
+		}
+	}
+	return m.pvcLabels[namespace][name], nil
+}
+
+// diffProtectionPlan compares the plan with the state of the from cluster and the PVs in the to cluster
+func diffProtectionPlan(plan protectionPlan, from, to kubeAccess) (*planDiff, error) {
+	diff := &planDiff{}
+	matcher := &pvcMatcher{cluster: from, plan: plan, pvcLabels: make(map[string]map[string]labels.Set)}
+
+	pvs, err := from.typedClient.CoreV1().PersistentVolumes().List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, errors.WithMessagef(err, "[%s] Issues when listing PVs", from.name)
+	}
+	// PVs without a mirror status are skipped below, so one failing pool does not block the whole plan
+	statuses, err := getMirrorStatuses(from, pvs.Items)
+	if err != nil {
+		log.WithError(err).Warnf("[%s] Issues when fetching the mirror status of the PVs", from.name)
+	}
+	var protectedPVs []corev1.PersistentVolume
+	namespaceMap := make(map[string]struct{})
+	poolMap := make(map[string]struct{})
+	for _, pv := range pvs.Items {
+		if !isCephPV(&pv) || pv.Spec.ClaimRef == nil {
+			continue
+		}
+		wanted, err := matcher.matches(pv.Spec.ClaimRef)
+		if err != nil {
+			return nil, err
+		}
+		pvcName := fmt.Sprintf("%s/%s", pv.Spec.ClaimRef.Namespace, pv.Spec.ClaimRef.Name)
+		status, known := statuses[pv.Name]
+		if !known {
+			diff.skip(pvcName, "could not fetch the mirror status of PV %s in the %s cluster", pv.Name, from.name)
+			continue
+		}
+		mirrored := status.State != rbdMirrorStateDisabled
+		if wanted {
+			protectedPVs = append(protectedPVs, pv)
+			namespaceMap[pv.Spec.ClaimRef.Namespace] = struct{}{}
+			// CephFS directories have their own snapshot schedule
+			if isRBDPV(&pv) {
+				poolMap[pv.Spec.CSI.VolumeAttributes["pool"]] = struct{}{}
+			}
+			if !mirrored {
+				diff.enablePVs = append(diff.enablePVs, pv)
+				diff.add(planEnableMirroring, pvcName, "enable mirroring of PV %s in the %s cluster", pv.Name, from.name)
+			}
+		} else if mirrored {
+			primary, err := isPrimaryIn(from, &pv, status)
+			if err != nil {
+				diff.skip(pvcName, "could not find out if PV %s is primary in the %s cluster: %s", pv.Name, from.name, err)
+				continue
+			}
+			if !primary {
+				// Replica of the peer, its mirroring is up to the peer
+				continue
+			}
+			diff.disablePVs = append(diff.disablePVs, pv)
+			diff.add(planDisableMirroring, pvcName, "disable mirroring of PV %s in the %s cluster", pv.Name, from.name)
+		}
+	}
+
+	for namespace := range namespaceMap {
+		diff.backupNamespaces = append(diff.backupNamespaces, namespace)
+	}
+	sort.Strings(diff.backupNamespaces)
+
+	if plan.SnapshotInterval != "" {
+		for pool := range poolMap {
+			diff.pools = append(diff.pools, pool)
+		}
+		sort.Strings(diff.pools)
+		if err = diffSnapshotSchedules(diff, from, plan.SnapshotInterval); err != nil {
+			return nil, err
+		}
+	}
+
+	if plan.backupEnabled() {
+		if err = diffBackupSchedule(diff, plan, from); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, pv := range protectedPVs {
+		_, err := to.typedClient.CoreV1().PersistentVolumes().Get(context.TODO(), pv.Name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			diff.add(planSyncPV, pv.Name, "create PV for %s/%s in the %s cluster", pv.Spec.ClaimRef.Namespace, pv.Spec.ClaimRef.Name, to.name)
+		} else if err != nil {
+			return nil, errors.WithMessagef(err, "[%s] Issues when fetching PV %s", to.name, pv.Name)
+		}
+	}
+	for _, pv := range diff.disablePVs {
+		diff.add(planSyncPV, pv.Name, "remove PV for %s/%s from the %s cluster", pv.Spec.ClaimRef.Namespace, pv.Spec.ClaimRef.Name, to.name)
+	}
+
+	return diff, nil
+}
+
+// isPrimaryIn returns true if the image or directory of the PV is mirrored from the cluster to its peers
+func isPrimaryIn(cluster kubeAccess, pv *corev1.PersistentVolume, status *rbdMirrorImageStatus) (bool, error) {
+	if isCephFSPV(pv) {
+		return status.State != cephfsMirrorStateReplica, nil
+	}
+	rbdName, poolName, err := getRBDInfoFromPV(pv)
+	if err != nil {
+		return false, err
+	}
+	info, err := newRBD(cluster).Info(poolName, rbdName)
+	if err != nil {
+		return false, err
+	}
+	return info.Mirroring != nil && info.Mirroring.Primary, nil
+}
+
+// diffSnapshotSchedules only keeps the pools in the diff whose snapshot schedule differs from the interval
+func diffSnapshotSchedules(diff *planDiff, cluster kubeAccess, interval string) error {
+	if err := cephv1.AddToScheme(cluster.controllerClient.Scheme()); err != nil {
+		return errors.WithMessagef(err, "[%s] Issues when adding the cephv1 scheme", cluster.name)
+	}
+	var changedPools []string
+	for _, pool := range diff.pools {
+		var blockPool cephv1.CephBlockPool
+		err := cluster.controllerClient.Get(context.TODO(), types.NamespacedName{Name: pool, Namespace: cluster.storageNamespace()}, &blockPool)
+		if err != nil {
+			return errors.WithMessagef(err, "[%s] Issues when fetching CephBlockPool %s", cluster.name, pool)
+		}
+		var current []string
+		for _, schedule := range blockPool.Spec.Mirroring.SnapshotSchedules {
+			current = append(current, schedule.Interval)
+		}
+		if len(current) == 1 && current[0] == interval {
+			continue
+		}
+		changedPools = append(changedPools, pool)
+		diff.add(planSnapshotSchedule, pool, "change snapshot schedule from [%s] to [%s]", strings.Join(current, ", "), interval)
+	}
+	diff.pools = changedPools
+	return nil
+}
+
+func diffBackupSchedule(diff *planDiff, plan protectionPlan, cluster kubeAccess) error {
+	if !checkForOADP(cluster) {
+		log.Warnf("[%s] OADP is not installed, skipping the backup settings of the plan", cluster.name)
+		return nil
+	}
+	settings, err := plan.backupSettings()
+	if err != nil {
+		return err
+	}
+	if err := velerov1.AddToScheme(cluster.controllerClient.Scheme()); err != nil {
+		return errors.WithMessagef(err, "[%s] Issues when adding velero schemas", cluster.name)
+	}
+	var schedule velerov1.Schedule
+	err = cluster.controllerClient.Get(context.TODO(), types.NamespacedName{Name: "regional-dr-backup", Namespace: "oadp-operator"}, &schedule)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return errors.WithMessagef(err, "[%s] Issues when fetching the backup Schedule", cluster.name)
+	}
+	currentNamespaces := append([]string{}, schedule.Spec.Template.IncludedNamespaces...)
+	sort.Strings(currentNamespaces)
+	if strings.Join(currentNamespaces, ",") != strings.Join(diff.backupNamespaces, ",") {
+		diff.add(planBackupSchedule, "regional-dr-backup", "change backed up namespaces from [%s] to [%s]",
+			strings.Join(currentNamespaces, ", "), strings.Join(diff.backupNamespaces, ", "))
+		diff.updateBackup = true
+	}
+	if schedule.Spec.Schedule != settings.Schedule {
+		diff.add(planBackupSchedule, "regional-dr-backup", "change schedule from %q to %q", schedule.Spec.Schedule, settings.Schedule)
+		diff.updateBackup = true
+	}
+	if schedule.Spec.Template.TTL.Duration != settings.TTL {
+		diff.add(planBackupSchedule, "regional-dr-backup", "change TTL from %s to %s", schedule.Spec.Template.TTL.Duration, settings.TTL)
+		diff.updateBackup = true
+	}
+	return nil
+}
+
+// applyProtectionPlan reconciles the from cluster with the plan and syncs the PVs to the to cluster.
+// The PVs are synced even without changes, so that the peers keep up with new PVs and added peers.
+func applyProtectionPlan(plan protectionPlan, from, to kubeAccess) error {
+	progress := protectionProgress.forCluster(from.name)
+	diff, err := diffProtectionPlan(plan, from, to)
+	if err != nil {
+		return err
+	}
+	for _, skipped := range diff.Skipped {
+		progress.warn("Skipped %s: %s", skipped.Target, skipped.Reason)
+	}
+	failed := false
+	if len(diff.Changes) == 0 {
+		progress.info("No changes, the clusters match the plan")
+	} else {
+		failed = !applyPlanChanges(plan, diff, from)
+	}
+
+	for _, target := range peerTargets(from, to) {
+		if err := syncPVs(from, target); err != nil {
+			return errors.WithMessagef(err, "Issues when syncing PVs to the %s cluster", target.name)
+		}
+		progress.info("PVs synced to the %s cluster", target.name)
+	}
+
+	if failed {
+		return errors.New("not all changes of the plan could be applied, please check the log")
+	}
+	return nil
+}
+
+// applyPlanChanges changes the mirroring of the PVs, the pool schedules and the backup of the diff,
+// it returns false if any change failed
+func applyPlanChanges(plan protectionPlan, diff *planDiff, from kubeAccess) bool {
+	progress := protectionProgress.forCluster(from.name)
+	failed := false
+	backend := replicationFor(context.TODO(), from)
+	for _, pv := range diff.enablePVs {
+		err := setMirrorStatus(context.TODO(), backend, &pv, true, "")
+		progress.result(pv.Name, err, "mirroring enabled for PV %s", pv.Name)
+		failed = failed || err != nil
+	}
+	for _, pv := range diff.disablePVs {
+		err := setMirrorStatus(context.TODO(), backend, &pv, false, "")
+		progress.result(pv.Name, err, "mirroring disabled for PV %s", pv.Name)
+		failed = failed || err != nil
+	}
+
+	for _, pool := range diff.pools {
+		err := setPoolSnapshotInterval(from, pool, plan.SnapshotInterval)
+		progress.result(pool, err, "snapshot schedule of pool %s set to %s", pool, plan.SnapshotInterval)
+		failed = failed || err != nil
+	}
+
+	if diff.updateBackup {
+		settings, _ := plan.backupSettings()
+		err := setNamespacesToBackup(from, diff.backupNamespaces, settings)
+		progress.result("regional-dr-backup", err, "backup Schedule updated")
+		failed = failed || err != nil
+	}
+	return !failed
+}
+
+func setPoolSnapshotInterval(cluster kubeAccess, pool, interval string) error {
+	patch := map[string]interface{}{
+		"spec": map[string]interface{}{
+			"mirroring": map[string]interface{}{
+				"snapshotSchedules": []cephv1.SnapshotScheduleSpec{{Interval: interval}},
+			},
+		},
+	}
+	patchJSON, err := json.Marshal(patch)
+	if err != nil {
+		return errors.WithMessage(err, "Issues when converting CephBlockPool Patch to JSON")
+	}
+	if dryRun.intercept(cluster, "patch", "CephBlockPool/"+pool, patchJSON) {
+		return nil
+	}
+	err = cluster.controllerClient.Patch(context.TODO(),
+		&cephv1.CephBlockPool{ObjectMeta: metav1.ObjectMeta{Name: pool, Namespace: cluster.storageNamespace()}},
+		client.RawPatch(types.MergePatchType, patchJSON))
+	if err != nil {
+		return errors.WithMessagef(err, "[%s] Issues when patching CephBlockPool %s", cluster.name, pool)
+	}
+	return nil
+}
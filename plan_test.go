@@ -0,0 +1,93 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestApplyProtectionPlanSyncsWithoutChanges(t *testing.T) {
+	clusters := useSites(t, nil, "east", "west1", "west2")
+	east, eastToolbox := newFakeCluster(t, "east", newRBDPV("pv-data", "shop", "data", "img-data", corev1.VolumeBound))
+	eastToolbox.mirrored["replicapool/img-data"] = "up+replaying"
+	west1, _ := newFakeCluster(t, "west1", newRBDPV("pv-data", "shop", "data", "img-data", corev1.VolumeReleased))
+	clusters[0], clusters[1] = east, west1
+	disabled := false
+	plan := protectionPlan{Namespaces: []string{"shop"}, Backup: planBackup{Enabled: &disabled}}
+
+	diff, err := diffProtectionPlan(plan, east, west1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diff.Changes) != 0 {
+		t.Fatalf("expected the clusters to match the plan, got %v", diff.Changes)
+	}
+	// west2 was added as peer later and misses the PV
+	if err := applyProtectionPlan(plan, east, west1); err != nil {
+		t.Fatal(err)
+	}
+	if names := listPVNames(t, clusters[2]); !reflect.DeepEqual(names, []string{"pv-data"}) {
+		t.Errorf("expected the PV to be synced to the new peer, got %v", names)
+	}
+}
+
+func TestDiffProtectionPlanWithCephFS(t *testing.T) {
+	east, _ := newFakeCluster(t, "east", newCephFSPV("pv-files", "shop", "files", "sub-files", corev1.VolumeBound))
+	west, _ := newFakeCluster(t, "west")
+	disabled := false
+	plan := protectionPlan{Namespaces: []string{"shop"}, SnapshotInterval: "1h", Backup: planBackup{Enabled: &disabled}}
+
+	diff, err := diffProtectionPlan(plan, east, west)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diff.enablePVs) != 1 || diff.enablePVs[0].Name != "pv-files" {
+		t.Errorf("expected mirroring of the CephFS PV to be enabled, got %v", diff.Changes)
+	}
+	if len(diff.pools) != 0 {
+		t.Errorf("expected no pool schedule for CephFS PVs, got %v", diff.pools)
+	}
+}
+
+func TestDiffProtectionPlanSkipsUnknownStatus(t *testing.T) {
+	brokenPV := newRBDPV("pv-logs", "other", "logs", "img-logs", corev1.VolumeBound)
+	brokenPV.Spec.CSI.VolumeAttributes["pool"] = "broken"
+	east, eastToolbox := newFakeCluster(t, "east", newRBDPV("pv-data", "shop", "data", "img-data", corev1.VolumeBound), brokenPV)
+	eastToolbox.failing["rbd mirror pool status broken --verbose --format json"] = rbdExitTimedOut
+	west, _ := newFakeCluster(t, "west")
+	disabled := false
+	plan := protectionPlan{Namespaces: []string{"shop"}, Backup: planBackup{Enabled: &disabled}}
+
+	diff, err := diffProtectionPlan(plan, east, west)
+	if err != nil {
+		t.Fatalf("expected the PV without mirror status to be skipped, got %s", err)
+	}
+	if len(diff.enablePVs) != 1 || diff.enablePVs[0].Name != "pv-data" {
+		t.Errorf("expected mirroring of pv-data to be enabled, got %v", diff.Changes)
+	}
+	if len(diff.Skipped) != 1 || diff.Skipped[0].Target != "other/logs" {
+		t.Errorf("expected other/logs to be skipped, got %v", diff.Skipped)
+	}
+}
+
+func TestDiffProtectionPlanKeepsReplicas(t *testing.T) {
+	east, eastToolbox := newFakeCluster(t, "east",
+		newRBDPV("pv-local", "old", "local", "img-local", corev1.VolumeBound),
+		newRBDPV("pv-replica", "old", "replica", "img-replica", corev1.VolumeReleased),
+	)
+	eastToolbox.mirrored["replicapool/img-local"] = "up+stopped"
+	eastToolbox.mirrored["replicapool/img-replica"] = "up+replaying"
+	eastToolbox.nonPrimary["replicapool/img-replica"] = true
+	west, _ := newFakeCluster(t, "west")
+	disabled := false
+	plan := protectionPlan{Namespaces: []string{"shop"}, Backup: planBackup{Enabled: &disabled}}
+
+	diff, err := diffProtectionPlan(plan, east, west)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diff.disablePVs) != 1 || diff.disablePVs[0].Name != "pv-local" {
+		t.Errorf("expected only the primary image to be disabled, got %v", diff.Changes)
+	}
+}
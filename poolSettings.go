@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	cephv1 "github.com/rook/rook/pkg/apis/ceph.rook.io/v1"
+)
+
+// poolSettings configure the mirrored Block Pool and its StorageClass, zero values fall back to the defaults
+type poolSettings struct {
+	// Name, ReplicaSize, FailureDomain and DeviceClass only apply to the dedicated pool
+	Name          string `yaml:"name,omitempty"`
+	ReplicaSize   uint   `yaml:"replicaSize,omitempty"`
+	FailureDomain string `yaml:"failureDomain,omitempty"`
+	DeviceClass   string `yaml:"deviceClass,omitempty"`
+	// SnapshotSchedules apply to the dedicated and the default pool
+	SnapshotSchedules []snapshotSchedule `yaml:"snapshotSchedules,omitempty"`
+	// StorageClassName and StorageClassParameters apply to the StorageClass of the dedicated pool,
+	// the parameters are added to the default parameters
+	StorageClassName       string            `yaml:"storageClassName,omitempty"`
+	StorageClassParameters map[string]string `yaml:"storageClassParameters,omitempty"`
+}
+
+// snapshotSchedule is how often mirror snapshots of the images are taken, starting at StartTime
+type snapshotSchedule struct {
+	Interval  string `yaml:"interval" json:"interval"`
+	StartTime string `yaml:"startTime,omitempty" json:"startTime,omitempty"`
+}
+
+const defaultPoolName = "ocs-storagecluster-cephblockpool"
+
+var defaultPoolSettings = poolSettings{
+	Name:              "replicapool",
+	ReplicaSize:       3,
+	SnapshotSchedules: []snapshotSchedule{{Interval: "1h"}},
+	StorageClassName:  "ocs-storagecluster-ceph-mirror",
+}
+
+// defaultPoolSnapshotSchedules are used when mirroring is enabled on the default pool
+var defaultPoolSnapshotSchedules = []snapshotSchedule{{Interval: "5m"}}
+
+// snapshotIntervalPattern matches the intervals that rbd understands, like 5m, 1h or 1d
+var snapshotIntervalPattern = regexp.MustCompile(`^[1-9][0-9]*[mhd]$`)
+
+// pool returns the configured pool settings, filled up with the defaults
+func pool() poolSettings {
+	settings := appConfig.Pool
+	if settings.Name == "" {
+		settings.Name = defaultPoolSettings.Name
+	}
+	if settings.ReplicaSize == 0 {
+		settings.ReplicaSize = defaultPoolSettings.ReplicaSize
+	}
+	if len(settings.SnapshotSchedules) == 0 {
+		settings.SnapshotSchedules = defaultPoolSettings.SnapshotSchedules
+		if !useNewBlockPoolForMirroring {
+			settings.SnapshotSchedules = defaultPoolSnapshotSchedules
+		}
+	}
+	if settings.StorageClassName == "" {
+		settings.StorageClassName = defaultPoolSettings.StorageClassName
+	}
+	return settings
+}
+
+// cephSnapshotSchedules converts the schedules for the CephBlockPool spec
+func (p poolSettings) cephSnapshotSchedules() []cephv1.SnapshotScheduleSpec {
+	schedules := make([]cephv1.SnapshotScheduleSpec, 0, len(p.SnapshotSchedules))
+	for _, schedule := range p.SnapshotSchedules {
+		schedules = append(schedules, cephv1.SnapshotScheduleSpec{Interval: schedule.Interval, StartTime: schedule.StartTime})
+	}
+	return schedules
+}
+
+// parseSnapshotSchedules reads schedules in the form "interval[@startTime]", separated by commas, e.g. "1h, 1d@02:00:00"
+func parseSnapshotSchedules(text string) ([]snapshotSchedule, error) {
+	var schedules []snapshotSchedule
+	for _, entry := range strings.Split(text, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, "@", 2)
+		schedule := snapshotSchedule{Interval: strings.TrimSpace(parts[0])}
+		if len(parts) == 2 {
+			schedule.StartTime = strings.TrimSpace(parts[1])
+		}
+		if !snapshotIntervalPattern.MatchString(schedule.Interval) {
+			return nil, errors.Errorf("invalid snapshot interval %q, use minutes, hours or days like 5m, 1h or 1d", schedule.Interval)
+		}
+		schedules = append(schedules, schedule)
+	}
+	return schedules, nil
+}
+
+func formatSnapshotSchedules(schedules []snapshotSchedule) string {
+	var entries []string
+	for _, schedule := range schedules {
+		if schedule.StartTime == "" {
+			entries = append(entries, schedule.Interval)
+		} else {
+			entries = append(entries, fmt.Sprintf("%s@%s", schedule.Interval, schedule.StartTime))
+		}
+	}
+	return strings.Join(entries, ", ")
+}
+
+// parseParameters reads "key=value" pairs, separated by commas
+func parseParameters(text string) (map[string]string, error) {
+	var parameters map[string]string
+	for _, entry := range strings.Split(text, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return nil, errors.Errorf("invalid parameter %q, use key=value", entry)
+		}
+		if parameters == nil {
+			parameters = make(map[string]string)
+		}
+		parameters[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+	return parameters, nil
+}
+
+func formatParameters(parameters map[string]string) string {
+	var entries []string
+	for key, value := range parameters {
+		entries = append(entries, fmt.Sprintf("%s=%s", key, value))
+	}
+	sort.Strings(entries)
+	return strings.Join(entries, ", ")
+}
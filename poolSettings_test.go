@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	cephv1 "github.com/rook/rook/pkg/apis/ceph.rook.io/v1"
+)
+
+func TestParseSnapshotSchedules(t *testing.T) {
+	schedules, err := parseSnapshotSchedules(" 1h, 1d@02:00:00-05:00 ,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []snapshotSchedule{{Interval: "1h"}, {Interval: "1d", StartTime: "02:00:00-05:00"}}
+	if !reflect.DeepEqual(schedules, expected) {
+		t.Errorf("expected %+v, got %+v", expected, schedules)
+	}
+	if text := formatSnapshotSchedules(schedules); text != "1h, 1d@02:00:00-05:00" {
+		t.Errorf("unexpected formatted schedules %q", text)
+	}
+	for _, invalid := range []string{"1w", "0m", "h", "@02:00:00"} {
+		if _, err := parseSnapshotSchedules(invalid); err == nil {
+			t.Errorf("expected %q to be invalid", invalid)
+		}
+	}
+
+	parameters, err := parseParameters("imageFeatures=layering,exclusive-lock, encrypted=true")
+	if err == nil {
+		t.Errorf("expected a value with a comma to be invalid, got %v", parameters)
+	}
+	parameters, err = parseParameters("imageFeatures=layering, encrypted=true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text := formatParameters(parameters); text != "encrypted=true, imageFeatures=layering" {
+		t.Errorf("unexpected formatted parameters %q", text)
+	}
+}
+
+func TestPoolSettings(t *testing.T) {
+	defer func(settings poolSettings, dedicated bool) {
+		appConfig.Pool, useNewBlockPoolForMirroring = settings, dedicated
+	}(appConfig.Pool, useNewBlockPoolForMirroring)
+
+	appConfig.Pool = poolSettings{}
+	useNewBlockPoolForMirroring = false
+	if schedules := pool().SnapshotSchedules; !reflect.DeepEqual(schedules, defaultPoolSnapshotSchedules) {
+		t.Errorf("expected the default pool schedules, got %+v", schedules)
+	}
+	if name := mirroringBlockPool(environmentODF); name != defaultPoolName {
+		t.Errorf("expected the default pool, got %s", name)
+	}
+
+	useNewBlockPoolForMirroring = true
+	appConfig.Pool = poolSettings{
+		Name:                   "mirrorpool",
+		FailureDomain:          "zone",
+		SnapshotSchedules:      []snapshotSchedule{{Interval: "30m", StartTime: "00:15:00"}},
+		StorageClassParameters: map[string]string{"imageFeatures": "layering,exclusive-lock", "pool": "ignored"},
+	}
+	blockPool := newMirroringBlockPool(environmentODF)
+	if blockPool.Name != "mirrorpool" || blockPool.Spec.Replicated.Size != 3 || blockPool.Spec.FailureDomain != "zone" {
+		t.Errorf("unexpected block pool %+v", blockPool)
+	}
+	if schedules := blockPool.Spec.Mirroring.SnapshotSchedules; !reflect.DeepEqual(schedules, []cephv1.SnapshotScheduleSpec{{Interval: "30m", StartTime: "00:15:00"}}) {
+		t.Errorf("unexpected snapshot schedules %+v", schedules)
+	}
+	storageClass := newMirroringStorageClass(environmentODF)
+	if storageClass.Name != defaultPoolSettings.StorageClassName || storageClass.Parameters["pool"] != "mirrorpool" ||
+		storageClass.Parameters["imageFeatures"] != "layering,exclusive-lock" || storageClass.Parameters["clusterID"] != "openshift-storage" {
+		t.Errorf("unexpected StorageClass %+v", storageClass)
+	}
+
+	// A changed schedule makes the install run the pool step again
+	cluster, _ := newFakeCluster(t, "primary", blockPool)
+	if done, err := checkDedicatedPool(context.Background(), cluster); err != nil || !done {
+		t.Errorf("expected the pool to match the settings, got %t, %v", done, err)
+	}
+	appConfig.Pool.SnapshotSchedules = []snapshotSchedule{{Interval: "1h"}}
+	if done, _ := checkDedicatedPool(context.Background(), cluster); done {
+		t.Error("expected the changed schedule to not match")
+	}
+}
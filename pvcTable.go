@@ -0,0 +1,334 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+)
+
+// pvcStatusPollInterval is how often the mirror status of the PVC table is refreshed
+const pvcStatusPollInterval = 30 * time.Second
+
+// pvcRenderInterval is the shortest time between two renders of the PVC table. The informers report every
+// PV on their initial list, rendering the whole table for each of them would flood the UI.
+const pvcRenderInterval = 250 * time.Millisecond
+
+// pvcTableRow is an RBD or CephFS PV with a claim, as shown in the PVC table
+type pvcTableRow struct {
+	pv corev1.PersistentVolume
+	// claimPresent is false if the PVC does not exist (any more), e.g. for synced PVs in the secondary cluster
+	claimPresent bool
+	// state is the mirror state, empty until it was polled
+	state string
+	// lag is how far journal based mirroring is behind, empty for snapshot based mirroring
+	lag      string
+	selected bool
+}
+
+// volumeType returns RBD or CephFS
+func (r pvcTableRow) volumeType() string {
+	if isCephFSPV(&r.pv) {
+		return "CephFS"
+	}
+	return "RBD"
+}
+
+// mirrored returns true if mirroring is enabled on the image of the row
+func (r pvcTableRow) mirrored() bool {
+	return r.state != "" && r.state != rbdMirrorStateDisabled
+}
+
+// pvcTableModel holds the state of the live PVC table.
+// Everything is keyed by PV name, so the selection survives updates of the PVs and PVCs.
+type pvcTableModel struct {
+	cluster kubeAccess
+	// onChange is called after the informers or the status poll changed the model
+	onChange func()
+	pollNow  chan struct{}
+
+	mu       sync.Mutex
+	pvs      map[string]corev1.PersistentVolume
+	claims   map[string]bool
+	states   map[string]string
+	lags     map[string]string
+	selected map[string]bool
+	lastPoll time.Time
+	pollErr  error
+}
+
+func newPVCTableModel(cluster kubeAccess) *pvcTableModel {
+	return &pvcTableModel{
+		cluster:  cluster,
+		onChange: func() {},
+		pollNow:  make(chan struct{}, 1),
+		pvs:      make(map[string]corev1.PersistentVolume),
+		claims:   make(map[string]bool),
+		states:   make(map[string]string),
+		lags:     make(map[string]string),
+		selected: make(map[string]bool),
+	}
+}
+
+func claimKey(namespace, name string) string {
+	return namespace + "/" + name
+}
+
+// showsPV returns true for the PVs that belong into the PVC table
+func showsPV(pv *corev1.PersistentVolume) bool {
+	return pv.Spec.ClaimRef != nil && isCephPV(pv)
+}
+
+func (m *pvcTableModel) setPV(pv *corev1.PersistentVolume) {
+	m.mu.Lock()
+	_, known := m.pvs[pv.Name]
+	if showsPV(pv) {
+		m.pvs[pv.Name] = *pv.DeepCopy()
+	} else {
+		m.removePVLocked(pv.Name)
+	}
+	m.mu.Unlock()
+	if !known && showsPV(pv) {
+		// The mirror status of new PVs should not wait for the next poll
+		m.requestPoll()
+	}
+	m.onChange()
+}
+
+func (m *pvcTableModel) deletePV(name string) {
+	m.mu.Lock()
+	m.removePVLocked(name)
+	m.mu.Unlock()
+	m.onChange()
+}
+
+func (m *pvcTableModel) removePVLocked(name string) {
+	delete(m.pvs, name)
+	delete(m.states, name)
+	delete(m.lags, name)
+	delete(m.selected, name)
+}
+
+func (m *pvcTableModel) setClaim(namespace, name string, present bool) {
+	m.mu.Lock()
+	if present {
+		m.claims[claimKey(namespace, name)] = true
+	} else {
+		delete(m.claims, claimKey(namespace, name))
+	}
+	m.mu.Unlock()
+	m.onChange()
+}
+
+// setStates stores the result of a mirror status poll
+func (m *pvcTableModel) setStates(statuses map[string]*rbdMirrorImageStatus, err error) {
+	m.mu.Lock()
+	for name := range m.pvs {
+		if status, known := statuses[name]; known {
+			m.states[name] = status.displayState()
+			if entries, journaled := status.journalEntriesBehind(); journaled {
+				m.lags[name] = fmt.Sprintf("%d entries", entries)
+			} else {
+				delete(m.lags, name)
+			}
+		}
+	}
+	m.lastPoll = time.Now()
+	m.pollErr = err
+	m.mu.Unlock()
+	m.onChange()
+}
+
+// setState changes the mirror state of a PV until the next poll, e.g. after mirroring was enabled
+func (m *pvcTableModel) setState(name, state string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, known := m.pvs[name]; known {
+		m.states[name] = state
+	}
+}
+
+// rows returns the rows sorted by namespace and PVC name
+func (m *pvcTableModel) rows() []pvcTableRow {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	rows := make([]pvcTableRow, 0, len(m.pvs))
+	for name, pv := range m.pvs {
+		rows = append(rows, pvcTableRow{
+			pv:           pv,
+			claimPresent: m.claims[claimKey(pv.Spec.ClaimRef.Namespace, pv.Spec.ClaimRef.Name)],
+			state:        m.states[name],
+			lag:          m.lags[name],
+			selected:     m.selected[name],
+		})
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		a, b := rows[i].pv.Spec.ClaimRef, rows[j].pv.Spec.ClaimRef
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		return a.Name < b.Name
+	})
+	return rows
+}
+
+func (m *pvcTableModel) row(name string) (pvcTableRow, bool) {
+	for _, row := range m.rows() {
+		if row.pv.Name == name {
+			return row, true
+		}
+	}
+	return pvcTableRow{}, false
+}
+
+// pollStatus returns when the mirror status was polled the last time and the error of that poll
+func (m *pvcTableModel) pollStatus() (time.Time, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.lastPoll, m.pollErr
+}
+
+func (m *pvcTableModel) toggle(name string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, known := m.pvs[name]; known {
+		m.selected[name] = !m.selected[name]
+	}
+}
+
+// selectAll (de-)selects all rows, or only the rows of the namespace if it is set
+func (m *pvcTableModel) selectAll(selected bool, namespace string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for name, pv := range m.pvs {
+		if namespace == "" || pv.Spec.ClaimRef.Namespace == namespace {
+			m.selected[name] = selected
+		}
+	}
+}
+
+// mirroredNamespaces returns the namespaces that contain PVCs with active mirroring
+func (m *pvcTableModel) mirroredNamespaces() []string {
+	namespaceMap := make(map[string]struct{})
+	for _, row := range m.rows() {
+		if row.mirrored() {
+			namespaceMap[row.pv.Spec.ClaimRef.Namespace] = struct{}{}
+		}
+	}
+	var namespaces []string
+	for namespace := range namespaceMap {
+		namespaces = append(namespaces, namespace)
+	}
+	sort.Strings(namespaces)
+	return namespaces
+}
+
+// renderCoalescer collects the changes of the model and renders them at most once per tick
+type renderCoalescer struct {
+	dirty chan struct{}
+}
+
+func newRenderCoalescer() *renderCoalescer {
+	return &renderCoalescer{dirty: make(chan struct{}, 1)}
+}
+
+// changed marks the table as outdated, it never blocks
+func (c *renderCoalescer) changed() {
+	select {
+	case c.dirty <- struct{}{}:
+	default:
+		// A render is already pending
+	}
+}
+
+// run calls render on every tick after a change, until stop is closed
+func (c *renderCoalescer) run(stop <-chan struct{}, interval time.Duration, render func()) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			select {
+			case <-c.dirty:
+				render()
+			default:
+			}
+		}
+	}
+}
+
+// requestPoll makes the watch poll the mirror status right away
+func (m *pvcTableModel) requestPoll() {
+	select {
+	case m.pollNow <- struct{}{}:
+	default:
+		// A poll is already pending
+	}
+}
+
+func (m *pvcTableModel) poll() {
+	m.mu.Lock()
+	pvs := make([]corev1.PersistentVolume, 0, len(m.pvs))
+	for _, pv := range m.pvs {
+		pvs = append(pvs, pv)
+	}
+	m.mu.Unlock()
+	statuses, err := getMirrorStatuses(m.cluster, pvs)
+	m.setStates(statuses, err)
+}
+
+// watch keeps the model up to date with informers on PVs and PVCs and polls the mirror status, until stop is closed
+func (m *pvcTableModel) watch(stop <-chan struct{}, pollInterval time.Duration) {
+	factory := informers.NewSharedInformerFactory(m.cluster.typedClient, 0)
+	factory.Core().V1().PersistentVolumes().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { m.setPV(obj.(*corev1.PersistentVolume)) },
+		UpdateFunc: func(_, obj interface{}) { m.setPV(obj.(*corev1.PersistentVolume)) },
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if pv, ok := obj.(*corev1.PersistentVolume); ok {
+				m.deletePV(pv.Name)
+			}
+		},
+	})
+	factory.Core().V1().PersistentVolumeClaims().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			pvc := obj.(*corev1.PersistentVolumeClaim)
+			m.setClaim(pvc.Namespace, pvc.Name, true)
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if pvc, ok := obj.(*corev1.PersistentVolumeClaim); ok {
+				m.setClaim(pvc.Namespace, pvc.Name, false)
+			}
+		},
+	})
+	factory.Start(stop)
+	factory.WaitForCacheSync(stop)
+	// The initial poll covers the PVs that were just added
+	select {
+	case <-m.pollNow:
+	default:
+	}
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		m.poll()
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		case <-m.pollNow:
+		}
+	}
+}
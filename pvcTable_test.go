@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func rowNames(rows []pvcTableRow) []string {
+	var names []string
+	for _, row := range rows {
+		names = append(names, row.pv.Name)
+	}
+	return names
+}
+
+func TestPVCTableModel(t *testing.T) {
+	cluster, _ := newFakeCluster(t, "primary")
+	model := newPVCTableModel(cluster)
+	changes := 0
+	model.onChange = func() { changes++ }
+
+	model.setPV(newRBDPV("pv-logs", "shop", "logs", "csi-vol-logs", corev1.VolumeBound))
+	model.setPV(newRBDPV("pv-db", "shop", "db", "csi-vol-db", corev1.VolumeBound))
+	model.setPV(newRBDPV("pv-cache", "blog", "cache", "csi-vol-cache", corev1.VolumeBound))
+	model.setPV(newRBDPV("pv-unbound", "", "", "csi-vol-unbound", corev1.VolumeAvailable))
+	model.setClaim("shop", "db", true)
+
+	if names := rowNames(model.rows()); !reflect.DeepEqual(names, []string{"pv-cache", "pv-db", "pv-logs"}) {
+		t.Errorf("expected the bound PVs sorted by namespace and PVC, got %v", names)
+	}
+	if changes != 5 {
+		t.Errorf("expected a change notification per update, got %d", changes)
+	}
+
+	model.toggle("pv-db")
+	model.setStates(map[string]*rbdMirrorImageStatus{"pv-db": {State: "up+replaying"}}, nil)
+	// An update of the PV must not lose the selection or the state
+	model.setPV(newRBDPV("pv-db", "shop", "db", "csi-vol-db", corev1.VolumeReleased))
+	row, found := model.row("pv-db")
+	if !found || !row.selected || !row.claimPresent || row.state != "up+replaying" || !row.mirrored() {
+		t.Errorf("unexpected row after the update %+v", row)
+	}
+	if namespaces := model.mirroredNamespaces(); !reflect.DeepEqual(namespaces, []string{"shop"}) {
+		t.Errorf("expected shop to be mirrored, got %v", namespaces)
+	}
+
+	model.selectAll(true, "shop")
+	if row, _ := model.row("pv-cache"); row.selected {
+		t.Error("expected only the PVs of the namespace to be selected")
+	}
+	model.deletePV("pv-db")
+	model.setPV(newRBDPV("pv-db", "shop", "db", "csi-vol-db", corev1.VolumeBound))
+	if row, _ := model.row("pv-db"); row.selected || row.state != "" {
+		t.Errorf("expected a recreated PV to start unselected without state, got %+v", row)
+	}
+}
+
+func TestPVCTableWatch(t *testing.T) {
+	cluster, toolbox := newFakeCluster(t, "primary",
+		newRBDPV("pv-db", "shop", "db", "csi-vol-db", corev1.VolumeBound),
+		&corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "db"}},
+	)
+	toolbox.mirrored["replicapool/csi-vol-db"] = "up+stopped"
+	model := newPVCTableModel(cluster)
+	changed := make(chan struct{}, 100)
+	model.onChange = func() { changed <- struct{}{} }
+	stop := make(chan struct{})
+	defer close(stop)
+	go model.watch(stop, time.Hour)
+
+	waitForRows := func(description string, check func([]pvcTableRow) bool) {
+		t.Helper()
+		timeout := time.After(10 * time.Second)
+		for !check(model.rows()) {
+			select {
+			case <-changed:
+			case <-timeout:
+				t.Fatalf("timed out waiting for %s, rows are %+v", description, model.rows())
+			}
+		}
+	}
+	waitForRows("the mirror status of the existing PV", func(rows []pvcTableRow) bool {
+		return len(rows) == 1 && rows[0].claimPresent && rows[0].state == "up+stopped"
+	})
+
+	// New PVs show up without a refresh and get their mirror status polled right away
+	_, err := cluster.typedClient.CoreV1().PersistentVolumes().Create(context.TODO(),
+		newRBDPV("pv-logs", "shop", "logs", "csi-vol-logs", corev1.VolumeBound), metav1.CreateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForRows("the new PV", func(rows []pvcTableRow) bool {
+		return len(rows) == 2 && rows[1].pv.Name == "pv-logs" && rows[1].state == rbdMirrorStateDisabled
+	})
+
+	err = cluster.typedClient.CoreV1().PersistentVolumes().Delete(context.TODO(), "pv-db", metav1.DeleteOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForRows("the deleted PV to disappear", func(rows []pvcTableRow) bool {
+		return len(rows) == 1 && rows[0].pv.Name == "pv-logs"
+	})
+}
+
+func TestRenderCoalescer(t *testing.T) {
+	renders := newRenderCoalescer()
+	rendered := make(chan struct{}, 10)
+	stop := make(chan struct{})
+	defer close(stop)
+	for i := 0; i < 1500; i++ {
+		renders.changed()
+	}
+	go renders.run(stop, 10*time.Millisecond, func() { rendered <- struct{}{} })
+
+	select {
+	case <-rendered:
+	case <-time.After(time.Second):
+		t.Fatal("expected the changes to be rendered")
+	}
+	select {
+	case <-rendered:
+		t.Error("expected all changes to be rendered at once")
+	case <-time.After(50 * time.Millisecond):
+	}
+	renders.changed()
+	select {
+	case <-rendered:
+	case <-time.After(time.Second):
+		t.Fatal("expected the next change to be rendered")
+	}
+}
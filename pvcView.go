@@ -3,8 +3,6 @@ package main
 import (
 	"context"
 	"fmt"
-	"strings"
-	"time"
 
 	"github.com/gdamore/tcell/v2"
 	"github.com/pkg/errors"
@@ -16,69 +14,92 @@ import (
 var primaryPVCs, secondaryPVCs *tview.Table
 var pvcStatusFrame *tview.Frame
 
+// protectionProgress reports changes to the protection of PVCs, like their mirroring and backup
+var protectionProgress = events.reporter("protection")
+
 func setPVCViewPage(table *tview.Table, currentCluster, otherCluster kubeAccess) {
 	// Check if the tools Pod is available
-	_, err := getToolsPod(currentCluster)
+	_, err := getToolsPod(currentCluster.typedClient, currentCluster.storageNamespace())
 	if err != nil {
 		showAlert("The Tools Pod is not ready. Please check that the install has completed successfully.")
 		return
 	}
 
+	model := newPVCTableModel(currentCluster)
+	stop := make(chan struct{})
+
 	table = tview.NewTable().
 		SetSelectable(true, false).
 		SetSeparator(tview.Borders.Vertical).
 		SetFixed(1, 1).
 		SetDoneFunc(func(key tcell.Key) {
 			if key == tcell.KeyEscape {
+				close(stop)
 				pages.SwitchToPage("main")
 				pages.RemovePage("pvcView")
 			}
-		}).
-		SetSelectedFunc(func(row int, column int) {
-			namespaceCell := table.GetCell(row, 0)
-			pvcCell := table.GetCell(row, 1)
-			ref := pvcCell.GetReference()
-			if ref == nil || ref == false {
-				pvcCell.SetTextColor(tcell.ColorRed)
-				namespaceCell.SetTextColor(tcell.ColorRed)
-				pvcCell.SetReference(true)
-			} else {
-				pvcCell.SetTextColor(tcell.ColorWhite)
-				namespaceCell.SetTextColor(tcell.ColorWhite)
-				pvcCell.SetReference(false)
-			}
 		})
+	table.SetSelectedFunc(func(row int, column int) {
+		if pvName, ok := table.GetCell(row, 0).GetReference().(string); ok {
+			model.toggle(pvName)
+			renderPVCTable(table, model)
+		}
+	})
 
 	table.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		row, _ := table.GetSelection()
+		pvName, _ := table.GetCell(row, 0).GetReference().(string)
 		switch event.Rune() {
 		case 'a':
-			selectAllFromTable(table, true)
+			model.selectAll(true, "")
 		case 'n':
-			row, _ := table.GetSelection()
-			namespace := table.GetCell(row, 0).Text
-			selectAllFromNamespaceFromTable(table, true, namespace)
+			model.selectAll(true, table.GetCell(row, 0).Text)
 		case 'x':
-			selectAllFromTable(table, false)
+			model.selectAll(false, "")
 		case 'r':
-			setPVStati(currentCluster, otherCluster, true, table)
+			dryRun.start()
+			setPVStati(currentCluster, otherCluster, true, "", model)
+			showDryRunPlan()
+		case 'j':
+			dryRun.start()
+			setPVStati(currentCluster, otherCluster, true, mirroringModeJournal, model)
+			showDryRunPlan()
 		case 'u':
-			setPVStati(currentCluster, otherCluster, false, table)
+			dryRun.start()
+			setPVStati(currentCluster, otherCluster, false, "", model)
+			showDryRunPlan()
 		case 's':
-			go populatePVCTable(table, currentCluster)
+			model.requestPoll()
 		case 'i':
-			row, _ := table.GetSelection()
-			pvReference := table.GetCell(row, 0).GetReference()
-			pv := pvReference.(corev1.PersistentVolume)
-			showRBDInfo(currentCluster, &pv)
+			if selected, found := model.row(pvName); found {
+				if isCephFSPV(&selected.pv) {
+					showCephFSInfo(currentCluster, &selected.pv)
+				} else {
+					showRBDInfo(currentCluster, &selected.pv)
+				}
+			}
+		case 'c':
+			if selected, found := model.row(pvName); found {
+				if isCephFSPV(&selected.pv) {
+					showAlert(fmt.Sprintf("CephFS directories are snapshotted every %s, the schedules only apply to RBD PVCs", defaultCephFSSnapshotInterval))
+					break
+				}
+				showPVSnapshotSchedules([]kubeAccess{currentCluster, otherCluster}, &selected.pv)
+			}
+		default:
+			return event
 		}
-		return event
+		renderPVCTable(table, model)
+		// The key is handled, the table must not move the selection for it as well (e.g. 'j' moves down)
+		return nil
 	})
 
 	helpText := tview.NewTextView().SetText(`
 Keyboard keys:
 General actions
-	(s) Refresh PVC table
-	(i) Show PVCs RBD info
+	(s) Refresh mirror status
+	(i) Show PVCs RBD or CephFS info
+	(c) Change PVCs snapshot schedules (RBD)
 Selection
 	(a) Select all
 	(n) Select all in namespace
@@ -86,7 +107,11 @@ Selection
 	(ENTER) (De-)Select single PVC
 Actions on Selection
 	(r) Activate for replication
+	(j) Activate for journal replication (RBD)
 	(u) Deactivate for replication
+
+PVCs are updated live, the mirror
+status is refreshed periodically.
 	`)
 	helperTextFrame := tview.NewFrame(helpText).
 		SetBorders(0, 1, 0, 0, 3, 0)
@@ -104,37 +129,13 @@ Actions on Selection
 	pages.AddAndSwitchToPage("pvcView",
 		pvcInfoFrame,
 		true)
-	go populatePVCTable(table, currentCluster)
-}
-
-func selectAllFromTable(table *tview.Table, selected bool) {
-	for row := 1; row < table.GetRowCount(); row++ {
-		for column := 0; column < 2; column++ {
-			cell := table.GetCell(row, column)
-			if selected {
-				cell.SetTextColor(tcell.ColorRed)
-			} else {
-				cell.SetTextColor(tcell.ColorWhite)
-			}
-			table.GetCell(row, 1).SetReference(selected)
-		}
-	}
-}
-func selectAllFromNamespaceFromTable(table *tview.Table, selected bool, namespace string) {
-	for row := 1; row < table.GetRowCount(); row++ {
-		if table.GetCell(row, 0).Text != namespace {
-			continue
-		}
-		for column := 0; column < 2; column++ {
-			currentCell := table.GetCell(row, column)
-			if selected {
-				currentCell.SetTextColor(tcell.ColorRed)
-			} else {
-				currentCell.SetTextColor(tcell.ColorWhite)
-			}
-			table.GetCell(row, 1).SetReference(selected)
-		}
-	}
+	renderPVCTable(table, model)
+	renders := newRenderCoalescer()
+	model.onChange = renders.changed
+	go renders.run(stop, pvcRenderInterval, func() {
+		app.QueueUpdateDraw(func() { renderPVCTable(table, model) })
+	})
+	go model.watch(stop, pvcStatusPollInterval)
 }
 
 // getSelectedRows Returns the row indexes that are selected
@@ -150,62 +151,114 @@ func getSelectedRows(table *tview.Table, column int) []int {
 	return result
 }
 
-// getActiveRows Returns the row indexes with active mirroring
-func getActiveRows(table *tview.Table) []int {
-	result := []int{}
-	for row := 1; row < table.GetRowCount(); row++ {
-		statusCell := table.GetCell(row, 2)
-		if statusCell.Text == "active" {
-			result = append(result, row)
-		}
+// mirrorStateColors are the colors of the mirror states in the PVC table, all other states are shown in red
+var mirrorStateColors = map[string]tcell.Color{
+	"up+replaying":           tcell.ColorGreen,
+	"up+stopped":             tcell.ColorGreen,
+	"enabled":                tcell.ColorGreen,
+	"up+starting_replay":     tcell.ColorYellow,
+	"up+syncing":             tcell.ColorYellow,
+	"up+stopping_replay":     tcell.ColorYellow,
+	"up+unknown":             tcell.ColorYellow,
+	cephfsMirrorStateMapped:  tcell.ColorGreen,
+	cephfsMirrorStateReplica: tcell.ColorGreen,
+	"shuffling":              tcell.ColorYellow,
+	rbdMirrorStateDisabled:   tcell.ColorGray,
+}
+
+// mirrorStateCell returns the table cell for the mirror state, the reference is true if mirroring is enabled
+func mirrorStateCell(state string) *tview.TableCell {
+	color, known := mirrorStateColors[state]
+	if !known {
+		color = tcell.ColorRed
+	}
+	return &tview.TableCell{
+		Text:            state,
+		Expansion:       1,
+		Color:           color,
+		BackgroundColor: tcell.ColorBlack,
+		Reference:       state != rbdMirrorStateDisabled,
 	}
-	return result
 }
 
-// setPVStati sets the PV status of selected rows to either active or inactive
-func setPVStati(currentCluster, otherCluster kubeAccess, enable bool, table *tview.Table) {
-	statusText := "active"
-	statusColor := tcell.ColorGreen
+// setPVStati enables or disables mirroring of the selected PVCs, an empty mode uses the mode configured for the pool
+func setPVStati(currentCluster, otherCluster kubeAccess, enable bool, mode string, model *pvcTableModel) {
+	progress := protectionProgress.forCluster(currentCluster.name)
+	statusText := "enabled"
 	if !enable {
-		statusText = "inactive"
-		statusColor = tcell.ColorRed
+		statusText = rbdMirrorStateDisabled
 	}
-	for _, row := range getSelectedRows(table, 1) {
-		statusCell := table.GetCell(row, 2)
-		if statusCell.Text == statusText {
-			// PV already in desired state
+	failed := 0
+	backend := replicationFor(context.TODO(), currentCluster)
+	for _, row := range model.rows() {
+		if !row.selected || (row.state != "" && row.mirrored() == enable) {
+			// Not selected or PV already in desired state
 			continue
 		}
-		pvReference := table.GetCell(row, 0).GetReference()
-		if pvReference == nil {
-			log.WithField("row", row).Warn("Could not get PV reference for row")
+		pv := row.pv
+		err := setMirrorStatus(context.TODO(), backend, &pv, enable, mode)
+		progress.result(pv.Name, err, "mirroring %s for PVC %s/%s", statusText, pv.Spec.ClaimRef.Namespace, pv.Spec.ClaimRef.Name)
+		if err != nil {
+			failed++
 			continue
 		}
-		pv := pvReference.(corev1.PersistentVolume)
-		err := setMirrorStatus(currentCluster, &pv, enable)
-		if err != nil {
-			log.WithError(err).WithField("pvName", pv.Name).Warn("Could not change PV mirror status")
+		if dryRun.enabled {
 			continue
 		}
-		table.SetCell(row, 2, tview.NewTableCell(statusText).SetTextColor(statusColor))
+		model.setState(pv.Name, statusText)
+	}
+	if failed > 0 {
+		progress.failed("mirroring", errors.Errorf("%d PVs failed", failed), "Could not change the mirror status of all selected PVCs, please check the log")
 	}
-	ensureActivePVCsBackuped(currentCluster, table)
-	syncPVs(currentCluster, otherCluster)
+	ensureActivePVCsBackuped(currentCluster, model)
+	for _, target := range peerTargets(currentCluster, otherCluster) {
+		syncPVs(currentCluster, target)
+	}
+	model.requestPoll()
+}
+
+func ensureActivePVCsBackuped(cluster kubeAccess, model *pvcTableModel) {
+	setNamespacesToBackup(cluster, model.mirroredNamespaces(), defaultBackupSettings)
 }
 
-func ensureActivePVCsBackuped(cluster kubeAccess, table *tview.Table) {
-	// Collect a list of unique namespace names
-	// that contain PVCs with active mirroring
+// getMirroredNamespaces returns the namespaces that contain PVCs with active mirroring
+func getMirroredNamespaces(cluster kubeAccess) ([]string, error) {
+	pvs, err := cluster.typedClient.CoreV1().PersistentVolumes().List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, errors.WithMessagef(err, "[%s] Issues when listing PVs", cluster.name)
+	}
+	statuses, _ := getMirrorStatuses(cluster, pvs.Items)
 	namespaceMap := make(map[string]struct{})
-	var namespaces []string
-	for _, row := range getActiveRows(table) {
-		namespace := table.GetCell(row, 0).Text
-		namespaceMap[namespace] = struct{}{}
+	for _, pv := range pvs.Items {
+		if pv.Spec.ClaimRef == nil {
+			continue
+		}
+		if status, known := statuses[pv.Name]; !known || status.State == rbdMirrorStateDisabled {
+			continue
+		}
+		namespaceMap[pv.Spec.ClaimRef.Namespace] = struct{}{}
 	}
+	var namespaces []string
 	for namespace := range namespaceMap {
 		namespaces = append(namespaces, namespace)
 	}
-	setNamespacesToBackup(cluster, namespaces)
+	return namespaces, nil
+}
+
+// getPVForPVC returns the PV that is bound to the given PVC
+func getPVForPVC(cluster kubeAccess, namespace, name string) (*corev1.PersistentVolume, error) {
+	pvc, err := cluster.typedClient.CoreV1().PersistentVolumeClaims(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		return nil, errors.WithMessagef(err, "[%s] Issues when fetching PVC %s/%s", cluster.name, namespace, name)
+	}
+	if pvc.Spec.VolumeName == "" {
+		return nil, errors.Errorf("[%s] PVC %s/%s is not bound to a PV", cluster.name, namespace, name)
+	}
+	pv, err := cluster.typedClient.CoreV1().PersistentVolumes().Get(context.TODO(), pvc.Spec.VolumeName, metav1.GetOptions{})
+	if err != nil {
+		return nil, errors.WithMessagef(err, "[%s] Issues when fetching PV %s", cluster.name, pvc.Spec.VolumeName)
+	}
+	return pv, nil
 }
 
 // syncPVs ensures that PVs in the from cluster are present in the to cluster
@@ -217,18 +270,20 @@ func syncPVs(from, to kubeAccess) error {
 		log.WithError(err).Warn("Issues when listing pods for PVC list")
 		return err
 	}
+	statuses, _ := getMirrorStatuses(from, pvs.Items)
 	for _, pv := range pvs.Items {
 		pvc := pv.Spec.ClaimRef
 		if pvc == nil {
 			// This happens for unbound PVs, we skip those
 			continue
 		}
-		mirroringEnabled, err := checkMirrorStatus(from, &pv)
-		if err != nil {
-			log.WithField("PV", pv.Name).WithError(err).Warn("Issues when fetching mirror status")
+		status, known := statuses[pv.Name]
+		if !known {
+			log.WithField("PV", pv.Name).Debug("No mirror status for PV")
 			continue
 		}
-		if !mirroringEnabled {
+		if status.State == rbdMirrorStateDisabled || status.State == cephfsMirrorStateReplica {
+			// Replicas of CephFS PVs are synced from the peer, not to it
 			continue
 		}
 		mirroredPVs = append(mirroredPVs, pv)
@@ -240,22 +295,30 @@ func syncPVs(from, to kubeAccess) error {
 	}
 	// Filter for PVs in Released state, these are most likely our mirrored PVs
 	// field-selector does not support status.phase for PVs :/
+	var releasedPVs []corev1.PersistentVolume
 	for _, pv := range targetPVs.Items {
 		if pv.Status.Phase != "Released" {
 			continue
 		}
-		if pv.Spec.CSI == nil || pv.Spec.CSI.Driver != "openshift-storage.rbd.csi.ceph.com" {
-			// not a CSI backed PV or not a Ceph RBD PV
+		if !isCephPV(&pv) {
+			// not a Ceph RBD or CephFS PV
 			continue
 		}
-		mirroringEnabled, err := checkMirrorStatus(to, &pv)
-		if err != nil {
-			log.WithField("PV", pv.Name).WithError(err).Warn("Issues when fetching mirror status")
+		releasedPVs = append(releasedPVs, pv)
+	}
+	targetStatuses, _ := getMirrorStatuses(to, releasedPVs)
+	for _, pv := range releasedPVs {
+		status, known := targetStatuses[pv.Name]
+		if !known {
+			log.WithField("PV", pv.Name).Debug("No mirror status for PV")
 			continue
 		}
-		if !mirroringEnabled {
-			// If the PV is in released state and backed by Ceph-RBD,
+		if status.State == rbdMirrorStateDisabled {
+			// If the PV is in released state and backed by Ceph,
 			// it is most likely dangling (not mirrored any more) and we remove it
+			if dryRun.intercept(to, "delete", "PersistentVolume/"+pv.Name, nil) {
+				continue
+			}
 			err = to.typedClient.CoreV1().PersistentVolumes().Delete(context.TODO(), pv.Name, metav1.DeleteOptions{})
 			if err != nil {
 				log.WithField("PV", pv.Name).WithError(err).Warnf("Issues when deleting dangling PV from %s cluster", to.name)
@@ -269,6 +332,9 @@ func syncPVs(from, to kubeAccess) error {
 			mirroredPVs = RemovePVFromSlice(mirroredPVs, index)
 		} else {
 			// We should not reach this, since if the image is NOT mirrored, it will also not be mirrored on the target cluster
+			if dryRun.intercept(to, "delete", "PersistentVolume/"+pv.Name, nil) {
+				continue
+			}
 			err = to.typedClient.CoreV1().PersistentVolumes().Delete(context.TODO(), pv.Name, metav1.DeleteOptions{})
 			if err != nil {
 				log.WithField("PV", pv.Name).WithError(err).Warnf("Issues when deleting dangling PV from %s cluster", to.name)
@@ -283,15 +349,28 @@ func syncPVs(from, to kubeAccess) error {
 		pv.ResourceVersion = ""
 		pv.Spec.ClaimRef.ResourceVersion = ""
 		pv.Spec.ClaimRef.UID = ""
+		if isCephFSPV(&pv) {
+			path, err := cephfsPath(from, &pv)
+			if err != nil {
+				protectionProgress.forCluster(to.name).result(pv.Name, err, "PV %s could not be synced", pv.Name)
+				failureDuringCreation = true
+				continue
+			}
+			cephfsReplicaPV(&pv, path)
+		}
+		adaptPVToEnvironment(&pv, to.environment())
+		if dryRun.intercept(to, "create", "PersistentVolume/"+pv.Name, fmt.Sprintf("for PVC %s/%s", pv.Spec.ClaimRef.Namespace, pv.Spec.ClaimRef.Name)) {
+			continue
+		}
 		_, err = to.typedClient.CoreV1().PersistentVolumes().Create(context.TODO(), &pv, metav1.CreateOptions{})
+		protectionProgress.forCluster(to.name).result(pv.Name, err, "PV %s synced for PVC %s/%s", pv.Name, pv.Spec.ClaimRef.Namespace, pv.Spec.ClaimRef.Name)
 		if err != nil {
 			failureDuringCreation = true
-			log.WithField("PV", pv.Name).WithError(err).Warnf("Issues when creating PV in the %s cluster", to.name)
-			continue
 		}
 	}
 	if failureDuringCreation {
-		showAlert(fmt.Sprintf("There were errors when creating PVs in the %s cluster. Please check the log for more information", to.name))
+		return protectionProgress.forCluster(to.name).failed("sync-pvs", errors.New("not all PVs could be created"),
+			"There were errors when creating PVs in the %s cluster. Please check the log for more information", to.name)
 	}
 
 	return nil
@@ -310,120 +389,144 @@ func RemovePVFromSlice(slice []corev1.PersistentVolume, index int) []corev1.Pers
 	return append(slice[:index], slice[index+1:]...)
 }
 
-func populatePVCTable(table *tview.Table, cluster kubeAccess) error {
-
-	pvcStatusFrame.AddText(
-		"Fetching list of PVCs and their mirroring status",
-		true,
-		tview.AlignCenter,
-		tcell.ColorWhite,
-	)
+// renderPVCTable shows the rows of the model, the cursor stays on the same PV
+func renderPVCTable(table *tview.Table, model *pvcTableModel) {
+	row, _ := table.GetSelection()
+	cursorPV, _ := table.GetCell(row, 0).GetReference().(string)
 
 	table.Clear()
-
 	table.
 		SetCell(0, 0, &tview.TableCell{Text: "Namespace", NotSelectable: true, Color: tcell.ColorYellow, BackgroundColor: tcell.ColorBlack}).
 		SetCell(0, 1, &tview.TableCell{Text: "PVC", NotSelectable: true, Color: tcell.ColorYellow, BackgroundColor: tcell.ColorBlack}).
-		SetCell(0, 2, &tview.TableCell{Text: "Replication status", NotSelectable: true, Color: tcell.ColorYellow, BackgroundColor: tcell.ColorBlack})
+		SetCell(0, 2, &tview.TableCell{Text: "Replication status", NotSelectable: true, Color: tcell.ColorYellow, BackgroundColor: tcell.ColorBlack}).
+		SetCell(0, 3, &tview.TableCell{Text: "Journal replay lag", NotSelectable: true, Color: tcell.ColorYellow, BackgroundColor: tcell.ColorBlack}).
+		SetCell(0, 4, &tview.TableCell{Text: "Type", NotSelectable: true, Color: tcell.ColorYellow, BackgroundColor: tcell.ColorBlack})
 
-	pvs, err := cluster.typedClient.CoreV1().PersistentVolumes().List(context.TODO(), metav1.ListOptions{})
-	if err != nil {
-		log.WithError(err).Warn("Issues when listing pods for PVC list")
-		return err
-	}
-
-	currentRow := 1
-	for _, pv := range pvs.Items {
-		pvc := pv.Spec.ClaimRef
-		if pvc == nil {
-			// This happens for unbound PVs, we skip those
-			continue
+	for i, pvcRow := range model.rows() {
+		color := tcell.ColorWhite
+		if !pvcRow.claimPresent {
+			// e.g. PVs that were synced to the secondary cluster
+			color = tcell.ColorGray
 		}
-		mirroringEnabled, err := checkMirrorStatus(cluster, &pv)
-		if err != nil {
-			log.WithField("PV", pv.Name).WithError(err).Warn("Issues when fetching mirror status")
-			continue
+		if pvcRow.selected {
+			color = tcell.ColorRed
 		}
-		table.SetCell(currentRow, 0, &tview.TableCell{
-			Text:            pvc.Namespace,
+		state := pvcRow.state
+		if state == "" {
+			state = "pending"
+		}
+		table.SetCell(i+1, 0, &tview.TableCell{
+			Text:            pvcRow.pv.Spec.ClaimRef.Namespace,
 			Expansion:       1,
-			Color:           tcell.ColorWhite,
+			Color:           color,
 			BackgroundColor: tcell.ColorBlack,
-			Reference:       pv,
+			Reference:       pvcRow.pv.Name,
 		})
-		table.SetCell(currentRow, 1, &tview.TableCell{
-			Text:            pvc.Name,
+		table.SetCell(i+1, 1, &tview.TableCell{
+			Text:            pvcRow.pv.Spec.ClaimRef.Name,
 			Expansion:       2,
-			Color:           tcell.ColorWhite,
+			Color:           color,
 			BackgroundColor: tcell.ColorBlack,
 		})
-		if mirroringEnabled {
-			table.SetCell(currentRow, 2, &tview.TableCell{
-				Text:            "active",
-				Expansion:       1,
-				Color:           tcell.ColorGreen,
-				BackgroundColor: tcell.ColorBlack,
-			})
-		} else {
-			table.SetCell(currentRow, 2, &tview.TableCell{
-				Text:            "inactive",
-				Expansion:       1,
-				Color:           tcell.ColorRed,
-				BackgroundColor: tcell.ColorBlack,
-			})
+		table.SetCell(i+1, 2, mirrorStateCell(state))
+		table.SetCell(i+1, 3, &tview.TableCell{Text: pvcRow.lag, Expansion: 1, Color: tcell.ColorWhite, BackgroundColor: tcell.ColorBlack})
+		table.SetCell(i+1, 4, &tview.TableCell{Text: pvcRow.volumeType(), Color: tcell.ColorWhite, BackgroundColor: tcell.ColorBlack})
+		if pvcRow.pv.Name == cursorPV {
+			table.Select(i+1, 0)
 		}
-		currentRow += 1
-		app.Draw()
 	}
 
-	pvcStatusFrame.Clear().AddText(
-		"Fetching list of PVCs completed",
-		true,
-		tview.AlignCenter,
-		tcell.ColorGreen,
-	)
-	app.Draw()
-
-	time.Sleep(5 * time.Second)
-
+	if pvcStatusFrame == nil {
+		return
+	}
+	lastPoll, err := model.pollStatus()
 	pvcStatusFrame.Clear()
-	app.Draw()
-
-	return nil
+	switch {
+	case lastPoll.IsZero():
+		pvcStatusFrame.AddText("Fetching list of PVCs and their mirroring status", true, tview.AlignCenter, tcell.ColorWhite)
+	case err != nil:
+		pvcStatusFrame.AddText(fmt.Sprintf("Could not fetch the mirror status of all PVCs at %s: %s", lastPoll.Format("15:04:05"), err), true, tview.AlignCenter, tcell.ColorRed)
+	default:
+		pvcStatusFrame.AddText(fmt.Sprintf("Mirror status updated at %s", lastPoll.Format("15:04:05")), true, tview.AlignCenter, tcell.ColorGreen)
+	}
 }
 
-func checkMirrorStatus(cluster kubeAccess, pv *corev1.PersistentVolume) (bool, error) {
+// getMirrorStatus returns the mirror status of the image or directory of the PV.
+// If mirroring is not enabled, the state of the returned status is rbdMirrorStateDisabled.
+func getMirrorStatus(cluster kubeAccess, pv *corev1.PersistentVolume) (*rbdMirrorImageStatus, error) {
+	if isCephFSPV(pv) {
+		return cephfsMirrorStatus(newCephFS(cluster), pv)
+	}
 	rbdName, poolName, err := getRBDInfoFromPV(pv)
 	if err != nil {
-		return false, err
+		return nil, err
 	}
-	command := fmt.Sprintf("rbd -p %s mirror image status %s", poolName, rbdName)
-	_, stderr, err := executeInToolbox(cluster, command)
-	// Catch error later, since exit code 22 is thrown when image is not enabled
-	if strings.Contains(stderr, "mirroring not enabled on the image") {
-		return false, nil
+	status, err := newRBD(cluster).MirrorImageStatus(poolName, rbdName)
+	if isRBDMirroringDisabled(err) {
+		return &rbdMirrorImageStatus{Name: rbdName, State: rbdMirrorStateDisabled}, nil
 	}
 	if err != nil {
-		return false, errors.New("could not get RBD mirror info from PV")
+		return nil, errors.WithMessage(err, "could not get RBD mirror info from PV")
 	}
-	return true, nil
+	return status, nil
 }
 
-func setMirrorStatus(cluster kubeAccess, pv *corev1.PersistentVolume, enable bool) error {
-	rbdName, poolName, err := getRBDInfoFromPV(pv)
-	if err != nil {
-		return err
+// getMirrorStatuses fetches the mirror status of the images of all given PVs with a single rbd call per pool,
+// and the status of the directories of CephFS PVs. The result is keyed by PV name. PVs that are not backed by Ceph,
+// PVs in pools whose status could not be fetched and CephFS PVs whose status could not be fetched are left out,
+// the returned error is the first of these errors.
+func getMirrorStatuses(cluster kubeAccess, pvs []corev1.PersistentVolume) (map[string]*rbdMirrorImageStatus, error) {
+	pvsByPool := make(map[string][]corev1.PersistentVolume)
+	for _, pv := range pvs {
+		_, poolName, err := getRBDInfoFromPV(&pv)
+		if err != nil {
+			continue
+		}
+		pvsByPool[poolName] = append(pvsByPool[poolName], pv)
+	}
+
+	rbd := newRBD(cluster)
+	statuses := make(map[string]*rbdMirrorImageStatus)
+	var firstErr error
+	for poolName, poolPVs := range pvsByPool {
+		imageStatus := make(map[string]*rbdMirrorImageStatus)
+		poolStatus, err := rbd.MirrorPoolStatus(poolName)
+		if err != nil && !isRBDMirroringDisabled(err) {
+			log.WithError(err).Warnf("[%s] Issues when fetching the mirror status of pool %s", cluster.name, poolName)
+			if firstErr == nil {
+				firstErr = errors.WithMessagef(err, "[%s] Issues when fetching the mirror status of pool %s", cluster.name, poolName)
+			}
+			continue
+		}
+		if err == nil {
+			for i := range poolStatus.Images {
+				imageStatus[poolStatus.Images[i].Name] = &poolStatus.Images[i]
+			}
+		}
+		// Images without mirroring are not part of the pool status, the same as all images of pools without mirroring
+		for _, pv := range poolPVs {
+			rbdName, _, _ := getRBDInfoFromPV(&pv)
+			status, mirrored := imageStatus[rbdName]
+			if !mirrored {
+				status = &rbdMirrorImageStatus{Name: rbdName, State: rbdMirrorStateDisabled}
+			}
+			statuses[pv.Name] = status
+		}
+	}
+	cephfsStatuses, err := getCephFSMirrorStatuses(cluster, pvs)
+	for name, status := range cephfsStatuses {
+		statuses[name] = status
 	}
-	var action string
-	if enable {
-		action = fmt.Sprintf("enable %s snapshot", rbdName)
-	} else {
-		action = fmt.Sprintf("disable %s", rbdName)
+	if firstErr == nil {
+		firstErr = err
 	}
-	command := fmt.Sprintf("rbd -p %s mirror image %s", poolName, action)
-	_, _, err = executeInToolbox(cluster, command)
+	return statuses, firstErr
+}
+
+func checkMirrorStatus(cluster kubeAccess, pv *corev1.PersistentVolume) (bool, error) {
+	status, err := getMirrorStatus(cluster, pv)
 	if err != nil {
-		return errors.Wrapf(err, "could not change RBD mirror status from PV. Command: %s", command)
+		return false, err
 	}
-	return nil
+	return status.State != rbdMirrorStateDisabled, nil
 }
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestSyncPVs(t *testing.T) {
+	from, fromToolbox := newFakeCluster(t, "primary",
+		newRBDPV("pv-mirrored", "shop", "data", "img-mirrored", corev1.VolumeBound),
+		newRBDPV("pv-synced", "shop", "logs", "img-synced", corev1.VolumeBound),
+		newRBDPV("pv-local", "shop", "cache", "img-local", corev1.VolumeBound),
+		newRBDPV("pv-unbound", "", "", "img-unbound", corev1.VolumeAvailable),
+	)
+	fromToolbox.mirrored["replicapool/img-mirrored"] = "up+replaying"
+	fromToolbox.mirrored["replicapool/img-synced"] = "up+replaying"
+
+	to, toToolbox := newFakeCluster(t, "secondary",
+		newRBDPV("pv-synced", "shop", "logs", "img-synced", corev1.VolumeReleased),
+		newRBDPV("pv-dangling", "shop", "old", "img-dangling", corev1.VolumeReleased),
+		newRBDPV("pv-removed", "shop", "removed", "img-removed", corev1.VolumeReleased),
+		newRBDPV("pv-in-use", "other", "data", "img-in-use", corev1.VolumeBound),
+	)
+	toToolbox.mirrored["replicapool/img-synced"] = "up+replaying"
+	// still mirrored on the secondary, but not any more on the primary
+	toToolbox.mirrored["replicapool/img-removed"] = "up+replaying"
+
+	if err := syncPVs(from, to); err != nil {
+		t.Fatalf("syncPVs failed: %s", err)
+	}
+
+	names := listPVNames(t, to)
+	sort.Strings(names)
+	expected := []string{"pv-in-use", "pv-mirrored", "pv-synced"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("expected PVs %v in the secondary cluster, got %v", expected, names)
+	}
+	created, err := to.typedClient.CoreV1().PersistentVolumes().Get(context.TODO(), "pv-mirrored", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.Spec.ClaimRef.UID != "" || created.Spec.ClaimRef.ResourceVersion != "" {
+		t.Errorf("expected the ClaimRef of the synced PV to be cleaned, got %+v", created.Spec.ClaimRef)
+	}
+}
+
+func TestSyncPVsDryRun(t *testing.T) {
+	from, fromToolbox := newFakeCluster(t, "primary",
+		newRBDPV("pv-mirrored", "shop", "data", "img-mirrored", corev1.VolumeBound),
+	)
+	fromToolbox.mirrored["replicapool/img-mirrored"] = "up+replaying"
+	to, _ := newFakeCluster(t, "secondary",
+		newRBDPV("pv-dangling", "shop", "old", "img-dangling", corev1.VolumeReleased),
+	)
+
+	dryRun.enabled = true
+	dryRun.start()
+	defer func() { dryRun.enabled = false }()
+	if err := syncPVs(from, to); err != nil {
+		t.Fatalf("syncPVs failed: %s", err)
+	}
+
+	if names := listPVNames(t, to); !reflect.DeepEqual(names, []string{"pv-dangling"}) {
+		t.Errorf("expected no changes during a dry run, got PVs %v", names)
+	}
+	var recorded []string
+	for _, action := range dryRun.plannedActions() {
+		recorded = append(recorded, action.String())
+	}
+	expected := []string{
+		"[secondary] delete PersistentVolume/pv-dangling",
+		"[secondary] create PersistentVolume/pv-mirrored",
+	}
+	if !reflect.DeepEqual(recorded, expected) {
+		t.Errorf("expected planned actions %v, got %v", expected, recorded)
+	}
+}
+
+func TestGetMirrorStatuses(t *testing.T) {
+	otherPoolPV := newRBDPV("pv-other-pool", "shop", "cache", "img-other", corev1.VolumeBound)
+	otherPoolPV.Spec.CSI.VolumeAttributes["pool"] = "otherpool"
+	brokenPoolPV := newRBDPV("pv-broken-pool", "shop", "tmp", "img-broken", corev1.VolumeBound)
+	brokenPoolPV.Spec.CSI.VolumeAttributes["pool"] = "brokenpool"
+	nfsPV := newRBDPV("pv-nfs", "files", "data", "", corev1.VolumeBound)
+	nfsPV.Spec.CSI = nil
+	pvs := []corev1.PersistentVolume{
+		*newRBDPV("pv-mirrored", "shop", "data", "img-mirrored", corev1.VolumeBound),
+		*newRBDPV("pv-syncing", "shop", "logs", "img-syncing", corev1.VolumeBound),
+		*newRBDPV("pv-local", "shop", "local", "img-local", corev1.VolumeBound),
+		*otherPoolPV,
+		*brokenPoolPV,
+		*nfsPV,
+	}
+	cluster, toolbox := newFakeCluster(t, "primary")
+	toolbox.mirrored["replicapool/img-mirrored"] = "up+stopped"
+	toolbox.mirrored["replicapool/img-syncing"] = "up+syncing"
+	toolbox.failing["rbd mirror pool status brokenpool --verbose --format json"] = rbdExitTimedOut
+
+	statuses, err := getMirrorStatuses(cluster, pvs)
+	if err == nil {
+		t.Error("expected the error of the broken pool")
+	}
+	states := make(map[string]string)
+	for pvName, status := range statuses {
+		states[pvName] = status.State
+	}
+	expected := map[string]string{
+		"pv-mirrored":   "up+stopped",
+		"pv-syncing":    "up+syncing",
+		"pv-local":      rbdMirrorStateDisabled,
+		"pv-other-pool": rbdMirrorStateDisabled,
+	}
+	if !reflect.DeepEqual(states, expected) {
+		t.Errorf("expected states %v, got %v", expected, states)
+	}
+	if len(toolbox.commands) != 3 {
+		t.Errorf("expected one command per pool, got %v", toolbox.commands)
+	}
+}
@@ -0,0 +1,385 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	utilexec "k8s.io/client-go/util/exec"
+)
+
+// Exit codes of the rbd CLI, rbd exits with the errno of the failed operation
+const (
+	rbdExitNotPermitted = 1
+	rbdExitNotFound     = 2
+	rbdExitBusy         = 16
+	rbdExitExists       = 17
+	rbdExitInvalid      = 22
+	rbdExitReadOnly     = 30
+	rbdExitTimedOut     = 110
+)
+
+// rbdMirrorStateDisabled is shown for images that do not have mirroring enabled
+const rbdMirrorStateDisabled = "disabled"
+
+// rbdError is a failed rbd command, classified by its exit code
+type rbdError struct {
+	Command  string
+	ExitCode int
+	Stderr   string
+	err      error
+}
+
+func (e *rbdError) Error() string {
+	reason := strings.TrimSpace(e.Stderr)
+	if reason == "" {
+		reason = e.err.Error()
+	}
+	return fmt.Sprintf("'%s' failed with exit code %d (%s): %s", e.Command, e.ExitCode, e.reason(), reason)
+}
+
+func (e *rbdError) Cause() error {
+	return e.err
+}
+
+func (e *rbdError) Unwrap() error {
+	return e.err
+}
+
+func (e *rbdError) reason() string {
+	switch e.ExitCode {
+	case rbdExitNotPermitted:
+		return "not permitted"
+	case rbdExitNotFound:
+		return "image or pool not found"
+	case rbdExitBusy:
+		return "image is busy or still primary in the peer cluster"
+	case rbdExitExists:
+		return "already exists"
+	case rbdExitInvalid:
+		return "mirroring not enabled or invalid argument"
+	case rbdExitReadOnly:
+		return "image is not primary"
+	case rbdExitTimedOut:
+		return "timed out"
+	}
+	return "unknown"
+}
+
+func rbdExitCode(err error) int {
+	var rbdErr *rbdError
+	if errors.As(err, &rbdErr) {
+		return rbdErr.ExitCode
+	}
+	return -1
+}
+
+// isRBDMirroringDisabled returns true if the command failed because mirroring is not enabled on the image
+func isRBDMirroringDisabled(err error) bool {
+	return rbdExitCode(err) == rbdExitInvalid
+}
+
+func isRBDNotFound(err error) bool {
+	return rbdExitCode(err) == rbdExitNotFound
+}
+
+// isRBDBusy returns true if the image is in use, e.g. a promotion without force while the peer image is still primary
+func isRBDBusy(err error) bool {
+	return rbdExitCode(err) == rbdExitBusy
+}
+
+// rbdMirrorImageStatus is the output of "rbd mirror image status --format json"
+type rbdMirrorImageStatus struct {
+	Name        string              `json:"name"`
+	GlobalID    string              `json:"global_id"`
+	State       string              `json:"state"`
+	Description string              `json:"description"`
+	LastUpdate  string              `json:"last_update"`
+	PeerSites   []rbdPeerSiteStatus `json:"peer_sites"`
+}
+
+type rbdPeerSiteStatus struct {
+	SiteName    string `json:"site_name"`
+	MirrorUUIDs string `json:"mirror_uuids"`
+	State       string `json:"state"`
+	Description string `json:"description"`
+	LastUpdate  string `json:"last_update"`
+}
+
+// displayState returns the state as shown to users, like up+replaying or split-brain
+func (s rbdMirrorImageStatus) displayState() string {
+	if strings.Contains(s.Description, "split-brain") {
+		return "split-brain"
+	}
+	for _, peer := range s.PeerSites {
+		if strings.Contains(peer.Description, "split-brain") {
+			return "split-brain"
+		}
+	}
+	if s.State == "" {
+		return "unknown"
+	}
+	return s.State
+}
+
+// journalReplayStatus is the JSON part of the description of journal based mirroring, like
+// replaying, {"bytes_per_second":0.0,"entries_behind_primary":3,"entries_per_second":0.0,...}
+type journalReplayStatus struct {
+	EntriesBehindPrimary *int64 `json:"entries_behind_primary"`
+}
+
+// entriesBehindPattern matches the description of journal based mirroring before Ceph Octopus
+var entriesBehindPattern = regexp.MustCompile(`entries_behind_(?:master|primary)=([0-9]+)`)
+
+// journalEntriesBehind returns how many journal entries the non-primary image still has to replay.
+// ok is false if the image does not use journal based mirroring or is not replaying.
+func (s rbdMirrorImageStatus) journalEntriesBehind() (entries int64, ok bool) {
+	descriptions := []string{s.Description}
+	for _, peer := range s.PeerSites {
+		descriptions = append(descriptions, peer.Description)
+	}
+	for _, description := range descriptions {
+		if behind, found := parseJournalEntriesBehind(description); found && (!ok || behind > entries) {
+			entries, ok = behind, true
+		}
+	}
+	return entries, ok
+}
+
+func parseJournalEntriesBehind(description string) (int64, bool) {
+	if match := entriesBehindPattern.FindStringSubmatch(description); match != nil {
+		entries, err := strconv.ParseInt(match[1], 10, 64)
+		return entries, err == nil
+	}
+	start := strings.Index(description, "{")
+	if start < 0 {
+		return 0, false
+	}
+	var status journalReplayStatus
+	if err := json.Unmarshal([]byte(description[start:]), &status); err != nil || status.EntriesBehindPrimary == nil {
+		return 0, false
+	}
+	return *status.EntriesBehindPrimary, true
+}
+
+// rbdPoolStatus is the output of "rbd mirror pool status --verbose --format json"
+type rbdPoolStatus struct {
+	Summary map[string]interface{} `json:"summary"`
+	Images  []rbdMirrorImageStatus `json:"images"`
+}
+
+// rbdImageInfo is the output of "rbd info --format json"
+type rbdImageInfo struct {
+	Name            string             `json:"name"`
+	ID              string             `json:"id"`
+	Size            uint64             `json:"size"`
+	Objects         uint64             `json:"objects"`
+	Format          int                `json:"format"`
+	Features        []string           `json:"features"`
+	CreateTimestamp string             `json:"create_timestamp"`
+	Mirroring       *rbdImageMirroring `json:"mirroring,omitempty"`
+}
+
+type rbdImageMirroring struct {
+	Mode     string `json:"mode"`
+	State    string `json:"state"`
+	GlobalID string `json:"global_id"`
+	Primary  bool   `json:"primary"`
+}
+
+// rbdSnapshotSchedule is one entry of "rbd mirror snapshot schedule ls --format json"
+type rbdSnapshotSchedule struct {
+	Interval  string `json:"interval"`
+	StartTime string `json:"start_time"`
+}
+
+// rbdSnapshotScheduleLevel is one entry of "rbd mirror snapshot schedule ls --recursive --format json",
+// Image is empty for the schedules of the pool
+type rbdSnapshotScheduleLevel struct {
+	Pool      string                `json:"pool"`
+	Namespace string                `json:"namespace"`
+	Image     string                `json:"image"`
+	Items     []rbdSnapshotSchedule `json:"items"`
+}
+
+// rbdScheduleStatus is the output of "rbd mirror snapshot schedule status --format json"
+type rbdScheduleStatus struct {
+	ScheduledImages []rbdScheduledImage `json:"scheduled_images"`
+}
+
+type rbdScheduledImage struct {
+	// Image is pool/image
+	Image        string `json:"image"`
+	ScheduleTime string `json:"schedule_time"`
+}
+
+// rbdClient runs rbd commands in the toolbox of a cluster.
+// Commands that change the Ceph state are only recorded during a dry run.
+type rbdClient struct {
+	cluster kubeAccess
+}
+
+func newRBD(cluster kubeAccess) rbdClient {
+	return rbdClient{cluster: cluster}
+}
+
+// run executes rbd with the arguments, the exit code decides about success.
+// Output on stderr is only logged, since rbd also prints warnings there.
+func (r rbdClient) run(args ...string) (string, error) {
+	command := "rbd " + strings.Join(args, " ")
+	stdout, stderr, err := r.cluster.toolbox.run(command)
+	if err != nil {
+		var exitErr utilexec.ExitError
+		if errors.As(err, &exitErr) {
+			return stdout, &rbdError{Command: command, ExitCode: exitErr.ExitStatus(), Stderr: stderr, err: err}
+		}
+		return stdout, errors.WithMessagef(err, "[%s] Could not run '%s'", r.cluster.name, command)
+	}
+	if stderr != "" {
+		log.WithField("command", command).WithField("stderr", stderr).Debugf("[%s] rbd printed warnings", r.cluster.name)
+	}
+	return stdout, nil
+}
+
+// runJSON executes rbd with JSON output and decodes it into target
+func (r rbdClient) runJSON(target interface{}, args ...string) error {
+	stdout, err := r.run(append(args, "--format", "json")...)
+	if err != nil {
+		return err
+	}
+	if err = json.Unmarshal([]byte(stdout), target); err != nil {
+		return errors.Wrapf(err, "[%s] Could not parse the output of rbd %s", r.cluster.name, strings.Join(args, " "))
+	}
+	return nil
+}
+
+// change executes a command that changes the Ceph state
+func (r rbdClient) change(args ...string) error {
+	if dryRun.intercept(r.cluster, "exec", "rook-ceph-tools", "rbd "+strings.Join(args, " ")) {
+		return nil
+	}
+	_, err := r.run(args...)
+	return err
+}
+
+func imageSpec(pool, image string) string {
+	return pool + "/" + image
+}
+
+// MirrorImageStatus returns the mirror status of an image, isRBDMirroringDisabled(err) is true if mirroring is not enabled
+func (r rbdClient) MirrorImageStatus(pool, image string) (*rbdMirrorImageStatus, error) {
+	var status rbdMirrorImageStatus
+	if err := r.runJSON(&status, "mirror", "image", "status", imageSpec(pool, image)); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// MirrorPoolStatus returns the mirror status of the pool and all its mirrored images
+func (r rbdClient) MirrorPoolStatus(pool string) (*rbdPoolStatus, error) {
+	var status rbdPoolStatus
+	if err := r.runJSON(&status, "mirror", "pool", "status", pool, "--verbose"); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+func (r rbdClient) Info(pool, image string) (*rbdImageInfo, error) {
+	var info rbdImageInfo
+	if err := r.runJSON(&info, "info", imageSpec(pool, image)); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// EnableMirroring enables mirroring on the image, mode is snapshot or journal
+func (r rbdClient) EnableMirroring(pool, image, mode string) error {
+	return r.change("mirror", "image", "enable", imageSpec(pool, image), mode)
+}
+
+func (r rbdClient) DisableMirroring(pool, image string) error {
+	return r.change("mirror", "image", "disable", imageSpec(pool, image))
+}
+
+// EnableFeatures enables image features like exclusive-lock and journaling
+func (r rbdClient) EnableFeatures(pool, image string, features ...string) error {
+	return r.change(append([]string{"feature", "enable", imageSpec(pool, image)}, features...)...)
+}
+
+func (r rbdClient) DisableFeatures(pool, image string, features ...string) error {
+	return r.change(append([]string{"feature", "disable", imageSpec(pool, image)}, features...)...)
+}
+
+// Promote makes the image primary. Without force, this fails with isRBDBusy(err) while the peer image is still primary.
+func (r rbdClient) Promote(pool, image string, force bool) error {
+	args := []string{"mirror", "image", "promote", imageSpec(pool, image)}
+	if force {
+		args = append(args, "--force")
+	}
+	return r.change(args...)
+}
+
+func (r rbdClient) Demote(pool, image string) error {
+	return r.change("mirror", "image", "demote", imageSpec(pool, image))
+}
+
+// Resync flags a non-primary image to be resynced from the primary, e.g. after a split-brain
+func (r rbdClient) Resync(pool, image string) error {
+	return r.change("mirror", "image", "resync", imageSpec(pool, image))
+}
+
+// SnapshotSchedule returns the mirror snapshot schedules of the pool, or of the image if one is given
+func (r rbdClient) SnapshotSchedule(pool, image string) ([]rbdSnapshotSchedule, error) {
+	args := []string{"mirror", "snapshot", "schedule", "ls", "--pool", pool}
+	if image != "" {
+		args = append(args, "--image", image)
+	}
+	var schedules []rbdSnapshotSchedule
+	if err := r.runJSON(&schedules, args...); err != nil {
+		return nil, err
+	}
+	return schedules, nil
+}
+
+// SnapshotSchedulesRecursive returns the mirror snapshot schedules of the pool and all its images
+func (r rbdClient) SnapshotSchedulesRecursive(pool string) ([]rbdSnapshotScheduleLevel, error) {
+	var levels []rbdSnapshotScheduleLevel
+	if err := r.runJSON(&levels, "mirror", "snapshot", "schedule", "ls", "--pool", pool, "--recursive"); err != nil {
+		return nil, err
+	}
+	return levels, nil
+}
+
+// SnapshotScheduleStatus returns when the next mirror snapshot of each scheduled image of the pool is taken
+func (r rbdClient) SnapshotScheduleStatus(pool string) (*rbdScheduleStatus, error) {
+	var status rbdScheduleStatus
+	if err := r.runJSON(&status, "mirror", "snapshot", "schedule", "status", "--pool", pool); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+func scheduleArgs(action, pool, image, interval, startTime string) []string {
+	args := []string{"mirror", "snapshot", "schedule", action, "--pool", pool}
+	if image != "" {
+		args = append(args, "--image", image)
+	}
+	args = append(args, interval)
+	if startTime != "" {
+		args = append(args, startTime)
+	}
+	return args
+}
+
+// AddSnapshotSchedule adds a mirror snapshot schedule to the image, or to the pool if image is empty
+func (r rbdClient) AddSnapshotSchedule(pool, image, interval, startTime string) error {
+	return r.change(scheduleArgs("add", pool, image, interval, startTime)...)
+}
+
+// RemoveSnapshotSchedule removes a mirror snapshot schedule, startTime must match the one it was added with
+func (r rbdClient) RemoveSnapshotSchedule(pool, image, interval, startTime string) error {
+	return r.change(scheduleArgs("remove", pool, image, interval, startTime)...)
+}
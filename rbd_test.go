@@ -0,0 +1,202 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+// scriptedToolbox returns fixed answers for the given commands
+type scriptedToolbox map[string]struct {
+	stdout, stderr string
+	err            error
+}
+
+func (s scriptedToolbox) run(command string) (string, string, error) {
+	answer, known := s[command]
+	if !known {
+		return "", "", errors.Errorf("unexpected command %s", command)
+	}
+	return answer.stdout, answer.stderr, answer.err
+}
+
+func TestRBDMirrorImageStatus(t *testing.T) {
+	toolbox := scriptedToolbox{
+		"rbd mirror image status replicapool/csi-vol-1 --format json": {
+			stdout: `{"name":"csi-vol-1","global_id":"4b5e7f5a","state":"up+stopped","description":"local image is primary","last_update":"2021-04-26 10:00:00",` +
+				`"peer_sites":[{"site_name":"b0f3-secondary","mirror_uuids":"8c2d","state":"up+replaying","description":"replaying, {\"local_snapshot_timestamp\":1619431200}","last_update":"2021-04-26 10:00:02"}]}`,
+		},
+		"rbd mirror image status replicapool/csi-vol-2 --format json": {
+			stdout: `{"name":"csi-vol-2","state":"up+error","description":"split-brain detected","peer_sites":[]}`,
+		},
+		"rbd mirror image status replicapool/csi-vol-3 --format json": {
+			stderr: "rbd: mirroring not enabled on the image",
+			err:    exitWith(rbdExitInvalid),
+		},
+		"rbd mirror image status replicapool/csi-vol-4 --format json": {
+			stderr: "rbd: error opening image csi-vol-4: (2) No such file or directory",
+			err:    exitWith(rbdExitNotFound),
+		},
+	}
+	rbd := newRBD(kubeAccess{name: "primary", toolbox: toolbox})
+
+	status, err := rbd.MirrorImageStatus("replicapool", "csi-vol-1")
+	if err != nil {
+		t.Fatalf("MirrorImageStatus failed: %s", err)
+	}
+	if status.displayState() != "up+stopped" || len(status.PeerSites) != 1 || status.PeerSites[0].State != "up+replaying" {
+		t.Errorf("unexpected status %+v", status)
+	}
+
+	status, err = rbd.MirrorImageStatus("replicapool", "csi-vol-2")
+	if err != nil {
+		t.Fatalf("MirrorImageStatus failed: %s", err)
+	}
+	if status.displayState() != "split-brain" {
+		t.Errorf("expected split-brain, got %s", status.displayState())
+	}
+
+	_, err = rbd.MirrorImageStatus("replicapool", "csi-vol-3")
+	if !isRBDMirroringDisabled(err) || isRBDNotFound(err) {
+		t.Errorf("expected mirroring disabled error, got %v", err)
+	}
+
+	_, err = rbd.MirrorImageStatus("replicapool", "csi-vol-4")
+	if !isRBDNotFound(err) || isRBDMirroringDisabled(err) {
+		t.Errorf("expected not found error, got %v", err)
+	}
+}
+
+func TestRBDErrorsWithoutExitCode(t *testing.T) {
+	toolbox := scriptedToolbox{
+		"rbd mirror image demote replicapool/csi-vol-1": {err: errors.New("connection refused")},
+	}
+	err := newRBD(kubeAccess{name: "primary", toolbox: toolbox}).Demote("replicapool", "csi-vol-1")
+	if err == nil || rbdExitCode(err) != -1 {
+		t.Errorf("expected an unclassified error, got %v", err)
+	}
+}
+
+func TestRBDInfoAndSnapshotSchedule(t *testing.T) {
+	toolbox := scriptedToolbox{
+		"rbd info replicapool/csi-vol-1 --format json": {
+			stdout: `{"name":"csi-vol-1","id":"10a5","size":1073741824,"objects":256,"order":22,"format":2,"features":["layering","exclusive-lock"],` +
+				`"create_timestamp":"Mon Apr 26 10:00:00 2021","mirroring":{"mode":"snapshot","state":"enabled","global_id":"4b5e7f5a","primary":true}}`,
+			stderr: "2021-04-26T10:00:00.000+0000 7f warning: some unrelated warning",
+		},
+		"rbd mirror snapshot schedule ls --pool replicapool --format json": {
+			stdout: `[{"interval":"1h","start_time":""}]`,
+		},
+	}
+	rbd := newRBD(kubeAccess{name: "primary", toolbox: toolbox})
+
+	info, err := rbd.Info("replicapool", "csi-vol-1")
+	if err != nil {
+		t.Fatalf("Info failed: %s", err)
+	}
+	if info.Size != 1073741824 || info.Mirroring == nil || !info.Mirroring.Primary || info.Mirroring.Mode != "snapshot" {
+		t.Errorf("unexpected info %+v", info)
+	}
+
+	schedules, err := rbd.SnapshotSchedule("replicapool", "")
+	if err != nil {
+		t.Fatalf("SnapshotSchedule failed: %s", err)
+	}
+	if !reflect.DeepEqual(schedules, []rbdSnapshotSchedule{{Interval: "1h"}}) {
+		t.Errorf("unexpected schedules %+v", schedules)
+	}
+}
+
+func TestRBDSnapshotSchedules(t *testing.T) {
+	toolbox := scriptedToolbox{
+		"rbd mirror snapshot schedule ls --pool replicapool --recursive --format json": {
+			stdout: `[{"pool":"replicapool","namespace":"","image":"","items":[{"interval":"1h","start_time":""}]},` +
+				`{"pool":"replicapool","namespace":"","image":"csi-vol-1","items":[{"interval":"5m","start_time":"14:00:00-05:00"}]}]`,
+		},
+		"rbd mirror snapshot schedule status --pool replicapool --format json": {
+			stdout: `{"scheduled_images":[{"schedule_time":"2021-04-26 11:00:00","image":"replicapool/csi-vol-1"}]}`,
+		},
+		"rbd mirror snapshot schedule add --pool replicapool --image csi-vol-1 1d 02:00:00": {},
+		"rbd mirror snapshot schedule remove --pool replicapool 1h":                         {},
+	}
+	rbd := newRBD(kubeAccess{name: "primary", toolbox: toolbox})
+
+	levels, err := rbd.SnapshotSchedulesRecursive("replicapool")
+	if err != nil {
+		t.Fatalf("SnapshotSchedulesRecursive failed: %s", err)
+	}
+	if len(levels) != 2 || levels[0].Image != "" || levels[1].Image != "csi-vol-1" || levels[1].Items[0].StartTime != "14:00:00-05:00" {
+		t.Errorf("unexpected schedules %+v", levels)
+	}
+	status, err := rbd.SnapshotScheduleStatus("replicapool")
+	if err != nil {
+		t.Fatalf("SnapshotScheduleStatus failed: %s", err)
+	}
+	if expected := []rbdScheduledImage{{Image: "replicapool/csi-vol-1", ScheduleTime: "2021-04-26 11:00:00"}}; !reflect.DeepEqual(status.ScheduledImages, expected) {
+		t.Errorf("unexpected status %+v", status)
+	}
+	if err := rbd.AddSnapshotSchedule("replicapool", "csi-vol-1", "1d", "02:00:00"); err != nil {
+		t.Errorf("AddSnapshotSchedule failed: %s", err)
+	}
+	if err := rbd.RemoveSnapshotSchedule("replicapool", "", "1h", ""); err != nil {
+		t.Errorf("RemoveSnapshotSchedule failed: %s", err)
+	}
+}
+
+func TestRBDChangesDuringDryRun(t *testing.T) {
+	dryRun.enabled = true
+	dryRun.start()
+	defer func() { dryRun.enabled = false }()
+
+	// The empty toolbox fails every command, so nothing must be executed
+	rbd := newRBD(kubeAccess{name: "secondary", toolbox: scriptedToolbox{}})
+	if err := rbd.Promote("replicapool", "csi-vol-1", true); err != nil {
+		t.Fatalf("Promote failed: %s", err)
+	}
+	if err := rbd.Resync("replicapool", "csi-vol-2"); err != nil {
+		t.Fatalf("Resync failed: %s", err)
+	}
+	expected := []plannedAction{
+		{Cluster: "secondary", Verb: "exec", Target: "rook-ceph-tools", Payload: "rbd mirror image promote replicapool/csi-vol-1 --force"},
+		{Cluster: "secondary", Verb: "exec", Target: "rook-ceph-tools", Payload: "rbd mirror image resync replicapool/csi-vol-2"},
+	}
+	if actions := dryRun.plannedActions(); !reflect.DeepEqual(actions, expected) {
+		t.Errorf("expected planned actions %+v, got %+v", expected, actions)
+	}
+}
+
+func TestJournalEntriesBehind(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  rbdMirrorImageStatus
+		entries int64
+		journal bool
+	}{
+		{
+			name: "replaying non-primary image",
+			status: rbdMirrorImageStatus{State: "up+replaying", Description: `replaying, {"bytes_per_second":512.0,"entries_behind_primary":12,"entries_per_second":2.5,` +
+				`"non_primary_position":{"entry_tid":3,"object_number":3,"tag_tid":1},"primary_position":{"entry_tid":15,"object_number":3,"tag_tid":1}}`},
+			entries: 12,
+			journal: true,
+		},
+		{
+			name: "primary image with a replaying peer before Octopus",
+			status: rbdMirrorImageStatus{State: "up+stopped", Description: "local image is primary", PeerSites: []rbdPeerSiteStatus{
+				{State: "up+replaying", Description: "replaying, master_position=[object_number=3, tag_tid=1, entry_tid=15], mirror_position=[object_number=3, tag_tid=1, entry_tid=3], entries_behind_master=12"},
+			}},
+			entries: 12,
+			journal: true,
+		},
+		{
+			name:   "snapshot based mirroring",
+			status: rbdMirrorImageStatus{State: "up+replaying", Description: `replaying, {"bytes_per_second":0.0,"local_snapshot_timestamp":1619431200,"remote_snapshot_timestamp":1619431200,"replay_state":"idle"}`},
+		},
+	}
+	for _, test := range tests {
+		entries, journal := test.status.journalEntriesBehind()
+		if entries != test.entries || journal != test.journal {
+			t.Errorf("%s: expected %d entries (journal %t), got %d (journal %t)", test.name, test.entries, test.journal, entries, journal)
+		}
+	}
+}
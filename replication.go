@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// replicationAuto picks the replication backend from the OCS/ODF release of the cluster
+const replicationAuto = "auto"
+
+// replicationBackend enables, disables, promotes and demotes the mirroring of the images or directories of PVs
+type replicationBackend interface {
+	name() string
+	// enable mirrors the image in the given mode, an empty mode uses the mode configured for the pool of the PV
+	enable(ctx context.Context, pv *corev1.PersistentVolume, mode string) error
+	disable(ctx context.Context, pv *corev1.PersistentVolume) error
+	// promote makes the image primary, force is required if the peer cluster is not reachable to demote it
+	promote(ctx context.Context, pv *corev1.PersistentVolume, force bool) error
+	demote(ctx context.Context, pv *corev1.PersistentVolume) error
+	// resync flags a non-primary image to be resynced from the primary, e.g. after a split-brain
+	resync(ctx context.Context, pv *corev1.PersistentVolume) error
+}
+
+func validateReplication(replication string) error {
+	switch replication {
+	case "", replicationAuto, replicationToolbox, replicationVolumeReplication:
+		return nil
+	}
+	return errors.Errorf("unknown replication backend %q, use auto, %s or %s", replication, replicationToolbox, replicationVolumeReplication)
+}
+
+// replicationFor returns the configured replication backend of the cluster.
+// With auto, VolumeReplication is used for the releases that support it.
+// The toolbox is used if the VolumeReplication API is not available in the cluster.
+// CephFS PVs are always mirrored with cephfs-mirror.
+func replicationFor(ctx context.Context, cluster kubeAccess) replicationBackend {
+	return cephPVReplication{rbd: rbdReplicationFor(ctx, cluster), cephfs: cephfsReplication{cluster: cluster}}
+}
+
+func rbdReplicationFor(ctx context.Context, cluster kubeAccess) replicationBackend {
+	toolbox := toolboxReplication{cluster: cluster}
+	switch appConfig.Replication {
+	case replicationToolbox:
+		return toolbox
+	case replicationVolumeReplication:
+	default:
+		profile, err := detectODFProfile(cluster)
+		if err != nil || profile.replication != replicationVolumeReplication {
+			return toolbox
+		}
+	}
+	if err := checkVolumeReplicationAPI(ctx, cluster); err != nil {
+		log.WithError(err).Warnf("[%s] The VolumeReplication API is not available, using rbd in the toolbox", cluster.name)
+		return toolbox
+	}
+	return volumeReplication{cluster: cluster, fallback: toolbox}
+}
+
+// setMirrorStatus enables or disables mirroring of the image of the PV
+func setMirrorStatus(ctx context.Context, backend replicationBackend, pv *corev1.PersistentVolume, enable bool, mode string) error {
+	if enable {
+		return backend.enable(ctx, pv, mode)
+	}
+	return backend.disable(ctx, pv)
+}
+
+// toolboxReplication runs rbd commands in the rook-ceph-tools Pod
+type toolboxReplication struct {
+	cluster kubeAccess
+}
+
+func (t toolboxReplication) name() string {
+	return replicationToolbox
+}
+
+// enable enables the image features that journal based mirroring needs first
+func (t toolboxReplication) enable(_ context.Context, pv *corev1.PersistentVolume, mode string) error {
+	rbdName, poolName, err := getRBDInfoFromPV(pv)
+	if err != nil {
+		return err
+	}
+	if mode == "" {
+		mode = mirroringModeFor(poolName)
+	}
+	if err = validateMirroringMode(mode); err != nil {
+		return err
+	}
+	rbd := newRBD(t.cluster)
+	if mode == mirroringModeJournal {
+		if err = enableJournaling(rbd, pv, poolName, rbdName); err != nil {
+			return err
+		}
+	}
+	if err = rbd.EnableMirroring(poolName, rbdName, mode); err != nil {
+		return errors.WithMessagef(err, "could not change RBD mirror status of PV %s", pv.Name)
+	}
+	return nil
+}
+
+// disable removes the journaling feature again, if the image was mirrored in journal mode
+func (t toolboxReplication) disable(_ context.Context, pv *corev1.PersistentVolume) error {
+	rbdName, poolName, err := getRBDInfoFromPV(pv)
+	if err != nil {
+		return err
+	}
+	rbd := newRBD(t.cluster)
+	info, infoErr := rbd.Info(poolName, rbdName)
+	err = rbd.DisableMirroring(poolName, rbdName)
+	if err == nil && infoErr == nil && info.Mirroring != nil && info.Mirroring.Mode == mirroringModeJournal {
+		err = disableJournaling(rbd, poolName, rbdName)
+	}
+	if err != nil {
+		return errors.WithMessagef(err, "could not change RBD mirror status of PV %s", pv.Name)
+	}
+	return nil
+}
+
+func (t toolboxReplication) promote(_ context.Context, pv *corev1.PersistentVolume, force bool) error {
+	rbdName, poolName, err := getRBDInfoFromPV(pv)
+	if err != nil {
+		return err
+	}
+	err = newRBD(t.cluster).Promote(poolName, rbdName, force)
+	if isRBDMirroringDisabled(err) {
+		return errors.WithMessagef(err, "mirroring is not enabled on PV %s", pv.Name)
+	}
+	return err
+}
+
+func (t toolboxReplication) demote(_ context.Context, pv *corev1.PersistentVolume) error {
+	rbdName, poolName, err := getRBDInfoFromPV(pv)
+	if err != nil {
+		return err
+	}
+	err = newRBD(t.cluster).Demote(poolName, rbdName)
+	if isRBDMirroringDisabled(err) {
+		return errors.WithMessagef(err, "mirroring is not enabled on PV %s", pv.Name)
+	}
+	return err
+}
+
+func (t toolboxReplication) resync(_ context.Context, pv *corev1.PersistentVolume) error {
+	rbdName, poolName, err := getRBDInfoFromPV(pv)
+	if err != nil {
+		return err
+	}
+	err = newRBD(t.cluster).Resync(poolName, rbdName)
+	if isRBDMirroringDisabled(err) {
+		return errors.WithMessagef(err, "mirroring is not enabled on PV %s", pv.Name)
+	}
+	return err
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/rivo/tview"
+)
+
+// clusterConfig is a named cluster of a setup with more than two clusters
+type clusterConfig struct {
+	Name           string `yaml:"name"`
+	KubeConfigPath string `yaml:"kubeConfigPath"`
+	// StorageNamespace overrides the detected namespace of the Ceph cluster, e.g. for Rook outside of rook-ceph
+	StorageNamespace string `yaml:"storageNamespace,omitempty"`
+}
+
+// peeringConfig is a pair of clusters that mirror to each other
+type peeringConfig struct {
+	Primary   string `yaml:"primary"`
+	Secondary string `yaml:"secondary"`
+}
+
+// sites are the reachable clusters of appConfig.Clusters, in the order of the config
+var sites []kubeAccess
+
+// namedSites returns true if the clusters are configured by name instead of the primary and secondary kubeconfig paths
+func namedSites() bool {
+	return len(appConfig.Clusters) > 0
+}
+
+// loadSites connects to the clusters of the config and selects the first peering as primary and secondary
+func loadSites() error {
+	sites = nil
+	if !namedSites() {
+		return nil
+	}
+	if err := validateSites(); err != nil {
+		return err
+	}
+	for _, cluster := range appConfig.Clusters {
+		access, err := validateKubeConfig(cluster.KubeConfigPath)
+		if err != nil {
+			log.WithError(err).Warnf("[%s] Skipping the cluster, its kubeconfig is not valid", cluster.Name)
+			continue
+		}
+		access.name = cluster.Name
+		access.detectStorage(cluster.StorageNamespace)
+		sites = append(sites, access)
+	}
+	return selectFirstPeering()
+}
+
+// selectFirstPeering selects the first peering of reachable clusters as primary and secondary,
+// peerings with a cluster that could not be loaded are skipped
+func selectFirstPeering() error {
+	for _, peering := range appConfig.Peerings {
+		if !peeringLoaded(peering) {
+			log.Warnf("Skipping the peering %s - %s, not both clusters are reachable", peering.Primary, peering.Secondary)
+		}
+	}
+	peerings := sitePeerings()
+	if len(peerings) == 0 {
+		return errors.New("at least one peering of two reachable clusters is needed")
+	}
+	return selectSites(peerings[0].Primary, peerings[0].Secondary)
+}
+
+// peeringLoaded returns true if both clusters of the peering are reachable
+func peeringLoaded(peering peeringConfig) bool {
+	_, primaryErr := siteByName(peering.Primary)
+	_, secondaryErr := siteByName(peering.Secondary)
+	return primaryErr == nil && secondaryErr == nil
+}
+
+// validateSites makes sure that the cluster names are unique and that the peerings only use configured clusters
+func validateSites() error {
+	names := make(map[string]bool)
+	for _, cluster := range appConfig.Clusters {
+		if cluster.Name == "" {
+			return errors.Errorf("the cluster with the kubeconfig %s has no name", cluster.KubeConfigPath)
+		}
+		if names[cluster.Name] {
+			return errors.Errorf("the cluster name %s is used more than once", cluster.Name)
+		}
+		names[cluster.Name] = true
+	}
+	for _, peering := range appConfig.Peerings {
+		if !names[peering.Primary] || !names[peering.Secondary] {
+			return errors.Errorf("the peering %s - %s uses an unknown cluster", peering.Primary, peering.Secondary)
+		}
+		if peering.Primary == peering.Secondary {
+			return errors.Errorf("the cluster %s can not be peered with itself", peering.Primary)
+		}
+	}
+	return nil
+}
+
+// allSites returns all clusters, the primary and the secondary cluster if they are not configured by name
+func allSites() []kubeAccess {
+	if namedSites() {
+		return sites
+	}
+	var clusters []kubeAccess
+	for _, cluster := range []kubeAccess{kubeConfigPrimary, kubeConfigSecondary} {
+		if cluster.name != "" {
+			clusters = append(clusters, cluster)
+		}
+	}
+	return clusters
+}
+
+// siteNames returns the names of all clusters
+func siteNames() []string {
+	var names []string
+	for _, cluster := range allSites() {
+		names = append(names, cluster.name)
+	}
+	return names
+}
+
+// siteByName returns the cluster with the name
+func siteByName(name string) (kubeAccess, error) {
+	for _, cluster := range allSites() {
+		if cluster.name == name {
+			return cluster, nil
+		}
+	}
+	return kubeAccess{}, errors.Errorf("unknown cluster %q, use one of %s", name, strings.Join(siteNames(), ", "))
+}
+
+// sitePeerings returns the configured peerings of reachable clusters. Without peerings in the config, the first
+// cluster is peered with all others.
+func sitePeerings() []peeringConfig {
+	if len(appConfig.Peerings) > 0 {
+		var peerings []peeringConfig
+		for _, peering := range appConfig.Peerings {
+			if peeringLoaded(peering) {
+				peerings = append(peerings, peering)
+			}
+		}
+		return peerings
+	}
+	clusters := allSites()
+	var peerings []peeringConfig
+	for i := 1; i < len(clusters); i++ {
+		peerings = append(peerings, peeringConfig{Primary: clusters[0].name, Secondary: clusters[i].name})
+	}
+	return peerings
+}
+
+// arePeered returns true if the clusters mirror to each other, the primary and secondary cluster always do
+func arePeered(a, b string) bool {
+	if !namedSites() {
+		return a != b
+	}
+	for _, peering := range sitePeerings() {
+		if (peering.Primary == a && peering.Secondary == b) || (peering.Primary == b && peering.Secondary == a) {
+			return true
+		}
+	}
+	return false
+}
+
+// peerTargets returns the to cluster and all other reachable peers of the from cluster. Images of the from cluster
+// are mirrored to all peers of its pool, so their PVs are synced to all of them.
+func peerTargets(from, to kubeAccess) []kubeAccess {
+	targets := []kubeAccess{to}
+	for _, cluster := range allSites() {
+		if cluster.name != from.name && cluster.name != to.name && arePeered(from.name, cluster.name) {
+			targets = append(targets, cluster)
+		}
+	}
+	return targets
+}
+
+// selectSites makes the source cluster the primary and the target cluster the secondary cluster of the menus and commands
+func selectSites(source, target string) error {
+	from, err := siteByName(source)
+	if err != nil {
+		return err
+	}
+	to, err := siteByName(target)
+	if err != nil {
+		return err
+	}
+	if !arePeered(source, target) {
+		return errors.Errorf("the clusters %s and %s are not peered, add them to the peerings in the config", source, target)
+	}
+	kubeConfigPrimary, kubeConfigSecondary = from, to
+	primaryLocation, secondaryLocation = siteLocation(from), siteLocation(to)
+	updateFrame()
+	return nil
+}
+
+// siteLocation returns the name and the API host of a named cluster, and only the API host otherwise
+func siteLocation(cluster kubeAccess) string {
+	location := cluster.name
+	if context, found := cluster.config.Contexts[cluster.config.CurrentContext]; found {
+		if server, found := cluster.config.Clusters[context.Cluster]; found {
+			if url, err := url.Parse(server.Server); err == nil {
+				location = strings.TrimPrefix(url.Hostname(), "api.")
+			}
+		}
+	}
+	if namedSites() {
+		return fmt.Sprintf("%s (%s)", cluster.name, location)
+	}
+	return location
+}
+
+// showSiteSelection lets the user choose the source and target cluster from the configured clusters
+func showSiteSelection() {
+	names := siteNames()
+	source, target := kubeConfigPrimary.name, kubeConfigSecondary.name
+	sourceIndex, _ := stringInSlice(source, names)
+	targetIndex, _ := stringInSlice(target, names)
+	form := tview.NewForm().
+		AddDropDown("Source (primary)", names, sourceIndex, func(option string, _ int) { source = option }).
+		AddDropDown("Target (secondary)", names, targetIndex, func(option string, _ int) { target = option })
+	back := func() {
+		pages.RemovePage("siteSelection")
+		pages.SwitchToPage("main")
+	}
+	form.
+		AddButton("Select", func() {
+			if err := selectSites(source, target); err != nil {
+				showAlert(err.Error())
+				return
+			}
+			back()
+		}).
+		AddButton("Go back", back).
+		SetCancelFunc(back)
+	form.SetBorder(true).
+		SetTitle("Select the clusters").SetTitleAlign(tview.AlignLeft)
+	pages.AddAndSwitchToPage("siteSelection", form, true)
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// useSites configures named clusters with fake clients, the config is restored when the test ends
+func useSites(t *testing.T, peerings []peeringConfig, names ...string) []kubeAccess {
+	t.Helper()
+	clusters, peers, configured := appConfig.Clusters, appConfig.Peerings, sites
+	primary, secondary := kubeConfigPrimary, kubeConfigSecondary
+	t.Cleanup(func() {
+		appConfig.Clusters, appConfig.Peerings, sites = clusters, peers, configured
+		kubeConfigPrimary, kubeConfigSecondary = primary, secondary
+	})
+	appConfig.Clusters, appConfig.Peerings, sites = nil, peerings, nil
+	for _, name := range names {
+		cluster, _ := newFakeCluster(t, name)
+		appConfig.Clusters = append(appConfig.Clusters, clusterConfig{Name: name, KubeConfigPath: "/kubeconfigs/" + name})
+		sites = append(sites, cluster)
+	}
+	return sites
+}
+
+func TestSitePeerings(t *testing.T) {
+	useSites(t, nil, "east", "west1", "west2")
+
+	expected := []peeringConfig{{Primary: "east", Secondary: "west1"}, {Primary: "east", Secondary: "west2"}}
+	if peerings := sitePeerings(); !reflect.DeepEqual(peerings, expected) {
+		t.Errorf("expected the first cluster to be peered with all others, got %v", peerings)
+	}
+	if !arePeered("west2", "east") || arePeered("west1", "west2") {
+		t.Error("expected only the first cluster to be peered with the others")
+	}
+	if err := selectSites("west1", "west2"); err == nil || !strings.Contains(err.Error(), "not peered") {
+		t.Errorf("expected the clusters that are not peered to be rejected, got %v", err)
+	}
+	if err := selectSites("west2", "east"); err != nil {
+		t.Fatal(err)
+	}
+	if kubeConfigPrimary.name != "west2" || kubeConfigSecondary.name != "east" {
+		t.Errorf("expected west2 and east to be selected, got %s and %s", kubeConfigPrimary.name, kubeConfigSecondary.name)
+	}
+
+	east, _ := siteByName("east")
+	west1, _ := siteByName("west1")
+	var targets []string
+	for _, target := range peerTargets(east, west1) {
+		targets = append(targets, target.name)
+	}
+	if !reflect.DeepEqual(targets, []string{"west1", "west2"}) {
+		t.Errorf("expected the PVs of east to be synced to both peers, got %v", targets)
+	}
+	if bootstrapSecretName("replicapool", east) != "mirror-bootstrap-replicapool-east" {
+		t.Errorf("expected the name of the cluster in the bootstrap secret, got %s", bootstrapSecretName("replicapool", east))
+	}
+
+	appConfig.Peerings = []peeringConfig{{Primary: "east", Secondary: "east"}}
+	if err := validateSites(); err == nil {
+		t.Error("expected a cluster peered with itself to be rejected")
+	}
+	appConfig.Peerings = []peeringConfig{{Primary: "east", Secondary: "north"}}
+	if err := validateSites(); err == nil || !strings.Contains(err.Error(), "unknown cluster") {
+		t.Errorf("expected the unknown cluster to be rejected, got %v", err)
+	}
+}
+
+func TestSitesWithUnreachableCluster(t *testing.T) {
+	useSites(t, []peeringConfig{{Primary: "north", Secondary: "east"}, {Primary: "east", Secondary: "west"}}, "east", "west")
+	// north is configured, but could not be loaded
+	appConfig.Clusters = append([]clusterConfig{{Name: "north", KubeConfigPath: "/kubeconfigs/north"}}, appConfig.Clusters...)
+
+	if err := selectFirstPeering(); err != nil {
+		t.Fatalf("expected the peering of the reachable clusters to be selected, got %s", err)
+	}
+	expected := []peeringConfig{{Primary: "east", Secondary: "west"}}
+	if peerings := sitePeerings(); !reflect.DeepEqual(peerings, expected) {
+		t.Errorf("expected only the peering of the reachable clusters, got %v", peerings)
+	}
+	if kubeConfigPrimary.name != "east" || kubeConfigSecondary.name != "west" {
+		t.Errorf("expected east and west to be selected, got %s and %s", kubeConfigPrimary.name, kubeConfigSecondary.name)
+	}
+	if arePeered("north", "east") {
+		t.Error("expected the unreachable cluster not to be peered")
+	}
+
+	appConfig.Peerings = []peeringConfig{{Primary: "north", Secondary: "east"}}
+	if err := selectFirstPeering(); err == nil {
+		t.Error("expected an error without a peering of reachable clusters")
+	}
+}
+
+func TestPeerChecksOfPeerings(t *testing.T) {
+	clusters := useSites(t, []peeringConfig{{Primary: "east", Secondary: "west1"}, {Primary: "west1", Secondary: "west2"}}, "east", "west1", "west2")
+
+	report := runChecks(context.Background(), phaseInstall, nil, clusters...)
+	var pairs []string
+	for _, result := range report.Results {
+		if result.Check == checkPodNetwork {
+			pairs = append(pairs, result.Cluster+"->"+strings.TrimSuffix(strings.TrimPrefix(result.Message, "The Pods of the "), " cluster are not reachable"))
+		}
+	}
+	expected := []string{"east->west1", "west1->east", "west1->west2", "west2->west1"}
+	if !reflect.DeepEqual(pairs, expected) {
+		t.Errorf("expected the peer checks %v, got %v", expected, pairs)
+	}
+}
+
+func TestRunInstallStepsPerPeer(t *testing.T) {
+	clusters := useSites(t, nil, "east", "west1", "west2")
+	east, west1, west2 := clusters[0], clusters[1], clusters[2]
+	ctx := context.Background()
+	ran := &stepLog{}
+	bootstrap := ran.step("bootstrap-secrets", "", nil)
+	bootstrap.perPeer = true
+	steps := []installStep{ran.step("toolbox", "", nil), bootstrap}
+
+	if err := runInstallSteps(ctx, steps, &east, &west1); err != nil {
+		t.Fatal(err)
+	}
+	ran.runs = nil
+	if err := runInstallSteps(ctx, steps, &east, &west2); err != nil {
+		t.Fatal(err)
+	}
+	// east already has the toolbox, but not the bootstrap secret of west2
+	expected := []string{"toolbox@west2", "bootstrap-secrets@east", "bootstrap-secrets@west2"}
+	if !reflect.DeepEqual(ran.runs, expected) {
+		t.Errorf("expected runs %v, got %v", expected, ran.runs)
+	}
+	records, err := loadInstallState(ctx, east)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"toolbox", "bootstrap-secrets.west1", "bootstrap-secrets.west2"} {
+		if _, found := records[name]; !found {
+			t.Errorf("expected the record %s in east, got %v", name, records)
+		}
+	}
+}
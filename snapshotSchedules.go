@@ -0,0 +1,430 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/pkg/errors"
+	"github.com/rivo/tview"
+	cephv1 "github.com/rook/rook/pkg/apis/ceph.rook.io/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// scheduleProgress reports changes to the mirror snapshot schedules
+var scheduleProgress = events.reporter("schedules")
+
+// snapshotScheduleLevel are the mirror snapshot schedules of a pool or an image in one cluster
+type snapshotScheduleLevel struct {
+	Cluster string `json:"cluster"`
+	Pool    string `json:"pool"`
+	// Image is empty for the schedules of the pool
+	Image string `json:"image,omitempty"`
+	// PVC is namespace/name of the PVC of the image
+	PVC string `json:"pvc,omitempty"`
+	// Spec are the schedules in the CephBlockPool spec, Rook applies them to the pool
+	Spec []snapshotSchedule `json:"spec,omitempty"`
+	// Active are the schedules that rbd runs
+	Active []snapshotSchedule `json:"active"`
+	// ScheduledImages is the number of images that get mirror snapshots
+	ScheduledImages int `json:"scheduledImages"`
+	// NextSnapshot is the time of the next mirror snapshot of the image or of any image of the pool
+	NextSnapshot string `json:"nextSnapshot,omitempty"`
+}
+
+func (l snapshotScheduleLevel) target() string {
+	if l.Image == "" {
+		return l.Pool
+	}
+	if l.PVC != "" {
+		return fmt.Sprintf("%s/%s (%s)", l.Pool, l.Image, l.PVC)
+	}
+	return imageSpec(l.Pool, l.Image)
+}
+
+// state summarizes whether the schedules are running
+func (l snapshotScheduleLevel) state() string {
+	var parts []string
+	if l.Image == "" && !sameSchedules(l.Spec, l.Active) {
+		parts = append(parts, fmt.Sprintf("spec %s not applied yet", formatScheduleList(l.Spec)))
+	}
+	if l.Image == "" {
+		parts = append(parts, fmt.Sprintf("%d images scheduled", l.ScheduledImages))
+	}
+	if l.NextSnapshot != "" {
+		parts = append(parts, "next snapshot "+l.NextSnapshot)
+	} else if len(l.Active) > 0 {
+		parts = append(parts, "no snapshot scheduled")
+	}
+	return strings.Join(parts, ", ")
+}
+
+func formatScheduleList(schedules []snapshotSchedule) string {
+	if len(schedules) == 0 {
+		return "none"
+	}
+	return formatSnapshotSchedules(schedules)
+}
+
+// sameSchedules compares the schedules independent of their order
+func sameSchedules(a, b []snapshotSchedule) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	counts := make(map[snapshotSchedule]int)
+	for _, schedule := range a {
+		counts[schedule]++
+	}
+	for _, schedule := range b {
+		counts[schedule]--
+		if counts[schedule] < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func toSnapshotSchedules(items []rbdSnapshotSchedule) []snapshotSchedule {
+	schedules := make([]snapshotSchedule, 0, len(items))
+	for _, item := range items {
+		schedules = append(schedules, snapshotSchedule{Interval: item.Interval, StartTime: item.StartTime})
+	}
+	return schedules
+}
+
+// listSnapshotSchedules returns the schedules of all mirrored pools of the cluster and of the images that have their own
+func listSnapshotSchedules(ctx context.Context, cluster kubeAccess) ([]snapshotScheduleLevel, error) {
+	if err := cephv1.AddToScheme(cluster.controllerClient.Scheme()); err != nil {
+		return nil, errors.WithMessagef(err, "[%s] Issues when adding the cephv1 scheme", cluster.name)
+	}
+	requestCtx, cancel := requestContext(ctx)
+	defer cancel()
+	var pools cephv1.CephBlockPoolList
+	if err := cluster.controllerClient.List(requestCtx, &pools, &client.ListOptions{Namespace: cluster.storageNamespace()}); err != nil {
+		return nil, errors.WithMessagef(err, "[%s] Issues when listing CephBlockPools", cluster.name)
+	}
+	pvs, err := cluster.typedClient.CoreV1().PersistentVolumes().List(requestCtx, metav1.ListOptions{})
+	if err != nil {
+		return nil, errors.WithMessagef(err, "[%s] Issues when listing PVs", cluster.name)
+	}
+	pvcs := make(map[string]string)
+	for _, pv := range pvs.Items {
+		rbdName, poolName, err := getRBDInfoFromPV(&pv)
+		if err != nil || pv.Spec.ClaimRef == nil {
+			continue
+		}
+		pvcs[imageSpec(poolName, rbdName)] = pv.Spec.ClaimRef.Namespace + "/" + pv.Spec.ClaimRef.Name
+	}
+
+	rbd := newRBD(cluster)
+	var levels []snapshotScheduleLevel
+	for _, blockPool := range pools.Items {
+		if !blockPool.Spec.Mirroring.Enabled {
+			continue
+		}
+		poolLevel := snapshotScheduleLevel{Cluster: cluster.name, Pool: blockPool.Name, Active: []snapshotSchedule{}}
+		for _, schedule := range blockPool.Spec.Mirroring.SnapshotSchedules {
+			poolLevel.Spec = append(poolLevel.Spec, snapshotSchedule{Interval: schedule.Interval, StartTime: schedule.StartTime})
+		}
+		rbdLevels, err := rbd.SnapshotSchedulesRecursive(blockPool.Name)
+		if err != nil {
+			return nil, errors.WithMessagef(err, "[%s] Issues when listing the snapshot schedules of pool %s", cluster.name, blockPool.Name)
+		}
+		status, err := rbd.SnapshotScheduleStatus(blockPool.Name)
+		if err != nil {
+			return nil, errors.WithMessagef(err, "[%s] Issues when fetching the snapshot schedule status of pool %s", cluster.name, blockPool.Name)
+		}
+		nextSnapshots := make(map[string]string)
+		for _, image := range status.ScheduledImages {
+			nextSnapshots[image.Image] = image.ScheduleTime
+			if poolLevel.NextSnapshot == "" || image.ScheduleTime < poolLevel.NextSnapshot {
+				poolLevel.NextSnapshot = image.ScheduleTime
+			}
+		}
+		poolLevel.ScheduledImages = len(status.ScheduledImages)
+
+		var imageLevels []snapshotScheduleLevel
+		for _, rbdLevel := range rbdLevels {
+			if rbdLevel.Image == "" {
+				poolLevel.Active = toSnapshotSchedules(rbdLevel.Items)
+				continue
+			}
+			spec := imageSpec(blockPool.Name, rbdLevel.Image)
+			imageLevels = append(imageLevels, snapshotScheduleLevel{
+				Cluster:      cluster.name,
+				Pool:         blockPool.Name,
+				Image:        rbdLevel.Image,
+				PVC:          pvcs[spec],
+				Active:       toSnapshotSchedules(rbdLevel.Items),
+				NextSnapshot: nextSnapshots[spec],
+			})
+		}
+		sort.Slice(imageLevels, func(i, j int) bool { return imageLevels[i].Image < imageLevels[j].Image })
+		levels = append(levels, poolLevel)
+		levels = append(levels, imageLevels...)
+	}
+	return levels, nil
+}
+
+// setSnapshotSchedules replaces the schedules of the pool, or of the image if it is set, in all clusters.
+// Pool schedules are changed in the CephBlockPool spec, so Rook keeps them, image schedules with rbd.
+func setSnapshotSchedules(ctx context.Context, clusters []kubeAccess, poolName, image string, schedules []snapshotSchedule) error {
+	target := poolName
+	if image != "" {
+		target = imageSpec(poolName, image)
+	}
+	failed := 0
+	for _, cluster := range clusters {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		progress := scheduleProgress.forCluster(cluster.name)
+		var err error
+		if image == "" {
+			err = setPoolSnapshotSchedules(ctx, cluster, poolName, schedules)
+		} else {
+			err = setImageSnapshotSchedules(cluster, poolName, image, schedules)
+		}
+		progress.result(target, err, "snapshot schedules of %s set to %s", target, formatScheduleList(schedules))
+		if err != nil {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return errors.Errorf("the snapshot schedules could not be changed in %d clusters", failed)
+	}
+	return nil
+}
+
+func setPoolSnapshotSchedules(ctx context.Context, cluster kubeAccess, poolName string, schedules []snapshotSchedule) error {
+	if err := cephv1.AddToScheme(cluster.controllerClient.Scheme()); err != nil {
+		return errors.WithMessagef(err, "[%s] Issues when adding the cephv1 scheme", cluster.name)
+	}
+	cephSchedules := poolSettings{SnapshotSchedules: schedules}.cephSnapshotSchedules()
+	patch, err := json.Marshal(map[string]interface{}{
+		"spec": map[string]interface{}{
+			"mirroring": map[string]interface{}{"snapshotSchedules": cephSchedules},
+		},
+	})
+	if err != nil {
+		return errors.WithMessage(err, "Issues when converting the snapshot schedules to JSON")
+	}
+	if dryRun.intercept(cluster, "patch", "CephBlockPool/"+poolName, patch) {
+		return nil
+	}
+	requestCtx, cancel := requestContext(ctx)
+	defer cancel()
+	err = cluster.controllerClient.Patch(requestCtx,
+		&cephv1.CephBlockPool{ObjectMeta: metav1.ObjectMeta{Name: poolName, Namespace: cluster.storageNamespace()}},
+		client.RawPatch(types.MergePatchType, patch))
+	if err != nil {
+		return errors.WithMessagef(err, "[%s] Issues when patching CephBlockPool %s", cluster.name, poolName)
+	}
+	return nil
+}
+
+// setImageSnapshotSchedules removes the schedules of the image that are not wanted any more and adds the missing ones
+func setImageSnapshotSchedules(cluster kubeAccess, poolName, image string, schedules []snapshotSchedule) error {
+	rbd := newRBD(cluster)
+	current, err := rbd.SnapshotSchedule(poolName, image)
+	if err != nil && !isRBDNotFound(err) {
+		return err
+	}
+	existing := make(map[snapshotSchedule]bool)
+	for _, schedule := range toSnapshotSchedules(current) {
+		existing[schedule] = true
+	}
+	wanted := make(map[snapshotSchedule]bool)
+	for _, schedule := range schedules {
+		wanted[schedule] = true
+	}
+	for _, schedule := range toSnapshotSchedules(current) {
+		if wanted[schedule] {
+			continue
+		}
+		if err := rbd.RemoveSnapshotSchedule(poolName, image, schedule.Interval, schedule.StartTime); err != nil {
+			return err
+		}
+	}
+	for _, schedule := range schedules {
+		if existing[schedule] {
+			continue
+		}
+		if err := rbd.AddSnapshotSchedule(poolName, image, schedule.Interval, schedule.StartTime); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// currentSnapshotSchedules returns the schedules in the CephBlockPool spec, or the ones of the image if it is set
+func currentSnapshotSchedules(ctx context.Context, cluster kubeAccess, poolName, image string) ([]snapshotSchedule, error) {
+	if image != "" {
+		schedules, err := newRBD(cluster).SnapshotSchedule(poolName, image)
+		if err != nil && !isRBDNotFound(err) {
+			return nil, err
+		}
+		return toSnapshotSchedules(schedules), nil
+	}
+	if err := cephv1.AddToScheme(cluster.controllerClient.Scheme()); err != nil {
+		return nil, errors.WithMessagef(err, "[%s] Issues when adding the cephv1 scheme", cluster.name)
+	}
+	requestCtx, cancel := requestContext(ctx)
+	defer cancel()
+	var blockPool cephv1.CephBlockPool
+	if err := cluster.controllerClient.Get(requestCtx, types.NamespacedName{Name: poolName, Namespace: cluster.storageNamespace()}, &blockPool); err != nil {
+		return nil, errors.WithMessagef(err, "[%s] Issues when fetching CephBlockPool %s", cluster.name, poolName)
+	}
+	schedules := []snapshotSchedule{}
+	for _, schedule := range blockPool.Spec.Mirroring.SnapshotSchedules {
+		schedules = append(schedules, snapshotSchedule{Interval: schedule.Interval, StartTime: schedule.StartTime})
+	}
+	return schedules, nil
+}
+
+func showSnapshotSchedulePage(clusters []kubeAccess) {
+	table := tview.NewTable().
+		SetSelectable(true, false).
+		SetSeparator(tview.Borders.Vertical).
+		SetFixed(1, 1).
+		SetDoneFunc(func(key tcell.Key) {
+			if key == tcell.KeyEscape {
+				pages.SwitchToPage("main")
+				pages.RemovePage("schedules")
+			}
+		})
+	statusFrame := tview.NewFrame(table)
+	statusFrame.SetBorder(true)
+
+	refresh := func() {
+		statusFrame.Clear().AddText("Fetching the snapshot schedules of all clusters...", true, tview.AlignCenter, tcell.ColorWhite)
+		go func() {
+			var levels []snapshotScheduleLevel
+			var errs []string
+			for _, cluster := range clusters {
+				clusterLevels, err := listSnapshotSchedules(context.Background(), cluster)
+				if err != nil {
+					errs = append(errs, err.Error())
+				}
+				levels = append(levels, clusterLevels...)
+			}
+			app.QueueUpdateDraw(func() {
+				populateScheduleTable(table, levels)
+				statusFrame.Clear()
+				if len(errs) > 0 {
+					statusFrame.AddText(strings.Join(errs, "; "), true, tview.AlignCenter, tcell.ColorRed)
+				}
+				statusFrame.AddText("(ENTER) Change the schedules in all clusters  (s) Refresh  (ESC) Back", false, tview.AlignCenter, tcell.ColorWhite)
+			})
+		}()
+	}
+	table.SetSelectedFunc(func(row int, column int) {
+		level, ok := table.GetCell(row, 0).GetReference().(snapshotScheduleLevel)
+		if !ok {
+			return
+		}
+		current := level.Active
+		if level.Image == "" {
+			current = level.Spec
+		}
+		showScheduleForm(clusters, level.Pool, level.Image, level.target(), current, refresh)
+	})
+	table.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		if event.Rune() == 's' {
+			refresh()
+		}
+		return event
+	})
+
+	pages.AddAndSwitchToPage("schedules", statusFrame, true)
+	refresh()
+}
+
+// populateScheduleTable shows one row per cluster and pool or image
+func populateScheduleTable(table *tview.Table, levels []snapshotScheduleLevel) {
+	table.Clear()
+	for column, header := range []string{"Cluster", "Pool / Image", "Schedules", "Status"} {
+		table.SetCell(0, column, &tview.TableCell{Text: header, NotSelectable: true, Color: tcell.ColorYellow, BackgroundColor: tcell.ColorBlack})
+	}
+	for i, level := range levels {
+		color := tcell.ColorWhite
+		if level.Image != "" {
+			color = tcell.ColorLightBlue
+		}
+		stateColor := tcell.ColorGreen
+		if level.NextSnapshot == "" || (level.Image == "" && !sameSchedules(level.Spec, level.Active)) {
+			stateColor = tcell.ColorYellow
+		}
+		table.SetCell(i+1, 0, &tview.TableCell{Text: level.Cluster, Color: color, BackgroundColor: tcell.ColorBlack, Reference: level})
+		table.SetCell(i+1, 1, &tview.TableCell{Text: level.target(), Expansion: 2, Color: color, BackgroundColor: tcell.ColorBlack})
+		table.SetCell(i+1, 2, &tview.TableCell{Text: formatScheduleList(level.Active), Expansion: 1, Color: color, BackgroundColor: tcell.ColorBlack})
+		table.SetCell(i+1, 3, &tview.TableCell{Text: level.state(), Expansion: 2, Color: stateColor, BackgroundColor: tcell.ColorBlack})
+	}
+	table.Select(1, 0)
+}
+
+// showScheduleForm changes the schedules of the pool, or of the image if it is set, in all clusters
+func showScheduleForm(clusters []kubeAccess, poolName, image, target string, current []snapshotSchedule, onDone func()) {
+	schedules := formatSnapshotSchedules(current)
+	closeForm := func() {
+		pages.RemovePage("scheduleForm")
+		if onDone != nil {
+			onDone()
+		}
+	}
+	form := tview.NewForm().
+		AddInputField("Snapshot schedules (interval[@startTime], ...)", schedules, 0, nil, func(text string) { schedules = text })
+	form.
+		AddButton("Save", func() {
+			parsed, err := parseSnapshotSchedules(schedules)
+			if err != nil {
+				showAlert(err.Error())
+				return
+			}
+			dryRun.start()
+			err = setSnapshotSchedules(context.Background(), clusters, poolName, image, parsed)
+			if err != nil {
+				showAlert(fmt.Sprintf("Could not change the snapshot schedules of %s: %s", target, err))
+			}
+			if image == "" && err == nil && !dryRun.enabled && stringInSliceBool(poolName, mirroringBlockPools()) {
+				// The next install must not reset the schedules
+				appConfig.Pool.SnapshotSchedules = parsed
+				writeNewConfig()
+			}
+			closeForm()
+			showDryRunPlan()
+		}).
+		AddButton("Cancel", closeForm).
+		SetCancelFunc(closeForm).
+		SetButtonsAlign(tview.AlignCenter)
+	form.SetBorder(true).SetTitle(fmt.Sprintf("Snapshot schedules of %s in all clusters", target))
+	if image != "" {
+		form.SetTitle(fmt.Sprintf("Snapshot schedules of %s in all clusters, empty to use the pool schedules", target))
+	}
+	pages.AddAndSwitchToPage("scheduleForm", form, true)
+}
+
+// showPVSnapshotSchedules lets the user override the schedules of the pool for the image of the PV
+func showPVSnapshotSchedules(clusters []kubeAccess, pv *corev1.PersistentVolume) {
+	rbdName, poolName, err := getRBDInfoFromPV(pv)
+	if err != nil {
+		showAlert(err.Error())
+		return
+	}
+	current, err := currentSnapshotSchedules(context.Background(), clusters[0], poolName, rbdName)
+	if err != nil {
+		showAlert(fmt.Sprintf("Could not get the snapshot schedules of PV %s: %s", pv.Name, err))
+		return
+	}
+	target := pv.Name
+	if pv.Spec.ClaimRef != nil {
+		target = fmt.Sprintf("%s/%s", pv.Spec.ClaimRef.Namespace, pv.Spec.ClaimRef.Name)
+	}
+	showScheduleForm(clusters, poolName, rbdName, target, current, nil)
+}
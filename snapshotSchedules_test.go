@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	cephv1 "github.com/rook/rook/pkg/apis/ceph.rook.io/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// newScheduledCluster returns a cluster with a mirrored pool, whose spec schedule is applied, and an image with its own schedule
+func newScheduledCluster(t *testing.T, name string) (kubeAccess, *fakeToolbox) {
+	t.Helper()
+	cluster, toolbox := newFakeCluster(t, name,
+		newRBDPV("pv-db", "shop", "db", "csi-vol-db", corev1.VolumeBound),
+		&cephv1.CephBlockPool{
+			ObjectMeta: metav1.ObjectMeta{Name: "replicapool", Namespace: environmentODF.namespace},
+			Spec: cephv1.PoolSpec{Mirroring: cephv1.MirroringSpec{
+				Enabled:           true,
+				Mode:              "image",
+				SnapshotSchedules: []cephv1.SnapshotScheduleSpec{{Interval: "1h"}},
+			}},
+		},
+		&cephv1.CephBlockPool{ObjectMeta: metav1.ObjectMeta{Name: "unmirrored", Namespace: environmentODF.namespace}},
+	)
+	toolbox.mirrored["replicapool/csi-vol-db"] = "up+stopped"
+	toolbox.schedules["replicapool"] = []rbdSnapshotSchedule{{Interval: "1h"}}
+	toolbox.schedules["replicapool/csi-vol-db"] = []rbdSnapshotSchedule{{Interval: "5m"}}
+	return cluster, toolbox
+}
+
+func TestListSnapshotSchedules(t *testing.T) {
+	cluster, _ := newScheduledCluster(t, "primary")
+
+	levels, err := listSnapshotSchedules(context.Background(), cluster)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []snapshotScheduleLevel{
+		{Cluster: "primary", Pool: "replicapool", Spec: []snapshotSchedule{{Interval: "1h"}}, Active: []snapshotSchedule{{Interval: "1h"}},
+			ScheduledImages: 1, NextSnapshot: "2021-04-26 11:00:00"},
+		{Cluster: "primary", Pool: "replicapool", Image: "csi-vol-db", PVC: "shop/db", Active: []snapshotSchedule{{Interval: "5m"}},
+			NextSnapshot: "2021-04-26 11:00:00"},
+	}
+	if !reflect.DeepEqual(levels, expected) {
+		t.Errorf("expected schedules\n%+v\ngot\n%+v", expected, levels)
+	}
+	if state := levels[0].state(); state != "1 images scheduled, next snapshot 2021-04-26 11:00:00" {
+		t.Errorf("unexpected state of the pool %q", state)
+	}
+	if target := levels[1].target(); target != "replicapool/csi-vol-db (shop/db)" {
+		t.Errorf("unexpected target %q", target)
+	}
+}
+
+func TestSetSnapshotSchedules(t *testing.T) {
+	primary, primaryToolbox := newScheduledCluster(t, "primary")
+	secondary, secondaryToolbox := newScheduledCluster(t, "secondary")
+	clusters := []kubeAccess{primary, secondary}
+	ctx := context.Background()
+	schedules := []snapshotSchedule{{Interval: "1d", StartTime: "02:00:00"}, {Interval: "5m"}}
+
+	if err := setSnapshotSchedules(ctx, clusters, "replicapool", "csi-vol-db", schedules); err != nil {
+		t.Fatal(err)
+	}
+	for _, toolbox := range []*fakeToolbox{primaryToolbox, secondaryToolbox} {
+		expected := []rbdSnapshotSchedule{{Interval: "5m"}, {Interval: "1d", StartTime: "02:00:00"}}
+		if actual := toolbox.schedules["replicapool/csi-vol-db"]; !reflect.DeepEqual(actual, expected) {
+			t.Errorf("expected image schedules %+v, got %+v", expected, actual)
+		}
+		if commands := toolbox.commandsContaining("schedule remove"); len(commands) != 0 {
+			t.Errorf("expected the unchanged schedule to stay, got %v", commands)
+		}
+	}
+
+	if err := setSnapshotSchedules(ctx, clusters, "replicapool", "", schedules); err != nil {
+		t.Fatal(err)
+	}
+	for _, cluster := range clusters {
+		var pool cephv1.CephBlockPool
+		if err := cluster.controllerClient.Get(ctx, types.NamespacedName{Name: "replicapool", Namespace: environmentODF.namespace}, &pool); err != nil {
+			t.Fatal(err)
+		}
+		expected := []cephv1.SnapshotScheduleSpec{{Interval: "1d", StartTime: "02:00:00"}, {Interval: "5m"}}
+		if !reflect.DeepEqual(pool.Spec.Mirroring.SnapshotSchedules, expected) || !pool.Spec.Mirroring.Enabled {
+			t.Errorf("[%s] expected pool schedules %+v, got %+v", cluster.name, expected, pool.Spec.Mirroring)
+		}
+	}
+}
+
+func TestSetSnapshotSchedulesDryRun(t *testing.T) {
+	defer func() { dryRun.enabled = false }()
+	primary, toolbox := newScheduledCluster(t, "primary")
+	dryRun.enabled = true
+	dryRun.start()
+
+	if err := setSnapshotSchedules(context.Background(), []kubeAccess{primary}, "replicapool", "csi-vol-db", nil); err != nil {
+		t.Fatal(err)
+	}
+	if actual := toolbox.schedules["replicapool/csi-vol-db"]; len(actual) != 1 {
+		t.Errorf("expected the dry run to keep the schedules, got %+v", actual)
+	}
+	expected := []plannedAction{{Cluster: "primary", Verb: "exec", Target: "rook-ceph-tools", Payload: "rbd mirror snapshot schedule remove --pool replicapool --image csi-vol-db 5m"}}
+	if actions := dryRun.plannedActions(); !reflect.DeepEqual(actions, expected) {
+		t.Errorf("expected planned actions %+v, got %+v", expected, actions)
+	}
+}
+
+func TestChangeSchedules(t *testing.T) {
+	current := []snapshotSchedule{{Interval: "1h"}, {Interval: "1d", StartTime: "02:00:00"}}
+
+	added := changeSchedules(current, []snapshotSchedule{{Interval: "1h"}, {Interval: "5m"}}, true)
+	if expected := []snapshotSchedule{{Interval: "1h"}, {Interval: "1d", StartTime: "02:00:00"}, {Interval: "5m"}}; !reflect.DeepEqual(added, expected) {
+		t.Errorf("expected %+v, got %+v", expected, added)
+	}
+	removed := changeSchedules(current, []snapshotSchedule{{Interval: "1d", StartTime: "02:00:00"}, {Interval: "5m"}}, false)
+	if expected := []snapshotSchedule{{Interval: "1h"}}; !reflect.DeepEqual(removed, expected) {
+		t.Errorf("expected %+v, got %+v", expected, removed)
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// timeoutSettings are the deadlines of long-running operations, zero values fall back to the defaults
+type timeoutSettings struct {
+	// Request is the deadline of a single API call or toolbox command
+	Request time.Duration `yaml:"request,omitempty"`
+	// Install is the deadline of each wait during the install, e.g. for the OADP operator
+	Install time.Duration `yaml:"install,omitempty"`
+	// Recovery is the deadline for the OADP restore during a failover
+	Recovery time.Duration `yaml:"recovery,omitempty"`
+	// Replication is the deadline for the csi-addons operator to change the image of a VolumeReplication
+	Replication time.Duration `yaml:"replication,omitempty"`
+	// BackoffInitial and BackoffMax bound the time between two checks while waiting
+	BackoffInitial time.Duration `yaml:"backoffInitial,omitempty"`
+	BackoffMax     time.Duration `yaml:"backoffMax,omitempty"`
+}
+
+var defaultTimeouts = timeoutSettings{
+	Request:        30 * time.Second,
+	Install:        15 * time.Minute,
+	Recovery:       30 * time.Minute,
+	Replication:    5 * time.Minute,
+	BackoffInitial: 2 * time.Second,
+	BackoffMax:     30 * time.Second,
+}
+
+// timeouts returns the configured timeouts, filled up with the defaults
+func timeouts() timeoutSettings {
+	settings := appConfig.Timeouts
+	if settings.Request <= 0 {
+		settings.Request = defaultTimeouts.Request
+	}
+	if settings.Install <= 0 {
+		settings.Install = defaultTimeouts.Install
+	}
+	if settings.Recovery <= 0 {
+		settings.Recovery = defaultTimeouts.Recovery
+	}
+	if settings.Replication <= 0 {
+		settings.Replication = defaultTimeouts.Replication
+	}
+	if settings.BackoffInitial <= 0 {
+		settings.BackoffInitial = defaultTimeouts.BackoffInitial
+	}
+	if settings.BackoffMax < settings.BackoffInitial {
+		settings.BackoffMax = defaultTimeouts.BackoffMax
+		if settings.BackoffMax < settings.BackoffInitial {
+			settings.BackoffMax = settings.BackoffInitial
+		}
+	}
+	return settings
+}
+
+// requestContext returns a context for a single request, that is also cancelled with ctx
+func requestContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, timeouts().Request)
+}
+
+// waitFor checks condition with exponential backoff until it is true, returns an error, the timeout passed or ctx is cancelled.
+// Each check gets its own request context.
+func waitFor(ctx context.Context, timeout time.Duration, description string, condition func(ctx context.Context) (bool, error)) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	settings := timeouts()
+	backoff := wait.Backoff{
+		Duration: settings.BackoffInitial,
+		Factor:   1.5,
+		Jitter:   0.1,
+		// Steps only limits the growth, the deadline ends the wait
+		Steps: math.MaxInt32,
+		Cap:   settings.BackoffMax,
+	}
+	for {
+		requestCtx, cancelRequest := requestContext(ctx)
+		done, err := condition(requestCtx)
+		cancelRequest()
+		if err != nil {
+			return err
+		}
+		if done {
+			return nil
+		}
+		timer := time.NewTimer(backoff.Step())
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return contextError(ctx, description, timeout)
+		case <-timer.C:
+		}
+	}
+}
+
+// contextError explains why ctx ended while waiting
+func contextError(ctx context.Context, description string, timeout time.Duration) error {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return errors.Wrapf(ctx.Err(), "gave up waiting for %s after %s", description, timeout)
+	}
+	return errors.Wrapf(ctx.Err(), "cancelled while waiting for %s", description)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func TestTimeoutsDefaults(t *testing.T) {
+	defer func(settings timeoutSettings) { appConfig.Timeouts = settings }(appConfig.Timeouts)
+
+	appConfig.Timeouts = timeoutSettings{Install: time.Hour, BackoffMax: time.Millisecond}
+	settings := timeouts()
+	if settings.Install != time.Hour {
+		t.Errorf("expected the configured install timeout, got %s", settings.Install)
+	}
+	if settings.Request != defaultTimeouts.Request || settings.Recovery != defaultTimeouts.Recovery {
+		t.Errorf("expected the default request and recovery timeouts, got %+v", settings)
+	}
+	if settings.BackoffMax < settings.BackoffInitial {
+		t.Errorf("expected the backoff maximum to be at least the initial backoff, got %+v", settings)
+	}
+}
+
+func TestWaitFor(t *testing.T) {
+	defer func(settings timeoutSettings) { appConfig.Timeouts = settings }(appConfig.Timeouts)
+	appConfig.Timeouts = timeoutSettings{BackoffInitial: time.Millisecond, BackoffMax: 5 * time.Millisecond}
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name          string
+		ctx           context.Context
+		timeout       time.Duration
+		condition     func(calls int) (bool, error)
+		expectedError string
+		expectedCalls int
+	}{
+		{
+			name:          "done after some checks",
+			ctx:           context.Background(),
+			timeout:       time.Minute,
+			condition:     func(calls int) (bool, error) { return calls == 3, nil },
+			expectedCalls: 3,
+		},
+		{
+			name:          "condition fails",
+			ctx:           context.Background(),
+			timeout:       time.Minute,
+			condition:     func(calls int) (bool, error) { return false, errors.New("broken") },
+			expectedError: "broken",
+			expectedCalls: 1,
+		},
+		{
+			name:          "timed out",
+			ctx:           context.Background(),
+			timeout:       20 * time.Millisecond,
+			condition:     func(calls int) (bool, error) { return false, nil },
+			expectedError: "gave up waiting for the test",
+		},
+		{
+			name:          "cancelled",
+			ctx:           cancelled,
+			timeout:       time.Minute,
+			condition:     func(calls int) (bool, error) { return false, nil },
+			expectedError: "cancelled while waiting for the test",
+			expectedCalls: 1,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			calls := 0
+			err := waitFor(test.ctx, test.timeout, "the test", func(ctx context.Context) (bool, error) {
+				if _, hasDeadline := ctx.Deadline(); !hasDeadline {
+					t.Error("expected the check to have a deadline")
+				}
+				calls++
+				return test.condition(calls)
+			})
+			if test.expectedError == "" && err != nil {
+				t.Fatalf("waitFor failed: %s", err)
+			}
+			if test.expectedError != "" && (err == nil || !strings.Contains(err.Error(), test.expectedError)) {
+				t.Fatalf("expected error %q, got %v", test.expectedError, err)
+			}
+			if test.expectedCalls != 0 && calls != test.expectedCalls {
+				t.Errorf("expected %d checks, got %d", test.expectedCalls, calls)
+			}
+		})
+	}
+}
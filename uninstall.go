@@ -0,0 +1,457 @@
+package main
+
+import (
+	"context"
+	"strings"
+
+	ocsv1 "github.com/openshift/ocs-operator/api/v1"
+	operatorsv1alpha1 "github.com/operator-framework/api/pkg/operators/v1alpha1"
+	"github.com/pkg/errors"
+	"github.com/rivo/tview"
+	cephv1 "github.com/rook/rook/pkg/apis/ceph.rook.io/v1"
+	velerov1 "github.com/vmware-tanzu/velero/pkg/apis/velero/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// uninstallProgress reports the progress of the uninstall
+var uninstallProgress = events.reporter("uninstall")
+
+// uninstallOADP also removes the OADP Subscription, the Velero CR and the backup Schedule
+var uninstallOADP = false
+
+func askSeriousForUninstall() {
+	showModal("sure", "Are you sure you want to remove the RDR setup from all clusters?\nMirroring of all PVCs will be disabled.",
+		[]string{"Uninstall", "Uninstall including OADP", "NO"},
+		func(buttonIndex int, buttonLabel string) {
+			pages.RemovePage("sure")
+			switch buttonLabel {
+			case "NO":
+				return
+			case "Uninstall":
+				uninstallOADP = false
+			case "Uninstall including OADP":
+				uninstallOADP = true
+			}
+			showUninstall()
+		},
+	)
+}
+
+func showUninstall() {
+	uninstallLog := tview.NewTextView().
+		SetChangedFunc(func() {
+			app.Draw()
+		})
+	pages.AddAndSwitchToPage("uninstall", uninstallLog, true)
+
+	unsubscribe := events.subscribe(forOperation("uninstall", textSink(uninstallLog)))
+	runCancellable(uninstallLog, func(ctx context.Context) {
+		dryRun.start()
+		doUninstall(ctx)
+		if dryRun.enabled {
+			dryRun.print(uninstallLog)
+		}
+	}, func() {
+		unsubscribe()
+		pages.SwitchToPage("main")
+		pages.RemovePage("uninstall")
+	})
+}
+
+// doUninstall reverts the install on all clusters, in the reverse order of the install
+func doUninstall(ctx context.Context) error {
+	uninstallProgress.info("Starting Uninstall!")
+	clusters := allSites()
+	for _, cluster := range clusters {
+		if err := ocsv1.AddToScheme(cluster.controllerClient.Scheme()); err != nil {
+			return uninstallProgress.failed("schemes", err, "Issues when adding the ocsv1 scheme to the %s client", cluster.name)
+		}
+		if err := cephv1.AddToScheme(cluster.controllerClient.Scheme()); err != nil {
+			return uninstallProgress.failed("schemes", err, "Issues when adding the cephv1 scheme to the %s client", cluster.name)
+		}
+	}
+	steps, err := uninstallSteps(ctx, clusters)
+	if err != nil {
+		return uninstallProgress.failed("install-state", err, "Issues when loading the install state")
+	}
+
+	// The first cluster goes first, so disabling its primary images also cleans up the secondary images
+	for _, step := range steps {
+		uninstallProgress.started(step.name, "%s", step.description)
+		for i := range clusters {
+			cluster := &clusters[i]
+			if err := ctx.Err(); err != nil {
+				return uninstallProgress.forCluster(cluster.name).failed(step.name, err, "Uninstall stopped")
+			}
+			if err := step.run(ctx, cluster, nil); err != nil {
+				return uninstallProgress.forCluster(cluster.name).failed(step.name, err, "Issues when running uninstall step %s", step.name)
+			}
+		}
+		uninstallProgress.finished(step.name, "%s done", step.description)
+	}
+	uninstallProgress.info("Uninstall steps done!!")
+	return nil
+}
+
+// uninstallState is the pool setup of one cluster that the uninstall reverts
+type uninstallState struct {
+	dedicated     bool
+	dedicatedPool string
+	mirrored      bool
+	defaultPool   string
+	// With the StorageCluster setup, ODF manages rbd-mirror, the OMAP generator and the default pool
+	storageClusterSetup bool
+	cephfsMirroring     bool
+}
+
+// loadUninstallState reads the recorded install of the cluster, if nothing was recorded the current pool
+// choice in the environment of the cluster is used
+func loadUninstallState(ctx context.Context, cluster kubeAccess) (uninstallState, error) {
+	records, err := loadInstallState(ctx, cluster)
+	if err != nil {
+		return uninstallState{}, err
+	}
+	dedicatedPool, dedicated := records["block-pool"]
+	defaultPool, mirrored := records["pool-mirroring"]
+	_, storageClusterSetup := records["storage-cluster-mirroring"]
+	_, cephfsMirroring := records["cephfs-mirroring"]
+	state := uninstallState{
+		dedicated:           dedicated,
+		dedicatedPool:       dedicatedPool.Input,
+		mirrored:            mirrored,
+		defaultPool:         defaultPool.Input,
+		storageClusterSetup: storageClusterSetup,
+		cephfsMirroring:     cephfsMirroring,
+	}
+	if !dedicated && !mirrored && !storageClusterSetup {
+		state.dedicated = useNewBlockPoolForMirroring
+		state.mirrored = !useNewBlockPoolForMirroring
+		state.dedicatedPool = mirroringBlockPool(cluster.environment())
+		state.defaultPool = mirroringBlockPool(cluster.environment())
+	}
+	return state, nil
+}
+
+// uninstallSteps returns the steps that revert the recorded install of the clusters. A step is part of the
+// uninstall if it was installed on any of the clusters, each cluster only runs the steps of its own install.
+func uninstallSteps(ctx context.Context, clusters []kubeAccess) ([]installStep, error) {
+	states := make(map[string]uninstallState, len(clusters))
+	var dedicated, mirrored, storageClusterSetup, omapGenerator, cephfsMirroring bool
+	for _, cluster := range clusters {
+		state, err := loadUninstallState(ctx, cluster)
+		if err != nil {
+			return nil, err
+		}
+		states[cluster.name] = state
+		dedicated = dedicated || state.dedicated
+		mirrored = mirrored || state.mirrored
+		storageClusterSetup = storageClusterSetup || state.storageClusterSetup
+		omapGenerator = omapGenerator || !state.storageClusterSetup
+		cephfsMirroring = cephfsMirroring || state.cephfsMirroring
+	}
+
+	steps := []installStep{
+		{
+			name:        "image-mirroring",
+			description: "Disabling mirroring on all images",
+			run: func(ctx context.Context, cluster, _ *kubeAccess) error {
+				return disableImageMirroring(ctx, *cluster)
+			},
+		},
+	}
+	if uninstallOADP {
+		steps = append(steps, installStep{
+			name:        "oadp",
+			description: "Removing OADP",
+			run: func(ctx context.Context, cluster, _ *kubeAccess) error {
+				return removeOADP(ctx, *cluster)
+			},
+		})
+	}
+	if cephfsMirroring {
+		steps = append(steps, installStep{
+			name:        "cephfs-mirroring",
+			description: "Removing the CephFS peers and mirror daemon",
+			run: func(ctx context.Context, cluster, _ *kubeAccess) error {
+				if !states[cluster.name].cephfsMirroring {
+					return nil
+				}
+				return disableCephFSMirroring(ctx, *cluster)
+			},
+		})
+	}
+	steps = append(steps, installStep{
+		name:        "bootstrap-secrets",
+		description: "Removing the rbd-mirror and the bootstrap secrets",
+		run: func(ctx context.Context, cluster, _ *kubeAccess) error {
+			return removeBootstrapSecrets(ctx, *cluster, !states[cluster.name].storageClusterSetup)
+		},
+	})
+	if dedicated {
+		steps = append(steps, installStep{
+			name:        "block-pool",
+			description: "Deleting the dedicated Block Pool and its StorageClass",
+			run: func(ctx context.Context, cluster, _ *kubeAccess) error {
+				state := states[cluster.name]
+				if !state.dedicated {
+					return nil
+				}
+				return deleteDedicatedPool(ctx, *cluster, state.dedicatedPool)
+			},
+		})
+	}
+	if mirrored {
+		steps = append(steps, installStep{
+			name:        "pool-mirroring",
+			description: "Disabling mirroring on the default Block Pool",
+			run: func(ctx context.Context, cluster, _ *kubeAccess) error {
+				state := states[cluster.name]
+				if !state.mirrored {
+					return nil
+				}
+				return disablePoolMirroring(ctx, *cluster, state.defaultPool)
+			},
+		})
+	}
+	if storageClusterSetup {
+		steps = append(steps, installStep{
+			name:        "storage-cluster-mirroring",
+			description: "Disabling mirroring in the StorageCluster",
+			run: func(ctx context.Context, cluster, _ *kubeAccess) error {
+				if !states[cluster.name].storageClusterSetup {
+					return nil
+				}
+				return disableStorageClusterMirroring(ctx, *cluster)
+			},
+		})
+	}
+	if omapGenerator {
+		steps = append(steps, installStep{
+			name:        "omap-generator",
+			description: "Disabling the OMAP generator",
+			run: func(ctx context.Context, cluster, _ *kubeAccess) error {
+				if states[cluster.name].storageClusterSetup {
+					return nil
+				}
+				return disableOMAPGenerator(ctx, *cluster)
+			},
+		})
+	}
+	steps = append(steps,
+		installStep{
+			name:        "install-state",
+			description: "Removing the install state",
+			run: func(ctx context.Context, cluster, _ *kubeAccess) error {
+				return deleteInstallState(ctx, *cluster)
+			},
+		})
+	return steps, nil
+}
+
+// disableImageMirroring disables mirroring on all RBD images and CephFS directories of PVs that have it enabled
+func disableImageMirroring(ctx context.Context, cluster kubeAccess) error {
+	progress := uninstallProgress.forCluster(cluster.name)
+	if _, err := getToolsPod(cluster.typedClient, cluster.storageNamespace()); err != nil {
+		// Without the toolbox, RDRhelper cannot have enabled mirroring on any image
+		progress.warn("skipping the images, the Ceph Toolbox is not available: %s", err)
+		return nil
+	}
+	requestCtx, cancel := requestContext(ctx)
+	pvs, err := cluster.typedClient.CoreV1().PersistentVolumes().List(requestCtx, metav1.ListOptions{})
+	cancel()
+	if err != nil {
+		return errors.WithMessagef(err, "[%s] Issues when listing PVs", cluster.name)
+	}
+	var cephPVs []corev1.PersistentVolume
+	for _, pv := range pvs.Items {
+		if isCephPV(&pv) {
+			cephPVs = append(cephPVs, pv)
+		}
+	}
+	statuses, err := getMirrorStatuses(cluster, cephPVs)
+	if err != nil {
+		return err
+	}
+	failed := 0
+	backend := replicationFor(ctx, cluster)
+	for _, pv := range cephPVs {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if status, known := statuses[pv.Name]; !known || status.State == rbdMirrorStateDisabled {
+			continue
+		}
+		err := backend.disable(ctx, &pv)
+		if rbdExitCode(err) == rbdExitReadOnly {
+			progress.warn("the image of PV %s is not primary, it is removed when mirroring is disabled in the other cluster", pv.Name)
+			continue
+		}
+		progress.result(pv.Name, err, "mirroring disabled for PV %s", pv.Name)
+		if err != nil {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return errors.Errorf("[%s] mirroring could not be disabled for %d PVs", cluster.name, failed)
+	}
+	if backend.name() == replicationVolumeReplication {
+		return deleteVolumeReplicationClasses(ctx, cluster)
+	}
+	return nil
+}
+
+// removeOADP deletes the backup Schedule, the Velero CR and the OADP Subscription
+func removeOADP(ctx context.Context, cluster kubeAccess) error {
+	if err := operatorsv1alpha1.AddToScheme(cluster.controllerClient.Scheme()); err != nil {
+		return errors.WithMessagef(err, "[%s] Issues when adding operator API schemas", cluster.name)
+	}
+	if err := velerov1.AddToScheme(cluster.controllerClient.Scheme()); err != nil {
+		return errors.WithMessagef(err, "[%s] Issues when adding velero schemas", cluster.name)
+	}
+	progress := uninstallProgress.forCluster(cluster.name)
+
+	if !dryRun.intercept(cluster, "delete", "oadp-operator/Schedule/regional-dr-backup", nil) {
+		err := cluster.controllerClient.Delete(ctx, &velerov1.Schedule{ObjectMeta: metav1.ObjectMeta{Name: "regional-dr-backup", Namespace: "oadp-operator"}})
+		if client.IgnoreNotFound(err) != nil {
+			return errors.WithMessagef(err, "[%s] Issues when deleting the backup Schedule", cluster.name)
+		}
+		progress.info("Backup Schedule deleted")
+	}
+
+	if !dryRun.intercept(cluster, "delete", "oadp-operator/Velero/oadp-velero", nil) {
+		veleroRes := schema.GroupVersionResource{
+			Group:    "konveyor.openshift.io",
+			Version:  "v1alpha1",
+			Resource: "veleros",
+		}
+		err := cluster.dynamicClient.Resource(veleroRes).Namespace("oadp-operator").Delete(ctx, "oadp-velero", metav1.DeleteOptions{})
+		if client.IgnoreNotFound(err) != nil {
+			return errors.WithMessagef(err, "[%s] Issues when deleting the Velero CR", cluster.name)
+		}
+		progress.info("OADP Velero CR deleted")
+	}
+
+	if !dryRun.intercept(cluster, "delete", "oadp-operator/Subscription/oadp-operator", nil) {
+		err := cluster.controllerClient.Delete(ctx, &operatorsv1alpha1.Subscription{ObjectMeta: metav1.ObjectMeta{Name: "oadp-operator", Namespace: "oadp-operator"}})
+		if client.IgnoreNotFound(err) != nil {
+			return errors.WithMessagef(err, "[%s] Issues when deleting the OADP Subscription", cluster.name)
+		}
+		progress.info("OADP Subscription deleted")
+	}
+	return nil
+}
+
+// removeBootstrapSecrets deletes the bootstrap secrets of the peers and, if RDRhelper deployed it, the rbd-mirror CR
+func removeBootstrapSecrets(ctx context.Context, cluster kubeAccess, deleteRBDMirror bool) error {
+	progress := uninstallProgress.forCluster(cluster.name)
+	if deleteRBDMirror && !dryRun.intercept(cluster, "delete", "CephRBDMirror/rbd-mirror", nil) {
+		err := cluster.controllerClient.Delete(ctx, &cephv1.CephRBDMirror{ObjectMeta: metav1.ObjectMeta{Name: "rbd-mirror", Namespace: cluster.storageNamespace()}})
+		if client.IgnoreNotFound(err) != nil {
+			return errors.WithMessagef(err, "[%s] Issues when deleting the rbd-mirror CR", cluster.name)
+		}
+		progress.info("rbd-mirror CR deleted")
+	}
+
+	for _, name := range getAllSecretNames(ctx, cluster) {
+		if !strings.HasPrefix(name, "mirror-bootstrap-") {
+			continue
+		}
+		if dryRun.intercept(cluster, "delete", "Secret/"+name, nil) {
+			continue
+		}
+		err := cluster.typedClient.CoreV1().Secrets(cluster.storageNamespace()).Delete(ctx, name, metav1.DeleteOptions{})
+		if client.IgnoreNotFound(err) != nil {
+			return errors.WithMessagef(err, "[%s] Issues when deleting bootstrap secret %s", cluster.name, name)
+		}
+		progress.info("Bootstrap secret %s deleted", name)
+	}
+	return nil
+}
+
+// deleteDedicatedPool deletes the StorageClass for mirrored PVCs and the dedicated Block Pool
+func deleteDedicatedPool(ctx context.Context, cluster kubeAccess, poolname string) error {
+	progress := uninstallProgress.forCluster(cluster.name)
+	storageClass := newMirroringStorageClass(cluster.environment()).Name
+	if !dryRun.intercept(cluster, "delete", "StorageClass/"+storageClass, nil) {
+		err := cluster.typedClient.StorageV1().StorageClasses().Delete(ctx, storageClass, metav1.DeleteOptions{})
+		if client.IgnoreNotFound(err) != nil {
+			return errors.WithMessagef(err, "[%s] Issues when deleting StorageClass %s", cluster.name, storageClass)
+		}
+		progress.info("StorageClass %s deleted", storageClass)
+	}
+	if dryRun.intercept(cluster, "delete", "CephBlockPool/"+poolname, nil) {
+		return nil
+	}
+	err := cluster.controllerClient.Delete(ctx, &cephv1.CephBlockPool{ObjectMeta: metav1.ObjectMeta{Name: poolname, Namespace: cluster.storageNamespace()}})
+	if client.IgnoreNotFound(err) != nil {
+		return errors.WithMessagef(err, "[%s] Issues when deleting CephBlockPool %s", cluster.name, poolname)
+	}
+	progress.info("CephBlockPool %s deleted", poolname)
+	return nil
+}
+
+// disablePoolMirroring reverts enablePoolMirroring, OCS manages the Block Pools again afterwards
+func disablePoolMirroring(ctx context.Context, cluster kubeAccess, poolname string) error {
+	progress := uninstallProgress.forCluster(cluster.name)
+	patchPoolJson := `{"spec": {"mirroring": {"enabled": false, "mode": "", "snapshotSchedules": null}}}`
+	// Removing the reconcile strategy restores the default
+	patchClusterJson := `{"spec": {"managedResources": {"cephBlockPools": {"reconcileStrategy": null}}}}`
+
+	storageCluster := cluster.environment().storageCluster
+	if dryRun.enabled {
+		dryRun.intercept(cluster, "patch", "CephBlockPool/"+poolname, patchPoolJson)
+		if storageCluster {
+			dryRun.intercept(cluster, "patch", "StorageCluster/ocs-storagecluster", patchClusterJson)
+		}
+		return nil
+	}
+	err := cluster.controllerClient.Patch(ctx,
+		&cephv1.CephBlockPool{ObjectMeta: metav1.ObjectMeta{Name: poolname, Namespace: cluster.storageNamespace()}},
+		client.RawPatch(types.MergePatchType, []byte(patchPoolJson)))
+	if client.IgnoreNotFound(err) != nil {
+		return errors.WithMessagef(err, "Issues when patching CephBlockPool in %s cluster", cluster.name)
+	}
+	progress.info("Mirroring of Block Pool %s disabled", poolname)
+	if !storageCluster {
+		return nil
+	}
+
+	err = cluster.controllerClient.Patch(ctx,
+		&ocsv1.StorageCluster{ObjectMeta: metav1.ObjectMeta{Name: "ocs-storagecluster", Namespace: cluster.storageNamespace()}},
+		client.RawPatch(types.MergePatchType, []byte(patchClusterJson)))
+	if err != nil {
+		return errors.WithMessagef(err, "Issues when patching StorageCluster in %s cluster", cluster.name)
+	}
+	progress.info("OCS Block Pool reconcile strategy restored")
+	return nil
+}
+
+// disableOMAPGenerator removes CSI_ENABLE_OMAP_GENERATOR from the rook operator config, which disables it again
+func disableOMAPGenerator(ctx context.Context, cluster kubeAccess) error {
+	payload := `{"data": {"CSI_ENABLE_OMAP_GENERATOR": null}}`
+	if dryRun.intercept(cluster, "patch", "ConfigMap/rook-ceph-operator-config", payload) {
+		return nil
+	}
+	_, err := cluster.typedClient.CoreV1().ConfigMaps(cluster.storageNamespace()).Patch(ctx, "rook-ceph-operator-config", types.MergePatchType, []byte(payload), metav1.PatchOptions{})
+	if err != nil {
+		return errors.WithMessagef(err, "failed with patching the OMAP client on %s", cluster.name)
+	}
+	uninstallProgress.forCluster(cluster.name).info("OMAP generator disabled")
+	return nil
+}
+
+// deleteInstallState removes the record of the install, so the next install runs all steps
+func deleteInstallState(ctx context.Context, cluster kubeAccess) error {
+	if dryRun.intercept(cluster, "delete", "ConfigMap/"+installStateConfigMap, nil) {
+		return nil
+	}
+	err := cluster.typedClient.CoreV1().ConfigMaps(cluster.storageNamespace()).Delete(ctx, installStateConfigMap, metav1.DeleteOptions{})
+	if client.IgnoreNotFound(err) != nil {
+		return errors.WithMessagef(err, "[%s] Issues when deleting the install state", cluster.name)
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	ocsv1 "github.com/openshift/ocs-operator/api/v1"
+	cephv1 "github.com/rook/rook/pkg/apis/ceph.rook.io/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// newInstalledCluster returns a cluster with the default pool setup of the install
+func newInstalledCluster(t *testing.T, name string) (kubeAccess, *fakeToolbox) {
+	t.Helper()
+	bootstrapLabels := map[string]string{"usage": "bootstrap"}
+	storageCluster := &ocsv1.StorageCluster{ObjectMeta: metav1.ObjectMeta{Name: "ocs-storagecluster", Namespace: environmentODF.namespace}}
+	storageCluster.Spec.ManagedResources.CephBlockPools.ReconcileStrategy = "ignore"
+	cluster, toolbox := newFakeCluster(t, name,
+		newPod(environmentODF.namespace, "rook-ceph-tools", map[string]string{"app": "rook-ceph-tools"}, true, "rook-ceph-tools"),
+		newRBDPV("pv-db", "shop", "db", "csi-vol-db", corev1.VolumeBound),
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "mirror-bootstrap-ocs-storagecluster-cephblockpool", Namespace: environmentODF.namespace, Labels: bootstrapLabels}},
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "manual-peer", Namespace: environmentODF.namespace, Labels: bootstrapLabels}},
+		&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "rook-ceph-operator-config", Namespace: environmentODF.namespace},
+			Data:       map[string]string{"CSI_ENABLE_OMAP_GENERATOR": "true", "CSI_LOG_LEVEL": "5"},
+		},
+		&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: installStateConfigMap, Namespace: environmentODF.namespace},
+			Data:       map[string]string{"pool-mirroring": `{"completed":"2021-04-01T10:00:00Z","input":"ocs-storagecluster-cephblockpool"}`},
+		},
+		&cephv1.CephRBDMirror{ObjectMeta: metav1.ObjectMeta{Name: "rbd-mirror", Namespace: environmentODF.namespace}},
+		&cephv1.CephBlockPool{
+			ObjectMeta: metav1.ObjectMeta{Name: "ocs-storagecluster-cephblockpool", Namespace: environmentODF.namespace},
+			Spec:       cephv1.PoolSpec{Mirroring: cephv1.MirroringSpec{Enabled: true, Mode: "image"}},
+		},
+		storageCluster,
+	)
+	toolbox.mirrored["replicapool/csi-vol-db"] = "up+stopped"
+	return cluster, toolbox
+}
+
+func TestDoUninstall(t *testing.T) {
+	defer func(primary, secondary kubeAccess) { kubeConfigPrimary, kubeConfigSecondary = primary, secondary }(kubeConfigPrimary, kubeConfigSecondary)
+	primary, primaryToolbox := newInstalledCluster(t, "primary")
+	secondary, _ := newInstalledCluster(t, "secondary")
+	kubeConfigPrimary, kubeConfigSecondary = primary, secondary
+	ctx := context.Background()
+
+	if err := doUninstall(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if commands := primaryToolbox.commandsContaining("mirror image disable replicapool/csi-vol-db"); len(commands) != 1 {
+		t.Errorf("expected mirroring of the image to be disabled, got %v", primaryToolbox.commands)
+	}
+	secrets := getAllSecretNames(ctx, primary)
+	if len(secrets) != 1 || secrets[0] != "manual-peer" {
+		t.Errorf("expected only the bootstrap secret of the install to be deleted, got %v", secrets)
+	}
+	if err := primary.controllerClient.Get(ctx, types.NamespacedName{Name: "rbd-mirror", Namespace: environmentODF.namespace}, &cephv1.CephRBDMirror{}); err == nil {
+		t.Error("expected the rbd-mirror CR to be deleted")
+	}
+	if mirrored, err := checkPoolMirroring(ctx, primary, "ocs-storagecluster-cephblockpool"); err != nil || mirrored {
+		t.Errorf("expected the pool mirroring to be disabled, got %t, %v", mirrored, err)
+	}
+	var storageCluster ocsv1.StorageCluster
+	if err := primary.controllerClient.Get(ctx, types.NamespacedName{Name: "ocs-storagecluster", Namespace: environmentODF.namespace}, &storageCluster); err != nil {
+		t.Fatal(err)
+	}
+	if strategy := storageCluster.Spec.ManagedResources.CephBlockPools.ReconcileStrategy; strategy != "" {
+		t.Errorf("expected the reconcile strategy to be restored, got %q", strategy)
+	}
+	configMap, err := primary.typedClient.CoreV1().ConfigMaps(environmentODF.namespace).Get(ctx, "rook-ceph-operator-config", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, found := configMap.Data["CSI_ENABLE_OMAP_GENERATOR"]; found || configMap.Data["CSI_LOG_LEVEL"] != "5" {
+		t.Errorf("expected only the OMAP generator setting to be removed, got %v", configMap.Data)
+	}
+	if records, _ := loadInstallState(ctx, secondary); len(records) != 0 {
+		t.Errorf("expected the install state to be removed, got %v", records)
+	}
+}
+
+func TestUninstallStepsFromRecordedState(t *testing.T) {
+	defer func() { uninstallOADP = false }()
+	cluster, _ := newFakeCluster(t, "primary", &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: installStateConfigMap, Namespace: environmentODF.namespace},
+		Data:       map[string]string{"block-pool": `{"completed":"2021-04-01T10:00:00Z","input":"replicapool"}`},
+	})
+	uninstallOADP = true
+	steps, err := uninstallSteps(context.Background(), []kubeAccess{cluster})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, step := range steps {
+		names = append(names, step.name)
+	}
+	expected := []string{"image-mirroring", "oadp", "bootstrap-secrets", "block-pool", "omap-generator", "install-state"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("expected steps %v, got %v", expected, names)
+	}
+}
+
+func TestUninstallStepsPerCluster(t *testing.T) {
+	mirroredPool := func(name, namespace string) *cephv1.CephBlockPool {
+		return &cephv1.CephBlockPool{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+			Spec:       cephv1.PoolSpec{Mirroring: cephv1.MirroringSpec{Enabled: true, Mode: "image"}},
+		}
+	}
+	// The ODF cluster was set up with the StorageCluster, the Rook cluster has no install state
+	primary, _ := newFakeCluster(t, "primary",
+		mirroredPool(defaultPoolName, environmentODF.namespace),
+		&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: installStateConfigMap, Namespace: environmentODF.namespace},
+			Data:       map[string]string{"storage-cluster-mirroring": `{"completed":"2021-04-01T10:00:00Z"}`},
+		})
+	secondary, _ := newFakeCluster(t, "secondary", mirroredPool(environmentRook.defaultPool, rookNamespace))
+	useEnvironment(&secondary, environmentRook)
+	ctx := context.Background()
+
+	steps, err := uninstallSteps(ctx, []kubeAccess{primary, secondary})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, step := range steps {
+		if step.name != "pool-mirroring" {
+			continue
+		}
+		for _, cluster := range []kubeAccess{primary, secondary} {
+			if err := step.run(ctx, &cluster, nil); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if mirrored, err := checkPoolMirroring(ctx, secondary, environmentRook.defaultPool); err != nil || mirrored {
+		t.Errorf("expected the mirroring of the Rook pool to be disabled, got %t, %v", mirrored, err)
+	}
+	var odfPool cephv1.CephBlockPool
+	if err := primary.controllerClient.Get(ctx, types.NamespacedName{Name: defaultPoolName, Namespace: environmentODF.namespace}, &odfPool); err != nil {
+		t.Fatal(err)
+	}
+	if !odfPool.Spec.Mirroring.Enabled {
+		t.Error("expected the StorageCluster to keep managing the mirroring of the ODF pool")
+	}
+}
@@ -3,6 +3,9 @@ package main
 import (
 	"context"
 	"fmt"
+	"io"
+	"strings"
+
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
 	"github.com/gdamore/tcell/v2"
@@ -10,232 +13,338 @@ import (
 	"github.com/rivo/tview"
 
 	cephv1 "github.com/rook/rook/pkg/apis/ceph.rook.io/v1"
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
 	"k8s.io/apimachinery/pkg/types"
 )
 
-var verifyText = tview.NewTextView().
-	SetChangedFunc(func() {
-		app.Draw()
-	})
+var verifyStatusColors = map[checkStatus]tcell.Color{
+	checkPass: tcell.ColorGreen,
+	checkWarn: tcell.ColorYellow,
+	checkFail: tcell.ColorRed,
+	checkSkip: tcell.ColorGray,
+}
 
-func init() {
-	verifyText.
+func showVerifyPage(clusters ...kubeAccess) {
+	table := tview.NewTable().
+		SetSelectable(true, true).
+		SetSeparator(tview.Borders.Vertical).
+		SetFixed(1, 1).
 		SetDoneFunc(func(key tcell.Key) {
-			verifyText.Clear()
-			pages.RemovePage("verify")
-			pages.SwitchToPage("main")
+			if key == tcell.KeyEscape {
+				pages.SwitchToPage("main")
+				pages.RemovePage("verify")
+			}
 		})
+	table.SetSelectedFunc(func(row int, column int) {
+		reference := table.GetCell(row, column).GetReference()
+		if reference == nil {
+			return
+		}
+		result := reference.(checkResult)
+		buttons := make(map[string]func())
+		buttons["Close"] = func() { pages.RemovePage("verifyDetail") }
+		showInfo("verifyDetail", verifyResultDetails(result), buttons)
+	})
+
+	statusFrame := tview.NewFrame(table).
+		AddText("Running verification checks on all clusters...", true, tview.AlignCenter, tcell.ColorWhite).
+		AddText("Select a cell with ENTER to see the details, go back to the main menu with ESC", false, tview.AlignCenter, tcell.ColorWhite)
+	statusFrame.SetBorder(true)
+
+	pages.AddAndSwitchToPage("verify", statusFrame, true)
+
+	go func() {
+		report := runVerifyChecks(context.Background(), clusters...)
+		populateVerifyTable(table, report)
+		statusFrame.Clear().
+			AddText(fmt.Sprintf("Verification finished at %s", report.Timestamp.Local().Format("15:04:05")), true, tview.AlignCenter, tcell.ColorWhite).
+			AddText("Select a cell with ENTER to see the details, go back to the main menu with ESC", false, tview.AlignCenter, tcell.ColorWhite)
+		app.Draw()
+	}()
 }
 
-func showVerifyPage(kubeConfigPrimary, kubeConfigSecondary kubeAccess) error {
-	pages.AddAndSwitchToPage("verify", verifyText, true)
-	clusters := []kubeAccess{ kubeConfigPrimary, kubeConfigSecondary }
-	for _, cluster := range clusters {
-		err := verifyOMAPpods(cluster)
-		if err != nil {
-			errormsg := fmt.Sprintf("[%s] ERRORS when verifying OMAP Pods. Please fix before proceeding.", cluster.name)
-			log.WithError(err).Warn(errormsg)
-			showAlert(errormsg)
-			addRowOfTextOutput(verifyText, "Press ENTER to get back to main")
-			return err
+// populateVerifyTable renders the report with one row per check and one column per cluster
+func populateVerifyTable(table *tview.Table, report *verifyReport) {
+	table.Clear()
+	table.SetCell(0, 0, &tview.TableCell{Text: "Check", NotSelectable: true, Color: tcell.ColorYellow, BackgroundColor: tcell.ColorBlack})
+	clusters := report.clusters()
+	for column, cluster := range clusters {
+		table.SetCell(0, column+1, &tview.TableCell{Text: cluster, NotSelectable: true, Expansion: 1, Color: tcell.ColorYellow, BackgroundColor: tcell.ColorBlack})
+	}
+	for row, check := range report.checks() {
+		table.SetCell(row+1, 0, &tview.TableCell{Text: check, NotSelectable: true, Color: tcell.ColorWhite, BackgroundColor: tcell.ColorBlack})
+		for column, cluster := range clusters {
+			result, found := report.get(check, cluster)
+			if !found {
+				table.SetCell(row+1, column+1, &tview.TableCell{Text: "-", Expansion: 1, Color: tcell.ColorGray, BackgroundColor: tcell.ColorBlack})
+				continue
+			}
+			table.SetCell(row+1, column+1, &tview.TableCell{
+				Text:            string(result.Status),
+				Expansion:       1,
+				Color:           verifyStatusColors[result.Status],
+				BackgroundColor: tcell.ColorBlack,
+				Reference:       result,
+			})
 		}
+	}
+	table.Select(1, 1)
+}
 
-		err = verifyRBDMirrorPods(cluster)
-		if err != nil {
-			errormsg := fmt.Sprintf("[%s] ERRORS when verifying RBD Mirror Pods. Please fix before proceeding.", cluster.name)
-			log.WithError(err).Warn(errormsg)
-			showAlert(errormsg)
-			addRowOfTextOutput(verifyText, "Press ENTER to get back to main")
-			return err
-		}
+func verifyResultDetails(result checkResult) string {
+	details := fmt.Sprintf("Check:   %s\nCluster: %s\nStatus:  %s\n", result.Check, result.Cluster, result.Status)
+	if check, found := findCheck(result.Check); found {
+		details += fmt.Sprintf("Checks:  %s\n", check.description)
+	}
+	details += fmt.Sprintf("\n%s\n", result.Message)
+	if result.Details != "" {
+		details += fmt.Sprintf("\nError:\n%s\n", result.Details)
+	}
+	if result.Remediation != "" {
+		details += fmt.Sprintf("\nRemediation:\n%s\n", result.Remediation)
+	}
+	return details
+}
 
-		err = verifyCBPmirror(cluster)
-		if err != nil {
-			errormsg := fmt.Sprintf("[%s] ERRORS when verifying Ceph Block Pool Pods. Please fix before proceeding.", cluster.name)
-			log.WithError(err).Error(errormsg)
-			showAlert(errormsg)
-			addRowOfTextOutput(verifyText, "Press ENTER to get back to main")
-			return err
+// printVerifyReport writes one line per check result
+func printVerifyReport(target io.Writer, report *verifyReport) {
+	for _, result := range report.Results {
+		addRowOfTextOutput(target, "%s", result)
+		if result.Status != checkPass && result.Remediation != "" {
+			addRowOfTextOutput(target, "    -> %s", result.Remediation)
 		}
+	}
+}
 
-		err = verifyOADPOperator(cluster)
-		if err != nil {
-			warningmsg := fmt.Sprintf("[%s] WARNING when verifying OADP Mirror Pods.", cluster.name)
-			addRowOfTextOutput(verifyText, warningmsg)
-		}
+// verifyProgress reports the result of every verification check
+var verifyProgress = events.reporter("verify")
 
+// runVerifyChecks runs the verify checks of the registry against all given clusters
+func runVerifyChecks(ctx context.Context, clusters ...kubeAccess) *verifyReport {
+	return runChecks(ctx, phaseVerify, reportTo(verifyProgress), clusters...)
+}
+
+// verifyODFRelease checks that RDRhelper has a profile for the installed OCS/ODF release
+func verifyODFRelease(cluster kubeAccess) checkResult {
+	result := checkResult{
+		Check:   checkODFRelease,
+		Cluster: cluster.name,
+		Status:  checkFail,
 	}
-	addRowOfTextOutput(verifyText, "Press ENTER to get back to main")
-	return nil
+	profile, err := detectODFProfile(cluster)
+	if err != nil {
+		result.Message = "The installed OCS/ODF release is not supported"
+		result.Details = err.Error()
+		result.Remediation = profile.unsupported
+		if result.Remediation == "" {
+			result.Remediation = fmt.Sprintf("Check that the ocs-operator is installed in the %s namespace", cluster.storageNamespace())
+		}
+		return result
+	}
+	result.Status = checkPass
+	result.Message = profile.describe()
+	return result
 }
 
 // Check OMAP configmap was enabled/patched "configmap/rook-ceph-operator-config patched"
-func verifyOMAPEnabled (cluster kubeAccess) error {
+func verifyOMAPEnabled(cluster kubeAccess) error {
 	rbdcmrookceph := "rook-ceph-operator-config"
-	rbdcm, err := cluster.typedClient.CoreV1().ConfigMaps(ocsNamespace).Get(context.TODO(),
+	rbdcm, err := cluster.typedClient.CoreV1().ConfigMaps(cluster.storageNamespace()).Get(context.TODO(),
 		rbdcmrookceph, metav1.GetOptions{})
 
 	if err != nil {
-		return fmt.Errorf("[%s] ERROR: Cannot get ConfigMap: %s",cluster.name,rbdcmrookceph)
+		return errors.WithMessagef(err, "Cannot get ConfigMap %s", rbdcmrookceph)
 	}
-	OMAPEnabled := fmt.Sprintf("[%s] CSI_ENABLE_OMAP_GENERATOR enabled? %+v",cluster.name,rbdcm.Data["CSI_ENABLE_OMAP_GENERATOR"])
 	if rbdcm.Data["CSI_ENABLE_OMAP_GENERATOR"] != "true" {
-		return fmt.Errorf("[%s] Please enable the OMAP Generator before proceeding",cluster.name)
+		return errors.Errorf("CSI_ENABLE_OMAP_GENERATOR is not enabled in ConfigMap %s", rbdcmrookceph)
 	}
-	addRowOfTextOutput(verifyText,OMAPEnabled)
 	return nil
 }
 
 // 1.2.2. Configuring RBD Mirroring between ODF clusters
 // oc -n openshift-storage get pods -l app=csi-rbd-plugin-provisioner
-func verifyOMAPpods(cluster kubeAccess) error {
+func verifyOMAPpods(cluster kubeAccess) checkResult {
+	result := checkResult{
+		Check:       checkOMAPGenerator,
+		Cluster:     cluster.name,
+		Status:      checkFail,
+		Remediation: "Run the install again to enable the OMAP generator or set CSI_ENABLE_OMAP_GENERATOR to true in the rook-ceph-operator-config ConfigMap",
+	}
 	omapLabelSelector := "app=csi-rbdplugin-provisioner"
 	if err := verifyOMAPEnabled(cluster); err != nil {
-		return err
+		result.Message = "Please enable the OMAP Generator before proceeding"
+		result.Details = err.Error()
+		return result
 	}
-	omappods, err := cluster.typedClient.CoreV1().Pods(ocsNamespace).
+	omappods, err := cluster.typedClient.CoreV1().Pods(cluster.storageNamespace()).
 		List(context.TODO(), metav1.ListOptions{LabelSelector: omapLabelSelector})
 	if err != nil || len(omappods.Items) == 0 {
-		errormsg := fmt.Sprintf("[%s] ERROR: OMAP No pods in %s namespace with label %s",
-			cluster.name, ocsNamespace, omapLabelSelector)
-		log.WithError(err).Error(errormsg)
-		addRowOfTextOutput(verifyText, errormsg)
-		return errors.WithMessagef(err,errormsg)
+		result.Message = fmt.Sprintf("No pods in %s namespace with label %s", cluster.storageNamespace(), omapLabelSelector)
+		if err != nil {
+			result.Details = err.Error()
+		}
+		return result
 	}
 	// TODO get replicas directly from deployment
 	if len(omappods.Items) != 2 {
-		errormsg := fmt.Sprintf("[%s] ERROR: There should be 2 pods from deployment/%s",
-			cluster.name, omapLabelSelector)
-		log.Errorf(errormsg)
-		showAlert(errormsg)
-		return errors.New(errormsg)
+		result.Message = fmt.Sprintf("There should be 2 pods with label %s, found %d", omapLabelSelector, len(omappods.Items))
+		return result
 	}
 	for _, pod := range omappods.Items {
 		for _, container := range pod.Status.ContainerStatuses {
-			if ! container.Ready {
-				errormsg := fmt.Sprintf("[%s] ERROR: Checking app=csi-rbdplugin-provisioner (OMAP) sidecar Pods: [%s] container: %s status: %t",
-					cluster.name, omapLabelSelector, container.Name, container.Ready)
-				log.Errorf(errormsg)
-				showAlert(errormsg)
-				return errors.WithMessagef(err,errormsg)
+			if !container.Ready {
+				result.Message = fmt.Sprintf("Container %s of pod %s is not ready", container.Name, pod.Name)
+				result.Remediation = fmt.Sprintf("Check the %s pods in the %s namespace", omapLabelSelector, cluster.storageNamespace())
+				return result
 			}
 		}
 	}
-	if ! checkForOMAPGenerator(cluster) {
-		errormsg := fmt.Sprintf("[%s] ERROR: OMAP Generator Container not present in app=csi-rbdplugin-provisioner (OMAP) Pods.", cluster.name)
-		log.Errorf(errormsg)
-		showAlert(errormsg)
-		return errors.WithMessagef(err,errormsg)
+	if !checkForOMAPGenerator(context.TODO(), cluster) {
+		result.Message = fmt.Sprintf("OMAP Generator container not present in %s pods", omapLabelSelector)
+		return result
 	}
-	addRowOfTextOutput(verifyText, "[%s] Setup for mirrored relationship OK", cluster.name)
-    return nil
+	result.Status = checkPass
+	result.Remediation = ""
+	result.Message = "Setup for mirrored relationship OK"
+	return result
 }
 
 // oc get pods -l 'app=rook-ceph-rbd-mirror' -n openshift-storage
-func verifyRBDMirrorPods(cluster kubeAccess) error {
+func verifyRBDMirrorPods(cluster kubeAccess) checkResult {
 	rbdLabelSelector := "app=rook-ceph-rbd-mirror"
-	rbdmirrorpods, err := cluster.typedClient.CoreV1().Pods(ocsNamespace).
+	result := checkResult{
+		Check:       checkRBDMirrorPods,
+		Cluster:     cluster.name,
+		Status:      checkFail,
+		Remediation: "Run the install again to create the rbd-mirror CR and check the rook-ceph-operator logs",
+	}
+	rbdmirrorpods, err := cluster.typedClient.CoreV1().Pods(cluster.storageNamespace()).
 		List(context.TODO(), metav1.ListOptions{LabelSelector: rbdLabelSelector})
 
 	if err != nil || len(rbdmirrorpods.Items) == 0 {
-		errormsg := fmt.Sprintf("[%s] ERROR: RBD Mirror No pods in %s namespace with label %s",
-			cluster.name, ocsNamespace, rbdLabelSelector)
-		log.WithError(err).Error(errormsg)
-		addRowOfTextOutput(verifyText, errormsg)
-		return errors.WithMessagef(err,errormsg)
+		result.Message = fmt.Sprintf("No RBD Mirror pods in %s namespace with label %s", cluster.storageNamespace(), rbdLabelSelector)
+		if err != nil {
+			result.Details = err.Error()
+		}
+		return result
 	}
 	for _, pod := range rbdmirrorpods.Items {
 		for _, container := range pod.Status.ContainerStatuses {
-			if container.Ready != true {
-				errormsg := fmt.Sprintf("[%s] ERROR: Checking RBD Mirror Pod: [%s] container: %s status: %t",
-					cluster.name, rbdLabelSelector, container.Name, container.Ready)
-				log.Errorf(errormsg)
-				showAlert(errormsg)
-				return errors.WithMessagef(err,errormsg)
+			if !container.Ready {
+				result.Message = fmt.Sprintf("Container %s of RBD Mirror pod %s is not ready", container.Name, pod.Name)
+				result.Remediation = fmt.Sprintf("Check the %s pods in the %s namespace", rbdLabelSelector, cluster.storageNamespace())
+				return result
 			}
 		}
 	}
-	addRowOfTextOutput(verifyText, "[%s] RBD Mirror Pods OK", cluster.name)
-	return nil
+	result.Status = checkPass
+	result.Remediation = ""
+	result.Message = "RBD Mirror Pods OK"
+	return result
 }
 
 // 1.2.2 Verify Ceph block pool has mirroring enabled
 // oc get cephblockpools.ceph.rook.io -n openshift-storage -o json | jq '.items[].status.mirroringStatus.summary.summary'
-func verifyCBPmirror(cluster kubeAccess) error {
-	if err := cephv1.AddToScheme(kubeConfigPrimary.controllerClient.Scheme()); err != nil {
-		errormsg := fmt.Sprintf("[%s] Issues when adding the cephv1 scheme to the primary client",
-			cluster.name)
-		log.WithError(err).Warn(errormsg)
-		showAlert(errormsg)
-		return errors.WithMessagef(err,errormsg)
+func verifyCBPmirror(cluster kubeAccess) checkResult {
+	result := checkResult{
+		Check:       checkBlockPoolMirror,
+		Cluster:     cluster.name,
+		Status:      checkFail,
+		Remediation: "Check the rbd-mirror daemon logs and the peer bootstrap secrets of the block pool",
+	}
+	if err := cephv1.AddToScheme(cluster.controllerClient.Scheme()); err != nil {
+		result.Message = "Issues when adding the cephv1 scheme to the client"
+		result.Details = err.Error()
+		return result
 	}
 	// list all cephblockpools
 	var cbpList cephv1.CephBlockPoolList
 	err := cluster.controllerClient.List(context.TODO(),
-		&cbpList, &client.ListOptions{Namespace: ocsNamespace})
+		&cbpList, &client.ListOptions{Namespace: cluster.storageNamespace()})
 	if err != nil {
-		return errors.WithMessagef(err, "[%s] Issues when listing CephBlockPools", cluster.name)
+		result.Message = "Issues when listing CephBlockPools"
+		result.Details = err.Error()
+		return result
 	}
 
-	cbpMirrorHealthKeys := map[string]bool {
+	cbpMirrorHealthKeys := map[string]bool{
 		"daemon_health": true,
-		"health": true,
-		"image_health": true,
+		"health":        true,
+		"image_health":  true,
 	}
-//	if visitedURL[thisSite] {
-//		fmt.Println("Already been here.")
-//	}
 	// For each cbp that has mirroring enabled, check mirror summary health status details
-	for _, cbp := range cbpList.Items  {
-		if cbp.Spec.Mirroring.Enabled {
-			addRowOfTextOutput(verifyText, "[%s] CephBlockPool %s Mirroring Enabled", cluster.name, cbp.Name)
-			currentBlockPool := cephv1.CephBlockPool{}
-			err = cluster.controllerClient.Get(context.TODO(),
-				types.NamespacedName{Name: cbp.Name, Namespace: ocsNamespace},
-				&currentBlockPool)
-			if err != nil {
-				errormsg := fmt.Sprintf("[%s] FETCHING ERRORS when fetching current CephBlockPool. Please fix before proceeding.", cluster.name)
-				log.WithError(err).Error(errormsg)
-				showAlert(errormsg)
-				return errors.WithMessagef(err, errormsg)
-			}
-			for cbpHealthKey, cbpMirrorHealth := range currentBlockPool.Status.MirroringStatus.Summary["summary"].(map[string]interface{}) {
-				// no cbpHealthKey in list. :-/
-				if cbpMirrorHealthKeys[cbpHealthKey] && cbpMirrorHealth != "OK"{
-					statusCBPnotready := fmt.Sprintf("[%s] CephBlockPool %s: %s is %s. Please investigate",
-						cluster.name, cbp.Name, cbpHealthKey, cbpMirrorHealth)
-					log.Error(statusCBPnotready)
-					showAlert(statusCBPnotready)
-					return errors.WithMessagef(err, "[%s] ERROR %s CephBlockPool mirror summary status %s %s",
-						cluster.name, cbp.Name, cbpHealthKey, cbpMirrorHealth)
-				}
+	var healthyPools []string
+	for _, cbp := range cbpList.Items {
+		if !cbp.Spec.Mirroring.Enabled {
+			continue
+		}
+		currentBlockPool := cephv1.CephBlockPool{}
+		err = cluster.controllerClient.Get(context.TODO(),
+			types.NamespacedName{Name: cbp.Name, Namespace: cluster.storageNamespace()},
+			&currentBlockPool)
+		if err != nil {
+			result.Message = fmt.Sprintf("Issues when fetching CephBlockPool %s", cbp.Name)
+			result.Details = err.Error()
+			return result
+		}
+		if currentBlockPool.Status == nil || currentBlockPool.Status.MirroringStatus == nil {
+			result.Message = fmt.Sprintf("CephBlockPool %s has no mirroring status yet", cbp.Name)
+			return result
+		}
+		summary, ok := currentBlockPool.Status.MirroringStatus.Summary["summary"].(map[string]interface{})
+		if !ok {
+			result.Message = fmt.Sprintf("CephBlockPool %s has no mirroring summary yet", cbp.Name)
+			return result
+		}
+		for cbpHealthKey, cbpMirrorHealth := range summary {
+			if cbpMirrorHealthKeys[cbpHealthKey] && cbpMirrorHealth != "OK" {
+				result.Message = fmt.Sprintf("CephBlockPool %s: %s is %s", cbp.Name, cbpHealthKey, cbpMirrorHealth)
+				return result
 			}
-			addRowOfTextOutput(verifyText,"[%s] CephBlockPool %s Mirror Summary Health OK", cluster.name, cbp.Name)
 		}
+		healthyPools = append(healthyPools, cbp.Name)
 	}
-	return nil
+	if len(healthyPools) == 0 {
+		result.Message = "No CephBlockPool has mirroring enabled"
+		result.Remediation = "Run the install to enable mirroring on a block pool"
+		return result
+	}
+	result.Status = checkPass
+	result.Remediation = ""
+	result.Message = fmt.Sprintf("CephBlockPool %s Mirror Summary Health OK", strings.Join(healthyPools, ", "))
+	return result
 }
 
 // Check and warn if OADP is not installed (but it's optional)
-func verifyOADPOperator(cluster kubeAccess) error {
+func verifyOADPOperator(cluster kubeAccess) checkResult {
+	result := checkResult{
+		Check:   checkOADPOperator,
+		Cluster: cluster.name,
+		Status:  checkPass,
+		Message: "OADP Operator status OK",
+	}
 	oadppod, err := cluster.typedClient.CoreV1().Pods("oadp-operator").
 		List(context.TODO(), metav1.ListOptions{})
 	if err != nil || len(oadppod.Items) == 0 {
-		warningmsg := fmt.Sprintf("[%s] WARNING: No OADP. Please consider installing OADP",cluster.name)
-		addRowOfTextOutput(verifyText,warningmsg)
-	} else {
-		for _, mypod := range oadppod.Items {
-			podstatusCondition := mypod.Status.Conditions[1]
-			if podstatusCondition.Status == "False" {
-				errormsg := fmt.Sprintf("[%s] ERROR: OADP Operator Check %s container ready? %s", cluster.name, mypod.Name, podstatusCondition.Status)
-				log.Error(errormsg)
-				showAlert(errormsg)
-				return errors.New(errormsg)
+		result.Status = checkWarn
+		result.Message = "No OADP. Please consider installing OADP"
+		result.Remediation = "Run the install with OADP enabled to back up the namespaces of mirrored PVCs"
+		if err != nil {
+			result.Details = err.Error()
+		}
+		return result
+	}
+	for _, mypod := range oadppod.Items {
+		for _, condition := range mypod.Status.Conditions {
+			if condition.Type == corev1.PodReady && condition.Status == corev1.ConditionFalse {
+				result.Status = checkFail
+				result.Message = fmt.Sprintf("OADP Operator pod %s is not ready", mypod.Name)
+				result.Remediation = "Check the pods in the oadp-operator namespace"
+				return result
 			}
 		}
-		addRowOfTextOutput(verifyText, "[%s] OADP Operator status OK", cluster.name)
 	}
-	return nil
+	return result
 }
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"strings"
+	"time"
+)
+
+type checkStatus string
+
+const (
+	checkPass checkStatus = "pass"
+	checkWarn checkStatus = "warn"
+	checkFail checkStatus = "fail"
+	// checkSkip is used for checks that were skipped with skipChecks
+	checkSkip checkStatus = "skip"
+)
+
+// checkResult is the outcome of a single verification check on a single cluster
+type checkResult struct {
+	Check       string      `json:"check"`
+	Cluster     string      `json:"cluster"`
+	Status      checkStatus `json:"status"`
+	Message     string      `json:"message"`
+	Remediation string      `json:"remediation,omitempty"`
+	// Details contains the underlying error, if there is one
+	Details string `json:"details,omitempty"`
+}
+
+func (r checkResult) String() string {
+	return fmt.Sprintf("[%s] %s %s: %s", r.Cluster, strings.ToUpper(string(r.Status)), r.Check, r.Message)
+}
+
+// verifyReport collects the results of one verification run
+type verifyReport struct {
+	Timestamp time.Time     `json:"timestamp"`
+	Results   []checkResult `json:"results"`
+}
+
+func newVerifyReport() *verifyReport {
+	return &verifyReport{Timestamp: time.Now().UTC()}
+}
+
+func (r *verifyReport) add(result checkResult) {
+	r.Results = append(r.Results, result)
+}
+
+// checks returns the names of all checks in the order they were run
+func (r *verifyReport) checks() []string {
+	var checks []string
+	for _, result := range r.Results {
+		if !stringInSliceBool(result.Check, checks) {
+			checks = append(checks, result.Check)
+		}
+	}
+	return checks
+}
+
+// clusters returns the names of all clusters in the order they were checked
+func (r *verifyReport) clusters() []string {
+	var clusters []string
+	for _, result := range r.Results {
+		if !stringInSliceBool(result.Cluster, clusters) {
+			clusters = append(clusters, result.Cluster)
+		}
+	}
+	return clusters
+}
+
+func (r *verifyReport) get(check, cluster string) (checkResult, bool) {
+	for _, result := range r.Results {
+		if result.Check == check && result.Cluster == cluster {
+			return result, true
+		}
+	}
+	return checkResult{}, false
+}
+
+// failed returns true if any of the checks failed
+func (r *verifyReport) failed() bool {
+	for _, result := range r.Results {
+		if result.Status == checkFail {
+			return true
+		}
+	}
+	return false
+}
+
+// withStatus returns a report with only the results of the given status
+func (r *verifyReport) withStatus(status checkStatus) *verifyReport {
+	filtered := &verifyReport{Timestamp: r.Timestamp}
+	for _, result := range r.Results {
+		if result.Status == status {
+			filtered.add(result)
+		}
+	}
+	return filtered
+}
+
+func (r *verifyReport) toJSON() ([]byte, error) {
+	return json.MarshalIndent(r, "", "  ")
+}
+
+type junitTestSuites struct {
+	XMLName    xml.Name         `xml:"testsuites"`
+	Name       string           `xml:"name,attr"`
+	Tests      int              `xml:"tests,attr"`
+	Failures   int              `xml:"failures,attr"`
+	Skipped    int              `xml:"skipped,attr"`
+	TestSuites []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Skipped   int             `xml:"skipped,attr"`
+	Timestamp string          `xml:"timestamp,attr"`
+	TestCases []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	Skipped   *junitSkipped `xml:"skipped,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+type junitSkipped struct {
+	Message string `xml:"message,attr"`
+}
+
+// toJUnit converts the report to JUnit XML with one testsuite per cluster.
+// Warnings are reported as passed tests with the message in system-out, since JUnit has no warning state.
+func (r *verifyReport) toJUnit() ([]byte, error) {
+	suites := junitTestSuites{Name: "RDRhelper verify"}
+	suiteIndex := make(map[string]int)
+	for _, result := range r.Results {
+		index, present := suiteIndex[result.Cluster]
+		if !present {
+			suites.TestSuites = append(suites.TestSuites, junitTestSuite{
+				Name:      result.Cluster,
+				Timestamp: r.Timestamp.Format(time.RFC3339),
+			})
+			index = len(suites.TestSuites) - 1
+			suiteIndex[result.Cluster] = index
+		}
+		suite := &suites.TestSuites[index]
+		testCase := junitTestCase{
+			Name:      result.Check,
+			ClassName: fmt.Sprintf("RDRhelper.%s", result.Cluster),
+		}
+		switch result.Status {
+		case checkFail:
+			text := result.Details
+			if result.Remediation != "" {
+				text = strings.TrimSpace(fmt.Sprintf("%s\nRemediation: %s", text, result.Remediation))
+			}
+			testCase.Failure = &junitFailure{Message: result.Message, Type: "fail", Text: text}
+			suite.Failures++
+			suites.Failures++
+		case checkSkip:
+			testCase.Skipped = &junitSkipped{Message: result.Message}
+			suite.Skipped++
+			suites.Skipped++
+		case checkWarn:
+			testCase.SystemOut = fmt.Sprintf("WARNING: %s\n%s", result.Message, result.Remediation)
+		default:
+			testCase.SystemOut = result.Message
+		}
+		suite.TestCases = append(suite.TestCases, testCase)
+		suite.Tests++
+		suites.Tests++
+	}
+	output, err := xml.MarshalIndent(suites, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), output...), nil
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	cephv1 "github.com/rook/rook/pkg/apis/ceph.rook.io/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func newOMAPConfigMap(enabled string) *corev1.ConfigMap {
+	return &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: environmentODF.namespace, Name: "rook-ceph-operator-config"},
+		Data:       map[string]string{"CSI_ENABLE_OMAP_GENERATOR": enabled},
+	}
+}
+
+func newProvisionerPod(name string, ready bool, containers ...string) *corev1.Pod {
+	return newPod(environmentODF.namespace, name, map[string]string{"app": "csi-rbdplugin-provisioner"}, ready, containers...)
+}
+
+func newMirroredBlockPool(name string, summary map[string]interface{}) *cephv1.CephBlockPool {
+	pool := &cephv1.CephBlockPool{
+		ObjectMeta: metav1.ObjectMeta{Namespace: environmentODF.namespace, Name: name},
+		Spec:       cephv1.PoolSpec{Mirroring: cephv1.MirroringSpec{Enabled: true, Mode: "image"}},
+	}
+	if summary != nil {
+		pool.Status = &cephv1.CephBlockPoolStatus{
+			MirroringStatus: &cephv1.MirroringStatusSpec{Summary: cephv1.SummarySpec{"summary": summary}},
+		}
+	}
+	return pool
+}
+
+func TestVerifyChecks(t *testing.T) {
+	healthy := map[string]interface{}{"health": "OK", "daemon_health": "OK", "image_health": "OK"}
+	degraded := map[string]interface{}{"health": "WARNING", "daemon_health": "OK", "image_health": "WARNING"}
+	rbdMirrorLabels := map[string]string{"app": "rook-ceph-rbd-mirror"}
+
+	tests := []struct {
+		name     string
+		check    func(kubeAccess) checkResult
+		objects  []runtime.Object
+		expected checkStatus
+	}{
+		{
+			name:  "OMAP generator running",
+			check: verifyOMAPpods,
+			objects: []runtime.Object{
+				newOMAPConfigMap("true"),
+				newProvisionerPod("provisioner-1", true, "csi-provisioner", "csi-omap-generator"),
+				newProvisionerPod("provisioner-2", true, "csi-provisioner", "csi-omap-generator"),
+			},
+			expected: checkPass,
+		},
+		{
+			name:     "OMAP generator not enabled",
+			check:    verifyOMAPpods,
+			objects:  []runtime.Object{newOMAPConfigMap("false")},
+			expected: checkFail,
+		},
+		{
+			name:  "OMAP generator container missing",
+			check: verifyOMAPpods,
+			objects: []runtime.Object{
+				newOMAPConfigMap("true"),
+				newProvisionerPod("provisioner-1", true, "csi-provisioner"),
+				newProvisionerPod("provisioner-2", true, "csi-provisioner"),
+			},
+			expected: checkFail,
+		},
+		{
+			name:  "OMAP generator not ready",
+			check: verifyOMAPpods,
+			objects: []runtime.Object{
+				newOMAPConfigMap("true"),
+				newProvisionerPod("provisioner-1", true, "csi-provisioner", "csi-omap-generator"),
+				newProvisionerPod("provisioner-2", false, "csi-provisioner", "csi-omap-generator"),
+			},
+			expected: checkFail,
+		},
+		{
+			name:     "rbd-mirror running",
+			check:    verifyRBDMirrorPods,
+			objects:  []runtime.Object{newPod(environmentODF.namespace, "rbd-mirror-a", rbdMirrorLabels, true, "rbd-mirror")},
+			expected: checkPass,
+		},
+		{
+			name:     "rbd-mirror missing",
+			check:    verifyRBDMirrorPods,
+			expected: checkFail,
+		},
+		{
+			name:     "rbd-mirror not ready",
+			check:    verifyRBDMirrorPods,
+			objects:  []runtime.Object{newPod(environmentODF.namespace, "rbd-mirror-a", rbdMirrorLabels, false, "rbd-mirror")},
+			expected: checkFail,
+		},
+		{
+			name:     "block pool mirroring healthy",
+			check:    verifyCBPmirror,
+			objects:  []runtime.Object{newMirroredBlockPool("replicapool", healthy)},
+			expected: checkPass,
+		},
+		{
+			name:     "block pool mirroring degraded",
+			check:    verifyCBPmirror,
+			objects:  []runtime.Object{newMirroredBlockPool("replicapool", degraded)},
+			expected: checkFail,
+		},
+		{
+			name:     "block pool without mirroring status",
+			check:    verifyCBPmirror,
+			objects:  []runtime.Object{newMirroredBlockPool("replicapool", nil)},
+			expected: checkFail,
+		},
+		{
+			name:     "no block pool with mirroring",
+			check:    verifyCBPmirror,
+			expected: checkFail,
+		},
+		{
+			name:     "OADP running",
+			check:    verifyOADPOperator,
+			objects:  []runtime.Object{newPod("oadp-operator", "velero-1", map[string]string{"component": "velero"}, true, "velero")},
+			expected: checkPass,
+		},
+		{
+			name:     "OADP not installed",
+			check:    verifyOADPOperator,
+			expected: checkWarn,
+		},
+		{
+			name:     "OADP not ready",
+			check:    verifyOADPOperator,
+			objects:  []runtime.Object{newPod("oadp-operator", "velero-1", map[string]string{"component": "velero"}, false, "velero")},
+			expected: checkFail,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cluster, _ := newFakeCluster(t, "primary", test.objects...)
+			result := test.check(cluster)
+			if result.Status != test.expected {
+				t.Errorf("expected status %s, got %s", test.expected, result)
+			}
+			if result.Cluster != "primary" {
+				t.Errorf("expected the result for the primary cluster, got %s", result.Cluster)
+			}
+			if result.Status != checkPass && result.Remediation == "" {
+				t.Errorf("expected a remediation for %s", result)
+			}
+		})
+	}
+}
+
+func TestRunVerifyChecks(t *testing.T) {
+	primary, _ := newFakeCluster(t, "primary")
+	secondary, _ := newFakeCluster(t, "secondary")
+
+	report := runVerifyChecks(context.Background(), primary, secondary)
+	// 5 checks per cluster and the Ceph network in both directions
+	if len(report.Results) != 12 {
+		t.Errorf("expected 12 results, got %d", len(report.Results))
+	}
+	if !report.failed() {
+		t.Error("expected the report of empty clusters to fail")
+	}
+	if result, found := report.get(checkOADPOperator, "secondary"); !found || result.Status != checkWarn {
+		t.Errorf("expected a warning for the missing OADP in the secondary cluster, got %v", result)
+	}
+}
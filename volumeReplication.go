@@ -0,0 +1,365 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+var volumeReplicationResource = schema.GroupVersionResource{
+	Group:    "replication.storage.openshift.io",
+	Version:  "v1alpha1",
+	Resource: "volumereplications",
+}
+
+var volumeReplicationClassResource = schema.GroupVersionResource{
+	Group:    "replication.storage.openshift.io",
+	Version:  "v1alpha1",
+	Resource: "volumereplicationclasses",
+}
+
+// Desired states in the VolumeReplication spec
+const (
+	replicationStatePrimary   = "primary"
+	replicationStateSecondary = "secondary"
+	replicationStateResync    = "resync"
+)
+
+// volumeReplicationClassName is the name of the VolumeReplicationClass that RDRhelper creates for the mirroring mode
+func volumeReplicationClassName(mode string) string {
+	return "rdrhelper-rbd-" + mode
+}
+
+// checkVolumeReplicationAPI returns an error if the csi-addons VolumeReplication CRDs are not installed
+func checkVolumeReplicationAPI(ctx context.Context, cluster kubeAccess) error {
+	requestCtx, cancel := requestContext(ctx)
+	defer cancel()
+	_, err := cluster.dynamicClient.Resource(volumeReplicationClassResource).List(requestCtx, metav1.ListOptions{Limit: 1})
+	return err
+}
+
+// volumeReplication creates and patches a VolumeReplication per PVC, the csi-addons operator then changes the image.
+// PVs whose PVC does not exist in the cluster, e.g. before OADP restored it, are changed with the fallback.
+type volumeReplication struct {
+	cluster  kubeAccess
+	fallback replicationBackend
+}
+
+func (v volumeReplication) name() string {
+	return replicationVolumeReplication
+}
+
+// claim returns the PVC of the PV, or nil if it does not exist in the cluster
+func (v volumeReplication) claim(ctx context.Context, pv *corev1.PersistentVolume) (*corev1.PersistentVolumeClaim, error) {
+	if pv.Spec.ClaimRef == nil {
+		return nil, nil
+	}
+	requestCtx, cancel := requestContext(ctx)
+	defer cancel()
+	pvc, err := v.cluster.typedClient.CoreV1().PersistentVolumeClaims(pv.Spec.ClaimRef.Namespace).Get(requestCtx, pv.Spec.ClaimRef.Name, metav1.GetOptions{})
+	if kerrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.WithMessagef(err, "[%s] Issues when fetching the PVC of PV %s", v.cluster.name, pv.Name)
+	}
+	return pvc, nil
+}
+
+func (v volumeReplication) enable(ctx context.Context, pv *corev1.PersistentVolume, mode string) error {
+	pvc, err := v.claim(ctx, pv)
+	if err != nil || pvc == nil {
+		return v.useFallback(ctx, err, pv, "enable", func() error { return v.fallback.enable(ctx, pv, mode) })
+	}
+	rbdName, poolName, err := getRBDInfoFromPV(pv)
+	if err != nil {
+		return err
+	}
+	if mode == "" {
+		mode = mirroringModeFor(poolName)
+	}
+	if err = validateMirroringMode(mode); err != nil {
+		return err
+	}
+	if mode == mirroringModeJournal {
+		if err = enableJournaling(newRBD(v.cluster), pv, poolName, rbdName); err != nil {
+			return err
+		}
+	}
+	return v.setState(ctx, pvc, mode, replicationStatePrimary)
+}
+
+// disable deletes the VolumeReplication, the csi-addons operator then disables mirroring of the image
+func (v volumeReplication) disable(ctx context.Context, pv *corev1.PersistentVolume) error {
+	pvc, err := v.claim(ctx, pv)
+	if err != nil || pvc == nil {
+		return v.useFallback(ctx, err, pv, "disable", func() error { return v.fallback.disable(ctx, pv) })
+	}
+	target := fmt.Sprintf("%s/VolumeReplication/%s", pvc.Namespace, pvc.Name)
+	if dryRun.intercept(v.cluster, "delete", target, nil) {
+		return nil
+	}
+	requestCtx, cancel := requestContext(ctx)
+	defer cancel()
+	err = v.cluster.dynamicClient.Resource(volumeReplicationResource).Namespace(pvc.Namespace).Delete(requestCtx, pvc.Name, metav1.DeleteOptions{})
+	if kerrors.IsNotFound(err) {
+		// Mirroring was enabled without VolumeReplication
+		return v.fallback.disable(ctx, pv)
+	}
+	if err != nil {
+		return errors.WithMessagef(err, "[%s] Issues when deleting the VolumeReplication of PV %s", v.cluster.name, pv.Name)
+	}
+	return nil
+}
+
+// promote falls back to the toolbox for forced promotions, since VolumeReplication has no force option
+func (v volumeReplication) promote(ctx context.Context, pv *corev1.PersistentVolume, force bool) error {
+	pvc, err := v.claim(ctx, pv)
+	if err != nil || pvc == nil || force {
+		return v.useFallback(ctx, err, pv, "promote", func() error { return v.fallback.promote(ctx, pv, force) })
+	}
+	return v.setState(ctx, pvc, "", replicationStatePrimary)
+}
+
+func (v volumeReplication) demote(ctx context.Context, pv *corev1.PersistentVolume) error {
+	pvc, err := v.claim(ctx, pv)
+	if err != nil || pvc == nil {
+		return v.useFallback(ctx, err, pv, "demote", func() error { return v.fallback.demote(ctx, pv) })
+	}
+	return v.setState(ctx, pvc, "", replicationStateSecondary)
+}
+
+func (v volumeReplication) resync(ctx context.Context, pv *corev1.PersistentVolume) error {
+	pvc, err := v.claim(ctx, pv)
+	if err != nil || pvc == nil {
+		return v.useFallback(ctx, err, pv, "resync", func() error { return v.fallback.resync(ctx, pv) })
+	}
+	return v.setState(ctx, pvc, "", replicationStateResync)
+}
+
+// useFallback changes the PV with the fallback backend, unless fetching its PVC failed
+func (v volumeReplication) useFallback(ctx context.Context, err error, pv *corev1.PersistentVolume, action string, change func() error) error {
+	if err != nil {
+		return err
+	}
+	log.Infof("[%s] Using %s to %s PV %s, there is no VolumeReplication for it", v.cluster.name, v.fallback.name(), action, pv.Name)
+	return change()
+}
+
+// setState creates the VolumeReplication of the PVC or changes its replicationState, then waits for the operator to finish.
+// The VolumeReplicationClass of the mode is only needed to create the VolumeReplication, an empty mode uses the mode of the pool.
+func (v volumeReplication) setState(ctx context.Context, pvc *corev1.PersistentVolumeClaim, mode, state string) error {
+	requestCtx, cancel := requestContext(ctx)
+	defer cancel()
+	resource := v.cluster.dynamicClient.Resource(volumeReplicationResource).Namespace(pvc.Namespace)
+	target := fmt.Sprintf("%s/VolumeReplication/%s", pvc.Namespace, pvc.Name)
+	_, err := resource.Get(requestCtx, pvc.Name, metav1.GetOptions{})
+	switch {
+	case kerrors.IsNotFound(err):
+		if mode == "" {
+			mode = mirroringModeFor(v.poolOf(ctx, pvc))
+		}
+		className, err := v.ensureClass(ctx, mode)
+		if err != nil {
+			return err
+		}
+		replication := newVolumeReplication(pvc, className, state)
+		if !dryRun.intercept(v.cluster, "create", target, replication.Object) {
+			if _, err = resource.Create(requestCtx, replication, metav1.CreateOptions{}); err != nil {
+				return errors.WithMessagef(err, "[%s] Issues when creating the VolumeReplication of PVC %s/%s", v.cluster.name, pvc.Namespace, pvc.Name)
+			}
+		}
+	case err != nil:
+		return errors.WithMessagef(err, "[%s] Issues when fetching the VolumeReplication of PVC %s/%s", v.cluster.name, pvc.Namespace, pvc.Name)
+	default:
+		patch, _ := json.Marshal(map[string]interface{}{"spec": map[string]interface{}{"replicationState": state}})
+		if !dryRun.intercept(v.cluster, "patch", target, patch) {
+			if _, err = resource.Patch(requestCtx, pvc.Name, types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+				return errors.WithMessagef(err, "[%s] Issues when patching the VolumeReplication of PVC %s/%s", v.cluster.name, pvc.Namespace, pvc.Name)
+			}
+		}
+	}
+	if dryRun.enabled {
+		return nil
+	}
+	return waitForVolumeReplication(ctx, v.cluster, pvc.Namespace, pvc.Name, state)
+}
+
+// poolOf returns the pool of the image of the PVC, empty if it is not bound yet
+func (v volumeReplication) poolOf(ctx context.Context, pvc *corev1.PersistentVolumeClaim) string {
+	if pvc.Spec.VolumeName == "" {
+		return ""
+	}
+	requestCtx, cancel := requestContext(ctx)
+	defer cancel()
+	pv, err := v.cluster.typedClient.CoreV1().PersistentVolumes().Get(requestCtx, pvc.Spec.VolumeName, metav1.GetOptions{})
+	if err != nil {
+		return ""
+	}
+	_, poolName, _ := getRBDInfoFromPV(pv)
+	return poolName
+}
+
+// ensureClass creates the VolumeReplicationClass of the mirroring mode, if it does not exist yet
+func (v volumeReplication) ensureClass(ctx context.Context, mode string) (string, error) {
+	name := volumeReplicationClassName(mode)
+	requestCtx, cancel := requestContext(ctx)
+	defer cancel()
+	resource := v.cluster.dynamicClient.Resource(volumeReplicationClassResource)
+	_, err := resource.Get(requestCtx, name, metav1.GetOptions{})
+	if err == nil {
+		return name, nil
+	}
+	if !kerrors.IsNotFound(err) {
+		return "", errors.WithMessagef(err, "[%s] Issues when fetching the VolumeReplicationClass %s", v.cluster.name, name)
+	}
+	class := newVolumeReplicationClass(name, mode, v.cluster.environment())
+	if dryRun.intercept(v.cluster, "create", "VolumeReplicationClass/"+name, class.Object) {
+		return name, nil
+	}
+	if _, err = resource.Create(requestCtx, class, metav1.CreateOptions{}); err != nil && !kerrors.IsAlreadyExists(err) {
+		return "", errors.WithMessagef(err, "[%s] Issues when creating the VolumeReplicationClass %s", v.cluster.name, name)
+	}
+	return name, nil
+}
+
+// deleteVolumeReplicationClasses deletes the VolumeReplicationClasses that RDRhelper created
+func deleteVolumeReplicationClasses(ctx context.Context, cluster kubeAccess) error {
+	for _, mode := range []string{mirroringModeSnapshot, mirroringModeJournal} {
+		name := volumeReplicationClassName(mode)
+		if dryRun.intercept(cluster, "delete", "VolumeReplicationClass/"+name, nil) {
+			continue
+		}
+		requestCtx, cancel := requestContext(ctx)
+		err := cluster.dynamicClient.Resource(volumeReplicationClassResource).Delete(requestCtx, name, metav1.DeleteOptions{})
+		cancel()
+		if err != nil && !kerrors.IsNotFound(err) {
+			return errors.WithMessagef(err, "[%s] Issues when deleting the VolumeReplicationClass %s", cluster.name, name)
+		}
+	}
+	return nil
+}
+
+func newVolumeReplicationClass(name, mode string, env storageEnvironment) *unstructured.Unstructured {
+	class := &unstructured.Unstructured{Object: map[string]interface{}{
+		"spec": map[string]interface{}{
+			"provisioner": env.rbdDriver,
+			"parameters": map[string]interface{}{
+				"mirroringMode": mode,
+				"replication.storage.openshift.io/replication-secret-name":      "rook-csi-rbd-provisioner",
+				"replication.storage.openshift.io/replication-secret-namespace": env.namespace,
+			},
+		},
+	}}
+	class.SetAPIVersion(volumeReplicationClassResource.GroupVersion().String())
+	class.SetKind("VolumeReplicationClass")
+	class.SetName(name)
+	return class
+}
+
+func newVolumeReplication(pvc *corev1.PersistentVolumeClaim, className, state string) *unstructured.Unstructured {
+	replication := &unstructured.Unstructured{Object: map[string]interface{}{
+		"spec": map[string]interface{}{
+			"volumeReplicationClass": className,
+			"replicationState":       state,
+			"dataSource": map[string]interface{}{
+				"kind": "PersistentVolumeClaim",
+				"name": pvc.Name,
+			},
+		},
+	}}
+	replication.SetAPIVersion(volumeReplicationResource.GroupVersion().String())
+	replication.SetKind("VolumeReplication")
+	replication.SetNamespace(pvc.Namespace)
+	replication.SetName(pvc.Name)
+	return replication
+}
+
+// volumeReplicationCondition is a condition in the VolumeReplication status
+type volumeReplicationCondition struct {
+	Type    string `json:"type"`
+	Status  string `json:"status"`
+	Reason  string `json:"reason,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// volumeReplicationStatus is the status that the csi-addons operator reports
+type volumeReplicationStatus struct {
+	// State is Primary, Secondary, Resyncing or Unknown
+	State              string                       `json:"state"`
+	Message            string                       `json:"message,omitempty"`
+	ObservedGeneration int64                        `json:"observedGeneration,omitempty"`
+	Conditions         []volumeReplicationCondition `json:"conditions,omitempty"`
+}
+
+func (s volumeReplicationStatus) condition(conditionType string) volumeReplicationCondition {
+	for _, condition := range s.Conditions {
+		if condition.Type == conditionType {
+			return condition
+		}
+	}
+	return volumeReplicationCondition{Type: conditionType, Status: string(metav1.ConditionUnknown)}
+}
+
+// reached returns true if the operator finished changing the image to the desired state of the spec.
+// The error explains why the operator could not change the image.
+func (s volumeReplicationStatus) reached(state string, generation int64) (bool, error) {
+	if s.ObservedGeneration < generation {
+		return false, nil
+	}
+	completed := s.condition("Completed")
+	if state != replicationStateResync {
+		if degraded := s.condition("Degraded"); completed.Status == string(metav1.ConditionFalse) && degraded.Status == string(metav1.ConditionTrue) {
+			return false, errors.Errorf("%s: %s", degraded.Reason, degraded.Message)
+		}
+	}
+	expected := state
+	if state == replicationStateResync {
+		// Resyncing images are secondary
+		expected = replicationStateSecondary
+	}
+	return completed.Status == string(metav1.ConditionTrue) && strings.EqualFold(s.State, expected), nil
+}
+
+func getVolumeReplicationStatus(ctx context.Context, cluster kubeAccess, namespace, name string) (volumeReplicationStatus, int64, error) {
+	var status volumeReplicationStatus
+	replication, err := cluster.dynamicClient.Resource(volumeReplicationResource).Namespace(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return status, 0, errors.WithMessagef(err, "[%s] Issues when fetching VolumeReplication %s/%s", cluster.name, namespace, name)
+	}
+	statusObject, found, err := unstructured.NestedMap(replication.Object, "status")
+	if err != nil || !found {
+		return status, replication.GetGeneration(), err
+	}
+	data, err := json.Marshal(statusObject)
+	if err == nil {
+		err = json.Unmarshal(data, &status)
+	}
+	return status, replication.GetGeneration(), errors.WithMessagef(err, "[%s] Issues when reading the status of VolumeReplication %s/%s", cluster.name, namespace, name)
+}
+
+// waitForVolumeReplication waits until the operator changed the image to the desired state
+func waitForVolumeReplication(ctx context.Context, cluster kubeAccess, namespace, name, state string) error {
+	description := fmt.Sprintf("VolumeReplication %s/%s in the %s cluster to become %s", namespace, name, cluster.name, state)
+	return waitFor(ctx, timeouts().Replication, description, func(ctx context.Context) (bool, error) {
+		status, generation, err := getVolumeReplicationStatus(ctx, cluster, namespace, name)
+		if err != nil {
+			log.WithError(err).Warn("Issues when checking the VolumeReplication")
+			return false, nil
+		}
+		done, err := status.reached(state, generation)
+		if err != nil {
+			return false, errors.WithMessagef(err, "[%s] VolumeReplication %s/%s could not become %s", cluster.name, namespace, name, state)
+		}
+		return done, nil
+	})
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// newVolumeReplicationCluster returns a cluster with a bound PVC and a PV, whose PVC was not restored yet
+func newVolumeReplicationCluster(t *testing.T) (volumeReplication, *fakeToolbox) {
+	t.Helper()
+	cluster, toolbox := newFakeCluster(t, "primary",
+		newRBDPV("pv-db", "shop", "db", "csi-vol-db", corev1.VolumeBound),
+		newRBDPV("pv-web", "shop", "web", "csi-vol-web", corev1.VolumeBound),
+		&corev1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "db"},
+			Spec:       corev1.PersistentVolumeClaimSpec{VolumeName: "pv-db"},
+		},
+	)
+	reconcileVolumeReplications(cluster)
+	return volumeReplication{cluster: cluster, fallback: toolboxReplication{cluster}}, toolbox
+}
+
+func replicationState(t *testing.T, cluster kubeAccess, namespace, name string) string {
+	t.Helper()
+	replication, err := cluster.dynamicClient.Resource(volumeReplicationResource).Namespace(namespace).Get(context.Background(), name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	state, _, _ := unstructured.NestedString(replication.Object, "spec", "replicationState")
+	return state
+}
+
+func TestVolumeReplicationBackend(t *testing.T) {
+	backend, toolbox := newVolumeReplicationCluster(t)
+	cluster := backend.cluster
+	ctx := context.Background()
+	pv := newRBDPV("pv-db", "shop", "db", "csi-vol-db", corev1.VolumeBound)
+
+	if err := backend.enable(ctx, pv, ""); err != nil {
+		t.Fatal(err)
+	}
+	class, err := cluster.dynamicClient.Resource(volumeReplicationClassResource).Get(ctx, "rdrhelper-rbd-snapshot", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode, _, _ := unstructured.NestedString(class.Object, "spec", "parameters", "mirroringMode"); mode != mirroringModeSnapshot {
+		t.Errorf("expected a snapshot VolumeReplicationClass, got %q", mode)
+	}
+	if state := replicationState(t, cluster, "shop", "db"); state != replicationStatePrimary {
+		t.Errorf("expected the VolumeReplication to be primary, got %q", state)
+	}
+
+	if err := backend.demote(ctx, pv); err != nil {
+		t.Fatal(err)
+	}
+	if state := replicationState(t, cluster, "shop", "db"); state != replicationStateSecondary {
+		t.Errorf("expected the VolumeReplication to be secondary, got %q", state)
+	}
+	if err := backend.resync(ctx, pv); err != nil {
+		t.Fatal(err)
+	}
+	if state := replicationState(t, cluster, "shop", "db"); state != replicationStateResync {
+		t.Errorf("expected the VolumeReplication to resync, got %q", state)
+	}
+
+	if err := backend.disable(ctx, pv); err != nil {
+		t.Fatal(err)
+	}
+	_, err = cluster.dynamicClient.Resource(volumeReplicationResource).Namespace("shop").Get(ctx, "db", metav1.GetOptions{})
+	if !kerrors.IsNotFound(err) {
+		t.Errorf("expected the VolumeReplication to be deleted, got %v", err)
+	}
+	if len(toolbox.commands) != 0 {
+		t.Errorf("expected no rbd commands, got %v", toolbox.commands)
+	}
+}
+
+func TestVolumeReplicationFallback(t *testing.T) {
+	backend, toolbox := newVolumeReplicationCluster(t)
+	toolbox.mirrored["replicapool/csi-vol-web"] = "up+stopped"
+	toolbox.mirrored["replicapool/csi-vol-db"] = "up+stopped"
+	ctx := context.Background()
+
+	// The PVC of pv-web was not restored yet
+	if err := backend.promote(ctx, newRBDPV("pv-web", "shop", "web", "csi-vol-web", corev1.VolumeBound), false); err != nil {
+		t.Fatal(err)
+	}
+	if commands := toolbox.commandsContaining("mirror image promote replicapool/csi-vol-web"); len(commands) != 1 {
+		t.Errorf("expected the toolbox to promote the image, got %v", toolbox.commands)
+	}
+	// VolumeReplication cannot force a promotion
+	if err := backend.promote(ctx, newRBDPV("pv-db", "shop", "db", "csi-vol-db", corev1.VolumeBound), true); err != nil {
+		t.Fatal(err)
+	}
+	if commands := toolbox.commandsContaining("mirror image promote replicapool/csi-vol-db --force"); len(commands) != 1 {
+		t.Errorf("expected the toolbox to force the promotion, got %v", toolbox.commands)
+	}
+}
+
+func TestVolumeReplicationStatusReached(t *testing.T) {
+	degraded := volumeReplicationStatus{
+		State: "Unknown",
+		Conditions: []volumeReplicationCondition{
+			{Type: "Completed", Status: "False", Reason: "FailedToPromote"},
+			{Type: "Degraded", Status: "True", Reason: "Error", Message: "image is busy"},
+		},
+	}
+	if _, err := degraded.reached(replicationStatePrimary, 0); err == nil || !strings.Contains(err.Error(), "image is busy") {
+		t.Errorf("expected the degraded message, got %v", err)
+	}
+	if done, err := degraded.reached(replicationStateResync, 0); done || err != nil {
+		t.Errorf("expected a resync to keep waiting, got %v, %v", done, err)
+	}
+	outdated := volumeReplicationStatus{State: "Primary", ObservedGeneration: 1, Conditions: []volumeReplicationCondition{{Type: "Completed", Status: "True"}}}
+	if done, _ := outdated.reached(replicationStatePrimary, 2); done {
+		t.Error("expected an outdated status to keep waiting")
+	}
+	if done, _ := outdated.reached(replicationStatePrimary, 1); !done {
+		t.Error("expected the primary state to be reached")
+	}
+}
+
+func TestReplicationFor(t *testing.T) {
+	defer func() { appConfig.Replication = "" }()
+	ctx := context.Background()
+	old, _ := newFakeCluster(t, "old", newOCSCSV(t, "4.8.0"))
+	newer, _ := newFakeCluster(t, "newer", newOCSCSV(t, "4.9.0"))
+
+	if backend := replicationFor(ctx, old); backend.name() != replicationToolbox {
+		t.Errorf("expected OCS 4.8 to use the toolbox, got %s", backend.name())
+	}
+	if backend := replicationFor(ctx, newer); backend.name() != replicationVolumeReplication {
+		t.Errorf("expected ODF 4.9 to use VolumeReplication, got %s", backend.name())
+	}
+	appConfig.Replication = replicationToolbox
+	if backend := replicationFor(ctx, newer); backend.name() != replicationToolbox {
+		t.Errorf("expected the configured toolbox, got %s", backend.name())
+	}
+
+	appConfig.Replication = replicationVolumeReplication
+	newer.dynamicClient.(*dynamicfake.FakeDynamicClient).PrependReactor("list", "volumereplicationclasses", func(k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, kerrors.NewNotFound(volumeReplicationClassResource.GroupResource(), "")
+	})
+	if backend := replicationFor(ctx, newer); backend.name() != replicationToolbox {
+		t.Errorf("expected the toolbox without the VolumeReplication API, got %s", backend.name())
+	}
+}
+
+func TestVolumeReplicationDryRun(t *testing.T) {
+	defer func() { dryRun.enabled = false }()
+	backend, _ := newVolumeReplicationCluster(t)
+	dryRun.enabled = true
+	dryRun.start()
+
+	if err := backend.enable(context.Background(), newRBDPV("pv-db", "shop", "db", "csi-vol-db", corev1.VolumeBound), ""); err != nil {
+		t.Fatal(err)
+	}
+	var targets []string
+	for _, action := range dryRun.plannedActions() {
+		targets = append(targets, action.Verb+" "+action.Target)
+	}
+	if expected := "create VolumeReplicationClass/rdrhelper-rbd-snapshot,create shop/VolumeReplication/db"; strings.Join(targets, ",") != expected {
+		t.Errorf("expected planned actions %s, got %v", expected, targets)
+	}
+	_, err := backend.cluster.dynamicClient.Resource(volumeReplicationResource).Namespace("shop").Get(context.Background(), "db", metav1.GetOptions{})
+	if !kerrors.IsNotFound(err) {
+		t.Errorf("expected the dry run to create no VolumeReplication, got %v", err)
+	}
+}